@@ -0,0 +1,10 @@
+// Package api holds the interface definitions of APR: the OpenAPI spec
+// generated from the swagger annotations and the gRPC protobuf definitions.
+package api
+
+import _ "embed"
+
+// Spec is the OpenAPI (Swagger 2.0) document of the HTTP API.
+//
+//go:embed openapi.json
+var Spec []byte
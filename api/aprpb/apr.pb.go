@@ -0,0 +1,890 @@
+// gRPC API used by other services of the platform for company lookups.
+//
+// Every call must carry a JWT issued by APR for the "apr" audience in the
+// "authorization" metadata, as "Bearer <jwt>".
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.30.0
+// 	protoc        v3.21.12
+// source: apr.proto
+
+package aprpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Person struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Jmbg     string `protobuf:"bytes,1,opt,name=jmbg,proto3" json:"jmbg,omitempty"`
+	Name     string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Lastname string `protobuf:"bytes,3,opt,name=lastname,proto3" json:"lastname,omitempty"`
+}
+
+func (x *Person) Reset() {
+	*x = Person{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_apr_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Person) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Person) ProtoMessage() {}
+
+func (x *Person) ProtoReflect() protoreflect.Message {
+	mi := &file_apr_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Person.ProtoReflect.Descriptor instead.
+func (*Person) Descriptor() ([]byte, []int) {
+	return file_apr_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Person) GetJmbg() string {
+	if x != nil {
+		return x.Jmbg
+	}
+	return ""
+}
+
+func (x *Person) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Person) GetLastname() string {
+	if x != nil {
+		return x.Lastname
+	}
+	return ""
+}
+
+type Nstj struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Oznaka string `protobuf:"bytes,1,opt,name=oznaka,proto3" json:"oznaka,omitempty"`
+	Naziv  string `protobuf:"bytes,2,opt,name=naziv,proto3" json:"naziv,omitempty"`
+}
+
+func (x *Nstj) Reset() {
+	*x = Nstj{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_apr_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Nstj) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Nstj) ProtoMessage() {}
+
+func (x *Nstj) ProtoReflect() protoreflect.Message {
+	mi := &file_apr_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Nstj.ProtoReflect.Descriptor instead.
+func (*Nstj) Descriptor() ([]byte, []int) {
+	return file_apr_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Nstj) GetOznaka() string {
+	if x != nil {
+		return x.Oznaka
+	}
+	return ""
+}
+
+func (x *Nstj) GetNaziv() string {
+	if x != nil {
+		return x.Naziv
+	}
+	return ""
+}
+
+type Company struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Pib           int64   `protobuf:"varint,1,opt,name=pib,proto3" json:"pib,omitempty"`
+	Naziv         string  `protobuf:"bytes,2,opt,name=naziv,proto3" json:"naziv,omitempty"`
+	AdresaSedista string  `protobuf:"bytes,3,opt,name=adresa_sedista,json=adresaSedista,proto3" json:"adresa_sedista,omitempty"`
+	Mesto         string  `protobuf:"bytes,4,opt,name=mesto,proto3" json:"mesto,omitempty"`
+	PostanskiBroj string  `protobuf:"bytes,5,opt,name=postanski_broj,json=postanskiBroj,proto3" json:"postanski_broj,omitempty"`
+	Delatnost     string  `protobuf:"bytes,6,opt,name=delatnost,proto3" json:"delatnost,omitempty"`
+	Sediste       *Nstj   `protobuf:"bytes,7,opt,name=sediste,proto3" json:"sediste,omitempty"`
+	Vlasnik       *Person `protobuf:"bytes,8,opt,name=vlasnik,proto3" json:"vlasnik,omitempty"`
+}
+
+func (x *Company) Reset() {
+	*x = Company{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_apr_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Company) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Company) ProtoMessage() {}
+
+func (x *Company) ProtoReflect() protoreflect.Message {
+	mi := &file_apr_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Company.ProtoReflect.Descriptor instead.
+func (*Company) Descriptor() ([]byte, []int) {
+	return file_apr_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Company) GetPib() int64 {
+	if x != nil {
+		return x.Pib
+	}
+	return 0
+}
+
+func (x *Company) GetNaziv() string {
+	if x != nil {
+		return x.Naziv
+	}
+	return ""
+}
+
+func (x *Company) GetAdresaSedista() string {
+	if x != nil {
+		return x.AdresaSedista
+	}
+	return ""
+}
+
+func (x *Company) GetMesto() string {
+	if x != nil {
+		return x.Mesto
+	}
+	return ""
+}
+
+func (x *Company) GetPostanskiBroj() string {
+	if x != nil {
+		return x.PostanskiBroj
+	}
+	return ""
+}
+
+func (x *Company) GetDelatnost() string {
+	if x != nil {
+		return x.Delatnost
+	}
+	return ""
+}
+
+func (x *Company) GetSediste() *Nstj {
+	if x != nil {
+		return x.Sediste
+	}
+	return nil
+}
+
+func (x *Company) GetVlasnik() *Person {
+	if x != nil {
+		return x.Vlasnik
+	}
+	return nil
+}
+
+type FindOneRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Pib int64 `protobuf:"varint,1,opt,name=pib,proto3" json:"pib,omitempty"`
+}
+
+func (x *FindOneRequest) Reset() {
+	*x = FindOneRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_apr_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FindOneRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FindOneRequest) ProtoMessage() {}
+
+func (x *FindOneRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_apr_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FindOneRequest.ProtoReflect.Descriptor instead.
+func (*FindOneRequest) Descriptor() ([]byte, []int) {
+	return file_apr_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *FindOneRequest) GetPib() int64 {
+	if x != nil {
+		return x.Pib
+	}
+	return 0
+}
+
+type FindManyRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Pibs []int64 `protobuf:"varint,1,rep,packed,name=pibs,proto3" json:"pibs,omitempty"`
+}
+
+func (x *FindManyRequest) Reset() {
+	*x = FindManyRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_apr_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FindManyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FindManyRequest) ProtoMessage() {}
+
+func (x *FindManyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_apr_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FindManyRequest.ProtoReflect.Descriptor instead.
+func (*FindManyRequest) Descriptor() ([]byte, []int) {
+	return file_apr_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *FindManyRequest) GetPibs() []int64 {
+	if x != nil {
+		return x.Pibs
+	}
+	return nil
+}
+
+type FindManyResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Companies []*Company `protobuf:"bytes,1,rep,name=companies,proto3" json:"companies,omitempty"`
+	// Requested PIBs for which no company was found
+	Missing []int64 `protobuf:"varint,2,rep,packed,name=missing,proto3" json:"missing,omitempty"`
+}
+
+func (x *FindManyResponse) Reset() {
+	*x = FindManyResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_apr_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FindManyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FindManyResponse) ProtoMessage() {}
+
+func (x *FindManyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_apr_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FindManyResponse.ProtoReflect.Descriptor instead.
+func (*FindManyResponse) Descriptor() ([]byte, []int) {
+	return file_apr_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *FindManyResponse) GetCompanies() []*Company {
+	if x != nil {
+		return x.Companies
+	}
+	return nil
+}
+
+func (x *FindManyResponse) GetMissing() []int64 {
+	if x != nil {
+		return x.Missing
+	}
+	return nil
+}
+
+type FindCompaniesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Page int32 `protobuf:"varint,1,opt,name=page,proto3" json:"page,omitempty"`
+	// One of naziv, vlasnik, PIB or mesto, PIB if empty
+	Order     string `protobuf:"bytes,2,opt,name=order,proto3" json:"order,omitempty"`
+	Desc      bool   `protobuf:"varint,3,opt,name=desc,proto3" json:"desc,omitempty"`
+	Delatnost string `protobuf:"bytes,4,opt,name=delatnost,proto3" json:"delatnost,omitempty"`
+	Sediste   string `protobuf:"bytes,5,opt,name=sediste,proto3" json:"sediste,omitempty"`
+	Mesto     string `protobuf:"bytes,6,opt,name=mesto,proto3" json:"mesto,omitempty"`
+}
+
+func (x *FindCompaniesRequest) Reset() {
+	*x = FindCompaniesRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_apr_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FindCompaniesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FindCompaniesRequest) ProtoMessage() {}
+
+func (x *FindCompaniesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_apr_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FindCompaniesRequest.ProtoReflect.Descriptor instead.
+func (*FindCompaniesRequest) Descriptor() ([]byte, []int) {
+	return file_apr_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *FindCompaniesRequest) GetPage() int32 {
+	if x != nil {
+		return x.Page
+	}
+	return 0
+}
+
+func (x *FindCompaniesRequest) GetOrder() string {
+	if x != nil {
+		return x.Order
+	}
+	return ""
+}
+
+func (x *FindCompaniesRequest) GetDesc() bool {
+	if x != nil {
+		return x.Desc
+	}
+	return false
+}
+
+func (x *FindCompaniesRequest) GetDelatnost() string {
+	if x != nil {
+		return x.Delatnost
+	}
+	return ""
+}
+
+func (x *FindCompaniesRequest) GetSediste() string {
+	if x != nil {
+		return x.Sediste
+	}
+	return ""
+}
+
+func (x *FindCompaniesRequest) GetMesto() string {
+	if x != nil {
+		return x.Mesto
+	}
+	return ""
+}
+
+type FindCompaniesResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Companies []*Company `protobuf:"bytes,1,rep,name=companies,proto3" json:"companies,omitempty"`
+}
+
+func (x *FindCompaniesResponse) Reset() {
+	*x = FindCompaniesResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_apr_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FindCompaniesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FindCompaniesResponse) ProtoMessage() {}
+
+func (x *FindCompaniesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_apr_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FindCompaniesResponse.ProtoReflect.Descriptor instead.
+func (*FindCompaniesResponse) Descriptor() ([]byte, []int) {
+	return file_apr_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *FindCompaniesResponse) GetCompanies() []*Company {
+	if x != nil {
+		return x.Companies
+	}
+	return nil
+}
+
+type ListNstjRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *ListNstjRequest) Reset() {
+	*x = ListNstjRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_apr_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListNstjRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListNstjRequest) ProtoMessage() {}
+
+func (x *ListNstjRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_apr_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListNstjRequest.ProtoReflect.Descriptor instead.
+func (*ListNstjRequest) Descriptor() ([]byte, []int) {
+	return file_apr_proto_rawDescGZIP(), []int{8}
+}
+
+type ListNstjResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Nstj []*Nstj `protobuf:"bytes,1,rep,name=nstj,proto3" json:"nstj,omitempty"`
+}
+
+func (x *ListNstjResponse) Reset() {
+	*x = ListNstjResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_apr_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListNstjResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListNstjResponse) ProtoMessage() {}
+
+func (x *ListNstjResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_apr_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListNstjResponse.ProtoReflect.Descriptor instead.
+func (*ListNstjResponse) Descriptor() ([]byte, []int) {
+	return file_apr_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ListNstjResponse) GetNstj() []*Nstj {
+	if x != nil {
+		return x.Nstj
+	}
+	return nil
+}
+
+var File_apr_proto protoreflect.FileDescriptor
+
+var file_apr_proto_rawDesc = []byte{
+	0x0a, 0x09, 0x61, 0x70, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06, 0x61, 0x70, 0x72,
+	0x2e, 0x76, 0x31, 0x22, 0x4c, 0x0a, 0x06, 0x50, 0x65, 0x72, 0x73, 0x6f, 0x6e, 0x12, 0x12, 0x0a,
+	0x04, 0x6a, 0x6d, 0x62, 0x67, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6a, 0x6d, 0x62,
+	0x67, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x6c, 0x61, 0x73, 0x74, 0x6e, 0x61, 0x6d,
+	0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x6c, 0x61, 0x73, 0x74, 0x6e, 0x61, 0x6d,
+	0x65, 0x22, 0x34, 0x0a, 0x04, 0x4e, 0x73, 0x74, 0x6a, 0x12, 0x16, 0x0a, 0x06, 0x6f, 0x7a, 0x6e,
+	0x61, 0x6b, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x6f, 0x7a, 0x6e, 0x61, 0x6b,
+	0x61, 0x12, 0x14, 0x0a, 0x05, 0x6e, 0x61, 0x7a, 0x69, 0x76, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x05, 0x6e, 0x61, 0x7a, 0x69, 0x76, 0x22, 0x85, 0x02, 0x0a, 0x07, 0x43, 0x6f, 0x6d, 0x70,
+	0x61, 0x6e, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x70, 0x69, 0x62, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x03, 0x70, 0x69, 0x62, 0x12, 0x14, 0x0a, 0x05, 0x6e, 0x61, 0x7a, 0x69, 0x76, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x6e, 0x61, 0x7a, 0x69, 0x76, 0x12, 0x25, 0x0a, 0x0e, 0x61,
+	0x64, 0x72, 0x65, 0x73, 0x61, 0x5f, 0x73, 0x65, 0x64, 0x69, 0x73, 0x74, 0x61, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0d, 0x61, 0x64, 0x72, 0x65, 0x73, 0x61, 0x53, 0x65, 0x64, 0x69, 0x73,
+	0x74, 0x61, 0x12, 0x14, 0x0a, 0x05, 0x6d, 0x65, 0x73, 0x74, 0x6f, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x05, 0x6d, 0x65, 0x73, 0x74, 0x6f, 0x12, 0x25, 0x0a, 0x0e, 0x70, 0x6f, 0x73, 0x74,
+	0x61, 0x6e, 0x73, 0x6b, 0x69, 0x5f, 0x62, 0x72, 0x6f, 0x6a, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x0d, 0x70, 0x6f, 0x73, 0x74, 0x61, 0x6e, 0x73, 0x6b, 0x69, 0x42, 0x72, 0x6f, 0x6a, 0x12,
+	0x1c, 0x0a, 0x09, 0x64, 0x65, 0x6c, 0x61, 0x74, 0x6e, 0x6f, 0x73, 0x74, 0x18, 0x06, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x09, 0x64, 0x65, 0x6c, 0x61, 0x74, 0x6e, 0x6f, 0x73, 0x74, 0x12, 0x26, 0x0a,
+	0x07, 0x73, 0x65, 0x64, 0x69, 0x73, 0x74, 0x65, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0c,
+	0x2e, 0x61, 0x70, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x4e, 0x73, 0x74, 0x6a, 0x52, 0x07, 0x73, 0x65,
+	0x64, 0x69, 0x73, 0x74, 0x65, 0x12, 0x28, 0x0a, 0x07, 0x76, 0x6c, 0x61, 0x73, 0x6e, 0x69, 0x6b,
+	0x18, 0x08, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x61, 0x70, 0x72, 0x2e, 0x76, 0x31, 0x2e,
+	0x50, 0x65, 0x72, 0x73, 0x6f, 0x6e, 0x52, 0x07, 0x76, 0x6c, 0x61, 0x73, 0x6e, 0x69, 0x6b, 0x22,
+	0x22, 0x0a, 0x0e, 0x46, 0x69, 0x6e, 0x64, 0x4f, 0x6e, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x12, 0x10, 0x0a, 0x03, 0x70, 0x69, 0x62, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x03,
+	0x70, 0x69, 0x62, 0x22, 0x25, 0x0a, 0x0f, 0x46, 0x69, 0x6e, 0x64, 0x4d, 0x61, 0x6e, 0x79, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x69, 0x62, 0x73, 0x18, 0x01,
+	0x20, 0x03, 0x28, 0x03, 0x52, 0x04, 0x70, 0x69, 0x62, 0x73, 0x22, 0x5b, 0x0a, 0x10, 0x46, 0x69,
+	0x6e, 0x64, 0x4d, 0x61, 0x6e, 0x79, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2d,
+	0x0a, 0x09, 0x63, 0x6f, 0x6d, 0x70, 0x61, 0x6e, 0x69, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x0f, 0x2e, 0x61, 0x70, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x61,
+	0x6e, 0x79, 0x52, 0x09, 0x63, 0x6f, 0x6d, 0x70, 0x61, 0x6e, 0x69, 0x65, 0x73, 0x12, 0x18, 0x0a,
+	0x07, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6e, 0x67, 0x18, 0x02, 0x20, 0x03, 0x28, 0x03, 0x52, 0x07,
+	0x6d, 0x69, 0x73, 0x73, 0x69, 0x6e, 0x67, 0x22, 0xa2, 0x01, 0x0a, 0x14, 0x46, 0x69, 0x6e, 0x64,
+	0x43, 0x6f, 0x6d, 0x70, 0x61, 0x6e, 0x69, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x12, 0x12, 0x0a, 0x04, 0x70, 0x61, 0x67, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04,
+	0x70, 0x61, 0x67, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x6f, 0x72, 0x64, 0x65, 0x72, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x05, 0x6f, 0x72, 0x64, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x65,
+	0x73, 0x63, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x04, 0x64, 0x65, 0x73, 0x63, 0x12, 0x1c,
+	0x0a, 0x09, 0x64, 0x65, 0x6c, 0x61, 0x74, 0x6e, 0x6f, 0x73, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x09, 0x64, 0x65, 0x6c, 0x61, 0x74, 0x6e, 0x6f, 0x73, 0x74, 0x12, 0x18, 0x0a, 0x07,
+	0x73, 0x65, 0x64, 0x69, 0x73, 0x74, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x73,
+	0x65, 0x64, 0x69, 0x73, 0x74, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x6d, 0x65, 0x73, 0x74, 0x6f, 0x18,
+	0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x6d, 0x65, 0x73, 0x74, 0x6f, 0x22, 0x46, 0x0a, 0x15,
+	0x46, 0x69, 0x6e, 0x64, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x6e, 0x69, 0x65, 0x73, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2d, 0x0a, 0x09, 0x63, 0x6f, 0x6d, 0x70, 0x61, 0x6e, 0x69,
+	0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x61, 0x70, 0x72, 0x2e, 0x76,
+	0x31, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x6e, 0x79, 0x52, 0x09, 0x63, 0x6f, 0x6d, 0x70, 0x61,
+	0x6e, 0x69, 0x65, 0x73, 0x22, 0x11, 0x0a, 0x0f, 0x4c, 0x69, 0x73, 0x74, 0x4e, 0x73, 0x74, 0x6a,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x34, 0x0a, 0x10, 0x4c, 0x69, 0x73, 0x74, 0x4e,
+	0x73, 0x74, 0x6a, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x20, 0x0a, 0x04, 0x6e,
+	0x73, 0x74, 0x6a, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x61, 0x70, 0x72, 0x2e,
+	0x76, 0x31, 0x2e, 0x4e, 0x73, 0x74, 0x6a, 0x52, 0x04, 0x6e, 0x73, 0x74, 0x6a, 0x32, 0x8a, 0x02,
+	0x0a, 0x08, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79, 0x12, 0x32, 0x0a, 0x07, 0x46, 0x69,
+	0x6e, 0x64, 0x4f, 0x6e, 0x65, 0x12, 0x16, 0x2e, 0x61, 0x70, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x46,
+	0x69, 0x6e, 0x64, 0x4f, 0x6e, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e,
+	0x61, 0x70, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x6e, 0x79, 0x12, 0x3d,
+	0x0a, 0x08, 0x46, 0x69, 0x6e, 0x64, 0x4d, 0x61, 0x6e, 0x79, 0x12, 0x17, 0x2e, 0x61, 0x70, 0x72,
+	0x2e, 0x76, 0x31, 0x2e, 0x46, 0x69, 0x6e, 0x64, 0x4d, 0x61, 0x6e, 0x79, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x1a, 0x18, 0x2e, 0x61, 0x70, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x46, 0x69, 0x6e,
+	0x64, 0x4d, 0x61, 0x6e, 0x79, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x4c, 0x0a,
+	0x0d, 0x46, 0x69, 0x6e, 0x64, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x6e, 0x69, 0x65, 0x73, 0x12, 0x1c,
+	0x2e, 0x61, 0x70, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x46, 0x69, 0x6e, 0x64, 0x43, 0x6f, 0x6d, 0x70,
+	0x61, 0x6e, 0x69, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1d, 0x2e, 0x61,
+	0x70, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x46, 0x69, 0x6e, 0x64, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x6e,
+	0x69, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x3d, 0x0a, 0x08, 0x4c,
+	0x69, 0x73, 0x74, 0x4e, 0x73, 0x74, 0x6a, 0x12, 0x17, 0x2e, 0x61, 0x70, 0x72, 0x2e, 0x76, 0x31,
+	0x2e, 0x4c, 0x69, 0x73, 0x74, 0x4e, 0x73, 0x74, 0x6a, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x18, 0x2e, 0x61, 0x70, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x4e, 0x73,
+	0x74, 0x6a, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x17, 0x5a, 0x15, 0x61, 0x70,
+	0x72, 0x2d, 0x62, 0x61, 0x63, 0x6b, 0x65, 0x6e, 0x64, 0x2f, 0x61, 0x70, 0x69, 0x2f, 0x61, 0x70,
+	0x72, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_apr_proto_rawDescOnce sync.Once
+	file_apr_proto_rawDescData = file_apr_proto_rawDesc
+)
+
+func file_apr_proto_rawDescGZIP() []byte {
+	file_apr_proto_rawDescOnce.Do(func() {
+		file_apr_proto_rawDescData = protoimpl.X.CompressGZIP(file_apr_proto_rawDescData)
+	})
+	return file_apr_proto_rawDescData
+}
+
+var file_apr_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_apr_proto_goTypes = []interface{}{
+	(*Person)(nil),                // 0: apr.v1.Person
+	(*Nstj)(nil),                  // 1: apr.v1.Nstj
+	(*Company)(nil),               // 2: apr.v1.Company
+	(*FindOneRequest)(nil),        // 3: apr.v1.FindOneRequest
+	(*FindManyRequest)(nil),       // 4: apr.v1.FindManyRequest
+	(*FindManyResponse)(nil),      // 5: apr.v1.FindManyResponse
+	(*FindCompaniesRequest)(nil),  // 6: apr.v1.FindCompaniesRequest
+	(*FindCompaniesResponse)(nil), // 7: apr.v1.FindCompaniesResponse
+	(*ListNstjRequest)(nil),       // 8: apr.v1.ListNstjRequest
+	(*ListNstjResponse)(nil),      // 9: apr.v1.ListNstjResponse
+}
+var file_apr_proto_depIdxs = []int32{
+	1, // 0: apr.v1.Company.sediste:type_name -> apr.v1.Nstj
+	0, // 1: apr.v1.Company.vlasnik:type_name -> apr.v1.Person
+	2, // 2: apr.v1.FindManyResponse.companies:type_name -> apr.v1.Company
+	2, // 3: apr.v1.FindCompaniesResponse.companies:type_name -> apr.v1.Company
+	1, // 4: apr.v1.ListNstjResponse.nstj:type_name -> apr.v1.Nstj
+	3, // 5: apr.v1.Registry.FindOne:input_type -> apr.v1.FindOneRequest
+	4, // 6: apr.v1.Registry.FindMany:input_type -> apr.v1.FindManyRequest
+	6, // 7: apr.v1.Registry.FindCompanies:input_type -> apr.v1.FindCompaniesRequest
+	8, // 8: apr.v1.Registry.ListNstj:input_type -> apr.v1.ListNstjRequest
+	2, // 9: apr.v1.Registry.FindOne:output_type -> apr.v1.Company
+	5, // 10: apr.v1.Registry.FindMany:output_type -> apr.v1.FindManyResponse
+	7, // 11: apr.v1.Registry.FindCompanies:output_type -> apr.v1.FindCompaniesResponse
+	9, // 12: apr.v1.Registry.ListNstj:output_type -> apr.v1.ListNstjResponse
+	9, // [9:13] is the sub-list for method output_type
+	5, // [5:9] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_apr_proto_init() }
+func file_apr_proto_init() {
+	if File_apr_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_apr_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Person); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_apr_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Nstj); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_apr_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Company); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_apr_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FindOneRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_apr_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FindManyRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_apr_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FindManyResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_apr_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FindCompaniesRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_apr_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FindCompaniesResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_apr_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListNstjRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_apr_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListNstjResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_apr_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_apr_proto_goTypes,
+		DependencyIndexes: file_apr_proto_depIdxs,
+		MessageInfos:      file_apr_proto_msgTypes,
+	}.Build()
+	File_apr_proto = out.File
+	file_apr_proto_rawDesc = nil
+	file_apr_proto_goTypes = nil
+	file_apr_proto_depIdxs = nil
+}
@@ -0,0 +1,235 @@
+// gRPC API used by other services of the platform for company lookups.
+//
+// Every call must carry a JWT issued by APR for the "apr" audience in the
+// "authorization" metadata, as "Bearer <jwt>".
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.21.12
+// source: apr.proto
+
+package aprpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Registry_FindOne_FullMethodName       = "/apr.v1.Registry/FindOne"
+	Registry_FindMany_FullMethodName      = "/apr.v1.Registry/FindMany"
+	Registry_FindCompanies_FullMethodName = "/apr.v1.Registry/FindCompanies"
+	Registry_ListNstj_FullMethodName      = "/apr.v1.Registry/ListNstj"
+)
+
+// RegistryClient is the client API for Registry service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RegistryClient interface {
+	// Finds one company by its PIB, NOT_FOUND if it doesn't exist or was
+	// liquidated.
+	FindOne(ctx context.Context, in *FindOneRequest, opts ...grpc.CallOption) (*Company, error)
+	// Finds many companies by PIB in a single round trip.
+	FindMany(ctx context.Context, in *FindManyRequest, opts ...grpc.CallOption) (*FindManyResponse, error)
+	// Filters, sorts and paginates companies, like GET /api/company/.
+	FindCompanies(ctx context.Context, in *FindCompaniesRequest, opts ...grpc.CallOption) (*FindCompaniesResponse, error)
+	// Lists all NSTJ codes.
+	ListNstj(ctx context.Context, in *ListNstjRequest, opts ...grpc.CallOption) (*ListNstjResponse, error)
+}
+
+type registryClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRegistryClient(cc grpc.ClientConnInterface) RegistryClient {
+	return &registryClient{cc}
+}
+
+func (c *registryClient) FindOne(ctx context.Context, in *FindOneRequest, opts ...grpc.CallOption) (*Company, error) {
+	out := new(Company)
+	err := c.cc.Invoke(ctx, Registry_FindOne_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryClient) FindMany(ctx context.Context, in *FindManyRequest, opts ...grpc.CallOption) (*FindManyResponse, error) {
+	out := new(FindManyResponse)
+	err := c.cc.Invoke(ctx, Registry_FindMany_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryClient) FindCompanies(ctx context.Context, in *FindCompaniesRequest, opts ...grpc.CallOption) (*FindCompaniesResponse, error) {
+	out := new(FindCompaniesResponse)
+	err := c.cc.Invoke(ctx, Registry_FindCompanies_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryClient) ListNstj(ctx context.Context, in *ListNstjRequest, opts ...grpc.CallOption) (*ListNstjResponse, error) {
+	out := new(ListNstjResponse)
+	err := c.cc.Invoke(ctx, Registry_ListNstj_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RegistryServer is the server API for Registry service.
+// All implementations must embed UnimplementedRegistryServer
+// for forward compatibility
+type RegistryServer interface {
+	// Finds one company by its PIB, NOT_FOUND if it doesn't exist or was
+	// liquidated.
+	FindOne(context.Context, *FindOneRequest) (*Company, error)
+	// Finds many companies by PIB in a single round trip.
+	FindMany(context.Context, *FindManyRequest) (*FindManyResponse, error)
+	// Filters, sorts and paginates companies, like GET /api/company/.
+	FindCompanies(context.Context, *FindCompaniesRequest) (*FindCompaniesResponse, error)
+	// Lists all NSTJ codes.
+	ListNstj(context.Context, *ListNstjRequest) (*ListNstjResponse, error)
+	mustEmbedUnimplementedRegistryServer()
+}
+
+// UnimplementedRegistryServer must be embedded to have forward compatible implementations.
+type UnimplementedRegistryServer struct {
+}
+
+func (UnimplementedRegistryServer) FindOne(context.Context, *FindOneRequest) (*Company, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindOne not implemented")
+}
+func (UnimplementedRegistryServer) FindMany(context.Context, *FindManyRequest) (*FindManyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindMany not implemented")
+}
+func (UnimplementedRegistryServer) FindCompanies(context.Context, *FindCompaniesRequest) (*FindCompaniesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindCompanies not implemented")
+}
+func (UnimplementedRegistryServer) ListNstj(context.Context, *ListNstjRequest) (*ListNstjResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListNstj not implemented")
+}
+func (UnimplementedRegistryServer) mustEmbedUnimplementedRegistryServer() {}
+
+// UnsafeRegistryServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RegistryServer will
+// result in compilation errors.
+type UnsafeRegistryServer interface {
+	mustEmbedUnimplementedRegistryServer()
+}
+
+func RegisterRegistryServer(s grpc.ServiceRegistrar, srv RegistryServer) {
+	s.RegisterService(&Registry_ServiceDesc, srv)
+}
+
+func _Registry_FindOne_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FindOneRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServer).FindOne(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Registry_FindOne_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServer).FindOne(ctx, req.(*FindOneRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Registry_FindMany_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FindManyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServer).FindMany(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Registry_FindMany_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServer).FindMany(ctx, req.(*FindManyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Registry_FindCompanies_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FindCompaniesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServer).FindCompanies(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Registry_FindCompanies_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServer).FindCompanies(ctx, req.(*FindCompaniesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Registry_ListNstj_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListNstjRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServer).ListNstj(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Registry_ListNstj_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServer).ListNstj(ctx, req.(*ListNstjRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Registry_ServiceDesc is the grpc.ServiceDesc for Registry service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Registry_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "apr.v1.Registry",
+	HandlerType: (*RegistryServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "FindOne",
+			Handler:    _Registry_FindOne_Handler,
+		},
+		{
+			MethodName: "FindMany",
+			Handler:    _Registry_FindMany_Handler,
+		},
+		{
+			MethodName: "FindCompanies",
+			Handler:    _Registry_FindCompanies_Handler,
+		},
+		{
+			MethodName: "ListNstj",
+			Handler:    _Registry_ListNstj_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "apr.proto",
+}
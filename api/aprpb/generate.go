@@ -0,0 +1,4 @@
+// Package aprpb holds the generated gRPC client and server code of APR.
+package aprpb
+
+//go:generate protoc -I .. --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative ../apr.proto
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ExtractContentType is the cty header of signed registry extracts. It
+// tells them apart from JWTs, which are signed with the same key.
+const ExtractContentType = "apr-extract+json"
+
+// ExtractKeysPath is where APR serves the public keys of every key which
+// signed extracts, also after they stopped verifying tokens
+const ExtractKeysPath = "/api/extract/keys"
+
+var ErrInvalidExtract = errors.New("Invalid signed extract")
+
+// JwsHeader is the protected header of JWS documents signed by APR
+type JwsHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Cty string `json:"cty,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// VerifyExtract checks a registry extract in JWS compact serialization
+// against the public key of APR and decodes its JSON payload into v. It
+// needs no connection to APR.
+func VerifyExtract(extract string, pubKey crypto.PublicKey, v any) error {
+	parts := strings.Split(strings.TrimSpace(extract), ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("%w: not in compact serialization", ErrInvalidExtract)
+	}
+
+	headerJson, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("%w: bad header encoding", ErrInvalidExtract)
+	}
+	var header JwsHeader
+	if err := json.Unmarshal(headerJson, &header); err != nil {
+		return fmt.Errorf("%w: bad header", ErrInvalidExtract)
+	}
+	if header.Cty != ExtractContentType {
+		return fmt.Errorf("%w: unexpected content type %q", ErrInvalidExtract, header.Cty)
+	}
+	method, ok := jwt.GetSigningMethod(header.Alg).(*jwt.SigningMethodRSA)
+	if !ok {
+		return fmt.Errorf("%w: unexpected signing method %q", ErrInvalidExtract, header.Alg)
+	}
+	if err := method.Verify(parts[0]+"."+parts[1], parts[2], pubKey); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidExtract, err.Error())
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("%w: bad payload encoding", ErrInvalidExtract)
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return fmt.Errorf("%w: bad payload: %s", ErrInvalidExtract, err.Error())
+	}
+	return nil
+}
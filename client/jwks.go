@@ -0,0 +1,81 @@
+package client
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// JwksPath is where APR serves the public keys its tokens are signed with
+const JwksPath = "/.well-known/jwks.json"
+
+var ErrInvalidJwk = errors.New("Invalid JWK")
+
+// JWK is a public RSA key in JSON Web Key format (RFC 7517)
+// swagger:model jwk
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS is a JSON Web Key Set
+// swagger:model jwks
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK describes pubKey as a signing key with the kid from Thumbprint
+func NewJWK(pubKey *rsa.PublicKey, alg string) JWK {
+	return JWK{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: alg,
+		Kid: Thumbprint(pubKey),
+		N:   base64.RawURLEncoding.EncodeToString(pubKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pubKey.E)).Bytes()),
+	}
+}
+
+// Thumbprint returns the JWK thumbprint (RFC 7638) of pubKey, which APR
+// uses as kid. Every replica holding the same key derives the same kid.
+func Thumbprint(pubKey *rsa.PublicKey) string {
+	// Members in lexicographic order, without whitespace
+	canonical, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pubKey.E)).Bytes()),
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(pubKey.N.Bytes()),
+	})
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// PublicKey decodes the RSA key described by jwk
+func (jwk JWK) PublicKey() (*rsa.PublicKey, error) {
+	if jwk.Kty != "RSA" {
+		return nil, fmt.Errorf("%w: unsupported key type %q", ErrInvalidJwk, jwk.Kty)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad modulus", ErrInvalidJwk)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, fmt.Errorf("%w: bad exponent", ErrInvalidJwk)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
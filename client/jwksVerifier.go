@@ -0,0 +1,129 @@
+package client
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrUnknownKid = errors.New("No key with this kid in JWKS")
+
+// Limit for JWKS documents, they hold a handful of keys
+const maxJwksSize = 1 << 20
+
+// JwksOptions configures NewJwksVerifier. Zero values fall back to the
+// defaults described on each field.
+type JwksOptions struct {
+	// Defaults to a client with a 10s timeout
+	HTTPClient *http.Client
+	// How long a fetched JWKS is used before it is fetched again, 5 minutes
+	// by default
+	CacheTTL time.Duration
+	// Minimum time between fetches, so that tokens with made up kids or an
+	// unreachable APR don't cause a fetch per request. 10 seconds by default.
+	MinRefreshInterval time.Duration
+	// Clock skew tolerated when checking exp, nbf and iat, none by default
+	Leeway time.Duration
+}
+
+// NewJwksVerifier returns a JwtVerifier which gets its keys from the JWKS
+// document at url, usually APR's JwksPath. Keys are selected by kid, and the
+// document is fetched again once the cache expires or a token names a kid
+// it doesn't know, so services keep working across key rotations.
+func NewJwksVerifier(url string, opts JwksOptions) JwtVerifier {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = 5 * time.Minute
+	}
+	if opts.MinRefreshInterval <= 0 {
+		opts.MinRefreshInterval = 10 * time.Second
+	}
+	cache := &jwksCache{url: url, opts: opts}
+	return NewKeyLookupVerifier(cache.lookup, opts.Leeway)
+}
+
+type jwksCache struct {
+	url  string
+	opts JwksOptions
+
+	mu   sync.Mutex
+	keys map[string]crypto.PublicKey
+	// kid of the first key, used for tokens without a kid
+	first     string
+	fetchedAt time.Time
+	triedAt   time.Time
+}
+
+func (jc *jwksCache) lookup(kid string) (crypto.PublicKey, error) {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+
+	now := time.Now()
+	expired := now.Sub(jc.fetchedAt) > jc.opts.CacheTTL
+	key, known := jc.find(kid)
+	if (expired || !known) && now.Sub(jc.triedAt) > jc.opts.MinRefreshInterval {
+		jc.triedAt = now
+		if err := jc.fetch(); err != nil {
+			// Keep using what is cached, APR may just be unreachable
+			if known {
+				return key, nil
+			}
+			return nil, err
+		}
+		key, known = jc.find(kid)
+	}
+	if !known {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKid, kid)
+	}
+	return key, nil
+}
+
+func (jc *jwksCache) find(kid string) (crypto.PublicKey, bool) {
+	if kid == "" {
+		kid = jc.first
+	}
+	key, ok := jc.keys[kid]
+	return key, ok
+}
+
+func (jc *jwksCache) fetch() error {
+	res, err := jc.opts.HTTPClient.Get(jc.url)
+	if err != nil {
+		return fmt.Errorf("Error fetching JWKS: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Error fetching JWKS: status %s", res.Status)
+	}
+
+	var jwks JWKS
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxJwksSize)).Decode(&jwks); err != nil {
+		return fmt.Errorf("Error decoding JWKS: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	first := ""
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			return err
+		}
+		if first == "" {
+			first = jwk.Kid
+		}
+		keys[jwk.Kid] = key
+	}
+	jc.keys = keys
+	jc.first = first
+	jc.fetchedAt = time.Now()
+	return nil
+}
@@ -3,22 +3,185 @@ package client
 import (
 	"crypto"
 	"crypto/rsa"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
 )
 
 const Principal = "principal"
+
+// Key under which CheckAuth stores the JMBG of the person acting for the
+// principal. It is only set for tokens with an act claim.
+const ActingPerson = "actingPerson"
+
+// Key under which CheckAuth stores the TokenClaims of the request
+const Claims = "claims"
+
+// Keys under which CheckAuth stores single claims: the roles and scopes as
+// []string, and the CompanyClaims of tokens which carry them
+const (
+	Roles   = "roles"
+	Scopes  = "scopes"
+	Company = "company"
+)
 const Apr = "apr"
 
+// Audience of tokens issued to persons, before they pick a company to act
+// for. Services never accept them in place of company tokens.
+const AprPerson = "apr-person"
+
+// Actor is the party acting on behalf of the subject of a token, as in the
+// act claim of RFC 8693
+type Actor struct {
+	// JMBG of the person
+	Subject string `json:"sub"`
+}
+
+// Authentication methods in the amr claim, as in RFC 8176
+const (
+	AmrPassword = "pwd"
+	// A one-time password as second factor
+	AmrOtp = "otp"
+)
+
+// Statuses of companies in CompanyClaims
+const (
+	CompanyActive     = "active"
+	CompanyLiquidated = "liquidated"
+)
+
+// CompanyClaims describe the company a token was issued for, so that
+// services don't have to look it up
+type CompanyClaims struct {
+	// Naziv of the company
+	Name string `json:"name"`
+	// CompanyActive or CompanyLiquidated
+	Status string `json:"status"`
+}
+
+// TokenClaims are the claims of tokens issued by APR
+type TokenClaims struct {
+	jwt.RegisteredClaims
+	// Set when a person acts for the company in sub, nil for tokens issued
+	// to the company itself
+	Act *Actor `json:"act,omitempty"`
+	// See RoleCompany and the other roles
+	Roles []string `json:"roles,omitempty"`
+	// Space separated scopes, as in RFC 8693
+	Scope string `json:"scope,omitempty"`
+	// Set in tokens of companies
+	Company *CompanyClaims `json:"company,omitempty"`
+	// How the subject authenticated, see AmrPassword and AmrOtp
+	Amr []string `json:"amr,omitempty"`
+	// Session the token was issued in, tokens of sessions which ended are
+	// rejected by a SessionRevocationStore
+	Sid string `json:"sid,omitempty"`
+}
+
+// ActingPerson returns the JMBG of the person acting for the subject, or
+// an empty string
+func (claims TokenClaims) ActingPerson() string {
+	if claims.Act == nil {
+		return ""
+	}
+	return claims.Act.Subject
+}
+
+// HasAmr reports whether the subject authenticated with method
+func (claims TokenClaims) HasAmr(method string) bool {
+	for _, m := range claims.Amr {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+var ErrInvalidAudience = errors.New("invalid audience")
+var ErrInvalidIssuer = errors.New("invalid issuer")
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// RevocationStore knows which tokens were revoked before they expired, by
+// their jti claim.
+type RevocationStore interface {
+	IsRevoked(jti string) (bool, error)
+}
+
+// SessionRevocationStore also knows which sessions ended, by the sid claim
+// of their tokens. ValidateToken checks sessions if the store passed to
+// WithRevocationStore is one.
+type SessionRevocationStore interface {
+	RevocationStore
+	IsSessionRevoked(sid string) (bool, error)
+}
+
+type authOptions struct {
+	revocations RevocationStore
+}
+
+// AuthOption configures CheckAuth and ValidateToken
+type AuthOption func(*authOptions)
+
+// WithRevocationStore rejects tokens revoked in store
+func WithRevocationStore(store RevocationStore) AuthOption {
+	return func(opts *authOptions) {
+		opts.revocations = store
+	}
+}
+
+// ValidateToken parses a JWT and checks that it was issued by APR for
+// serviceName. It is used by CheckAuth and by other transports which carry
+// the same tokens.
+func ValidateToken(verifier JwtVerifier, tokenStr string, serviceName string, options ...AuthOption) (TokenClaims, error) {
+	var opts authOptions
+	for _, option := range options {
+		option(&opts)
+	}
+
+	claims, err := verifier.ParseJwt(tokenStr)
+	if err != nil {
+		return claims, err
+	}
+
+	if !claims.VerifyAudience(serviceName, true) {
+		return claims, fmt.Errorf("%w: expected %q, got %v", ErrInvalidAudience, serviceName, claims.Audience)
+	}
+
+	if !claims.VerifyIssuer(Apr, true) {
+		return claims, ErrInvalidIssuer
+	}
+
+	if opts.revocations != nil && claims.ID != "" {
+		revoked, err := opts.revocations.IsRevoked(claims.ID)
+		if err != nil {
+			return claims, fmt.Errorf("Error checking revocation: %w", err)
+		}
+		if revoked {
+			return claims, ErrTokenRevoked
+		}
+	}
+	if sessions, ok := opts.revocations.(SessionRevocationStore); ok && claims.Sid != "" {
+		revoked, err := sessions.IsSessionRevoked(claims.Sid)
+		if err != nil {
+			return claims, fmt.Errorf("Error checking revocation: %w", err)
+		}
+		if revoked {
+			return claims, ErrTokenRevoked
+		}
+	}
+	return claims, nil
+}
+
 // Middleware for gin, check if user is logged in
-func CheckAuth(verifier JwtVerifier, serviceName string) gin.HandlerFunc {
+func CheckAuth(verifier JwtVerifier, serviceName string, options ...AuthOption) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		bearerToken := ctx.GetHeader("Authorization")
 		if bearerToken == "" {
@@ -34,26 +197,47 @@ func CheckAuth(verifier JwtVerifier, serviceName string) gin.HandlerFunc {
 		}
 
 		//Check that jwt is valid
-		claims, err := verifier.ParseJwt(tokenStr)
-		if err != nil {
+		claims, err := ValidateToken(verifier, tokenStr, serviceName, options...)
+		switch {
+		case errors.Is(err, ErrInvalidAudience), errors.Is(err, ErrInvalidIssuer), errors.Is(err, ErrTokenRevoked):
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		case err != nil:
 			log.Println(err)
 			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			return
 		}
 
-		if !claims.VerifyAudience(serviceName, true) {
-			errMsg := fmt.Sprintf("invalid audience: expected %q, got %v", serviceName, claims.Audience)
-			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": errMsg})
-			return
+		ctx.Set(Principal, claims.Subject)
+		if actor := claims.ActingPerson(); actor != "" {
+			ctx.Set(ActingPerson, actor)
 		}
-
-		if !claims.VerifyIssuer(Apr, true) {
-			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid issuer"})
-			return
+		ctx.Set(Roles, claims.Roles)
+		ctx.Set(Scopes, claims.Scopes())
+		if claims.Company != nil {
+			ctx.Set(Company, *claims.Company)
 		}
+		ctx.Set(Claims, claims)
+	}
+}
 
-		ctx.Set(Principal, claims.Subject)
+// GetClaims returns the claims CheckAuth stored for the request, ok is false
+// if it didn't run
+func GetClaims(ctx *gin.Context) (TokenClaims, bool) {
+	value, ok := ctx.Get(Claims)
+	if !ok {
+		return TokenClaims{}, false
 	}
+	claims, ok := value.(TokenClaims)
+	return claims, ok
+}
+
+// GetCompany returns the company claims of the request, ok is false for
+// tokens without them
+func GetCompany(ctx *gin.Context) (CompanyClaims, bool) {
+	value, _ := ctx.Get(Company)
+	company, ok := value.(CompanyClaims)
+	return company, ok
 }
 
 func ReadRSAPublicKeyFromFile(filePath string) (*rsa.PublicKey, error) {
@@ -75,33 +259,61 @@ func ReadRSAPublicKeyFromFile(filePath string) (*rsa.PublicKey, error) {
 }
 
 type JwtVerifier interface {
-	ParseJwt(token string) (jwt.RegisteredClaims, error)
+	ParseJwt(token string) (TokenClaims, error)
 }
 
+// KeyLookup returns the public key a token with kid was signed with. kid is
+// empty for tokens without one.
+type KeyLookup func(kid string) (crypto.PublicKey, error)
+
 // NewVerifier() returns a new JwtVerifier instance
 // @pubKey public RSA key of the signee
 // @serviceName used to verify `aud` claims
 func NewVerifier(pubKey crypto.PublicKey) JwtVerifier {
-	return defaultJwtVerifier{pubKey: pubKey}
+	return NewKeyLookupVerifier(func(string) (crypto.PublicKey, error) {
+		return pubKey, nil
+	}, 0)
+}
+
+// NewKeyLookupVerifier returns a JwtVerifier which picks the key by the kid
+// header of each token, for signees with more than one key. leeway is the
+// clock skew tolerated when checking exp, nbf and iat.
+func NewKeyLookupVerifier(lookup KeyLookup, leeway time.Duration) JwtVerifier {
+	return defaultJwtVerifier{lookup: lookup, leeway: leeway}
 }
 
 type defaultJwtVerifier struct {
-	pubKey crypto.PublicKey
+	lookup KeyLookup
+	leeway time.Duration
 }
 
 func (jwtGen defaultJwtVerifier) keyFunc(t *jwt.Token) (interface{}, error) {
 	if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
 		return nil, fmt.Errorf("Unexpected signing method: %v", t.Header["alg"])
 	}
-	return jwtGen.pubKey, nil
+	kid, _ := t.Header["kid"].(string)
+	return jwtGen.lookup(kid)
 }
 
 // CheckJwt implements JwtVerifier
-func (jwtGen defaultJwtVerifier) ParseJwt(token string) (jwt.RegisteredClaims, error) {
-	var claims jwt.RegisteredClaims
-	_, err := jwt.ParseWithClaims(token, &claims, jwtGen.keyFunc)
+func (jwtGen defaultJwtVerifier) ParseJwt(token string) (TokenClaims, error) {
+	var claims TokenClaims
+	// Time based claims are checked below, with leeway
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+	_, err := parser.ParseWithClaims(token, &claims, jwtGen.keyFunc)
 	if err != nil {
 		return claims, fmt.Errorf("Cannot parse jwt: %w", err)
 	}
-	return claims, claims.Valid()
+
+	now := time.Now()
+	if !claims.VerifyExpiresAt(now.Add(-jwtGen.leeway), false) {
+		return claims, jwt.ErrTokenExpired
+	}
+	if !claims.VerifyIssuedAt(now.Add(jwtGen.leeway), false) {
+		return claims, jwt.ErrTokenUsedBeforeIssued
+	}
+	if !claims.VerifyNotBefore(now.Add(jwtGen.leeway), false) {
+		return claims, jwt.ErrTokenNotValidYet
+	}
+	return claims, nil
 }
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RevocationsPath is where APR lists the access tokens revoked before they
+// expire
+const RevocationsPath = "/api/auth/revocations"
+
+var ErrRevocationsStale = errors.New("Revocation list is out of date")
+
+// Limit for revocation lists, they only hold tokens of the last 15 minutes
+const maxRevocationsSize = 4 << 20
+
+// RevocationList holds the jti of every revoked access token which hasn't
+// expired yet, and the sid of sessions which ended while their tokens may
+// still be valid
+// swagger:model revocationList
+type RevocationList struct {
+	// Required: true
+	Jtis []string `json:"jtis"`
+	// Required: true
+	Sessions []string `json:"sessions"`
+}
+
+// RevocationOptions configures NewRevocationList. Zero values fall back to
+// the defaults described on each field.
+type RevocationOptions struct {
+	// Defaults to a client with a 10s timeout
+	HTTPClient *http.Client
+	// How long a fetched list is used before it is fetched again, 30 seconds
+	// by default. Revoked tokens are accepted for up to this long.
+	RefreshInterval time.Duration
+	// How long the last list is used while APR can't be reached, 5 minutes
+	// by default. After that every token is rejected.
+	MaxStale time.Duration
+}
+
+// NewRevocationList returns a SessionRevocationStore backed by the list at
+// url, usually APR's RevocationsPath. Services pass it to
+// WithRevocationStore so that tokens ended by a logout are rejected before
+// they expire.
+func NewRevocationList(url string, opts RevocationOptions) SessionRevocationStore {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = 30 * time.Second
+	}
+	if opts.MaxStale <= 0 {
+		opts.MaxStale = 5 * time.Minute
+	}
+	return &revocationCache{url: url, opts: opts}
+}
+
+type revocationCache struct {
+	url  string
+	opts RevocationOptions
+
+	mu        sync.Mutex
+	jtis      map[string]bool
+	sessions  map[string]bool
+	fetchedAt time.Time
+	triedAt   time.Time
+}
+
+// IsRevoked implements RevocationStore
+func (rc *revocationCache) IsRevoked(jti string) (bool, error) {
+	return rc.lookup(func() bool { return rc.jtis[jti] })
+}
+
+// IsSessionRevoked implements SessionRevocationStore
+func (rc *revocationCache) IsSessionRevoked(sid string) (bool, error) {
+	return rc.lookup(func() bool { return rc.sessions[sid] })
+}
+
+// lookup calls revoked with a list that isn't stale, fetching it if it is
+// due
+func (rc *revocationCache) lookup(revoked func() bool) (bool, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(rc.fetchedAt) > rc.opts.RefreshInterval && now.Sub(rc.triedAt) > rc.opts.RefreshInterval {
+		rc.triedAt = now
+		if err := rc.fetch(); err != nil && now.Sub(rc.fetchedAt) > rc.opts.MaxStale {
+			// A revoked token could pass unnoticed
+			return false, fmt.Errorf("%w: %s", ErrRevocationsStale, err.Error())
+		}
+	}
+	if now.Sub(rc.fetchedAt) > rc.opts.MaxStale {
+		return false, ErrRevocationsStale
+	}
+	return revoked(), nil
+}
+
+func (rc *revocationCache) fetch() error {
+	res, err := rc.opts.HTTPClient.Get(rc.url)
+	if err != nil {
+		return fmt.Errorf("Error fetching revocations: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Error fetching revocations: status %s", res.Status)
+	}
+
+	var list RevocationList
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxRevocationsSize)).Decode(&list); err != nil {
+		return fmt.Errorf("Error decoding revocations: %w", err)
+	}
+	rc.jtis = set(list.Jtis)
+	rc.sessions = set(list.Sessions)
+	rc.fetchedAt = time.Now()
+	return nil
+}
+
+func set(values []string) map[string]bool {
+	m := make(map[string]bool, len(values))
+	for _, v := range values {
+		m[v] = true
+	}
+	return m
+}
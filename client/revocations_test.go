@@ -0,0 +1,82 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRevocationList(t *testing.T) {
+	var mu sync.Mutex
+	list := RevocationList{Jtis: []string{"revoked"}, Sessions: []string{"ended"}}
+	fetches, down := 0, false
+	apr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(list)
+	}))
+	defer apr.Close()
+	set := func(f func()) {
+		mu.Lock()
+		defer mu.Unlock()
+		f()
+	}
+	const refresh = 100 * time.Millisecond
+	revocations := NewRevocationList(apr.URL, RevocationOptions{RefreshInterval: refresh, MaxStale: 3 * refresh})
+	check := func(jti string, want bool, wantErr error) {
+		t.Helper()
+		revoked, err := revocations.IsRevoked(jti)
+		if !errors.Is(err, wantErr) || revoked != want {
+			t.Errorf("%s: got %v, %v, want %v, %v", jti, revoked, err, want, wantErr)
+		}
+	}
+
+	check("revoked", true, nil)
+	check("valid", false, nil)
+	if ended, err := revocations.IsSessionRevoked("ended"); err != nil || !ended {
+		t.Errorf("ended session: got %v, %v, want true", ended, err)
+	}
+	if ended, err := revocations.IsSessionRevoked("valid"); err != nil || ended {
+		t.Errorf("valid session: got %v, %v, want false", ended, err)
+	}
+	set(func() {
+		if fetches != 1 {
+			t.Errorf("fetched %d times, want the list to be cached", fetches)
+		}
+	})
+
+	// Tokens revoked in the meantime are seen after the refresh interval
+	set(func() { list.Jtis = append(list.Jtis, "logged out") })
+	check("logged out", false, nil)
+	time.Sleep(refresh * 3 / 2)
+	check("logged out", true, nil)
+
+	// The last list is used while APR is down, but not for too long
+	set(func() { down = true })
+	time.Sleep(refresh * 3 / 2)
+	check("revoked", true, nil)
+	time.Sleep(3 * refresh)
+	check("valid", false, ErrRevocationsStale)
+
+	set(func() { down = false })
+	time.Sleep(refresh * 3 / 2)
+	check("valid", false, nil)
+}
+
+func TestRevocationListUnreachable(t *testing.T) {
+	apr := httptest.NewServer(http.NotFoundHandler())
+	defer apr.Close()
+	// Without a list nothing can be checked
+	if _, err := NewRevocationList(apr.URL, RevocationOptions{}).IsRevoked("jti"); !errors.Is(err, ErrRevocationsStale) {
+		t.Errorf("got %v, want %v", err, ErrRevocationsStale)
+	}
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles carried in the roles claim of tokens issued by APR
+const (
+	// Companies, and persons acting for them. The subject is the PIB.
+	RoleCompany = "company"
+	// Registrar officials, the subject is their JMBG
+	RoleClerk      = "clerk"
+	RoleSupervisor = "supervisor"
+	RoleAdmin      = "admin"
+	// Can read everything admins can, but change nothing
+	RoleAuditor = "auditor"
+	// Service accounts of other systems, the subject is their client id.
+	// What they may do is limited by their scopes.
+	RoleService = "service"
+)
+
+// Scopes which can be granted to service accounts
+const (
+	// Reading companies, like FindCompanies and FindOne
+	ScopeCompaniesRead = "companies:read"
+	ScopeNstjRead      = "nstj:read"
+)
+
+// HasRole reports whether the token was issued with role
+func (claims TokenClaims) HasRole(role string) bool {
+	for _, r := range claims.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Scopes returns the space separated scope claim as a list
+func (claims TokenClaims) Scopes() []string {
+	return strings.Fields(claims.Scope)
+}
+
+// HasScope reports whether the token was issued with scope
+func (claims TokenClaims) HasScope(scope string) bool {
+	for _, s := range claims.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireRoles only lets through tokens with at least one of roles. It has
+// to run after CheckAuth.
+func RequireRoles(roles ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		claims, ok := GetClaims(ctx)
+		if !ok {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+		for _, role := range roles {
+			if claims.HasRole(role) {
+				return
+			}
+		}
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "requires one of roles " + strings.Join(roles, ", ")})
+	}
+}
+
+// RequireScopes only lets through tokens with every one of scopes. It has to
+// run after CheckAuth.
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		claims, ok := GetClaims(ctx)
+		if !ok {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+		for _, scope := range scopes {
+			if !claims.HasScope(scope) {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "requires scope " + scope})
+				return
+			}
+		}
+	}
+}
+
+// RequireScopesFor lets tokens with role through only on the routes in
+// routeScopes, keyed by method and path like "POST /graphql", and only if
+// they have every scope the route requires. Tokens without role aren't
+// checked. It has to run after CheckAuth.
+func RequireScopesFor(role string, routeScopes map[string][]string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		claims, ok := GetClaims(ctx)
+		if !ok {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+		if !claims.HasRole(role) {
+			return
+		}
+		scopes, ok := routeScopes[ctx.Request.Method+" "+ctx.FullPath()]
+		if !ok {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "route isn't available to role " + role})
+			return
+		}
+		for _, scope := range scopes {
+			if !claims.HasScope(scope) {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "requires scope " + scope})
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireScopesFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name       string
+		claims     TokenClaims
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{"listed route with scope", TokenClaims{Roles: []string{RoleService}, Scope: ScopeNstjRead}, http.MethodGet, "/nstj", http.StatusOK},
+		{"listed route without scope", TokenClaims{Roles: []string{RoleService}, Scope: ScopeCompaniesRead}, http.MethodGet, "/nstj", http.StatusForbidden},
+		// Routes have to be opened to the role explicitly
+		{"unlisted route", TokenClaims{Roles: []string{RoleService}, Scope: ScopeCompaniesRead}, http.MethodPost, "/nstj", http.StatusForbidden},
+		{"other role", TokenClaims{Roles: []string{RoleCompany}}, http.MethodPost, "/nstj", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(func(ctx *gin.Context) { ctx.Set(Claims, tt.claims) })
+			router.Use(RequireScopesFor(RoleService, map[string][]string{"GET /nstj": {ScopeNstjRead}}))
+			ok := func(ctx *gin.Context) { ctx.Status(http.StatusOK) }
+			router.GET("/nstj", ok)
+			router.POST("/nstj", ok)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers sent with every webhook delivery
+const (
+	WebhookSignatureHeader = "X-APR-Signature"
+	WebhookTimestampHeader = "X-APR-Timestamp"
+	WebhookEventHeader     = "X-APR-Event"
+	WebhookDeliveryHeader  = "X-APR-Delivery"
+)
+
+var ErrInvalidWebhookSignature = errors.New("Invalid webhook signature")
+
+// SignWebhook returns the signature of a webhook body sent at timestamp, in
+// the format of the X-APR-Signature header.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature and timestamp headers of a webhook
+// delivery. Deliveries older than tolerance are rejected to prevent replays.
+func VerifyWebhook(secret, signature, timestamp string, body []byte, tolerance time.Duration) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: bad timestamp %q", ErrInvalidWebhookSignature, timestamp)
+	}
+	age := time.Since(time.Unix(ts, 0))
+	if age > tolerance || age < -tolerance {
+		return fmt.Errorf("%w: timestamp outside of tolerance", ErrInvalidWebhookSignature)
+	}
+	if !strings.HasPrefix(signature, "sha256=") {
+		return fmt.Errorf("%w: unknown scheme", ErrInvalidWebhookSignature)
+	}
+	expected := SignWebhook(secret, ts, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidWebhookSignature
+	}
+	return nil
+}
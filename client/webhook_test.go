@@ -0,0 +1,45 @@
+package client
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyWebhook(t *testing.T) {
+	const secret = "subscription secret"
+	body := []byte(`{"id":1,"type":"company.registered"}`)
+	now := time.Now().Unix()
+	signature := SignWebhook(secret, now, body)
+
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		timestamp string
+		body      []byte
+		wantErr   bool
+	}{
+		{"valid", secret, signature, strconv.FormatInt(now, 10), body, false},
+		{"other secret", "other secret", signature, strconv.FormatInt(now, 10), body, true},
+		{"changed body", secret, signature, strconv.FormatInt(now, 10), []byte(`{"id":2,"type":"company.registered"}`), true},
+		{"changed timestamp", secret, signature, strconv.FormatInt(now+1, 10), body, true},
+		{"replayed", secret, SignWebhook(secret, now-600, body), strconv.FormatInt(now-600, 10), body, true},
+		{"from the future", secret, SignWebhook(secret, now+600, body), strconv.FormatInt(now+600, 10), body, true},
+		{"bad timestamp", secret, signature, "yesterday", body, true},
+		{"unknown scheme", secret, "md5=" + signature[len("sha256="):], strconv.FormatInt(now, 10), body, true},
+		{"no signature", secret, "", strconv.FormatInt(now, 10), body, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyWebhook(tt.secret, tt.signature, tt.timestamp, tt.body, 5*time.Minute)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidWebhookSignature) {
+				t.Errorf("got %v, want ErrInvalidWebhookSignature", err)
+			}
+		})
+	}
+}
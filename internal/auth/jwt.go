@@ -4,80 +4,199 @@ import (
 	"apr-backend/client"
 	"crypto/rand"
 	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
 type JwtGenerator interface {
-	SignJwt(claims jwt.RegisteredClaims) (string, error)
-	GenerateAndSignJWT(principal int, audience string) (string, error)
+	SignJwt(claims jwt.Claims) (string, error)
+	GenerateJWT(id Identity, audience string) (string, error)
+	// Signs a token for a registered downstream service
+	GenerateServiceJWT(id Identity, audiences []string, lifetime time.Duration) (string, error)
+	// Signs a token for a person, only accepted by endpoints for
+	// client.AprPerson
+	GeneratePersonJWT(jmbg string) (string, error)
+	// Signs a registry extract as JWS in compact serialization, see
+	// client.VerifyExtract
+	SignExtract(payload []byte) (string, error)
+	// Public keys which currently verify tokens
+	JWKS() client.JWKS
+	// Public keys which verify extracts, also those which no longer verify
+	// tokens
+	ExtractJWKS() client.JWKS
 	client.JwtVerifier
 }
 
-func ReadRSAPrivateKeyFromFile(keyFilePath string) (*rsa.PrivateKey, error) {
-	var key *rsa.PrivateKey
-	keyFile, err := os.Open(keyFilePath)
-	if err != nil {
-		key, err = rsa.GenerateKey(rand.Reader, 4096)
-		if err != nil {
-			return key, fmt.Errorf("No key provided, error generating key: %w", err)
-		}
-		return key, nil
+const keyBits = 4096
+
+// Identity is whom a token is issued to
+type Identity struct {
+	// PIB of a company, or JMBG of an official
+	Subject string
+	// JMBG of the person acting for the company, empty if the company
+	// itself logged in
+	Actor string
+	Roles []string
+	// Company the subject is, nil for officials
+	Company *client.CompanyClaims
+	Scopes  []string
+	// Authentication methods of the session, see client.AmrPassword
+	Amr []string
+	// Refresh token family of the session, the sid claim
+	Session string
+}
+
+// Access tokens are short lived, sessions are kept alive with refresh tokens
+const AccessTokenLifetime = 15 * time.Minute
+
+// NewTokenId returns a random jti
+func NewTokenId() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("Error generating token id: %w", err)
 	}
-	defer keyFile.Close()
-	keyData, err := ioutil.ReadAll(keyFile)
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// GenerateKey is the default KeyGenerator
+func GenerateKey() (*rsa.PrivateKey, error) {
+	return rsa.GenerateKey(rand.Reader, keyBits)
+}
+
+// ReadRSAPrivateKeyFromFile reads a PEM encoded key. Missing files are an
+// error, wrapping os.ErrNotExist.
+func ReadRSAPrivateKeyFromFile(keyFilePath string) (*rsa.PrivateKey, error) {
+	keyData, err := ioutil.ReadFile(keyFilePath)
 	if err != nil {
 		return nil, fmt.Errorf("Error reading from key file: %w", err)
 	}
-	key, err = jwt.ParseRSAPrivateKeyFromPEM(keyData)
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(keyData)
 	if err != nil {
 		return key, fmt.Errorf("Error parsing PMA encoded key: %w", err)
 	}
 	return key, nil
 }
 
-func NewJwtGenerator(key *rsa.PrivateKey) JwtGenerator {
+// NewJwtGenerator signs with the active key of keys, and verifies with any
+// key it still holds.
+func NewJwtGenerator(keys *Keyring) JwtGenerator {
 	generator := jwtGeneratorRsa{
-		key:         key,
+		keys:        keys,
 		serviceName: client.Apr,
-		JwtVerifier: client.NewVerifier(key.Public()),
+		JwtVerifier: client.NewKeyLookupVerifier(keys.PublicKey, 0),
 	}
 	return generator
 }
 
 type jwtGeneratorRsa struct {
-	key         *rsa.PrivateKey
+	keys        *Keyring
 	serviceName string
 	client.JwtVerifier
 }
 
-// GenerateAndSignJWT implements JwtGenerator
-func (jwtGen jwtGeneratorRsa) GenerateAndSignJWT(principal int, audience string) (string, error) {
-
+// GenerateJWT implements JwtGenerator
+func (jwtGen jwtGeneratorRsa) GenerateJWT(id Identity, audience string) (string, error) {
 	aud := client.Apr
 	if audience != "" {
 		aud = audience
 	}
+	claims, err := newClaims(id.Subject, aud)
+	if err != nil {
+		return "", err
+	}
+	return jwtGen.signIdentity(claims, id)
+}
 
-	claims := jwt.RegisteredClaims{
-		Issuer:    client.Apr,
-		Subject:   fmt.Sprint(principal),
-		Audience:  []string{aud},
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
+// GenerateServiceJWT implements JwtGenerator
+func (jwtGen jwtGeneratorRsa) GenerateServiceJWT(id Identity, audiences []string, lifetime time.Duration) (string, error) {
+	claims, err := newClaims(id.Subject, "")
+	if err != nil {
+		return "", err
+	}
+	claims.Audience = audiences
+	claims.ExpiresAt = jwt.NewNumericDate(claims.IssuedAt.Add(lifetime))
+	return jwtGen.signIdentity(claims, id)
+}
+
+func (jwtGen jwtGeneratorRsa) signIdentity(claims client.TokenClaims, id Identity) (string, error) {
+	if id.Actor != "" {
+		claims.Act = &client.Actor{Subject: id.Actor}
 	}
+	claims.Roles = id.Roles
+	claims.Company = id.Company
+	claims.Scope = strings.Join(id.Scopes, " ")
+	claims.Amr = id.Amr
+	claims.Sid = id.Session
 	return jwtGen.SignJwt(claims)
 }
 
-func (jwtGen jwtGeneratorRsa) SignJwt(claims jwt.RegisteredClaims) (string, error) {
+// GeneratePersonJWT implements JwtGenerator
+func (jwtGen jwtGeneratorRsa) GeneratePersonJWT(jmbg string) (string, error) {
+	claims, err := newClaims(jmbg, client.AprPerson)
+	if err != nil {
+		return "", err
+	}
+	return jwtGen.SignJwt(claims)
+}
+
+func newClaims(subject string, audience string) (client.TokenClaims, error) {
+	jti, err := NewTokenId()
+	if err != nil {
+		return client.TokenClaims{}, err
+	}
+	return client.TokenClaims{RegisteredClaims: jwt.RegisteredClaims{
+		ID:        jti,
+		Issuer:    client.Apr,
+		Subject:   subject,
+		Audience:  []string{audience},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenLifetime)),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+	}}, nil
+}
+
+func (jwtGen jwtGeneratorRsa) SignJwt(claims jwt.Claims) (string, error) {
+	kid, key := jwtGen.keys.Signer()
 	token := jwt.NewWithClaims(jwt.SigningMethodRS512, claims)
-	signed, err := token.SignedString(jwtGen.key)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
 	if err != nil {
 		return "", fmt.Errorf("Error signing token: %w", err)
 	}
 	return signed, nil
 }
+
+// SignExtract implements JwtGenerator
+func (jwtGen jwtGeneratorRsa) SignExtract(payload []byte) (string, error) {
+	kid, key := jwtGen.keys.Signer()
+	header, err := json.Marshal(client.JwsHeader{
+		Alg: jwt.SigningMethodRS512.Alg(),
+		Typ: "JOSE",
+		Cty: client.ExtractContentType,
+		Kid: kid,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error encoding extract header: %w", err)
+	}
+	signingString := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature, err := jwt.SigningMethodRS512.Sign(signingString, key)
+	if err != nil {
+		return "", fmt.Errorf("Error signing extract: %w", err)
+	}
+	return signingString + "." + signature, nil
+}
+
+// JWKS implements JwtGenerator
+func (jwtGen jwtGeneratorRsa) JWKS() client.JWKS {
+	return jwtGen.keys.JWKS()
+}
+
+// ExtractJWKS implements JwtGenerator
+func (jwtGen jwtGeneratorRsa) ExtractJWKS() client.JWKS {
+	return jwtGen.keys.ExtractJWKS()
+}
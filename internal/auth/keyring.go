@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"apr-backend/client"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var ErrUnknownKey = errors.New("Unknown signing key")
+
+// KeyGenerator creates the next signing key when keys are rotated
+type KeyGenerator func() (*rsa.PrivateKey, error)
+
+type signingKey struct {
+	kid string
+	key *rsa.PrivateKey
+	// Zero for keys which verify until they are removed by hand
+	expiresAt time.Time
+}
+
+func newSigningKey(key *rsa.PrivateKey, expiresAt time.Time) signingKey {
+	return signingKey{kid: client.Thumbprint(&key.PublicKey), key: key, expiresAt: expiresAt}
+}
+
+// Keyring holds the key which signs new tokens and the retired keys which
+// still verify tokens issued before a rotation. Keys are identified by
+// their kid. Once retired keys expire, their public keys are kept to verify
+// the registry extracts they signed.
+type Keyring struct {
+	mu      sync.RWMutex
+	active  signingKey
+	retired []signingKey
+	// Public keys of expired keys, they only verify extracts
+	expired []*rsa.PublicKey
+}
+
+// NewKeyring signs with active and keeps accepting tokens signed with any of
+// retired.
+func NewKeyring(active *rsa.PrivateKey, retired ...*rsa.PrivateKey) *Keyring {
+	kr := &Keyring{active: newSigningKey(active, time.Time{})}
+	for _, key := range retired {
+		kr.retired = append(kr.retired, newSigningKey(key, time.Time{}))
+	}
+	return kr
+}
+
+// RetiredKey is a key which no longer signs, but verifies until ExpiresAt.
+// A zero ExpiresAt never expires.
+type RetiredKey struct {
+	Key       *rsa.PrivateKey
+	ExpiresAt time.Time
+}
+
+// Reset replaces every key in the keyring
+func (kr *Keyring) Reset(active *rsa.PrivateKey, retired []RetiredKey, expired []*rsa.PublicKey) {
+	keys := make([]signingKey, 0, len(retired))
+	for _, rk := range retired {
+		keys = append(keys, newSigningKey(rk.Key, rk.ExpiresAt))
+	}
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.active = newSigningKey(active, time.Time{})
+	kr.retired = keys
+	kr.expired = expired
+}
+
+// Signer returns the active key and its kid
+func (kr *Keyring) Signer() (string, *rsa.PrivateKey) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.active.kid, kr.active.key
+}
+
+// PublicKey implements client.KeyLookup. Tokens without a kid were issued
+// before keys had one, they are checked against the active key.
+func (kr *Keyring) PublicKey(kid string) (crypto.PublicKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	if kid == "" || kid == kr.active.kid {
+		return kr.active.key.Public(), nil
+	}
+	now := time.Now()
+	for _, sk := range kr.retired {
+		if sk.kid == kid && (sk.expiresAt.IsZero() || now.Before(sk.expiresAt)) {
+			return sk.key.Public(), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+}
+
+// Rotate makes key the active key. The previously active key keeps
+// verifying for grace, which should be longer than the lifetime of tokens.
+func (kr *Keyring) Rotate(key *rsa.PrivateKey, grace time.Duration) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	now := time.Now()
+	retired := []signingKey{}
+	for _, sk := range kr.retired {
+		if sk.expiresAt.IsZero() || now.Before(sk.expiresAt) {
+			retired = append(retired, sk)
+		} else {
+			kr.expired = append(kr.expired, &sk.key.PublicKey)
+		}
+	}
+	old := kr.active
+	old.expiresAt = now.Add(grace)
+	kr.retired = append(retired, old)
+	kr.active = newSigningKey(key, time.Time{})
+}
+
+// JWKS returns the public keys of every key which currently verifies
+// tokens, the active one first.
+func (kr *Keyring) JWKS() client.JWKS {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	alg := jwt.SigningMethodRS512.Alg()
+	jwks := client.JWKS{Keys: []client.JWK{client.NewJWK(&kr.active.key.PublicKey, alg)}}
+	now := time.Now()
+	for _, sk := range kr.retired {
+		if sk.expiresAt.IsZero() || now.Before(sk.expiresAt) {
+			jwks.Keys = append(jwks.Keys, client.NewJWK(&sk.key.PublicKey, alg))
+		}
+	}
+	return jwks
+}
+
+// ExtractJWKS returns the public keys of every key which signed registry
+// extracts, including expired ones, the active one first.
+func (kr *Keyring) ExtractJWKS() client.JWKS {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	alg := jwt.SigningMethodRS512.Alg()
+	jwks := client.JWKS{Keys: []client.JWK{client.NewJWK(&kr.active.key.PublicKey, alg)}}
+	for _, sk := range kr.retired {
+		jwks.Keys = append(jwks.Keys, client.NewJWK(&sk.key.PublicKey, alg))
+	}
+	for _, key := range kr.expired {
+		jwks.Keys = append(jwks.Keys, client.NewJWK(key, alg))
+	}
+	return jwks
+}
+
+// RunRotation replaces the active key with one from generate every
+// interval, until ctx is done.
+func (kr *Keyring) RunRotation(ctx context.Context, interval, grace time.Duration, generate KeyGenerator) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		key, err := generate()
+		if err != nil {
+			log.Printf("Error generating signing key, keeping the current one: %s", err.Error())
+			continue
+		}
+		kr.Rotate(key, grace)
+		kid, _ := kr.Signer()
+		log.Printf("Rotated signing key, new kid %s", kid)
+	}
+}
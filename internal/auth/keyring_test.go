@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"apr-backend/client"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+)
+
+func testKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func kids(jwks client.JWKS) map[string]bool {
+	kids := map[string]bool{}
+	for _, key := range jwks.Keys {
+		kids[key.Kid] = true
+	}
+	return kids
+}
+
+func TestKeyringKeepsExtractKeys(t *testing.T) {
+	first, second, third := testKey(t), testKey(t), testKey(t)
+	// Retired keys which expire right away are dropped on the next rotation
+	kr := NewKeyring(first)
+	kr.Rotate(second, 0)
+	kr.Rotate(third, 0)
+	kr.Rotate(testKey(t), time.Hour)
+
+	tests := []struct {
+		name       string
+		key        *rsa.PrivateKey
+		wantTokens bool
+	}{
+		{"expired", first, false},
+		{"expired on the last rotation", second, false},
+		{"retired", third, true},
+	}
+	tokenKids, extractKids := kids(kr.JWKS()), kids(kr.ExtractJWKS())
+	for _, tt := range tests {
+		kid := client.Thumbprint(&tt.key.PublicKey)
+		if tokenKids[kid] != tt.wantTokens {
+			t.Errorf("%s key verifies tokens: %v, want %v", tt.name, tokenKids[kid], tt.wantTokens)
+		}
+		if !extractKids[kid] {
+			t.Errorf("%s key doesn't verify extracts", tt.name)
+		}
+	}
+	if len(extractKids) != 4 {
+		t.Errorf("got %d extract keys, want 4", len(extractKids))
+	}
+}
+
+func TestExtractSignedBeforeRotation(t *testing.T) {
+	key := testKey(t)
+	kr := NewKeyring(key)
+	jwtGen := NewJwtGenerator(kr)
+	signed, err := jwtGen.SignExtract([]byte(`{"code":"ABC"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr.Rotate(testKey(t), 0)
+	kr.Rotate(testKey(t), 0)
+
+	var pubKey *rsa.PublicKey
+	for _, jwk := range jwtGen.ExtractJWKS().Keys {
+		if jwk.Kid == client.Thumbprint(&key.PublicKey) {
+			pubKey, err = jwk.PublicKey()
+		}
+	}
+	if pubKey == nil || err != nil {
+		t.Fatalf("no key for the extract: %v", err)
+	}
+	var payload map[string]string
+	if err := client.VerifyExtract(signed, pubKey, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload["code"] != "ABC" {
+		t.Errorf("got %v", payload)
+	}
+}
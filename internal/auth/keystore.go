@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	saltSize = 16
+	// scrypt parameters recommended for interactive logins as of 2017
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	// How often replicas pick up keys rotated by others
+	keySyncInterval = time.Minute
+	// Rotated keys are published this long before they sign, so that every
+	// replica verifies them and serves them in its JWKS first
+	keyActivationDelay = 2 * keySyncInterval
+)
+
+// Binds ciphertexts to their purpose
+var keyAad = []byte("apr signing key")
+
+var ErrKeyDecryption = errors.New("Couldn't decrypt signing key, is the passphrase right?")
+
+// KeyStore keeps signing keys in the database, encrypted with a key derived
+// from a passphrase. Keys are generated once and shared by every replica.
+type KeyStore struct {
+	repo       db.SigningKeyRepository
+	passphrase []byte
+
+	mu sync.Mutex
+	// Decrypted keys by kid, derivation is deliberately slow
+	cache map[string]*rsa.PrivateKey
+}
+
+func NewKeyStore(repo db.SigningKeyRepository, passphrase []byte) *KeyStore {
+	return &KeyStore{
+		repo:       repo,
+		passphrase: passphrase,
+		cache:      make(map[string]*rsa.PrivateKey),
+	}
+}
+
+func (ks *KeyStore) encrypt(key *rsa.PrivateKey) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("Error generating salt: %w", err)
+	}
+	aead, err := ks.cipher(salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("Error generating nonce: %w", err)
+	}
+	out := append(salt, nonce...)
+	return aead.Seal(out, nonce, x509.MarshalPKCS1PrivateKey(key), keyAad), nil
+}
+
+func (ks *KeyStore) decrypt(data []byte) (*rsa.PrivateKey, error) {
+	if len(data) < saltSize {
+		return nil, ErrKeyDecryption
+	}
+	aead, err := ks.cipher(data[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	data = data[saltSize:]
+	if len(data) < aead.NonceSize() {
+		return nil, ErrKeyDecryption
+	}
+	der, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], keyAad)
+	if err != nil {
+		return nil, ErrKeyDecryption
+	}
+	key, err := x509.ParsePKCS1PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing signing key: %w", err)
+	}
+	return key, nil
+}
+
+func (ks *KeyStore) cipher(salt []byte) (cipher.AEAD, error) {
+	secret, err := scrypt.Key(ks.passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Error deriving key: %w", err)
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func (ks *KeyStore) key(stored model.SigningKey) (*rsa.PrivateKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if key, ok := ks.cache[stored.Kid]; ok {
+		return key, nil
+	}
+	key, err := ks.decrypt(stored.Encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("Key %s: %w", stored.Kid, err)
+	}
+	ks.cache[stored.Kid] = key
+	return key, nil
+}
+
+// rotate generates a key and stores it as active from activatesAt, unless
+// the active key is newer than notAfter.
+func (ks *KeyStore) rotate(notAfter time.Time, grace time.Duration, activatesAt time.Time) (bool, error) {
+	key, err := GenerateKey()
+	if err != nil {
+		return false, fmt.Errorf("Error generating signing key: %w", err)
+	}
+	encrypted, err := ks.encrypt(key)
+	if err != nil {
+		return false, err
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return false, fmt.Errorf("Error encoding public key: %w", err)
+	}
+	return ks.repo.Rotate(model.SigningKey{
+		Kid:         client.Thumbprint(&key.PublicKey),
+		Encrypted:   encrypted,
+		Public:      public,
+		CreatedAt:   time.Now().UTC(),
+		ActivatesAt: activatesAt,
+	}, notAfter, grace)
+}
+
+// load reads every valid key, generating the first one if there are none.
+func (ks *KeyStore) load() ([]model.SigningKey, error) {
+	stored, err := ks.repo.FindValid()
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) > 0 && stored[0].ExpiresAt.IsZero() {
+		return stored, nil
+	}
+	// Replicas starting together all try, the first one's key is kept
+	generated, err := ks.rotate(time.Time{}, 0, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	if generated {
+		log.Println("Generated a new signing key")
+	}
+	return ks.repo.FindValid()
+}
+
+// keys returns the key which signs and the other keys, which only verify.
+// Keys which haven't activated yet verify until they sign.
+func (ks *KeyStore) keys(stored []model.SigningKey) (*rsa.PrivateKey, []RetiredKey, error) {
+	signing := 0
+	now := time.Now()
+	for i, sk := range stored {
+		if !sk.ActivatesAt.After(now) {
+			signing = i
+			break
+		}
+	}
+	active, err := ks.key(stored[signing])
+	if err != nil {
+		return nil, nil, err
+	}
+	var retired []RetiredKey
+	for i, sk := range stored {
+		if i == signing {
+			continue
+		}
+		key, err := ks.key(sk)
+		if err != nil {
+			return nil, nil, err
+		}
+		retired = append(retired, RetiredKey{Key: key, ExpiresAt: sk.ExpiresAt})
+	}
+	return active, retired, nil
+}
+
+// expired returns the public keys of expired keys
+func (ks *KeyStore) expired() ([]*rsa.PublicKey, error) {
+	stored, err := ks.repo.FindExpired()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*rsa.PublicKey, 0, len(stored))
+	for _, sk := range stored {
+		if sk.Public == nil {
+			key, err := ks.key(sk)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, &key.PublicKey)
+			continue
+		}
+		public, err := x509.ParsePKIXPublicKey(sk.Public)
+		if err != nil {
+			return nil, fmt.Errorf("Public key %s: %w", sk.Kid, err)
+		}
+		key, ok := public.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("Public key %s isn't an RSA key", sk.Kid)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Keyring returns a keyring with the stored keys. The first key is
+// generated and stored if there are none yet.
+func (ks *KeyStore) Keyring() (*Keyring, error) {
+	stored, err := ks.load()
+	if err != nil {
+		return nil, err
+	}
+	active, retired, err := ks.keys(stored)
+	if err != nil {
+		return nil, err
+	}
+	expired, err := ks.expired()
+	if err != nil {
+		return nil, err
+	}
+	kr := NewKeyring(active)
+	kr.Reset(active, retired, expired)
+	return kr, nil
+}
+
+// Run keeps kr up to date with the stored keys until ctx is done. If
+// rotateEvery isn't zero, the active key is replaced once it is older than
+// that, and the previous key verifies for grace after the new one signs.
+func (ks *KeyStore) Run(ctx context.Context, kr *Keyring, rotateEvery, grace time.Duration) {
+	ticker := time.NewTicker(keySyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := ks.sync(kr, rotateEvery, grace); err != nil {
+			log.Printf("Error loading signing keys: %s", err.Error())
+		}
+	}
+}
+
+// sync rotates the active key if it is older than rotateEvery and resets kr
+// to the stored keys
+func (ks *KeyStore) sync(kr *Keyring, rotateEvery, grace time.Duration) error {
+	stored, err := ks.load()
+	if err != nil {
+		return err
+	}
+	if rotateEvery > 0 && time.Since(stored[0].CreatedAt) > rotateEvery {
+		now := time.Now()
+		rotated, err := ks.rotate(now.Add(-rotateEvery), grace, now.Add(keyActivationDelay))
+		if err != nil {
+			log.Printf("Error rotating signing key, keeping the current one: %s", err.Error())
+		}
+		if rotated {
+			log.Printf("Rotated signing key, the new one signs from %s", now.Add(keyActivationDelay).Format(time.RFC3339))
+		}
+		if stored, err = ks.load(); err != nil {
+			return err
+		}
+	}
+	active, retired, err := ks.keys(stored)
+	if err != nil {
+		return err
+	}
+	expired, err := ks.expired()
+	if err != nil {
+		return fmt.Errorf("Error loading expired signing keys: %w", err)
+	}
+	kr.Reset(active, retired, expired)
+	return nil
+}
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// keyTable keeps signing keys in memory, following the contract of
+// db.SigningKeyRepository
+type keyTable struct {
+	mu   sync.Mutex
+	keys []model.SigningKey
+}
+
+func (kt *keyTable) find(match func(model.SigningKey) bool) []model.SigningKey {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	keys := []model.SigningKey{}
+	for _, key := range kt.keys {
+		if match(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		if keys[i].ExpiresAt.IsZero() != keys[j].ExpiresAt.IsZero() {
+			return keys[i].ExpiresAt.IsZero()
+		}
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+func (kt *keyTable) FindValid() ([]model.SigningKey, error) {
+	now := time.Now()
+	return kt.find(func(key model.SigningKey) bool { return key.ExpiresAt.IsZero() || key.ExpiresAt.After(now) }), nil
+}
+
+func (kt *keyTable) FindExpired() ([]model.SigningKey, error) {
+	now := time.Now()
+	return kt.find(func(key model.SigningKey) bool { return !key.ExpiresAt.IsZero() && !key.ExpiresAt.After(now) }), nil
+}
+
+func (kt *keyTable) Rotate(key model.SigningKey, notAfter time.Time, grace time.Duration) (bool, error) {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	now := time.Now()
+	activatesAt := now
+	if key.ActivatesAt.After(now) {
+		activatesAt = key.ActivatesAt
+	}
+	for i, stored := range kt.keys {
+		if stored.ExpiresAt.IsZero() {
+			if stored.CreatedAt.After(notAfter) {
+				return false, nil
+			}
+			kt.keys[i].ExpiresAt = activatesAt.Add(grace)
+		}
+	}
+	key.ActivatesAt = activatesAt
+	kt.keys = append(kt.keys, key)
+	return true, nil
+}
+
+// activate makes keys which wait to sign activate now
+func (kt *keyTable) activate() {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	now := time.Now()
+	for i := range kt.keys {
+		if kt.keys[i].ActivatesAt.After(now) {
+			kt.keys[i].ActivatesAt = now
+		}
+	}
+}
+
+var _ db.SigningKeyRepository = &keyTable{}
+
+func TestKeyEncryption(t *testing.T) {
+	ks := NewKeyStore(&keyTable{}, []byte("lozinka"))
+	key := testKey(t)
+	encrypted, err := ks.encrypt(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := ks.decrypt(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decrypted.Equal(key) {
+		t.Error("decrypted key differs from the encrypted one")
+	}
+
+	if _, err := NewKeyStore(&keyTable{}, []byte("pogresna")).decrypt(encrypted); !errors.Is(err, ErrKeyDecryption) {
+		t.Errorf("wrong passphrase: got %v, want %v", err, ErrKeyDecryption)
+	}
+	tampered := append([]byte{}, encrypted...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := ks.decrypt(tampered); !errors.Is(err, ErrKeyDecryption) {
+		t.Errorf("tampered key: got %v, want %v", err, ErrKeyDecryption)
+	}
+	if _, err := ks.decrypt(encrypted[:saltSize]); !errors.Is(err, ErrKeyDecryption) {
+		t.Errorf("truncated key: got %v, want %v", err, ErrKeyDecryption)
+	}
+}
+
+func TestKeyStoreRotate(t *testing.T) {
+	table := &keyTable{}
+	ks := NewKeyStore(table, []byte("lozinka"))
+	kr, err := ks.Keyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := kr.Signer()
+	// Restarts keep the stored key
+	if again, err := ks.Keyring(); err != nil {
+		t.Fatal(err)
+	} else if kid, _ := again.Signer(); kid != first {
+		t.Errorf("got kid %s after a restart, want %s", kid, first)
+	}
+
+	// Keys which aren't older than rotateEvery are kept
+	if err := ks.sync(kr, time.Hour, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if len(table.keys) != 1 {
+		t.Fatalf("got %d keys, want the first one only", len(table.keys))
+	}
+	if err := ks.sync(kr, time.Nanosecond, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if len(table.keys) != 2 {
+		t.Fatalf("got %d keys, want a rotated one", len(table.keys))
+	}
+	second := table.keys[1].Kid
+	// The new key is published before it signs
+	if kid, _ := kr.Signer(); kid != first {
+		t.Errorf("got signer %s before the new key activated, want %s", kid, first)
+	}
+	if !kids(kr.JWKS())[second] {
+		t.Error("JWKS lacks the key which signs next")
+	}
+
+	table.activate()
+	if err := ks.sync(kr, time.Hour, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if kid, _ := kr.Signer(); kid != second {
+		t.Errorf("got signer %s after the new key activated, want %s", kid, second)
+	}
+	// Tokens signed with the first key verify for grace
+	if _, err := kr.PublicKey(first); err != nil {
+		t.Errorf("replaced key: %v", err)
+	}
+}
+
+func TestKeyStoreSync(t *testing.T) {
+	table := &keyTable{}
+	// Two replicas share the table
+	rotating, other := NewKeyStore(table, []byte("lozinka")), NewKeyStore(table, []byte("lozinka"))
+	rotatingKeys, err := rotating.Keyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKeys, err := other.Keyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatingKid, _ := rotatingKeys.Signer()
+	if kid, _ := otherKeys.Signer(); kid != rotatingKid {
+		t.Fatalf("replicas sign with %s and %s, want the same key", rotatingKid, kid)
+	}
+
+	if err := rotating.sync(rotatingKeys, time.Nanosecond, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	next := table.keys[1].Kid
+	// The other replica learns the key at its next sync, before it signs
+	if err := other.sync(otherKeys, time.Hour, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otherKeys.PublicKey(next); err != nil {
+		t.Errorf("other replica: %v", err)
+	}
+	if !kids(otherKeys.JWKS())[next] {
+		t.Error("JWKS of the other replica lacks the key which signs next")
+	}
+	// Both keep signing with the old key meanwhile
+	for _, kr := range []*Keyring{rotatingKeys, otherKeys} {
+		if kid, _ := kr.Signer(); kid != rotatingKid {
+			t.Errorf("got signer %s before the new key activated, want %s", kid, rotatingKid)
+		}
+	}
+	if _, err := otherKeys.PublicKey(client.Thumbprint(&testKey(t).PublicKey)); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unknown kid: got %v, want %v", err, ErrUnknownKey)
+	}
+}
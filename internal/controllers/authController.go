@@ -4,9 +4,13 @@ import (
 	"apr-backend/client"
 	"apr-backend/internal/auth"
 	"apr-backend/internal/db"
+	"apr-backend/internal/lockout"
 	"apr-backend/internal/model"
+	"apr-backend/internal/ratelimit"
 	"apr-backend/internal/services"
+	"errors"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
 
@@ -14,18 +18,59 @@ import (
 	"github.com/gin-gonic/gin/binding"
 )
 
-// NewAuthController creates a new AuthController
-func NewAuthController(authServ services.AuthService, gen auth.JwtGenerator) AuthController {
-	return AuthController{authServ: authServ, jwtGenerator: gen}
+// NewAuthController creates a new AuthController. resetLimiter limits
+// password reset requests.
+func NewAuthController(authServ services.AuthService, catalogueServ services.CatalogueService, gen auth.JwtGenerator, resetLimiter *ratelimit.Limiter) AuthController {
+	return AuthController{authServ: authServ, catalogueServ: catalogueServ, jwtGenerator: gen, resetLimiter: resetLimiter}
 }
 
 type AuthController struct {
-	authServ     services.AuthService
-	jwtGenerator auth.JwtGenerator
+	authServ      services.AuthService
+	catalogueServ services.CatalogueService
+	jwtGenerator  auth.JwtGenerator
+	resetLimiter  *ratelimit.Limiter
+}
+
+// Tokens issued on login
+// swagger:response tokenPairRes
+type tokenPairRes struct {
+	// in: body
+	Body model.TokenPair
+}
+
+// tooManyAttempts responds with 429 if err is a lockout.RetryError
+func tooManyAttempts(c *gin.Context, err error) bool {
+	var retry lockout.RetryError
+	if !errors.As(err, &retry) {
+		return false
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retry.RetryAfter.Seconds()))))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, ErrorResponse{Error: retry.Error()})
+	return true
+}
+
+// rateLimited responds with 429 if err is a ratelimit.LimitError
+func rateLimited(c *gin.Context, err error) bool {
+	var limit ratelimit.LimitError
+	if !errors.As(err, &limit) {
+		return false
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(limit.RetryAfter.Seconds()))))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, ErrorResponse{Error: limit.Error()})
+	return true
+}
+
+// Challenge of the second factor, returned by login instead of tokens
+// swagger:response mfaChallengeRes
+type mfaChallengeRes struct {
+	// in: body
+	Body model.MfaChallenge
 }
 
 // swagger:route POST /api/auth/login/ auth LoginUser
-// Used for user authorization.
+// Used for user authorization. Returns a short lived access token and a
+// refresh token to renew it with. Companies with a second factor get a
+// challenge instead, which is completed at /api/auth/login/mfa.
 //
 // Parameters:
 // +name: credentials
@@ -34,8 +79,10 @@ type AuthController struct {
 // description: credentials with which to login
 //
 // Responses:
-// 201: jwtResponse
-// 400: errRes
+// 200: tokenPairRes
+// 202: mfaChallengeRes
+// 401: errRes
+// 429: errRes
 // 500: errRes
 func (controller AuthController) Login(c *gin.Context) {
 	var creds model.CredentialsDto
@@ -43,58 +90,333 @@ func (controller AuthController) Login(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if err := controller.authServ.CheckCredentials(creds); err != nil {
-		switch err {
-		case db.DatabaseError:
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-			return
-		default:
-			log.Println(err.Error())
-			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
+	tokens, challenge, err := controller.authServ.Login(creds, c.ClientIP())
+	if tooManyAttempts(c, err) {
+		return
+	}
+	if errors.Is(err, db.DatabaseError) {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
+		return
+	}
+
+	if challenge != nil {
+		c.JSON(http.StatusAccepted, challenge)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// swagger:route POST /api/auth/login/mfa auth LoginMfa
+// Completes a login with a code of the second factor, or with a recovery
+// code. Wrong codes count as failed logins. Every challenge can be
+// completed once.
+//
+// Parameters:
+// +name: login
+// in: body
+// type: mfaLoginRequest
+//
+// Responses:
+// 200: tokenPairRes
+// 400: errRes
+// 401: errRes
+// 429: errRes
+// 500: errRes
+func (controller AuthController) LoginMfa(c *gin.Context) {
+	var req model.MfaLoginRequest
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide challenge and code"})
+		return
+	}
+	tokens, err := controller.authServ.CompleteLogin(req.Challenge, req.Code, c.ClientIP())
+	if tooManyAttempts(c, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrInvalidChallenge), errors.Is(err, services.ErrInvalidCode), errors.Is(err, services.ErrMfaNotEnrolled):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// swagger:route POST /api/auth/refresh auth Refresh
+// Exchanges a refresh token for a new access and refresh token. Every
+// refresh token can be used once, using it again ends the session.
+//
+// Parameters:
+// +name: refresh
+// in: body
+// type: refreshRequest
+//
+// Responses:
+// 200: tokenPairRes
+// 400: errRes
+// 401: errRes
+// 500: errRes
+func (controller AuthController) Refresh(c *gin.Context) {
+	var req model.RefreshRequest
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide refreshToken"})
+		return
+	}
+	tokens, err := controller.authServ.Refresh(req.RefreshToken, "")
+	switch {
+	case errors.Is(err, db.RefreshTokenReusedError):
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "Refresh token was already used, the session has been ended"})
+		return
+	case errors.Is(err, db.NoSuchRefreshTokenError):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid refresh token"})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// swagger:route POST /api/auth/logout auth Logout
+// Revokes the access token the request is made with and, if given, the
+// refresh token of the session.
+//
+// Parameters:
+// +name: logout
+// in: body
+// type: logoutRequest
+// required: false
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: succRes
+// 400: errRes
+// 401: errRes
+// 500: errRes
+func (controller AuthController) Logout(c *gin.Context) {
+	var req model.LogoutRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide valid JSON"})
 			return
 		}
 	}
+	claims := c.MustGet(client.Claims).(client.TokenClaims)
+	if err := controller.authServ.Logout(claims, req.RefreshToken); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Success: "Logged out"})
+}
+
+// swagger:route GET /api/auth/login/{service} auth SSOLogin
+// Generate SSO token for a registered service. The token has the audiences
+// and lifetime the service was registered with.
+//
+// Parameters:
+// +name: service
+// in: path
+// description: name of service for which to generate JWT
+// required: true
+// type: string
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: jwtRes
+// 401: errRes
+// 403: errRes
+// 404: errRes
+// 500: errRes
+func (controller AuthController) SSOLogin(c *gin.Context) {
+	// The token for the service is issued to whoever this one was
+	claims := c.MustGet(client.Claims).(client.TokenClaims)
+	ssoToken, err := controller.catalogueServ.Issue(claims, c.Param("service"))
+	switch {
+	case errors.Is(err, db.NoSuchServiceError):
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	case errors.Is(err, services.ErrServiceForbidden):
+		c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Couldn't generate JWT"})
+		return
+	}
+	c.JSON(http.StatusOK, JwtResponse{Jwt: ssoToken})
+}
 
-	token, err := controller.jwtGenerator.GenerateAndSignJWT(creds.PIB, client.Apr)
+// swagger:route GET /.well-known/jwks.json auth JWKS
+// Public keys which verify tokens issued by APR. Keys are identified by the
+// kid header of a token. Signed extracts may outlive these keys, their keys
+// are at /api/extract/keys.
+//
+// Responses:
+// 200: jwks
+func (controller AuthController) JWKS(c *gin.Context) {
+	// Keys are rotated far less often, but clients shouldn't miss a new one
+	// for long
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, controller.jwtGenerator.JWKS())
+}
+
+// swagger:route GET /api/auth/revocations auth Revocations
+// Access tokens revoked before they expire, by jti, and sessions which
+// ended, by the sid of their tokens. Services which verify tokens
+// themselves fetch it through client.NewRevocationList.
+//
+// Responses:
+// 200: revocationList
+// 500: errRes
+func (controller AuthController) Revocations(c *gin.Context) {
+	list, err := controller.authServ.Revocations()
 	if err != nil {
-		log.Printf("error: %s\n", err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Couldn't read revocations"})
 		return
 	}
-
-	c.JSON(http.StatusOK, JwtResponse{Jwt: token})
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, list)
 }
 
-// swagger:route POST /api/auth/login/:service auth SSOLogin
-// Generate SSO token for service.
+// swagger:route POST /api/auth/password auth ChangePassword
+// Changes the password of the logged-in company and ends its sessions,
+// including the one of this request. Their access tokens are rejected by
+// APR and by services checking revoked sessions, others accept them until
+// they expire within 15 minutes. Admins can require a login with a second
+// factor for it.
 //
 // Parameters:
-//   - name: service
-//     in: path
-//     description: name of service for which to generate JWT
-//     required: false
-//     type: integer
-//     format: string
+// +name: passwords
+// in: body
+// type: changePasswordRequest
 //
 // Security:
 //   - bearerAuth:
 //
 // Responses:
-// 201: jwtResponse
+// 200: succRes
 // 400: errRes
+// 401: errRes
+// 403: errRes
+// 429: errRes
 // 500: errRes
-func (controller AuthController) SSOLogin(c *gin.Context) {
-	serviceName := c.Param("service")
-	principal := c.GetString("principal")
-	pib, err := strconv.Atoi(principal)
+func (controller AuthController) ChangePassword(c *gin.Context) {
+	pib, err := strconv.Atoi(c.GetString(client.Principal))
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid JWT"})
 		return
 	}
-	ssoToken, err := controller.jwtGenerator.GenerateAndSignJWT(pib, serviceName)
+	var req model.ChangePasswordRequest
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide oldPassword and newPassword"})
+		return
+	}
+
+	err = controller.authServ.ChangePassword(pib, req.OldPassword, req.NewPassword, c.ClientIP())
+	if tooManyAttempts(c, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrWrongPassword):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "Wrong old password"})
+		return
+	case errors.Is(err, services.ErrInvalidPassword):
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Success: "Password changed"})
+}
+
+// swagger:route POST /api/auth/password/reset auth RequestPasswordReset
+// Sends a password reset token to the company. The response is the same
+// whether the company exists or not. Requests are limited by client IP and by
+// PIB.
+//
+// Parameters:
+// +name: company
+// in: body
+// type: passwordResetRequest
+//
+// Responses:
+// 202: succRes
+// 400: errRes
+// 429: errRes
+// 500: errRes
+// 501: errRes
+func (controller AuthController) RequestPasswordReset(c *gin.Context) {
+	var req model.PasswordResetRequest
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide pib"})
+		return
+	}
+	// Every request notifies the company, so neither an attacker's address
+	// nor a targeted company may send many
+	if rateLimited(c, controller.resetLimiter.Allow("ip:"+c.ClientIP())) ||
+		rateLimited(c, controller.resetLimiter.Allow("pib:"+strconv.Itoa(req.PIB))) {
+		return
+	}
+	err := controller.authServ.RequestPasswordReset(req.PIB)
+	if errors.Is(err, services.ErrNotifierDisabled) {
+		c.AbortWithStatusJSON(http.StatusNotImplemented, ErrorResponse{Error: "Password reset isn't available"})
+		return
+	}
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Couldn't generate JWT"})
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
 		return
 	}
-	c.JSON(http.StatusOK, JwtResponse{Jwt: ssoToken})
+	c.JSON(http.StatusAccepted, SuccessResponse{Success: "If the company exists, a reset token has been sent"})
+}
+
+// swagger:route POST /api/auth/password/reset/confirm auth ResetPassword
+// Sets a new password with a reset token and ends the sessions of the
+// company. Tokens can be used once. Access tokens of the sessions are
+// rejected like after ChangePassword.
+//
+// Parameters:
+// +name: reset
+// in: body
+// type: passwordResetConfirmation
+//
+// Responses:
+// 200: succRes
+// 400: errRes
+// 500: errRes
+func (controller AuthController) ResetPassword(c *gin.Context) {
+	var req model.PasswordResetConfirmation
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide token and newPassword"})
+		return
+	}
+
+	err := controller.authServ.ResetPassword(req.Token, req.NewPassword)
+	switch {
+	case errors.Is(err, db.NoSuchResetTokenError), errors.Is(err, services.ErrInvalidPassword):
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Success: "Password changed"})
 }
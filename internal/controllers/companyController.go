@@ -2,8 +2,8 @@ package controllers
 
 import (
 	"apr-backend/client"
-	"apr-backend/internal/auth"
 	"apr-backend/internal/db"
+	"apr-backend/internal/export"
 	"apr-backend/internal/model"
 	"apr-backend/internal/services"
 	"errors"
@@ -19,20 +19,20 @@ import (
 
 // Response on successful login or registration, returns a valid JWT used for
 // authentication.
-// swagger:response jwtRes
+// swagger:model jwtResponse
 type JwtResponse struct {
 	// The JWT
 	Jwt string `json:"jwt"`
 }
 
 // Error is used to specify what kind of error occured when processing request.
-// swagger:response errRes
+// swagger:model errorResponse
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
 // Specifies what was successful
-// swagger:response succRes
+// swagger:model successResponse
 type SuccessResponse struct {
 	Success string `json:"success"`
 }
@@ -40,18 +40,59 @@ type SuccessResponse struct {
 // Error is used to specify field errors on struct.
 // swagger:response invalidBodyRes
 type InvalidBodyResponse struct {
+	// Message for every invalid field
+	// in: body
 	ValidationErrors map[string]string
 }
 
+// Response on successful login or registration, returns a valid JWT used for
+// authentication.
+// swagger:response jwtRes
+type jwtRes struct {
+	// in: body
+	Body JwtResponse
+}
+
+// Error is used to specify what kind of error occured when processing request.
+// swagger:response errRes
+type errRes struct {
+	// in: body
+	Body ErrorResponse
+}
+
+// Specifies what was successful
+// swagger:response succRes
+type succRes struct {
+	// in: body
+	Body SuccessResponse
+}
+
+// companyErrors maps a binding error of a company to messages per field. It
+// returns false if err isn't a validation error.
+func companyErrors(err error) (map[string]string, bool) {
+	if errors.Is(err, model.ErrInvalidDelatnost) {
+		return map[string]string{"Delatnost": "Delatnost must be one of predefined values"}, true
+	}
+	errs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return nil, false
+	}
+	errMsg := make(map[string]string)
+	for _, e := range errs {
+		errMsg[e.Field()] = model.CompanyErrors[e.Field()]
+	}
+	return errMsg, true
+}
+
 type CompanyController struct {
-	comServ services.CompanyService
-	jwtGen  auth.JwtGenerator
+	comServ  services.CompanyService
+	authServ services.AuthService
 }
 
-func NewCompanyController(comServ services.CompanyService, jwtGen auth.JwtGenerator) CompanyController {
+func NewCompanyController(comServ services.CompanyService, authServ services.AuthService) CompanyController {
 	return CompanyController{
-		comServ: comServ,
-		jwtGen:  jwtGen,
+		comServ:  comServ,
+		authServ: authServ,
 	}
 }
 
@@ -64,48 +105,45 @@ func NewCompanyController(comServ services.CompanyService, jwtGen auth.JwtGenera
 // type: company
 // description: company to be created
 //
-// Security:
-// bearerAuth:
-//
 // Responses:
-// 201: company Company which was created
-// 400:
-// 500:
-// This text will appear as description of your response body.
+// 200: tokenPairRes
+// 400: invalidBodyRes
+// 500: errRes
 func (companyCtr CompanyController) CreateCompany(c *gin.Context) {
 	var company model.Company
 	if err := c.ShouldBindWith(&company, binding.JSON); err != nil {
-		errs, ok := err.(validator.ValidationErrors)
+		errMsg, ok := companyErrors(err)
 		if !ok {
-			if errors.Is(err, model.ErrInvalidDelatnost) {
-				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Delatnost": "Delatnost must be one of predefined values"})
-				return
-			}
 			log.Println(err.Error())
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Must provide valid company as JSON"})
 			return
 		}
-		errMsg := make(map[string]string)
-		for _, e := range errs {
-			errMsg[e.Field()] = model.CompanyErrors[e.Field()]
-		}
 		c.AbortWithStatusJSON(http.StatusBadRequest, errMsg)
 		return
 	}
 
 	err := companyCtr.comServ.SaveCompany(&company)
+	if errors.Is(err, db.NoSuchJmbgError) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Vlasnik": "Person with this JMBG doesn't exist"})
+		return
+	}
+	if errors.Is(err, db.NoSuchNstjError) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Sediste": "NSTJ with this oznaka doesn't exist"})
+		return
+	}
 	if err != nil {
 		log.Printf("Couldn't save company: %s", err.Error())
 		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Error when saving to database."})
 		return
 	}
 
-	jwt, err := companyCtr.jwtGen.GenerateAndSignJWT(company.PIB, client.Apr)
+	tokens, err := companyCtr.authServ.IssueTokens(company.PIB)
 	if err != nil {
 		log.Printf("Error creating token: %s", err.Error())
 		c.AbortWithStatus(http.StatusInternalServerError)
+		return
 	}
-	c.JSON(http.StatusOK, JwtResponse{Jwt: jwt})
+	c.JSON(http.StatusOK, tokens)
 }
 
 const (
@@ -121,6 +159,41 @@ const (
 	naziv int = iota
 )
 
+// parseCompanyFilter reads the filtering, sorting and pagination query
+// parameters shared by every endpoint listing companies.
+func parseCompanyFilter(c *gin.Context) model.CompanyFilter {
+	pageStr, ok := c.GetQuery(pageQuery)
+	page := 0
+	var err error
+	if ok {
+		page, err = strconv.Atoi(pageStr)
+		if err != nil || page < 0 {
+			page = 0
+		}
+	}
+	column, ok := c.GetQuery(sortQuery)
+	if !ok {
+		column = "PIB"
+	}
+	delatnost := c.Query(delatnostQuery)
+	sediste := c.Query(sedisteQuery)
+	mesto := c.Query(mestoQuery)
+	ascStr, _ := c.GetQuery(ascQuery)
+	asc := true
+	if ascStr == "false" {
+		asc = false
+	}
+
+	return model.CompanyFilter{
+		OrderBy:   column,
+		Asc:       asc,
+		Page:      page,
+		Mesto:     mesto,
+		Sediste:   sediste,
+		Delatnost: delatnost,
+	}
+}
+
 // swagger:route GET /api/company/ company FindCompanies
 // Filters, sorts and paginates companies
 //
@@ -149,42 +222,17 @@ const (
 // required: false
 // type: string
 // description: mesto by which to filter
+// +name: sediste
+// in: query
+// required: false
+// type: string
+// description: oznaka of the NSTJ by which to filter
 //
 // Responses:
-// 201: []company
-// 400:
+// 200: []company
 // 500:
 func (companyCtr CompanyController) FindCompanies(c *gin.Context) {
-	pageStr, ok := c.GetQuery(pageQuery)
-	page := 0
-	var err error
-	if ok {
-		page, err = strconv.Atoi(pageStr)
-		if err != nil || page < 0 {
-			page = 0
-		}
-	}
-	column, ok := c.GetQuery(sortQuery)
-	if !ok {
-		column = "PIB"
-	}
-	delatnost := c.Query(delatnostQuery)
-	sediste := c.Query(sedisteQuery)
-	mesto := c.Query(mestoQuery)
-	ascStr, _ := c.GetQuery(ascQuery)
-	asc := true
-	if ascStr == "false" {
-		asc = false
-	}
-
-	companies, err := companyCtr.comServ.FindCompanies(model.CompanyFilter{
-		OrderBy:   column,
-		Asc:       asc,
-		Page:      page,
-		Mesto:     mesto,
-		Sediste:   sediste,
-		Delatnost: delatnost,
-	})
+	companies, err := companyCtr.comServ.FindCompanies(parseCompanyFilter(c))
 
 	if errors.Is(err, db.DatabaseError) {
 		log.Println(err.Error())
@@ -206,10 +254,106 @@ func (companyCtr CompanyController) FindCompanies(c *gin.Context) {
 	return
 }
 
-// swagger:route GET /api/company/:pib company FindOne
+const formatQuery = "format"
+
+// swagger:route GET /api/company/export company ExportCompanies
+// Streams every company matching the filter as a single file
+//
+// Parameters:
+// +name: format
+// in: query
+// required: true
+// type: string
+// description: one of csv, xlsx or jsonl
+// +name: order
+// in: query
+// required: false
+// type: string
+// +name: asc
+// in: query
+// required: false
+// type: boolean
+// +name: delatnost
+// in: query
+// required: false
+// type: string
+// +name: sediste
+// in: query
+// required: false
+// type: string
+// +name: mesto
+// in: query
+// required: false
+// type: string
+//
+// Responses:
+// 200:
+// 400: errRes
+// 500: errRes
+func (companyCtr CompanyController) ExportCompanies(c *gin.Context) {
+	format := c.Query(formatQuery)
+	if !export.IsFormat(format) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "format must be one of csv, xlsx or jsonl"})
+		return
+	}
+
+	// The response is only started once the query has produced its first
+	// row, so that failures before that can still be reported as JSON.
+	var writer export.CompanyWriter
+	start := func() (err error) {
+		c.Header("Content-Type", export.ContentType(format))
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="companies.%s"`, format))
+		c.Status(http.StatusOK)
+		writer, err = export.NewCompanyWriter(format, c.Writer)
+		return err
+	}
+
+	err := companyCtr.comServ.ExportCompanies(parseCompanyFilter(c), func(com model.Company) error {
+		if writer == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if err := writer.Write(com); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err == nil && writer == nil {
+		err = start()
+	}
+	if err != nil {
+		log.Printf("Error exporting companies: %s", err.Error())
+		switch {
+		case writer != nil:
+			// Body is already partially sent, the client gets a truncated file.
+		case errors.Is(err, db.InvalidFilter):
+			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		default:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		}
+		return
+	}
+
+	if err := writer.Close(); err != nil {
+		log.Printf("Error finishing export: %s", err.Error())
+	}
+}
+
+// swagger:route GET /api/company/{pib} company FindOne
 // Finds one company by its pib
+//
+// Parameters:
+// +name: pib
+// in: path
+// required: true
+// type: integer
+//
 // Responses:
 // 200: company
+// 400: errRes
+// 404: errRes
 // 500: errRes
 func (comCtr CompanyController) FindOne(c *gin.Context) {
 	pibParam := c.Param("pib")
@@ -231,10 +375,25 @@ func (comCtr CompanyController) FindOne(c *gin.Context) {
 	c.JSON(http.StatusOK, company)
 }
 
-// swagger:route DELETE /api/company/:pib company LiquidateById
-// Liquidates company
+// swagger:route DELETE /api/company/{pib} company LiquidateById
+// Liquidates company, persons acting for it have to be its owner. Admins
+// can require a login with a second factor for it.
+//
+// Parameters:
+// +name: pib
+// in: path
+// required: true
+// type: integer
+//
+// Security:
+//   - bearerAuth:
+//
 // Responses:
 // 200: succRes
+// 400: errRes
+// 401: errRes
+// 403: errRes
+// 404: errRes
 // 500: errRes
 func (comCtr CompanyController) LiquidateById(c *gin.Context) {
 	principal := c.GetString("principal")
@@ -256,7 +415,11 @@ func (comCtr CompanyController) LiquidateById(c *gin.Context) {
 		return
 	}
 
-	err = comCtr.comServ.LiquidateById(pib)
+	err = comCtr.comServ.LiquidateById(pib, c.GetString(client.ActingPerson))
+	if errors.Is(err, services.ErrNotOwner) {
+		c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Error: "Only the owner can liquidate the company"})
+		return
+	}
 	if errors.Is(err, db.NoSuchPibError) {
 		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf("Couldnt' find company with pib %s", pibParam)})
 		return
@@ -267,3 +430,44 @@ func (comCtr CompanyController) LiquidateById(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, SuccessResponse{Success: "Company liquidated"})
 }
+
+// swagger:route DELETE /api/registrar/company/{pib} registrar ForceLiquidate
+// Liquidates a company by decision of the registrar, only for clerks and
+// supervisors
+//
+// Parameters:
+// +name: pib
+// in: path
+// required: true
+// type: integer
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: succRes
+// 400: errRes
+// 401: errRes
+// 403: errRes
+// 404: errRes
+// 500: errRes
+func (comCtr CompanyController) ForceLiquidate(c *gin.Context) {
+	pibParam := c.Param("pib")
+	pib, err := strconv.Atoi(pibParam)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("Provided pib %s is invalid", pibParam)})
+		return
+	}
+
+	err = comCtr.comServ.ForceLiquidate(pib, c.GetString(client.Principal))
+	if errors.Is(err, db.NoSuchPibError) {
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf("Couldn't find company with pib %s", pibParam)})
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Success: "Company liquidated"})
+}
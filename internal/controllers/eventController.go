@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"apr-backend/internal/events"
+	"apr-backend/internal/model"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+const keepAliveInterval = 30 * time.Second
+
+type EventController struct {
+	broker *events.Broker
+}
+
+func NewEventController(broker *events.Broker) EventController {
+	return EventController{broker: broker}
+}
+
+// swagger:route GET /api/events/stream events StreamEvents
+// Streams registry events as Server-Sent Events
+//
+// Reconnecting clients send the Last-Event-ID header (or the lastEventId
+// query parameter) to receive the events they missed.
+//
+// Parameters:
+// +name: type
+// in: query
+// description: event type to receive, can be repeated
+// required: false
+// type: string
+// +name: pib
+// in: query
+// description: only receive events of this company
+// required: false
+// type: integer
+// +name: Last-Event-ID
+// in: header
+// required: false
+// type: integer
+//
+// Responses:
+// 200: event
+// 400: errRes
+func (eventCtr EventController) Stream(c *gin.Context) {
+	filter := model.EventFilter{Types: c.QueryArray("type")}
+	if pibStr, ok := c.GetQuery("pib"); ok {
+		pib, err := strconv.Atoi(pibStr)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("Provided pib %s is invalid", pibStr)})
+			return
+		}
+		filter.PIB = pib
+	}
+
+	lastIdStr := c.GetHeader("Last-Event-ID")
+	if lastIdStr == "" {
+		lastIdStr = c.Query("lastEventId")
+	}
+	var lastId int64
+	if lastIdStr != "" {
+		var err error
+		if lastId, err = strconv.ParseInt(lastIdStr, 10, 64); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("Provided event id %s is invalid", lastIdStr)})
+			return
+		}
+	}
+
+	replay, sub := eventCtr.broker.Subscribe(lastId, filter)
+	defer eventCtr.broker.Unsubscribe(sub)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	render := func(event model.Event) {
+		c.Render(-1, sse.Event{
+			Id:    strconv.FormatInt(event.Id, 10),
+			Event: event.Type,
+			Data:  event,
+		})
+	}
+	for _, event := range replay {
+		render(event)
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-sub.C:
+			if !ok {
+				// Fell behind, the client reconnects with Last-Event-ID
+				return false
+			}
+			render(event)
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		}
+		return true
+	})
+}
@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"apr-backend/internal/auth"
+	"apr-backend/internal/db"
+	"apr-backend/internal/extract"
+	"apr-backend/internal/model"
+	"apr-backend/internal/ratelimit"
+	"apr-backend/internal/services"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ExtractController struct {
+	extractServ services.ExtractService
+	jwtGen      auth.JwtGenerator
+	publicUrl   string
+	limiter     *ratelimit.Limiter
+}
+
+// publicUrl is the address at which the API is reachable from outside, used
+// in verification links. Every issued extract is kept, so limiter limits
+// how many each client address may issue.
+func NewExtractController(extractServ services.ExtractService, jwtGen auth.JwtGenerator, publicUrl string, limiter *ratelimit.Limiter) ExtractController {
+	return ExtractController{
+		extractServ: extractServ,
+		jwtGen:      jwtGen,
+		publicUrl:   publicUrl,
+		limiter:     limiter,
+	}
+}
+
+func (extCtr ExtractController) verifyUrl(code string) string {
+	return extCtr.publicUrl + "/api/extract/verify/" + code
+}
+
+// issue issues an extract of the company from the pib path parameter. If it
+// fails, the error response has already been sent.
+func (extCtr ExtractController) issue(c *gin.Context) (model.Extract, bool) {
+	pibParam := c.Param("pib")
+	pib, err := strconv.Atoi(pibParam)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("Provided pib %s is invalid", pibParam)})
+		return model.Extract{}, false
+	}
+	if rateLimited(c, extCtr.limiter.Allow(c.ClientIP())) {
+		return model.Extract{}, false
+	}
+
+	ext, err := extCtr.extractServ.Issue(pib)
+	if errors.Is(err, db.NoSuchPibError) {
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf("Couldn't find company with pib %s", pibParam)})
+		return model.Extract{}, false
+	}
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return model.Extract{}, false
+	}
+	return ext, true
+}
+
+// swagger:route GET /api/company/{pib}/extract.pdf company DownloadExtract
+// Issues a registry extract of the company as a PDF, with a code and a QR
+// code by which it can be verified. Only served when APR_PUBLIC_URL is set.
+// Each client address may issue 10 extracts a minute.
+//
+// Produces:
+// - application/pdf
+//
+// Parameters:
+// +name: pib
+// in: path
+// required: true
+// type: integer
+//
+// Responses:
+// 200:
+// 400: errRes
+// 404: errRes
+// 429: errRes
+// 500: errRes
+func (extCtr ExtractController) Download(c *gin.Context) {
+	ext, ok := extCtr.issue(c)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := extract.WritePDF(&buf, ext, extCtr.verifyUrl(ext.Code)); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="izvod-%d.pdf"`, ext.Company.PIB))
+	c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+}
+
+// swagger:route GET /api/company/{pib}/extract.jws company DownloadSignedExtract
+// Issues a registry extract of the company as JSON, signed with the APR key
+// as a JWS in compact serialization. It can be verified offline with
+// client.VerifyExtract and the key from /api/extract/keys with its kid, or
+// online by its code. Counts towards the same
+// limit as PDF extracts.
+//
+// Produces:
+// - application/jose
+//
+// Parameters:
+// +name: pib
+// in: path
+// required: true
+// type: integer
+//
+// Responses:
+// 200:
+// 400: errRes
+// 404: errRes
+// 429: errRes
+// 500: errRes
+func (extCtr ExtractController) DownloadSigned(c *gin.Context) {
+	ext, ok := extCtr.issue(c)
+	if !ok {
+		return
+	}
+
+	payload, err := json.Marshal(ext)
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	signed, err := extCtr.jwtGen.SignExtract(payload)
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="izvod-%d.jws"`, ext.Company.PIB))
+	c.Data(http.StatusOK, "application/jose", []byte(signed))
+}
+
+// swagger:route GET /api/extract/verify/{code} company VerifyExtract
+// Returns the data an extract was issued with, and whether it is still
+// current
+//
+// Parameters:
+// +name: code
+// in: path
+// required: true
+// type: string
+//
+// Responses:
+// 200: extractVerification
+// 404: errRes
+// 500: errRes
+func (extCtr ExtractController) Verify(c *gin.Context) {
+	verification, err := extCtr.extractServ.Verify(c.Param("code"))
+	if errors.Is(err, db.NoSuchExtractError) {
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{Error: "No extract was issued with this code"})
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, verification)
+}
+
+// swagger:route GET /api/extract/keys company ExtractKeys
+// Public keys which verify signed extracts, identified by the kid header of
+// an extract. Unlike the JWKS of tokens it keeps the keys of rotated out
+// signing keys, so extracts stay verifiable.
+//
+// Responses:
+// 200: jwks
+func (extCtr ExtractController) Keys(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, extCtr.jwtGen.ExtractJWKS())
+}
@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/gql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type GraphqlController struct {
+	schema *gql.Schema
+}
+
+func NewGraphqlController(schema *gql.Schema) GraphqlController {
+	return GraphqlController{schema: schema}
+}
+
+// swagger:route POST /graphql graphql Query
+// Runs a GraphQL query over companies, their owners and regions
+//
+// Queries deeper than 6 levels or with an estimated complexity over 2000
+// are rejected. Service accounts need companies:read for company fields
+// and nstj:read for nstj.
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200:
+// 400: errRes
+func (graphqlCtr GraphqlController) Query(c *gin.Context) {
+	var req gql.Request
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide a GraphQL request as JSON"})
+		return
+	}
+	claims, _ := client.GetClaims(c)
+	c.JSON(http.StatusOK, graphqlCtr.schema.Execute(c.Request.Context(), claims, req))
+}
@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"apr-backend/internal/services"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Columns an import file must have, by name, in any order.
+var importColumns = []string{"naziv", "adresaSedista", "mesto", "postanskiBroj", "delatnost", "sediste", "vlasnik", "password"}
+
+type ImportController struct {
+	importServ services.ImportService
+}
+
+func NewImportController(importServ services.ImportService) ImportController {
+	return ImportController{importServ: importServ}
+}
+
+// parseImportFile reads a CSV file with a header row and validates every row
+// the same way CreateCompany validates its body.
+func parseImportFile(data []byte) ([]model.ImportRow, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read header: %w", err)
+	}
+	index := make(map[string]int, len(header))
+	for i, col := range header {
+		index[col] = i
+	}
+	for _, col := range importColumns {
+		if _, ok := index[col]; !ok {
+			return nil, fmt.Errorf("Missing column %s", col)
+		}
+	}
+
+	rows := make([]model.ImportRow, 0)
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't read line %d: %w", line, err)
+		}
+		col := func(name string) string {
+			return record[index[name]]
+		}
+
+		row := model.ImportRow{
+			Row: line,
+			Company: model.Company{
+				Naziv:         col("naziv"),
+				AdresaSedista: col("adresaSedista"),
+				Mesto:         col("mesto"),
+				PostanskiBroj: col("postanskiBroj"),
+				Sediste:       model.Nstj{Oznaka: col("sediste")},
+				Vlasnik:       model.Person{Jmbg: col("vlasnik")},
+				Password:      col("password"),
+			},
+		}
+		delatnost, err := model.Delatnosti.Parse(col("delatnost"))
+		if err != nil {
+			row.Errors, _ = companyErrors(err)
+		} else {
+			row.Company.Delatnost = delatnost
+			if err := binding.Validator.ValidateStruct(&row.Company); err != nil {
+				var ok bool
+				if row.Errors, ok = companyErrors(err); !ok {
+					row.Errors = map[string]string{"error": err.Error()}
+				}
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// swagger:parameters ImportCompanies
+type importFileParam struct {
+	// CSV file to import
+	// in: formData
+	// required: true
+	// swagger:file
+	File io.ReadCloser `json:"file"`
+}
+
+// swagger:route POST /api/admin/import/company admin ImportCompanies
+// Imports companies from a CSV file
+//
+// The file must have a header row with the columns naziv, adresaSedista,
+// mesto, postanskiBroj, delatnost, sediste, vlasnik and password. Dry runs
+// report the rows which would fail. Atomic and chunked imports start a job
+// in the background and return it with status 202, its progress and
+// failed rows are shown at /api/admin/import/company/{id}.
+//
+// Consumes:
+// - multipart/form-data
+//
+// Parameters:
+// +name: mode
+// in: query
+// description: one of dry-run, atomic or chunked
+// required: true
+// type: string
+// +name: resume
+// in: query
+// description: id of a chunked import job to continue
+// required: false
+// type: integer
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: importReport
+// 202: importReport
+// 400: errRes
+// 403: errRes
+// 404: errRes
+// 409: errRes
+// 500: errRes
+func (importCtr ImportController) ImportCompanies(c *gin.Context) {
+	mode := c.Query("mode")
+	resume := 0
+	if resumeStr, ok := c.GetQuery("resume"); ok {
+		var err error
+		if resume, err = strconv.Atoi(resumeStr); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("Provided job id %s is invalid", resumeStr)})
+			return
+		}
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide a CSV file as file"})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	checksum := sha256.Sum256(data)
+
+	rows, err := parseImportFile(data)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	report, err := importCtr.importServ.Import(rows, hex.EncodeToString(checksum[:]), mode, resume)
+	switch {
+	case err == nil && report.Job != nil:
+		c.JSON(http.StatusAccepted, report)
+	case err == nil:
+		c.JSON(http.StatusOK, report)
+	case errors.Is(err, services.ErrInvalidImportMode):
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, db.NoSuchImportJobError):
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, services.ErrImportMismatch), errors.Is(err, services.ErrImportFinished), errors.Is(err, services.ErrImportRunning):
+		c.AbortWithStatusJSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+	default:
+		log.Printf("Error importing companies: %s", err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+	}
+}
+
+// swagger:route GET /api/admin/import/company/{id} admin FindImportJob
+// Gets the progress and failed rows of an atomic or chunked import
+//
+// Parameters:
+// +name: id
+// in: path
+// required: true
+// type: integer
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: importJob
+// 400: errRes
+// 404: errRes
+// 500: errRes
+func (importCtr ImportController) FindJob(c *gin.Context) {
+	idParam := c.Param("id")
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("Provided job id %s is invalid", idParam)})
+		return
+	}
+	job, err := importCtr.importServ.FindJob(id)
+	if errors.Is(err, db.NoSuchImportJobError) {
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
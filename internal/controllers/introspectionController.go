@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"apr-backend/internal/ratelimit"
+	"apr-backend/internal/services"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type IntrospectionController struct {
+	introspectionServ services.IntrospectionService
+}
+
+func NewIntrospectionController(introspectionServ services.IntrospectionService) IntrospectionController {
+	return IntrospectionController{introspectionServ: introspectionServ}
+}
+
+// swagger:parameters Introspect
+type introspectParams struct {
+	// The token to introspect
+	// in: formData
+	// required: true
+	Token string `json:"token"`
+	// Ignored, only access tokens can be introspected
+	// in: formData
+	TokenTypeHint string `json:"token_type_hint"`
+}
+
+// swagger:route POST /oauth/introspect oauth Introspect
+// Token introspection as in RFC 7662, for services which can't verify
+// tokens themselves
+//
+// Services authenticate with HTTP Basic, their name and introspection
+// secret. Tokens which weren't issued for one of the service's audiences
+// are inactive.
+//
+// Consumes:
+// - application/x-www-form-urlencoded
+//
+// Security:
+//   - basicAuth:
+//
+// Responses:
+// 200: introspection
+// 400: oauthError
+// 401: oauthError
+// 429: oauthError
+// 500: oauthError
+func (introCtr IntrospectionController) Introspect(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	name, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		c.Header("WWW-Authenticate", `Basic realm="apr"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, model.OAuthError{Code: "invalid_client"})
+		return
+	}
+	token := c.PostForm("token")
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.OAuthError{Code: "invalid_request", Description: "token is required"})
+		return
+	}
+
+	res, err := introCtr.introspectionServ.Introspect(name, secret, token)
+	var limitErr ratelimit.LimitError
+	switch {
+	case errors.Is(err, services.ErrInvalidServiceCredentials):
+		c.Header("WWW-Authenticate", `Basic realm="apr"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, model.OAuthError{Code: "invalid_client"})
+		return
+	case errors.As(err, &limitErr):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(limitErr.RetryAfter.Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, model.OAuthError{Code: "slow_down", Description: limitErr.Error()})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.OAuthError{Code: "server_error"})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// swagger:route POST /api/admin/services/{name}/introspection-secret admin RotateIntrospectionSecret
+// Generates a new secret the service authenticates to the introspection
+// endpoint with. The old secret stops working.
+//
+// Parameters:
+// +name: name
+// in: path
+// required: true
+// type: string
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: introspectionSecret
+// 404: errRes
+// 500: errRes
+func (introCtr IntrospectionController) RotateSecret(c *gin.Context) {
+	secret, err := introCtr.introspectionServ.RotateSecret(c.Param("name"))
+	switch {
+	case errors.Is(err, db.NoSuchServiceError):
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, secret)
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/lockout"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LockoutController struct {
+	guard lockout.Guard
+}
+
+func NewLockoutController(guard lockout.Guard) LockoutController {
+	return LockoutController{guard: guard}
+}
+
+// swagger:route GET /api/admin/lockouts admin FindLockouts
+// Lists PIBs, persons and addresses currently locked out for failed logins
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: []lockout
+// 500: errRes
+func (lockCtr LockoutController) FindActive(c *gin.Context) {
+	lockouts, err := lockCtr.guard.Lockouts()
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, lockouts)
+}
+
+// swagger:route DELETE /api/admin/lockouts/{kind}/{subject} admin Unlock
+// Lifts the lockout of a company account, a person account or an address
+// and forgets its failed logins
+//
+// Parameters:
+// +name: kind
+// in: path
+// required: true
+// type: string
+// enum: pib,person,ip
+// +name: subject
+// in: path
+// required: true
+// type: string
+// description: The PIB, JMBG or IP address
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: succRes
+// 400: errRes
+// 500: errRes
+func (lockCtr LockoutController) Unlock(c *gin.Context) {
+	kind, subject := c.Param("kind"), c.Param("subject")
+	err := lockCtr.guard.Unlock(kind, subject, c.GetString(client.Principal))
+	switch {
+	case errors.Is(err, lockout.ErrUnknownKind):
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Success: fmt.Sprintf("Unlocked %s %s", kind, subject)})
+}
@@ -0,0 +1,320 @@
+package controllers
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"apr-backend/internal/services"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type MfaController struct {
+	mfaServ services.MfaService
+}
+
+func NewMfaController(mfaServ services.MfaService) MfaController {
+	return MfaController{mfaServ: mfaServ}
+}
+
+// mfaError responds to errors of managing second factors
+func mfaError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidCode):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, services.ErrMfaEnrolled), errors.Is(err, services.ErrUnknownOperation):
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, services.ErrMfaNotEnrolled), errors.Is(err, db.NoSuchPibError):
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+	default:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+	}
+}
+
+// companyPib returns the PIB of the logged-in company. The second factor
+// belongs to the company's own login, so persons acting for it can't
+// manage it.
+func companyPib(c *gin.Context) (int, bool) {
+	pib, err := strconv.Atoi(c.GetString(client.Principal))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid JWT"})
+		return 0, false
+	}
+	if c.GetString(client.ActingPerson) != "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Error: "Only the company itself can manage its second factor"})
+		return 0, false
+	}
+	return pib, true
+}
+
+// RequireMfa only lets through tokens from logins with a second factor, if
+// admins require one for operation. It has to run after CheckAuth.
+func (mfaCtr MfaController) RequireMfa(operation string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		required, err := mfaCtr.mfaServ.Required(operation)
+		if err != nil {
+			mfaError(c, err)
+			return
+		}
+		if !required {
+			return
+		}
+		if claims, ok := client.GetClaims(c); ok && claims.HasAmr(client.AmrOtp) {
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Error: "Operation requires a login with a second factor"})
+	}
+}
+
+// swagger:route GET /api/auth/mfa auth MfaStatus
+// Shows whether the logged-in company has a second factor
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: mfaStatus
+// 403: errRes
+// 500: errRes
+func (mfaCtr MfaController) Status(c *gin.Context) {
+	pib, ok := companyPib(c)
+	if !ok {
+		return
+	}
+	status, err := mfaCtr.mfaServ.Status(pib)
+	if err != nil {
+		mfaError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// swagger:route POST /api/auth/mfa/totp auth EnrollTotp
+// Starts TOTP enrolment of the logged-in company with a new secret. Logins
+// need a code once it is confirmed. Calling it again before confirming
+// replaces the secret.
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: totpEnrollment
+// 400: errRes
+// 403: errRes
+// 500: errRes
+func (mfaCtr MfaController) Enroll(c *gin.Context) {
+	pib, ok := companyPib(c)
+	if !ok {
+		return
+	}
+	enrollment, err := mfaCtr.mfaServ.Enroll(pib)
+	if err != nil {
+		mfaError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// swagger:route POST /api/auth/mfa/totp/confirm auth ConfirmTotp
+// Confirms TOTP enrolment with the first code of the authenticator app.
+// Returns the recovery codes, which are not shown again. Every session of
+// the company ends and it logs in again with the second factor.
+//
+// Parameters:
+// +name: code
+// in: body
+// type: mfaCodeRequest
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: recoveryCodes
+// 400: errRes
+// 401: errRes
+// 403: errRes
+// 404: errRes
+// 500: errRes
+func (mfaCtr MfaController) Confirm(c *gin.Context) {
+	pib, ok := companyPib(c)
+	if !ok {
+		return
+	}
+	var req model.MfaCodeRequest
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide code"})
+		return
+	}
+	codes, err := mfaCtr.mfaServ.Confirm(pib, req.Code)
+	if err != nil {
+		mfaError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, codes)
+}
+
+// swagger:route DELETE /api/auth/mfa/totp auth DisableTotp
+// Removes the second factor of the logged-in company, with a code of it or
+// a recovery code. Every session of the company ends.
+//
+// Parameters:
+// +name: code
+// in: body
+// type: mfaCodeRequest
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: succRes
+// 400: errRes
+// 401: errRes
+// 403: errRes
+// 404: errRes
+// 500: errRes
+func (mfaCtr MfaController) Disable(c *gin.Context) {
+	pib, ok := companyPib(c)
+	if !ok {
+		return
+	}
+	var req model.MfaCodeRequest
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide code"})
+		return
+	}
+	if err := mfaCtr.mfaServ.Disable(pib, req.Code); err != nil {
+		mfaError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Success: "Second factor disabled"})
+}
+
+// swagger:route POST /api/auth/mfa/recovery-codes auth RegenerateRecoveryCodes
+// Replaces the recovery codes of the logged-in company, with a code of its
+// second factor. Earlier recovery codes stop working.
+//
+// Parameters:
+// +name: code
+// in: body
+// type: mfaCodeRequest
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: recoveryCodes
+// 400: errRes
+// 401: errRes
+// 403: errRes
+// 404: errRes
+// 500: errRes
+func (mfaCtr MfaController) RegenerateRecoveryCodes(c *gin.Context) {
+	pib, ok := companyPib(c)
+	if !ok {
+		return
+	}
+	var req model.MfaCodeRequest
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide code"})
+		return
+	}
+	codes, err := mfaCtr.mfaServ.RegenerateRecoveryCodes(pib, req.Code)
+	if err != nil {
+		mfaError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, codes)
+}
+
+// swagger:route GET /api/admin/mfa/policy admin FindMfaPolicies
+// Lists the operations which can require a second factor
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: []mfaPolicy
+// 500: errRes
+func (mfaCtr MfaController) FindPolicies(c *gin.Context) {
+	policies, err := mfaCtr.mfaServ.Policies()
+	if err != nil {
+		mfaError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, policies)
+}
+
+// swagger:route PUT /api/admin/mfa/policy/{operation} admin SetMfaPolicy
+// Sets whether an operation requires a login with a second factor. Tokens
+// from logins without one are refused for it from then on.
+//
+// Parameters:
+// +name: operation
+// in: path
+// required: true
+// type: string
+// enum: liquidate,changePassword,representatives
+// +name: policy
+// in: body
+// type: mfaPolicy
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: mfaPolicy
+// 400: errRes
+// 500: errRes
+func (mfaCtr MfaController) SetPolicy(c *gin.Context) {
+	var policy model.MfaPolicy
+	if err := c.ShouldBindWith(&policy, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide required"})
+		return
+	}
+	policy.Operation = c.Param("operation")
+	policy.UpdatedBy = c.GetString(client.Principal)
+	policy, err := mfaCtr.mfaServ.SetPolicy(policy)
+	if err != nil {
+		mfaError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, policy)
+}
+
+// swagger:route DELETE /api/admin/mfa/{pib} admin ResetMfa
+// Removes the second factor of a company which lost it and its recovery
+// codes and ends every session of the company. It logs in with its password
+// only until it enrols again.
+//
+// Parameters:
+// +name: pib
+// in: path
+// required: true
+// type: integer
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: succRes
+// 400: errRes
+// 404: errRes
+// 500: errRes
+func (mfaCtr MfaController) Reset(c *gin.Context) {
+	pib, err := strconv.Atoi(c.Param("pib"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid pib"})
+		return
+	}
+	if err := mfaCtr.mfaServ.Reset(pib); err != nil {
+		mfaError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Success: "Second factor removed"})
+}
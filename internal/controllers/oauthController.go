@@ -0,0 +1,424 @@
+package controllers
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/auth"
+	"apr-backend/internal/db"
+	"apr-backend/internal/lockout"
+	"apr-backend/internal/model"
+	"apr-backend/internal/services"
+	"crypto/subtle"
+	"errors"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Cookie of the SSO session at the authorization endpoint
+const ssoCookie = "apr_sso"
+
+// Cookie of the CSRF token of the login form, which posts the token back.
+// Other sites can't read it, so they can't log browsers in.
+const csrfCookie = "apr_csrf"
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="sr">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>APR - prijava</title>
+</head>
+<body>
+<h1>{{.Client}}</h1>
+<p>Prijavite se nalogom privrednog društva</p>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post">
+{{range $name, $value := .Hidden}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<label>PIB <input name="pib" inputmode="numeric" required autofocus></label>
+<label>Lozinka <input name="password" type="password" required></label>
+<label>Kod iz aplikacije za autentifikaciju, ako je uključena <input name="code" autocomplete="one-time-code"></label>
+<button type="submit">Prijava</button>
+</form>
+</body>
+</html>
+`))
+
+type OAuthController struct {
+	oauthServ services.OAuthService
+	// Session cookies are only sent over https when APR is served with it
+	secureCookie bool
+}
+
+func NewOAuthController(oauthServ services.OAuthService) OAuthController {
+	issuer := oauthServ.Configuration().Issuer
+	return OAuthController{oauthServ: oauthServ, secureCookie: strings.HasPrefix(issuer, "https://")}
+}
+
+// swagger:route GET /.well-known/openid-configuration oauth OidcConfiguration
+// Returns the OpenID Provider configuration of APR
+//
+// Responses:
+// 200: oidcConfiguration
+func (oauthCtr OAuthController) Configuration(c *gin.Context) {
+	c.JSON(http.StatusOK, oauthCtr.oauthServ.Configuration())
+}
+
+func (oauthCtr OAuthController) renderLogin(c *gin.Context, status int, req model.AuthorizationRequest, clientName, errMsg string) {
+	csrfToken, err := auth.NewTokenId()
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(csrfCookie, csrfToken, 0, services.OAuthAuthorizePath, "", oauthCtr.secureCookie, true)
+	hidden := map[string]string{
+		"csrf_token":            csrfToken,
+		"response_type":         req.ResponseType,
+		"client_id":             req.ClientId,
+		"redirect_uri":          req.RedirectUri,
+		"scope":                 req.Scope,
+		"state":                 req.State,
+		"nonce":                 req.Nonce,
+		"code_challenge":        req.CodeChallenge,
+		"code_challenge_method": req.CodeChallengeMethod,
+	}
+	// The login form mustn't be framed by other sites
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", "default-src 'none'; form-action 'self'; frame-ancestors 'none'")
+	c.Header("Cache-Control", "no-store")
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	err = loginPage.Execute(c.Writer, gin.H{"Client": clientName, "Error": errMsg, "Hidden": hidden})
+	if err != nil {
+		log.Printf("Error rendering login page: %s", err.Error())
+	}
+}
+
+// checkAuthorization binds and checks an authorization request, responding
+// to it if it isn't valid
+func (oauthCtr OAuthController) checkAuthorization(c *gin.Context, req *model.AuthorizationRequest) (model.OAuthClient, bool) {
+	if err := c.ShouldBind(req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid authorization request"})
+		return model.OAuthClient{}, false
+	}
+	oauthClient, err := oauthCtr.oauthServ.CheckAuthorization(*req)
+	var oauthErr model.OAuthError
+	switch {
+	case errors.Is(err, services.ErrUnknownClient), errors.Is(err, services.ErrInvalidRedirectUri):
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return oauthClient, false
+	case errors.As(err, &oauthErr):
+		c.Redirect(http.StatusFound, services.AuthorizationErrorUrl(*req, oauthErr))
+		c.Abort()
+		return oauthClient, false
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return oauthClient, false
+	}
+	return oauthClient, true
+}
+
+func (oauthCtr OAuthController) redirectWithCode(c *gin.Context, req model.AuthorizationRequest, session model.SsoSession) {
+	redirect, err := oauthCtr.oauthServ.Authorize(req, session)
+	var oauthErr model.OAuthError
+	if errors.As(err, &oauthErr) {
+		c.Redirect(http.StatusFound, services.AuthorizationErrorUrl(req, oauthErr))
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		c.Redirect(http.StatusFound, services.AuthorizationErrorUrl(req, model.OAuthError{Code: "server_error"}))
+		return
+	}
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// Authorize is the authorization endpoint of the code flow. Companies with
+// an SSO session are sent straight back to the client, others get the
+// login form.
+func (oauthCtr OAuthController) Authorize(c *gin.Context) {
+	var req model.AuthorizationRequest
+	oauthClient, ok := oauthCtr.checkAuthorization(c, &req)
+	if !ok {
+		return
+	}
+	token, _ := c.Cookie(ssoCookie)
+	session, ok := oauthCtr.oauthServ.Session(token)
+	if ok && req.Prompt != "login" {
+		oauthCtr.redirectWithCode(c, req, session)
+		return
+	}
+	if req.Prompt == "none" {
+		c.Redirect(http.StatusFound, services.AuthorizationErrorUrl(req, model.OAuthError{Code: "login_required"}))
+		return
+	}
+	oauthCtr.renderLogin(c, http.StatusOK, req, oauthClient.Name, "")
+}
+
+// Login handles the login form of the authorization endpoint. Forms
+// without the CSRF token of the page are rejected.
+func (oauthCtr OAuthController) Login(c *gin.Context) {
+	var req model.AuthorizationRequest
+	oauthClient, ok := oauthCtr.checkAuthorization(c, &req)
+	if !ok {
+		return
+	}
+	csrfToken, _ := c.Cookie(csrfCookie)
+	if csrfToken == "" || subtle.ConstantTimeCompare([]byte(csrfToken), []byte(c.PostForm("csrf_token"))) != 1 {
+		oauthCtr.renderLogin(c, http.StatusForbidden, req, oauthClient.Name, "Prijava je istekla, pokušajte ponovo")
+		return
+	}
+	pib, err := strconv.Atoi(c.PostForm("pib"))
+	if err != nil {
+		oauthCtr.renderLogin(c, http.StatusUnauthorized, req, oauthClient.Name, "Pogrešan PIB ili lozinka")
+		return
+	}
+	token, session, err := oauthCtr.oauthServ.StartSession(model.CredentialsDto{PIB: pib, Password: c.PostForm("password")}, c.PostForm("code"), c.ClientIP())
+	var retry lockout.RetryError
+	switch {
+	case errors.As(err, &retry):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retry.RetryAfter.Seconds()))))
+		oauthCtr.renderLogin(c, http.StatusTooManyRequests, req, oauthClient.Name, "Previše neuspelih pokušaja, pokušajte kasnije")
+		return
+	case errors.Is(err, services.ErrMfaRequired):
+		oauthCtr.renderLogin(c, http.StatusUnauthorized, req, oauthClient.Name, "Unesite kod iz aplikacije za autentifikaciju")
+		return
+	case errors.Is(err, services.ErrInvalidCode):
+		oauthCtr.renderLogin(c, http.StatusUnauthorized, req, oauthClient.Name, "Pogrešan kod iz aplikacije za autentifikaciju")
+		return
+	case errors.Is(err, db.DatabaseError):
+		oauthCtr.renderLogin(c, http.StatusInternalServerError, req, oauthClient.Name, "Greška servera")
+		return
+	case err != nil:
+		log.Println(err.Error())
+		oauthCtr.renderLogin(c, http.StatusUnauthorized, req, oauthClient.Name, "Pogrešan PIB ili lozinka")
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoCookie, token, int(services.SsoSessionLifetime.Seconds()), "/oauth", "", oauthCtr.secureCookie, true)
+	oauthCtr.redirectWithCode(c, req, session)
+}
+
+// swagger:parameters OAuthToken
+type tokenParams struct {
+	// in: formData
+	// required: true
+	// enum: ["authorization_code","refresh_token","client_credentials"]
+	GrantType string `json:"grant_type"`
+	// in: formData
+	Code string `json:"code"`
+	// in: formData
+	RedirectUri string `json:"redirect_uri"`
+	// in: formData
+	ClientId string `json:"client_id"`
+	// in: formData
+	CodeVerifier string `json:"code_verifier"`
+	// in: formData
+	RefreshToken string `json:"refresh_token"`
+	// in: formData
+	ClientSecret string `json:"client_secret"`
+	// urn:ietf:params:oauth:client-assertion-type:jwt-bearer
+	// in: formData
+	ClientAssertionType string `json:"client_assertion_type"`
+	// in: formData
+	ClientAssertion string `json:"client_assertion"`
+	// Scopes requested by a service account, all of its scopes if empty
+	// in: formData
+	Scope string `json:"scope"`
+}
+
+// swagger:route POST /oauth/token oauth OAuthToken
+// Token endpoint of the authorization code flow with PKCE. Also refreshes
+// tokens with the refresh_token grant, and issues tokens to service
+// accounts with the client_credentials grant.
+//
+// Access tokens of clients are only accepted by the client and the
+// userinfo endpoint. Refresh tokens are issued with the offline_access
+// scope, and only the client they were issued to can refresh with them,
+// sending its client_id. Codes used twice revoke the tokens issued for
+// them.
+//
+// Service accounts authenticate with client_secret, in the form or with
+// HTTP Basic, or with a private_key_jwt client_assertion.
+//
+// Consumes:
+// - application/x-www-form-urlencoded
+//
+// Responses:
+// 200: oidcTokenResponse
+// 400: oauthError
+// 401: oauthError
+// 500: oauthError
+func (oauthCtr OAuthController) Token(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	var req model.TokenRequest
+	if err := c.ShouldBindWith(&req, binding.Form); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.OAuthError{Code: "invalid_request"})
+		return
+	}
+	clientId, secret, basic := c.Request.BasicAuth()
+	if basic {
+		req.ClientId, req.ClientSecret = clientId, secret
+	}
+	res, err := oauthCtr.oauthServ.Exchange(req)
+	var oauthErr model.OAuthError
+	switch {
+	case errors.As(err, &oauthErr) && oauthErr.Code == "invalid_client":
+		if basic {
+			c.Header("WWW-Authenticate", `Basic realm="apr"`)
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, oauthErr)
+		return
+	case errors.As(err, &oauthErr):
+		c.AbortWithStatusJSON(http.StatusBadRequest, oauthErr)
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.OAuthError{Code: "server_error"})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// swagger:route GET /oauth/userinfo oauth UserInfo
+// Returns claims about the company the access token was issued to. Only
+// access tokens of OAuth clients with the openid scope are accepted.
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: userInfo
+// 401: errRes
+// 403: errRes
+// 500: errRes
+func (oauthCtr OAuthController) UserInfo(c *gin.Context) {
+	pib, err := strconv.Atoi(c.GetString(client.Principal))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid token subject"})
+		return
+	}
+	info, err := oauthCtr.oauthServ.UserInfo(pib)
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// Logout ends the SSO session. Browsers are sent back to
+// post_logout_redirect_uri only if it is registered for client_id.
+func (oauthCtr OAuthController) Logout(c *gin.Context) {
+	token, _ := c.Cookie(ssoCookie)
+	if err := oauthCtr.oauthServ.EndSession(token); err != nil {
+		log.Println(err.Error())
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoCookie, "", -1, "/oauth", "", oauthCtr.secureCookie, true)
+
+	redirect, ok := oauthCtr.oauthServ.LogoutRedirect(c.Query("client_id"), c.Query("post_logout_redirect_uri"), c.Query("state"))
+	if ok {
+		c.Redirect(http.StatusFound, redirect)
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Success: "Logged out"})
+}
+
+// swagger:route GET /api/admin/oauth/clients admin FindOAuthClients
+// Lists client applications registered for OpenID Connect
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: []oauthClient
+// 500: errRes
+func (oauthCtr OAuthController) FindClients(c *gin.Context) {
+	clients, err := oauthCtr.oauthServ.FindClients()
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, clients)
+}
+
+// swagger:route POST /api/admin/oauth/clients admin CreateOAuthClient
+// Registers a client application. Clients are public and have to use
+// PKCE.
+//
+// Parameters:
+// +name: client
+// in: body
+// type: oauthClient
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 201: oauthClient
+// 400: errRes
+// 409: errRes
+// 500: errRes
+func (oauthCtr OAuthController) CreateClient(c *gin.Context) {
+	var oauthClient model.OAuthClient
+	if err := c.ShouldBindWith(&oauthClient, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide clientId, name and redirectUris"})
+		return
+	}
+	err := oauthCtr.oauthServ.SaveClient(&oauthClient)
+	switch {
+	case errors.Is(err, services.ErrInvalidRedirectUris):
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	case errors.Is(err, db.OAuthClientExistsError):
+		c.AbortWithStatusJSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusCreated, oauthClient)
+}
+
+// swagger:route DELETE /api/admin/oauth/clients/{id} admin DeleteOAuthClient
+// Removes a client application, its unused codes can't be exchanged anymore
+//
+// Parameters:
+// +name: id
+// in: path
+// required: true
+// type: string
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: succRes
+// 404: errRes
+// 500: errRes
+func (oauthCtr OAuthController) DeleteClient(c *gin.Context) {
+	err := oauthCtr.oauthServ.DeleteClient(c.Param("id"))
+	switch {
+	case errors.Is(err, db.NoSuchOAuthClientError):
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Success: "Client deleted"})
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"apr-backend/internal/services"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type OfficialController struct {
+	roleServ services.RoleService
+}
+
+func NewOfficialController(roleServ services.RoleService) OfficialController {
+	return OfficialController{roleServ: roleServ}
+}
+
+// swagger:route GET /api/admin/officials admin FindOfficials
+// Lists roles granted to registrar officials
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: []officialRole
+// 500: errRes
+func (offCtr OfficialController) FindAll(c *gin.Context) {
+	roles, err := offCtr.roleServ.FindOfficials()
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+// swagger:route POST /api/admin/officials admin GrantRole
+// Grants a role to a person, who gets it the next time they start an
+// official session
+//
+// Parameters:
+// +name: role
+// in: body
+// type: officialRole
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: succRes
+// 400: errRes
+// 500: errRes
+func (offCtr OfficialController) Grant(c *gin.Context) {
+	var role model.OfficialRole
+	if err := c.ShouldBindWith(&role, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide jmbg and role"})
+		return
+	}
+	role.GrantedBy = c.GetString(client.Principal)
+
+	err := offCtr.roleServ.Grant(role)
+	switch {
+	case errors.Is(err, services.ErrInvalidRole):
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	case errors.Is(err, db.NoSuchJmbgError):
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Person with this JMBG doesn't exist"})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Success: "Role granted"})
+}
+
+// swagger:route DELETE /api/admin/officials/{jmbg}/{role} admin RevokeRole
+// Revokes a role of an official and ends their sessions
+//
+// Access tokens of the sessions are rejected by APR. Services which don't
+// check revoked sessions accept them until they expire, up to 15 minutes
+// later.
+//
+// Parameters:
+// +name: jmbg
+// in: path
+// required: true
+// type: string
+// +name: role
+// in: path
+// required: true
+// type: string
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: succRes
+// 404: errRes
+// 500: errRes
+func (offCtr OfficialController) Revoke(c *gin.Context) {
+	err := offCtr.roleServ.Revoke(c.Param("jmbg"), c.Param("role"))
+	switch {
+	case errors.Is(err, db.NoSuchOfficialRoleError):
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Success: "Role revoked"})
+}
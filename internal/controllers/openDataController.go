@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"apr-backend/internal/services"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OpenDataController struct {
+	openDataServ services.OpenDataService
+}
+
+func NewOpenDataController(openDataServ services.OpenDataService) OpenDataController {
+	return OpenDataController{openDataServ: openDataServ}
+}
+
+// swagger:route GET /api/opendata/ opendata ListSnapshots
+// Lists open data snapshots of the registry, newest first
+// Responses:
+// 200: []snapshot
+// 500: errRes
+func (odCtr OpenDataController) ListSnapshots(c *gin.Context) {
+	snapshots, err := odCtr.openDataServ.ListSnapshots()
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, snapshots)
+}
+
+// swagger:route GET /api/opendata/{file} opendata DownloadSnapshot
+// Downloads a gzip compressed snapshot or diff
+//
+// Parameters:
+// +name: file
+// in: path
+// description: file name of the snapshot or diff, as returned by ListSnapshots
+// required: true
+// type: string
+//
+// Responses:
+// 200:
+// 404: errRes
+// 500: errRes
+func (odCtr OpenDataController) Download(c *gin.Context) {
+	name := c.Param("file")
+	f, err := odCtr.openDataServ.OpenFile(name)
+	if errors.Is(err, services.ErrNoSuchSnapshot) {
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{Error: "No such snapshot"})
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	defer f.Close()
+
+	c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+	c.Header("Content-Type", "application/gzip")
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, f); err != nil {
+		log.Printf("Error sending %s: %s", name, err.Error())
+	}
+}
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"apr-backend/internal/events"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OutboxController struct {
+	relay *events.Relay
+}
+
+func NewOutboxController(relay *events.Relay) OutboxController {
+	return OutboxController{relay: relay}
+}
+
+// swagger:route GET /api/admin/outbox admin RelayStatus
+// Shows how far behind the outbox each event consumer is
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: relayStatus
+// 500: errRes
+func (outboxCtr OutboxController) Status(c *gin.Context) {
+	status, err := outboxCtr.relay.Status()
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,416 @@
+package controllers
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"apr-backend/internal/ratelimit"
+	"apr-backend/internal/services"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+type PersonController struct {
+	personServ services.PersonService
+	limiter    *ratelimit.Limiter
+}
+
+// limiter limits requests for activation codes
+func NewPersonController(personServ services.PersonService, limiter *ratelimit.Limiter) PersonController {
+	return PersonController{personServ: personServ, limiter: limiter}
+}
+
+// ownCompany returns the PIB in the path if it is the principal's
+func ownCompany(c *gin.Context) (int, bool) {
+	pibParam := c.Param("pib")
+	pib, err := strconv.Atoi(pibParam)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("Provided pib %s is invalid", pibParam)})
+		return 0, false
+	}
+	if c.GetString(client.Principal) != strconv.Itoa(pib) {
+		c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Error: fmt.Sprintf("Not logged in as company %d", pib)})
+		return 0, false
+	}
+	return pib, true
+}
+
+// swagger:route POST /api/person/register person RegisterPerson
+// Creates an account for a person in the registry, who can then act for
+// companies they own or represent. The account can't be used until it is
+// activated with a code sent to those companies.
+//
+// Parameters:
+// +name: registration
+// in: body
+// type: personRegistration
+//
+// Responses:
+// 201: succRes
+// 400: invalidBodyRes
+// 409: errRes
+// 500: errRes
+// 501: errRes
+func (personCtr PersonController) Register(c *gin.Context) {
+	var reg model.PersonRegistration
+	if err := c.ShouldBindWith(&reg, binding.JSON); err != nil {
+		errs, ok := err.(validator.ValidationErrors)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide valid registration as JSON"})
+			return
+		}
+		errMsg := make(map[string]string)
+		for _, e := range errs {
+			errMsg[e.Field()] = model.UserErrors[e.Field()]
+		}
+		c.AbortWithStatusJSON(http.StatusBadRequest, errMsg)
+		return
+	}
+
+	err := personCtr.personServ.Register(reg)
+	switch {
+	case errors.Is(err, services.ErrNotifierDisabled):
+		c.AbortWithStatusJSON(http.StatusNotImplemented, ErrorResponse{Error: "Registration isn't available"})
+		return
+	case errors.Is(err, services.ErrInvalidPassword):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Password": model.UserErrors["Password"]})
+		return
+	case errors.Is(err, services.ErrUnknownPerson):
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	case errors.Is(err, db.PersonAccountExistsError):
+		c.AbortWithStatusJSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusCreated, SuccessResponse{Success: "Account created, the companies you own or represent received its activation code"})
+}
+
+// swagger:route POST /api/person/activation person RequestPersonActivation
+// Sends a new activation code to the companies the person owns or
+// represents. The response is the same whether the account exists or not.
+// Requests are limited by client IP and by JMBG.
+//
+// Parameters:
+// +name: person
+// in: body
+// type: personActivationRequest
+//
+// Responses:
+// 202: succRes
+// 400: errRes
+// 429: errRes
+// 500: errRes
+// 501: errRes
+func (personCtr PersonController) RequestActivation(c *gin.Context) {
+	var req model.PersonActivationRequest
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide jmbg"})
+		return
+	}
+	if rateLimited(c, personCtr.limiter.Allow("ip:"+c.ClientIP())) ||
+		rateLimited(c, personCtr.limiter.Allow("jmbg:"+req.Jmbg)) {
+		return
+	}
+	err := personCtr.personServ.RequestActivation(req.Jmbg)
+	switch {
+	case errors.Is(err, services.ErrNotifierDisabled):
+		c.AbortWithStatusJSON(http.StatusNotImplemented, ErrorResponse{Error: "Activation isn't available"})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusAccepted, SuccessResponse{Success: "If the account is inactive, its companies received an activation code"})
+}
+
+// swagger:route POST /api/person/activation/confirm person ActivatePerson
+// Activates a person account with a code its companies received
+//
+// Parameters:
+// +name: activation
+// in: body
+// type: personActivation
+//
+// Responses:
+// 200: succRes
+// 400: errRes
+// 500: errRes
+func (personCtr PersonController) Activate(c *gin.Context) {
+	var req model.PersonActivation
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide jmbg and code"})
+		return
+	}
+	err := personCtr.personServ.Activate(req.Jmbg, req.Code)
+	switch {
+	case errors.Is(err, db.NoSuchActivationError):
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Success: "Account activated"})
+}
+
+// swagger:route POST /api/person/login person LoginPerson
+// Logs a person in. The token is only good for picking a company to act
+// for, with CreateSession. Accounts which weren't activated get 403.
+//
+// Parameters:
+// +name: credentials
+// in: body
+// type: personCredentials
+//
+// Responses:
+// 200: jwtRes
+// 400: errRes
+// 401: errRes
+// 403: errRes
+// 429: errRes
+// 500: errRes
+func (personCtr PersonController) Login(c *gin.Context) {
+	var creds model.PersonCredentials
+	if err := c.ShouldBindWith(&creds, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide jmbg and password"})
+		return
+	}
+	token, err := personCtr.personServ.Login(creds, c.ClientIP())
+	if tooManyAttempts(c, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrWrongPassword), errors.Is(err, db.NoSuchPersonAccountError):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
+		return
+	case errors.Is(err, services.ErrAccountInactive):
+		c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, JwtResponse{Jwt: token})
+}
+
+// swagger:route GET /api/person/companies person FindPersonCompanies
+// Lists companies the logged-in person owns or represents
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: []personCompany
+// 401: errRes
+// 500: errRes
+func (personCtr PersonController) FindCompanies(c *gin.Context) {
+	companies, err := personCtr.personServ.FindCompanies(c.GetString(client.Principal))
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, companies)
+}
+
+// swagger:route POST /api/person/companies/{pib}/session person CreateSession
+// Starts a session of the logged-in person acting for a company. Tokens of
+// the session name the company as subject and the person in the act claim.
+//
+// Parameters:
+// +name: pib
+// in: path
+// required: true
+// type: integer
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: tokenPairRes
+// 400: errRes
+// 401: errRes
+// 403: errRes
+// 500: errRes
+func (personCtr PersonController) CreateSession(c *gin.Context) {
+	pibParam := c.Param("pib")
+	pib, err := strconv.Atoi(pibParam)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("Provided pib %s is invalid", pibParam)})
+		return
+	}
+	tokens, err := personCtr.personServ.Act(c.GetString(client.Principal), pib)
+	switch {
+	case errors.Is(err, db.NotRepresentativeError):
+		c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Error: fmt.Sprintf("You can't act for company %d", pib)})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// swagger:route POST /api/person/official/session person CreateOfficialSession
+// Starts a session of the logged-in person as a registrar official. Tokens
+// of the session name the person as subject and carry their roles.
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: tokenPairRes
+// 401: errRes
+// 403: errRes
+// 500: errRes
+func (personCtr PersonController) CreateOfficialSession(c *gin.Context) {
+	tokens, err := personCtr.personServ.ActAsOfficial(c.GetString(client.Principal))
+	switch {
+	case errors.Is(err, services.ErrNotOfficial):
+		c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Error: "You aren't a registrar official"})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// representativeError responds to errors of managing representatives
+func representativeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrNotOwner):
+		c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Error: "Only the owner can manage representatives"})
+	case errors.Is(err, db.NoSuchJmbgError):
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Person with this JMBG doesn't exist"})
+	case errors.Is(err, db.NotRepresentativeError):
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{Error: "Person doesn't represent the company"})
+	default:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+	}
+}
+
+// swagger:route GET /api/company/{pib}/representatives company FindRepresentatives
+// Lists persons who may act for the company besides its owner
+//
+// Parameters:
+// +name: pib
+// in: path
+// required: true
+// type: integer
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: []user
+// 400: errRes
+// 401: errRes
+// 403: errRes
+// 500: errRes
+func (personCtr PersonController) FindRepresentatives(c *gin.Context) {
+	pib, ok := ownCompany(c)
+	if !ok {
+		return
+	}
+	persons, err := personCtr.personServ.FindRepresentatives(pib, c.GetString(client.ActingPerson))
+	if err != nil {
+		representativeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, persons)
+}
+
+// swagger:route POST /api/company/{pib}/representatives company AddRepresentative
+// Lets a person act for the company. Only the owner, or the company
+// itself, can add representatives. Admins can require a login with a
+// second factor for it.
+//
+// Parameters:
+// +name: pib
+// in: path
+// required: true
+// type: integer
+// +name: representative
+// in: body
+// type: representativeRequest
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: succRes
+// 400: errRes
+// 401: errRes
+// 403: errRes
+// 500: errRes
+func (personCtr PersonController) AddRepresentative(c *gin.Context) {
+	pib, ok := ownCompany(c)
+	if !ok {
+		return
+	}
+	var req model.RepresentativeRequest
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: model.UserErrors["Jmbg"]})
+		return
+	}
+	if err := personCtr.personServ.AddRepresentative(pib, c.GetString(client.ActingPerson), req.Jmbg); err != nil {
+		representativeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Success: "Representative added"})
+}
+
+// swagger:route DELETE /api/company/{pib}/representatives/{jmbg} company RemoveRepresentative
+// Stops a person from acting for the company and ends their sessions.
+// Admins can require a login with a second factor for it.
+//
+// Parameters:
+// +name: pib
+// in: path
+// required: true
+// type: integer
+// +name: jmbg
+// in: path
+// required: true
+// type: string
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: succRes
+// 400: errRes
+// 401: errRes
+// 403: errRes
+// 404: errRes
+// 500: errRes
+func (personCtr PersonController) RemoveRepresentative(c *gin.Context) {
+	pib, ok := ownCompany(c)
+	if !ok {
+		return
+	}
+	err := personCtr.personServ.RemoveRepresentative(pib, c.GetString(client.ActingPerson), c.Param("jmbg"))
+	if err != nil {
+		representativeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Success: "Representative removed"})
+}
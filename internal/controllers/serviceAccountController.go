@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"apr-backend/internal/services"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type ServiceAccountController struct {
+	accountServ services.ServiceAccountService
+}
+
+func NewServiceAccountController(accountServ services.ServiceAccountService) ServiceAccountController {
+	return ServiceAccountController{accountServ: accountServ}
+}
+
+// accountError responds to errors of managing service accounts
+func accountError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidScope), errors.Is(err, services.ErrInvalidPublicKey):
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, db.NoSuchServiceAccountError):
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+	default:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+	}
+}
+
+// swagger:route GET /api/admin/service-accounts admin FindServiceAccounts
+// Lists service accounts
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: []serviceAccount
+// 500: errRes
+func (accountCtr ServiceAccountController) FindAll(c *gin.Context) {
+	accounts, err := accountCtr.accountServ.FindAll()
+	if err != nil {
+		accountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, accounts)
+}
+
+// swagger:route POST /api/admin/service-accounts admin CreateServiceAccount
+// Creates a service account. The secret of client_secret accounts is only
+// returned here and when it is rotated.
+//
+// Parameters:
+// +name: account
+// in: body
+// type: serviceAccount
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 201: serviceAccountCredentials
+// 400: errRes
+// 500: errRes
+func (accountCtr ServiceAccountController) Create(c *gin.Context) {
+	var account model.ServiceAccount
+	if err := c.ShouldBindWith(&account, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide name, scopes and authMethod client_secret or private_key_jwt"})
+		return
+	}
+	account.CreatedBy = c.GetString(client.Principal)
+	creds, err := accountCtr.accountServ.Create(account)
+	if err != nil {
+		accountError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, creds)
+}
+
+// swagger:route POST /api/admin/service-accounts/{clientId}/rotate admin RotateServiceAccount
+// Replaces the credentials of a service account. client_secret accounts get
+// a new secret, private_key_jwt accounts the given public key. The old
+// credentials stop working and the tokens issued with them are revoked.
+//
+// Parameters:
+// +name: clientId
+// in: path
+// required: true
+// type: string
+// +name: rotation
+// in: body
+// type: rotateCredentialsRequest
+// required: false
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: serviceAccountCredentials
+// 400: errRes
+// 404: errRes
+// 500: errRes
+func (accountCtr ServiceAccountController) Rotate(c *gin.Context) {
+	var req model.RotateCredentialsRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide valid JSON"})
+			return
+		}
+	}
+	creds, err := accountCtr.accountServ.Rotate(c.Param("clientId"), req)
+	if err != nil {
+		accountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, creds)
+}
+
+// swagger:route POST /api/admin/service-accounts/{clientId}/disable admin DisableServiceAccount
+// Disables a service account, it gets no more tokens and the tokens it
+// already has are revoked.
+//
+// Parameters:
+// +name: clientId
+// in: path
+// required: true
+// type: string
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: succRes
+// 404: errRes
+// 500: errRes
+func (accountCtr ServiceAccountController) Disable(c *gin.Context) {
+	if err := accountCtr.accountServ.SetDisabled(c.Param("clientId"), true); err != nil {
+		accountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Success: "Service account disabled"})
+}
+
+// swagger:route POST /api/admin/service-accounts/{clientId}/enable admin EnableServiceAccount
+// Enables a disabled service account again
+//
+// Parameters:
+// +name: clientId
+// in: path
+// required: true
+// type: string
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: succRes
+// 404: errRes
+// 500: errRes
+func (accountCtr ServiceAccountController) Enable(c *gin.Context) {
+	if err := accountCtr.accountServ.SetDisabled(c.Param("clientId"), false); err != nil {
+		accountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Success: "Service account enabled"})
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"apr-backend/internal/services"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type ServiceController struct {
+	catalogueServ services.CatalogueService
+}
+
+func NewServiceController(catalogueServ services.CatalogueService) ServiceController {
+	return ServiceController{catalogueServ: catalogueServ}
+}
+
+// saveServiceError responds to errors of saving a service
+func saveServiceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidService):
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, db.ServiceExistsError), errors.Is(err, services.ErrAudienceTaken):
+		c.AbortWithStatusJSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, db.NoSuchServiceError):
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+	default:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+	}
+}
+
+// swagger:route GET /api/admin/services admin FindServices
+// Lists services SSO tokens can be issued for
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: []registeredService
+// 500: errRes
+func (serviceCtr ServiceController) FindAll(c *gin.Context) {
+	registered, err := serviceCtr.catalogueServ.FindAll()
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, registered)
+}
+
+// swagger:route POST /api/admin/services admin CreateService
+// Registers a service, so that SSO tokens can be issued for it. Audiences
+// can't belong to another service.
+//
+// Parameters:
+// +name: service
+// in: body
+// type: registeredService
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 201: registeredService
+// 400: errRes
+// 409: errRes
+// 500: errRes
+func (serviceCtr ServiceController) Create(c *gin.Context) {
+	var service model.RegisteredService
+	if err := c.ShouldBindWith(&service, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide name, audiences, tokenLifetime between 60 and 86400 and allowedRoles"})
+		return
+	}
+	if err := serviceCtr.catalogueServ.Save(&service); err != nil {
+		saveServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, service)
+}
+
+// swagger:route PUT /api/admin/services/{name} admin UpdateService
+// Changes a registered service. Tokens issued before keep their audiences
+// and lifetime.
+//
+// Parameters:
+// +name: name
+// in: path
+// required: true
+// type: string
+// +name: service
+// in: body
+// type: registeredService
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: registeredService
+// 400: errRes
+// 404: errRes
+// 409: errRes
+// 500: errRes
+func (serviceCtr ServiceController) Update(c *gin.Context) {
+	var service model.RegisteredService
+	if err := c.ShouldBindWith(&service, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide name, audiences, tokenLifetime between 60 and 86400 and allowedRoles"})
+		return
+	}
+	if service.Name != c.Param("name") {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Services can't be renamed"})
+		return
+	}
+	if err := serviceCtr.catalogueServ.Update(&service); err != nil {
+		saveServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, service)
+}
+
+// swagger:route DELETE /api/admin/services/{name} admin DeleteService
+// Removes a service, no more tokens are issued for it
+//
+// Parameters:
+// +name: name
+// in: path
+// required: true
+// type: string
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: succRes
+// 404: errRes
+// 500: errRes
+func (serviceCtr ServiceController) Delete(c *gin.Context) {
+	err := serviceCtr.catalogueServ.Delete(c.Param("name"))
+	switch {
+	case errors.Is(err, db.NoSuchServiceError):
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Success: "Service deleted"})
+}
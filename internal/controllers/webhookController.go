@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"apr-backend/internal/services"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+type WebhookController struct {
+	webhookServ services.WebhookService
+}
+
+func NewWebhookController(webhookServ services.WebhookService) WebhookController {
+	return WebhookController{webhookServ: webhookServ}
+}
+
+// swagger:route POST /api/admin/webhooks/ admin CreateWebhook
+// Subscribes a URL to registry events
+//
+// Deliveries are POSTed as JSON and signed with HMAC-SHA256 of
+// "<X-APR-Timestamp>.<body>" using the returned secret, sent in the
+// X-APR-Signature header as "sha256=<hex>".
+//
+// Parameters:
+// +name: webhook
+// in: body
+// type: webhookSubscription
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 201: webhookSubscription
+// 400: invalidBodyRes
+// 500: errRes
+func (webhookCtr WebhookController) Create(c *gin.Context) {
+	var sub model.WebhookSubscription
+	if err := c.ShouldBindWith(&sub, binding.JSON); err != nil {
+		errs, ok := err.(validator.ValidationErrors)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Must provide valid webhook as JSON"})
+			return
+		}
+		errMsg := make(map[string]string)
+		for _, e := range errs {
+			errMsg[e.StructField()] = model.WebhookErrors[e.StructField()]
+		}
+		c.AbortWithStatusJSON(http.StatusBadRequest, errMsg)
+		return
+	}
+
+	if err := webhookCtr.webhookServ.Create(&sub); err != nil {
+		log.Printf("Couldn't save webhook: %s", err.Error())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Error when saving to database."})
+		return
+	}
+	c.JSON(http.StatusCreated, sub)
+}
+
+// swagger:route GET /api/admin/webhooks/ admin FindWebhooks
+// Lists webhook subscriptions
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: []webhookSubscription
+// 500: errRes
+func (webhookCtr WebhookController) FindAll(c *gin.Context) {
+	subs, err := webhookCtr.webhookServ.FindAll()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, subs)
+}
+
+func idParam(c *gin.Context) (int, bool) {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("Provided id %s is invalid", idStr)})
+		return 0, false
+	}
+	return id, true
+}
+
+// swagger:route DELETE /api/admin/webhooks/{id} admin DeleteWebhook
+// Removes a webhook subscription together with its queued deliveries and
+// dead letters
+//
+// Parameters:
+// +name: id
+// in: path
+// required: true
+// type: integer
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: succRes
+// 400: errRes
+// 404: errRes
+// 500: errRes
+func (webhookCtr WebhookController) Delete(c *gin.Context) {
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	err := webhookCtr.webhookServ.Delete(id)
+	if errors.Is(err, db.NoSuchWebhookError) {
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, SuccessResponse{Success: "Webhook deleted"})
+}
+
+// swagger:route GET /api/admin/webhooks/dead-letters admin FindDeadLetters
+// Lists deliveries which failed on every retry
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 200: []deadLetter
+// 500: errRes
+func (webhookCtr WebhookController) FindDeadLetters(c *gin.Context) {
+	dls, err := webhookCtr.webhookServ.FindDeadLetters()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, dls)
+}
+
+// swagger:route POST /api/admin/webhooks/dead-letters/{id}/redeliver admin RedeliverDeadLetter
+// Queues a dead letter for delivery again
+//
+// Parameters:
+// +name: id
+// in: path
+// required: true
+// type: integer
+//
+// Security:
+//   - bearerAuth:
+//
+// Responses:
+// 202: succRes
+// 400: errRes
+// 404: errRes
+// 500: errRes
+func (webhookCtr WebhookController) Redeliver(c *gin.Context) {
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	err := webhookCtr.webhookServ.Redeliver(id)
+	if errors.Is(err, db.NoSuchDeadLetterError) || errors.Is(err, db.NoSuchWebhookError) {
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusAccepted, SuccessResponse{Success: "Redelivery queued"})
+}
@@ -6,10 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 )
 
 var InvalidFilter = errors.New("Invalid filter")
 var NoSuchPibError = errors.New("PIB not found in database")
+var NoSuchNstjError = errors.New("NSTJ doesn't exist in the database")
 
 func NewCompanyRepository(db *sql.DB, personRepo PersonRepository) CompanyRepository {
 	return companyRepository{
@@ -21,10 +23,32 @@ func NewCompanyRepository(db *sql.DB, personRepo PersonRepository) CompanyReposi
 type CompanyRepository interface {
 	// Saves a company, making sure that person with JMBG already exists in the db
 	SaveCompany(com *model.Company) error
+	// Same as SaveCompany, but inside a transaction owned by the caller.
+	// Every mutation also writes its event to the outbox in the same
+	// transaction.
+	SaveCompanyTx(tx *sql.Tx, com *model.Company) error
+	// Checks what SaveCompanyTx checks before saving, NoSuchJmbgError or
+	// NoSuchNstjError, without saving anything
+	CheckCompanyTx(tx *sql.Tx, com *model.Company) error
 	FindCompanies(filter model.CompanyFilter) ([]model.Company, error)
+	// Calls fn for every company matching filter, ignoring pagination.
+	// Rows are read one at a time, so the result set is never held in memory.
+	StreamCompanies(filter model.CompanyFilter, fn func(model.Company) error) error
 	FindOne(pib int) (model.Company, error)
+	// Same as FindOne, but also finds liquidated companies.
+	FindOneAny(pib int) (model.Company, error)
+	// Finds every company with one of pibs, PIBs which don't exist are
+	// skipped.
+	FindMany(pibs []int) ([]model.Company, error)
 	FindOneCredentials(pib int) (model.Company, error)
-	LiquidateById(pib int) error
+	// Stores a new password hash for an active company, a company.changed
+	// event
+	UpdatePassword(pib int, passwordHash string) error
+	// Liquidates the company, by is the JMBG of the person who did it and
+	// empty if the company did. It is kept in
+	//
+	//	ALTER TABLE company ADD COLUMN likvidirao CHAR(13) NULL;
+	LiquidateById(pib int, by string) error
 }
 
 type companyRepository struct {
@@ -33,15 +57,22 @@ type companyRepository struct {
 }
 
 // LiquidateById implements CompanyRepository
-func (cr companyRepository) LiquidateById(pib int) error {
-	query := `UPDATE company SET likvidirana = 1 WHERE PIB = ?`
-	stmt, err := cr.db.Prepare(query)
+func (cr companyRepository) LiquidateById(pib int, by string) error {
+	tx, err := cr.db.Begin()
+	if err != nil {
+		return DatabaseError
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE company SET likvidirana = 1, likvidirao = ? WHERE PIB = ? AND likvidirana = 0`
+	stmt, err := tx.Prepare(query)
 	if err != nil {
 		log.Printf("err: %s\n", err.Error())
 		return fmt.Errorf("Error creating prepared statement: %w", DatabaseError)
 	}
+	defer stmt.Close()
 
-	res, err := stmt.Exec(pib)
+	res, err := stmt.Exec(nullString(by), pib)
 	if err != nil {
 		log.Printf("err: %s\n", err.Error())
 		return fmt.Errorf("Error executing query: %w", DatabaseError)
@@ -55,27 +86,49 @@ func (cr companyRepository) LiquidateById(pib int) error {
 		return fmt.Errorf("Cannot delete company with pib %d: %w", pib, NoSuchPibError)
 	}
 
+	if err := saveEvent(tx, model.Event{Type: model.EventCompanyLiquidated, PIB: pib}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return fmt.Errorf("Error committing liquidation: %w", DatabaseError)
+	}
 	return nil
 }
 
 // FindOne implements CompanyRepository
 func (cr companyRepository) FindOne(pib int) (model.Company, error) {
+	return cr.findOne(pib, false)
+}
+
+// FindOneAny implements CompanyRepository
+func (cr companyRepository) FindOneAny(pib int) (model.Company, error) {
+	return cr.findOne(pib, true)
+}
+
+func (cr companyRepository) findOne(pib int, withLiquidated bool) (model.Company, error) {
+	return findCompany(cr.db, pib, withLiquidated)
+}
+
+// queryer is a database or a transaction
+type queryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func findCompany(q queryer, pib int, withLiquidated bool) (model.Company, error) {
 	query := `SELECT PIB, delatnost, vlasnik, c.naziv, adresaSedista,
-    postanskiBroj, mesto, n.oznaka, n.naziv as nstjNaziv, p.name, p.lastname
+    postanskiBroj, mesto, n.oznaka, n.naziv as nstjNaziv, p.name, p.lastname, likvidirana
     FROM company c
     LEFT JOIN NSTJ n ON c.sediste = n.oznaka
     LEFT JOIN person p ON p.jmbg = c.vlasnik
-    WHERE (c.PIB = ?)
+    WHERE (c.PIB = ?)`
+	if !withLiquidated {
+		query += `
     AND (likvidirana = 0)`
-
-	stmt, err := cr.db.Prepare(query)
-	if err != nil {
-		log.Printf("err: %s\n", err.Error())
-		return model.Company{}, fmt.Errorf("Error creating prepared statement: %w", DatabaseError)
 	}
 
 	var company model.Company
-	err = stmt.QueryRow(pib).Scan(&company.PIB, &company.Delatnost, &company.Vlasnik.Jmbg, &company.Naziv, &company.AdresaSedista, &company.PostanskiBroj, &company.Mesto, &company.Sediste.Oznaka, &company.Sediste.Naziv, &company.Vlasnik.Name, &company.Vlasnik.Lastname)
+	err := q.QueryRow(query, pib).Scan(&company.PIB, &company.Delatnost, &company.Vlasnik.Jmbg, &company.Naziv, &company.AdresaSedista, &company.PostanskiBroj, &company.Mesto, &company.Sediste.Oznaka, &company.Sediste.Naziv, &company.Vlasnik.Name, &company.Vlasnik.Lastname, &company.Likvidirana)
 	if err == sql.ErrNoRows {
 		return model.Company{}, fmt.Errorf("Company with PIB %d not found: %w", pib, NoSuchPibError)
 	}
@@ -86,6 +139,43 @@ func (cr companyRepository) FindOne(pib int) (model.Company, error) {
 	return company, nil
 }
 
+// FindMany implements CompanyRepository
+func (cr companyRepository) FindMany(pibs []int) ([]model.Company, error) {
+	if len(pibs) == 0 {
+		return []model.Company{}, nil
+	}
+	placeholders := strings.Repeat(",?", len(pibs))[1:]
+	args := make([]any, len(pibs))
+	for i, pib := range pibs {
+		args[i] = pib
+	}
+	query := `SELECT PIB, delatnost, vlasnik, c.naziv, adresaSedista,
+    postanskiBroj, mesto, n.oznaka, n.naziv as nstjNaziv, p.name, p.lastname
+    FROM company c
+    LEFT JOIN NSTJ n ON c.sediste = n.oznaka
+    LEFT JOIN person p ON p.jmbg = c.vlasnik
+    WHERE c.PIB IN (` + placeholders + `)
+    AND (likvidirana = 0)`
+
+	rows, err := cr.db.Query(query, args...)
+	if err != nil {
+		log.Printf("err: %s\n", err.Error())
+		return []model.Company{}, fmt.Errorf("Error executing query: %w", DatabaseError)
+	}
+	defer rows.Close()
+
+	companies := make([]model.Company, 0, len(pibs))
+	for rows.Next() {
+		var company model.Company
+		err := rows.Scan(&company.PIB, &company.Delatnost, &company.Vlasnik.Jmbg, &company.Naziv, &company.AdresaSedista, &company.PostanskiBroj, &company.Mesto, &company.Sediste.Oznaka, &company.Sediste.Naziv, &company.Vlasnik.Name, &company.Vlasnik.Lastname)
+		if err != nil {
+			return companies, fmt.Errorf("%w: couldn't scan company %#v", DatabaseError, company)
+		}
+		companies = append(companies, company)
+	}
+	return companies, nil
+}
+
 // FindOne implements CompanyRepository
 func (cr companyRepository) FindOneCredentials(pib int) (model.Company, error) {
 	query := `SELECT c.password
@@ -110,6 +200,43 @@ func (cr companyRepository) FindOneCredentials(pib int) (model.Company, error) {
 	return company, nil
 }
 
+// UpdatePassword implements CompanyRepository
+func (cr companyRepository) UpdatePassword(pib int, passwordHash string) error {
+	tx, err := cr.db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err.Error())
+		return fmt.Errorf("Error updating password: %w", DatabaseError)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE company SET password = ? WHERE PIB = ? AND likvidirana = 0`, passwordHash, pib)
+	if err != nil {
+		log.Printf("Error updating password of %d: %s", pib, err.Error())
+		return fmt.Errorf("Error updating password: %w", DatabaseError)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("Company with PIB %d not found: %w", pib, NoSuchPibError)
+	}
+	if err := saveChanged(tx, pib); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return fmt.Errorf("Error updating password: %w", DatabaseError)
+	}
+	return nil
+}
+
+// saveChanged writes the company.changed event of pib, with the company as
+// tx sees it
+func saveChanged(tx *sql.Tx, pib int) error {
+	com, err := findCompany(tx, pib, false)
+	if err != nil {
+		return err
+	}
+	return saveEvent(tx, model.Event{Type: model.EventCompanyChanged, PIB: pib, Company: &com})
+}
+
 func validateColumn(col string) bool {
 	validColumns := []string{"naziv", "vlasnik", "PIB", "mesto"}
 	for _, valCol := range validColumns {
@@ -120,8 +247,7 @@ func validateColumn(col string) bool {
 	return false
 }
 
-// FindCompanies implements CompanyRepository
-func (cr companyRepository) FindCompanies(filter model.CompanyFilter) ([]model.Company, error) {
+func filterQuery(filter model.CompanyFilter) (string, error) {
 	query := `SELECT PIB, delatnost, vlasnik, c.naziv, adresaSedista, postanskiBroj, mesto, n.oznaka, n.naziv as nstjNaziv,
         p.name, p.lastname
         FROM company c
@@ -134,7 +260,7 @@ func (cr companyRepository) FindCompanies(filter model.CompanyFilter) ([]model.C
         ORDER BY `
 	valid := validateColumn(filter.OrderBy)
 	if !valid {
-		return []model.Company{}, fmt.Errorf("%w: %s is an invalid column", InvalidFilter, filter.OrderBy)
+		return "", fmt.Errorf("%w: %s is an invalid column", InvalidFilter, filter.OrderBy)
 	}
 
 	if filter.Asc {
@@ -142,6 +268,19 @@ func (cr companyRepository) FindCompanies(filter model.CompanyFilter) ([]model.C
 	} else {
 		query = fmt.Sprintf("%s %s %s", query, filter.OrderBy, "DESC")
 	}
+	return query, nil
+}
+
+func filterArgs(filter model.CompanyFilter) []any {
+	return []any{filter.Delatnost, filter.Delatnost, filter.Sediste, filter.Sediste, filter.Mesto, filter.Mesto}
+}
+
+// FindCompanies implements CompanyRepository
+func (cr companyRepository) FindCompanies(filter model.CompanyFilter) ([]model.Company, error) {
+	query, err := filterQuery(filter)
+	if err != nil {
+		return []model.Company{}, err
+	}
 
 	query = fmt.Sprintf("%s %s %d;", query, "LIMIT 50 OFFSET", filter.Page*50)
 
@@ -150,7 +289,12 @@ func (cr companyRepository) FindCompanies(filter model.CompanyFilter) ([]model.C
 		return []model.Company{}, DatabaseError
 	}
 
-	rows, err := stmt.Query(filter.Delatnost, filter.Delatnost, filter.Sediste, filter.Sediste, filter.Mesto, filter.Mesto)
+	rows, err := stmt.Query(filterArgs(filter)...)
+	if err != nil {
+		log.Printf("err: %s\n", err.Error())
+		return []model.Company{}, fmt.Errorf("Error executing query: %w", DatabaseError)
+	}
+	defer rows.Close()
 
 	companies := make([]model.Company, 0, 50)
 	for rows.Next() {
@@ -164,6 +308,37 @@ func (cr companyRepository) FindCompanies(filter model.CompanyFilter) ([]model.C
 	return companies, nil
 }
 
+// StreamCompanies implements CompanyRepository
+func (cr companyRepository) StreamCompanies(filter model.CompanyFilter, fn func(model.Company) error) error {
+	query, err := filterQuery(filter)
+	if err != nil {
+		return err
+	}
+
+	rows, err := cr.db.Query(query, filterArgs(filter)...)
+	if err != nil {
+		log.Printf("err: %s\n", err.Error())
+		return fmt.Errorf("Error executing query: %w", DatabaseError)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var company model.Company
+		err := rows.Scan(&company.PIB, &company.Delatnost, &company.Vlasnik.Jmbg, &company.Naziv, &company.AdresaSedista, &company.PostanskiBroj, &company.Mesto, &company.Sediste.Oznaka, &company.Sediste.Naziv, &company.Vlasnik.Name, &company.Vlasnik.Lastname)
+		if err != nil {
+			return fmt.Errorf("%w: couldn't scan company %#v", DatabaseError, company)
+		}
+		if err := fn(company); err != nil {
+			return err
+		}
+	}
+	if rows.Err() != nil {
+		log.Printf("Error reading companies: %s\n", rows.Err().Error())
+		return fmt.Errorf("Error reading companies: %w", DatabaseError)
+	}
+	return nil
+}
+
 // SaveCompany implements CompanyRepository
 func (cr companyRepository) SaveCompany(com *model.Company) error {
 	tx, err := cr.db.Begin()
@@ -172,11 +347,42 @@ func (cr companyRepository) SaveCompany(com *model.Company) error {
 	}
 	defer tx.Rollback()
 
-	_, err = cr.personRepo.GetOne(com.Vlasnik.Jmbg, tx)
+	if err := cr.SaveCompanyTx(tx, com); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return fmt.Errorf("Error committing company: %w", DatabaseError)
+	}
+	return nil
+}
+
+// CheckCompanyTx implements CompanyRepository
+func (cr companyRepository) CheckCompanyTx(tx *sql.Tx, com *model.Company) error {
+	_, err := cr.personRepo.GetOne(com.Vlasnik.Jmbg, tx)
 	if err != nil {
 		return fmt.Errorf("Error getting user with JMBG %s: %w", com.Vlasnik.Jmbg, NoSuchJmbgError)
 	}
 
+	var oznaka string
+	err = tx.QueryRow(`SELECT oznaka FROM NSTJ WHERE oznaka = ?`, com.Sediste.Oznaka).Scan(&oznaka)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("Error getting NSTJ %s: %w", com.Sediste.Oznaka, NoSuchNstjError)
+	}
+	if err != nil {
+		log.Printf("Error getting NSTJ %s: %s", com.Sediste.Oznaka, err.Error())
+		return DatabaseError
+	}
+	return nil
+}
+
+// SaveCompanyTx implements CompanyRepository
+func (cr companyRepository) SaveCompanyTx(tx *sql.Tx, com *model.Company) error {
+	if err := cr.CheckCompanyTx(tx, com); err != nil {
+		return err
+	}
+
 	stmt, err := tx.Prepare(`INSERT INTO company
         (delatnost, vlasnik, naziv, adresaSedista, postanskiBroj, mesto, sediste, password)
         VALUES(?, ?, ?, ?, ?, ?, ?, ?);`)
@@ -192,13 +398,10 @@ func (cr companyRepository) SaveCompany(com *model.Company) error {
 		return fmt.Errorf("%w", DatabaseError)
 	}
 	pib, err := res.LastInsertId()
-
-	com.PIB = int(pib)
-
 	if err != nil {
 		return fmt.Errorf("Error when getting PIB of new company: %w", DatabaseError)
 	}
+	com.PIB = int(pib)
 
-	tx.Commit()
-	return nil
+	return saveEvent(tx, model.Event{Type: model.EventCompanyRegistered, PIB: com.PIB, Company: com})
 }
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"apr-backend/internal/model"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+var NoSuchExtractError = errors.New("Extract doesn't exist")
+
+// ExtractRepository keeps every issued registry extract, so that their codes
+// can be verified
+//
+//	CREATE TABLE company_extract (
+//	    code     VARCHAR(32) PRIMARY KEY,
+//	    pib      INT NOT NULL,
+//	    issuedAt DATETIME NOT NULL,
+//	    company  JSON NOT NULL,
+//	    INDEX (pib)
+//	);
+type ExtractRepository interface {
+	Save(ext model.Extract) error
+	FindOne(code string) (model.Extract, error)
+}
+
+func NewExtractRepository(db *sql.DB) ExtractRepository {
+	return extractRepo{db: db}
+}
+
+type extractRepo struct {
+	db *sql.DB
+}
+
+// Save implements ExtractRepository
+func (er extractRepo) Save(ext model.Extract) error {
+	company, err := json.Marshal(ext.Company)
+	if err != nil {
+		return fmt.Errorf("Error encoding extract: %w", err)
+	}
+	_, err = er.db.Exec(`INSERT INTO company_extract (code, pib, issuedAt, company) VALUES (?, ?, ?, ?)`,
+		ext.Code, ext.Company.PIB, ext.IssuedAt, company)
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error saving extract: %w", DatabaseError)
+	}
+	return nil
+}
+
+// FindOne implements ExtractRepository
+func (er extractRepo) FindOne(code string) (model.Extract, error) {
+	var ext model.Extract
+	var company []byte
+	err := er.db.QueryRow(`SELECT code, issuedAt, company FROM company_extract WHERE code = ?`, code).
+		Scan(&ext.Code, &ext.IssuedAt, &company)
+	if err == sql.ErrNoRows {
+		return model.Extract{}, fmt.Errorf("Extract %s not found: %w", code, NoSuchExtractError)
+	}
+	if err != nil {
+		log.Printf("Error getting extract %s: %s", code, err.Error())
+		return model.Extract{}, fmt.Errorf("Error getting extract: %w", DatabaseError)
+	}
+	if err := json.Unmarshal(company, &ext.Company); err != nil {
+		log.Printf("Error decoding extract %s: %s", code, err.Error())
+		return model.Extract{}, fmt.Errorf("Error decoding extract: %w", DatabaseError)
+	}
+	return ext, nil
+}
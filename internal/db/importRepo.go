@@ -0,0 +1,213 @@
+package db
+
+import (
+	"apr-backend/internal/model"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+var NoSuchImportJobError = errors.New("Import job doesn't exist")
+
+// ImportRepository stores company imports.
+//
+// Progress of atomic and chunked imports is kept in the tables
+//
+//	CREATE TABLE company_import (
+//	    id        INT AUTO_INCREMENT PRIMARY KEY,
+//	    checksum  CHAR(64) NOT NULL,
+//	    total     INT NOT NULL,
+//	    rowsDone  INT NOT NULL DEFAULT 0,
+//	    imported  INT NOT NULL DEFAULT 0,
+//	    finished  BOOLEAN NOT NULL DEFAULT 0,
+//	    createdAt DATETIME NOT NULL
+//	);
+//
+//	ALTER TABLE company_import
+//	    ADD COLUMN mode  VARCHAR(10) NOT NULL DEFAULT 'chunked',
+//	    ADD COLUMN error VARCHAR(255) NULL;
+//
+//	CREATE TABLE company_import_error (
+//	    jobId  INT NOT NULL,
+//	    line   INT NOT NULL,
+//	    errors TEXT NOT NULL, -- JSON object, field to message
+//	    PRIMARY KEY (jobId, line)
+//	);
+type ImportRepository interface {
+	CreateJob(job *model.ImportJob) error
+	// Finds a job with the errors of its rows
+	FindJob(id int) (model.ImportJob, error)
+	// Stores the progress of job and adds rowErrors to it, outside of a
+	// chunk
+	UpdateJob(job *model.ImportJob, rowErrors []model.ImportRowError) error
+	// Saves companies in a single transaction and returns the error of each
+	// one, in order. After all companies have been tried, commit decides
+	// whether the transaction is committed and returns the row errors to
+	// record. When it is and job is not nil, the job's progress and those
+	// errors are stored in the same transaction, so progress never gets
+	// ahead of or behind the saved companies.
+	SaveChunk(job *model.ImportJob, coms []*model.Company, commit func(errs []error) ([]model.ImportRowError, bool)) ([]error, bool, error)
+	// Returns the error SaveChunk would return for each company, without
+	// saving any
+	CheckChunk(coms []*model.Company) ([]error, error)
+}
+
+func NewImportRepository(db *sql.DB, comRepo CompanyRepository) ImportRepository {
+	return importRepo{db: db, comRepo: comRepo}
+}
+
+type importRepo struct {
+	db      *sql.DB
+	comRepo CompanyRepository
+}
+
+// CreateJob implements ImportRepository
+func (ir importRepo) CreateJob(job *model.ImportJob) error {
+	res, err := ir.db.Exec(`INSERT INTO company_import (checksum, total, rowsDone, imported, finished, mode, createdAt)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`, job.Checksum, job.Total, job.RowsDone, job.Imported, job.Finished, job.Mode, job.CreatedAt)
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error creating import job: %w", DatabaseError)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("Error when getting id of import job: %w", DatabaseError)
+	}
+	job.Id = int(id)
+	return nil
+}
+
+// FindJob implements ImportRepository
+func (ir importRepo) FindJob(id int) (model.ImportJob, error) {
+	var job model.ImportJob
+	var jobError sql.NullString
+	err := ir.db.QueryRow(`SELECT id, mode, checksum, total, rowsDone, imported, finished, error, createdAt
+        FROM company_import WHERE id = ?`, id).
+		Scan(&job.Id, &job.Mode, &job.Checksum, &job.Total, &job.RowsDone, &job.Imported, &job.Finished, &jobError, &job.CreatedAt)
+	if err == sql.ErrNoRows {
+		return job, fmt.Errorf("Import job %d not found: %w", id, NoSuchImportJobError)
+	}
+	if err != nil {
+		log.Printf("Error getting import job %d: %s", id, err.Error())
+		return job, DatabaseError
+	}
+	job.Error = jobError.String
+
+	rows, err := ir.db.Query(`SELECT line, errors FROM company_import_error WHERE jobId = ? ORDER BY line`, id)
+	if err != nil {
+		log.Printf("Error getting errors of import job %d: %s", id, err.Error())
+		return job, DatabaseError
+	}
+	defer rows.Close()
+	job.Errors = []model.ImportRowError{}
+	for rows.Next() {
+		var rowError model.ImportRowError
+		var errs []byte
+		if err := rows.Scan(&rowError.Row, &errs); err != nil {
+			log.Printf("Error getting errors of import job %d: %s", id, err.Error())
+			return job, DatabaseError
+		}
+		if err := json.Unmarshal(errs, &rowError.Errors); err != nil {
+			log.Printf("Invalid errors of import job %d row %d: %s", id, rowError.Row, err.Error())
+			return job, DatabaseError
+		}
+		job.Errors = append(job.Errors, rowError)
+	}
+	return job, nil
+}
+
+// saveJob stores the progress of job and adds rowErrors to it
+func saveJob(tx *sql.Tx, job *model.ImportJob, rowErrors []model.ImportRowError) error {
+	_, err := tx.Exec(`UPDATE company_import SET rowsDone = ?, imported = ?, finished = ?, error = ? WHERE id = ?`,
+		job.RowsDone, job.Imported, job.Finished, nullString(job.Error), job.Id)
+	if err != nil {
+		log.Printf("Error updating import job %d: %s", job.Id, err.Error())
+		return fmt.Errorf("Error updating import job: %w", DatabaseError)
+	}
+	for _, rowError := range rowErrors {
+		errs, err := json.Marshal(rowError.Errors)
+		if err != nil {
+			return fmt.Errorf("Error encoding errors of row %d: %w", rowError.Row, err)
+		}
+		// A resumed chunk may fail the same rows again
+		_, err = tx.Exec(`INSERT INTO company_import_error (jobId, line, errors) VALUES (?, ?, ?)
+            ON DUPLICATE KEY UPDATE errors = VALUES(errors)`, job.Id, rowError.Row, errs)
+		if err != nil {
+			log.Printf("Error saving errors of import job %d: %s", job.Id, err.Error())
+			return fmt.Errorf("Error updating import job: %w", DatabaseError)
+		}
+	}
+	return nil
+}
+
+// UpdateJob implements ImportRepository
+func (ir importRepo) UpdateJob(job *model.ImportJob, rowErrors []model.ImportRowError) error {
+	tx, err := ir.db.Begin()
+	if err != nil {
+		return DatabaseError
+	}
+	defer tx.Rollback()
+
+	if err := saveJob(tx, job, rowErrors); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return fmt.Errorf("Error updating import job: %w", DatabaseError)
+	}
+	return nil
+}
+
+// SaveChunk implements ImportRepository
+func (ir importRepo) SaveChunk(job *model.ImportJob, coms []*model.Company, commit func(errs []error) ([]model.ImportRowError, bool)) ([]error, bool, error) {
+	tx, err := ir.db.Begin()
+	if err != nil {
+		return nil, false, DatabaseError
+	}
+	defer tx.Rollback()
+
+	errs := make([]error, len(coms))
+	for i, com := range coms {
+		errs[i] = ir.comRepo.SaveCompanyTx(tx, com)
+		if errors.Is(errs[i], DatabaseError) {
+			return errs, false, errs[i]
+		}
+	}
+	rowErrors, ok := commit(errs)
+	if !ok {
+		return errs, false, nil
+	}
+
+	if job != nil {
+		if err := saveJob(tx, job, rowErrors); err != nil {
+			return errs, false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return errs, false, fmt.Errorf("Error committing import: %w", DatabaseError)
+	}
+	return errs, true, nil
+}
+
+// CheckChunk implements ImportRepository. Nothing is written, so no PIBs
+// are used up.
+func (ir importRepo) CheckChunk(coms []*model.Company) ([]error, error) {
+	tx, err := ir.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, DatabaseError
+	}
+	defer tx.Rollback()
+
+	errs := make([]error, len(coms))
+	for i, com := range coms {
+		errs[i] = ir.comRepo.CheckCompanyTx(tx, com)
+		if errors.Is(errs[i], DatabaseError) {
+			return errs, errs[i]
+		}
+	}
+	return errs, nil
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"apr-backend/internal/model"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// LockoutRepository records lockouts caused by failed logins
+//
+//	CREATE TABLE account_lockout (
+//	    id          INT AUTO_INCREMENT PRIMARY KEY,
+//	    kind        VARCHAR(10) NOT NULL,
+//	    subject     VARCHAR(100) NOT NULL,
+//	    failures    INT NOT NULL,
+//	    lockedAt    DATETIME NOT NULL,
+//	    lockedUntil DATETIME NOT NULL,
+//	    unlockedAt  DATETIME NULL,
+//	    unlockedBy  VARCHAR(100) NULL,
+//	    INDEX (kind, subject)
+//	);
+type LockoutRepository interface {
+	Save(lockout *model.Lockout) error
+	// Lockouts which haven't expired or been lifted
+	FindActive() ([]model.Lockout, error)
+	// Lifts active lockouts of subject
+	Unlock(kind, subject, by string) error
+}
+
+func NewLockoutRepository(db *sql.DB) LockoutRepository {
+	return lockoutRepo{db: db}
+}
+
+type lockoutRepo struct {
+	db *sql.DB
+}
+
+// Save implements LockoutRepository
+func (lr lockoutRepo) Save(lockout *model.Lockout) error {
+	res, err := lr.db.Exec(`INSERT INTO account_lockout (kind, subject, failures, lockedAt, lockedUntil) VALUES (?, ?, ?, ?, ?)`,
+		lockout.Kind, lockout.Subject, lockout.Failures, lockout.LockedAt, lockout.LockedUntil)
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error saving lockout: %w", DatabaseError)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("Error when getting id of lockout: %w", DatabaseError)
+	}
+	lockout.Id = int(id)
+	return nil
+}
+
+// FindActive implements LockoutRepository
+func (lr lockoutRepo) FindActive() ([]model.Lockout, error) {
+	rows, err := lr.db.Query(`SELECT id, kind, subject, failures, lockedAt, lockedUntil FROM account_lockout
+    WHERE unlockedAt IS NULL AND lockedUntil > ? ORDER BY lockedAt DESC`, time.Now().UTC())
+	if err != nil {
+		log.Printf("Error reading lockouts: %s", err.Error())
+		return nil, fmt.Errorf("Error reading lockouts: %w", DatabaseError)
+	}
+	defer rows.Close()
+
+	lockouts := []model.Lockout{}
+	for rows.Next() {
+		var l model.Lockout
+		if err := rows.Scan(&l.Id, &l.Kind, &l.Subject, &l.Failures, &l.LockedAt, &l.LockedUntil); err != nil {
+			log.Printf("Error scanning lockout: %s", err.Error())
+			return nil, fmt.Errorf("Error reading lockouts: %w", DatabaseError)
+		}
+		lockouts = append(lockouts, l)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error reading lockouts: %s", err.Error())
+		return nil, fmt.Errorf("Error reading lockouts: %w", DatabaseError)
+	}
+	return lockouts, nil
+}
+
+// Unlock implements LockoutRepository
+func (lr lockoutRepo) Unlock(kind, subject, by string) error {
+	now := time.Now().UTC()
+	_, err := lr.db.Exec(`UPDATE account_lockout SET unlockedAt = ?, unlockedBy = ?
+    WHERE kind = ? AND subject = ? AND unlockedAt IS NULL AND lockedUntil > ?`, now, by, kind, subject, now)
+	if err != nil {
+		log.Printf("Error unlocking %s %s: %s", kind, subject, err.Error())
+		return fmt.Errorf("Error unlocking: %w", DatabaseError)
+	}
+	return nil
+}
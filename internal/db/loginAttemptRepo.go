@@ -0,0 +1,138 @@
+package db
+
+import (
+	"apr-backend/internal/model"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// LoginAttemptRepository is the shared store of failed logins, used by
+// lockout.Guard when several replicas run
+//
+//	CREATE TABLE login_attempt (
+//	    attemptKey  VARCHAR(100) PRIMARY KEY,
+//	    failures    INT NOT NULL,
+//	    lastFailure DATETIME(6) NOT NULL,
+//	    lockedUntil DATETIME(6) NULL
+//	);
+type LoginAttemptRepository interface {
+	Get(key string) (model.LoginAttempts, error)
+	// Counts a failure unless check rejects the attempts so far, with the
+	// row of key locked in between
+	Attempt(key string, window time.Duration, check func(model.LoginAttempts) error) error
+	Release(key string) error
+	// Locks key until until, false if it already was locked
+	Lock(key string, until time.Time) (bool, error)
+	Reset(key string) error
+}
+
+func NewLoginAttemptRepository(db *sql.DB) LoginAttemptRepository {
+	return loginAttemptRepo{db: db}
+}
+
+type loginAttemptRepo struct {
+	db *sql.DB
+}
+
+// Implemented by both *sql.DB and *sql.Tx
+type rowQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// get reads the attempts of key, lock is appended to the query
+func (lar loginAttemptRepo) get(q rowQuerier, key string, lock string) (model.LoginAttempts, error) {
+	var attempts model.LoginAttempts
+	var lockedUntil sql.NullTime
+	err := q.QueryRow(`SELECT failures, lastFailure, lockedUntil FROM login_attempt WHERE attemptKey = ?`+lock, key).
+		Scan(&attempts.Failures, &attempts.LastFailure, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return model.LoginAttempts{}, nil
+	}
+	if err != nil {
+		log.Printf("Error reading login attempts: %s", err.Error())
+		return model.LoginAttempts{}, fmt.Errorf("Error reading login attempts: %w", DatabaseError)
+	}
+	attempts.LockedUntil = lockedUntil.Time
+	return attempts, nil
+}
+
+// Get implements LoginAttemptRepository
+func (lar loginAttemptRepo) Get(key string) (model.LoginAttempts, error) {
+	return lar.get(lar.db, key, "")
+}
+
+// Attempt implements LoginAttemptRepository
+func (lar loginAttemptRepo) Attempt(key string, window time.Duration, check func(model.LoginAttempts) error) error {
+	tx, err := lar.db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err.Error())
+		return fmt.Errorf("Error saving login attempt: %w", DatabaseError)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	// The row has to exist for FOR UPDATE to lock it
+	if _, err := tx.Exec(`INSERT IGNORE INTO login_attempt (attemptKey, failures, lastFailure) VALUES (?, 0, ?)`, key, now); err != nil {
+		log.Printf("Error saving login attempt: %s", err.Error())
+		return fmt.Errorf("Error saving login attempt: %w", DatabaseError)
+	}
+	attempts, err := lar.get(tx, key, " FOR UPDATE")
+	if err != nil {
+		return err
+	}
+	if now.Sub(attempts.LastFailure) > window {
+		attempts.Failures = 0
+	}
+	if err := check(attempts); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE login_attempt SET failures = ?, lastFailure = ? WHERE attemptKey = ?`, attempts.Failures+1, now, key); err != nil {
+		log.Printf("Error saving login attempt: %s", err.Error())
+		return fmt.Errorf("Error saving login attempt: %w", DatabaseError)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return fmt.Errorf("Error saving login attempt: %w", DatabaseError)
+	}
+	return nil
+}
+
+// Release implements LoginAttemptRepository
+func (lar loginAttemptRepo) Release(key string) error {
+	if _, err := lar.db.Exec(`UPDATE login_attempt SET failures = failures - 1 WHERE attemptKey = ? AND failures > 0`, key); err != nil {
+		log.Printf("Error releasing login attempt: %s", err.Error())
+		return fmt.Errorf("Error releasing login attempt: %w", DatabaseError)
+	}
+	return nil
+}
+
+// Lock implements LoginAttemptRepository
+func (lar loginAttemptRepo) Lock(key string, until time.Time) (bool, error) {
+	res, err := lar.db.Exec(`UPDATE login_attempt SET lockedUntil = ? WHERE attemptKey = ? AND (lockedUntil IS NULL OR lockedUntil < ?)`,
+		until.UTC(), key, time.Now().UTC())
+	if err != nil {
+		log.Printf("Error locking %s: %s", key, err.Error())
+		return false, fmt.Errorf("Error locking: %w", DatabaseError)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Error locking: %w", DatabaseError)
+	}
+	return n > 0, nil
+}
+
+// Reset implements LoginAttemptRepository
+func (lar loginAttemptRepo) Reset(key string) error {
+	// Keys of addresses are never reset by a successful login, so stale ones
+	// are cleaned up here as well
+	now := time.Now().UTC()
+	_, err := lar.db.Exec(`DELETE FROM login_attempt WHERE attemptKey = ?
+    OR (lastFailure < ? AND (lockedUntil IS NULL OR lockedUntil < ?))`, key, now.Add(-24*time.Hour), now)
+	if err != nil {
+		log.Printf("Error resetting %s: %s", key, err.Error())
+		return fmt.Errorf("Error resetting login attempts: %w", DatabaseError)
+	}
+	return nil
+}
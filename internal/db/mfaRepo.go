@@ -0,0 +1,263 @@
+package db
+
+import (
+	"apr-backend/internal/model"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var NoSuchTotpError = errors.New("Company has no TOTP secret")
+var TotpStepUsedError = errors.New("TOTP code was already used")
+var NoSuchRecoveryCodeError = errors.New("Recovery code doesn't exist or was used")
+
+// MfaRepository stores the second factors of companies and the operations
+// which require one. Only hashes of recovery codes are stored.
+//
+//	CREATE TABLE company_totp (
+//	    pib         INT PRIMARY KEY,
+//	    secret      VARCHAR(64) NOT NULL,
+//	    confirmedAt DATETIME NULL,
+//	    lastStep    BIGINT NOT NULL DEFAULT 0,
+//	    createdAt   DATETIME NOT NULL
+//	);
+//
+//	CREATE TABLE company_recovery_code (
+//	    hash   CHAR(64) PRIMARY KEY,
+//	    pib    INT NOT NULL,
+//	    usedAt DATETIME NULL,
+//	    INDEX (pib)
+//	);
+//
+//	CREATE TABLE mfa_policy (
+//	    operation VARCHAR(32) PRIMARY KEY,
+//	    required  BOOLEAN NOT NULL,
+//	    updatedAt DATETIME NOT NULL,
+//	    updatedBy VARCHAR(20) NULL
+//	);
+type MfaRepository interface {
+	FindTotp(pib int) (model.Totp, error)
+	// Stores an unconfirmed secret for pib, replacing an earlier one
+	SaveTotp(pib int, secret string) error
+	// Confirms the secret of pib with the code of step, and replaces its
+	// recovery codes with hashes
+	ConfirmTotp(pib int, step int64, hashes []string) error
+	// Records that the code of step was used, TotpStepUsedError if it or a
+	// later one was used already
+	UseStep(pib int, step int64) error
+	// Removes the secret and recovery codes of pib
+	DeleteTotp(pib int) error
+	ReplaceRecoveryCodes(pib int, hashes []string) error
+	UseRecoveryCode(pib int, hash string) error
+	// Counts the recovery codes of pib which weren't used
+	CountRecoveryCodes(pib int) (int, error)
+	FindPolicies() ([]model.MfaPolicy, error)
+	SavePolicy(policy *model.MfaPolicy) error
+}
+
+func NewMfaRepository(db *sql.DB) MfaRepository {
+	return mfaRepo{db: db}
+}
+
+type mfaRepo struct {
+	db *sql.DB
+}
+
+// FindTotp implements MfaRepository
+func (mr mfaRepo) FindTotp(pib int) (model.Totp, error) {
+	totp := model.Totp{PIB: pib}
+	var confirmedAt sql.NullTime
+	err := mr.db.QueryRow(`SELECT secret, confirmedAt, lastStep, createdAt FROM company_totp WHERE pib = ?`, pib).
+		Scan(&totp.Secret, &confirmedAt, &totp.LastStep, &totp.CreatedAt)
+	if err == sql.ErrNoRows {
+		return totp, NoSuchTotpError
+	}
+	if err != nil {
+		log.Printf("Error reading TOTP secret: %s", err.Error())
+		return totp, fmt.Errorf("Error reading TOTP secret: %w", DatabaseError)
+	}
+	if confirmedAt.Valid {
+		totp.ConfirmedAt = &confirmedAt.Time
+	}
+	return totp, nil
+}
+
+// SaveTotp implements MfaRepository
+func (mr mfaRepo) SaveTotp(pib int, secret string) error {
+	_, err := mr.db.Exec(`INSERT INTO company_totp (pib, secret, createdAt) VALUES (?, ?, ?)
+    ON DUPLICATE KEY UPDATE secret = VALUES(secret), confirmedAt = NULL, lastStep = 0, createdAt = VALUES(createdAt)`,
+		pib, secret, time.Now().UTC())
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error saving TOTP secret: %w", DatabaseError)
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, pib int, hashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM company_recovery_code WHERE pib = ?`, pib); err != nil {
+		log.Printf("Delete error: %s", err.Error())
+		return fmt.Errorf("Error saving recovery codes: %w", DatabaseError)
+	}
+	for _, hash := range hashes {
+		if _, err := tx.Exec(`INSERT INTO company_recovery_code (hash, pib) VALUES (?, ?)`, hash, pib); err != nil {
+			log.Printf("Insert error: %s", err.Error())
+			return fmt.Errorf("Error saving recovery codes: %w", DatabaseError)
+		}
+	}
+	return nil
+}
+
+// ConfirmTotp implements MfaRepository
+func (mr mfaRepo) ConfirmTotp(pib int, step int64, hashes []string) error {
+	tx, err := mr.db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err.Error())
+		return fmt.Errorf("Error confirming TOTP secret: %w", DatabaseError)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE company_totp SET confirmedAt = ?, lastStep = ? WHERE pib = ? AND confirmedAt IS NULL`,
+		time.Now().UTC(), step, pib)
+	if err != nil {
+		log.Printf("Update error: %s", err.Error())
+		return fmt.Errorf("Error confirming TOTP secret: %w", DatabaseError)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return NoSuchTotpError
+	}
+	if err := replaceRecoveryCodes(tx, pib, hashes); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return fmt.Errorf("Error confirming TOTP secret: %w", DatabaseError)
+	}
+	return nil
+}
+
+// UseStep implements MfaRepository
+func (mr mfaRepo) UseStep(pib int, step int64) error {
+	res, err := mr.db.Exec(`UPDATE company_totp SET lastStep = ? WHERE pib = ? AND lastStep < ?`, step, pib, step)
+	if err != nil {
+		log.Printf("Update error: %s", err.Error())
+		return fmt.Errorf("Error using TOTP code: %w", DatabaseError)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return TotpStepUsedError
+	}
+	return nil
+}
+
+// DeleteTotp implements MfaRepository
+func (mr mfaRepo) DeleteTotp(pib int) error {
+	tx, err := mr.db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err.Error())
+		return fmt.Errorf("Error deleting TOTP secret: %w", DatabaseError)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM company_totp WHERE pib = ?`, pib)
+	if err != nil {
+		log.Printf("Delete error: %s", err.Error())
+		return fmt.Errorf("Error deleting TOTP secret: %w", DatabaseError)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return NoSuchTotpError
+	}
+	if _, err := tx.Exec(`DELETE FROM company_recovery_code WHERE pib = ?`, pib); err != nil {
+		log.Printf("Delete error: %s", err.Error())
+		return fmt.Errorf("Error deleting recovery codes: %w", DatabaseError)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return fmt.Errorf("Error deleting TOTP secret: %w", DatabaseError)
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes implements MfaRepository
+func (mr mfaRepo) ReplaceRecoveryCodes(pib int, hashes []string) error {
+	tx, err := mr.db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err.Error())
+		return fmt.Errorf("Error saving recovery codes: %w", DatabaseError)
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, pib, hashes); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return fmt.Errorf("Error saving recovery codes: %w", DatabaseError)
+	}
+	return nil
+}
+
+// UseRecoveryCode implements MfaRepository
+func (mr mfaRepo) UseRecoveryCode(pib int, hash string) error {
+	res, err := mr.db.Exec(`UPDATE company_recovery_code SET usedAt = ? WHERE hash = ? AND pib = ? AND usedAt IS NULL`,
+		time.Now().UTC(), hash, pib)
+	if err != nil {
+		log.Printf("Update error: %s", err.Error())
+		return fmt.Errorf("Error using recovery code: %w", DatabaseError)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return NoSuchRecoveryCodeError
+	}
+	return nil
+}
+
+// CountRecoveryCodes implements MfaRepository
+func (mr mfaRepo) CountRecoveryCodes(pib int) (int, error) {
+	var count int
+	err := mr.db.QueryRow(`SELECT COUNT(*) FROM company_recovery_code WHERE pib = ? AND usedAt IS NULL`, pib).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting recovery codes: %s", err.Error())
+		return 0, fmt.Errorf("Error counting recovery codes: %w", DatabaseError)
+	}
+	return count, nil
+}
+
+// FindPolicies implements MfaRepository
+func (mr mfaRepo) FindPolicies() ([]model.MfaPolicy, error) {
+	rows, err := mr.db.Query(`SELECT operation, required, updatedAt, updatedBy FROM mfa_policy ORDER BY operation`)
+	if err != nil {
+		log.Printf("Error reading MFA policies: %s", err.Error())
+		return nil, fmt.Errorf("Error reading MFA policies: %w", DatabaseError)
+	}
+	defer rows.Close()
+
+	policies := []model.MfaPolicy{}
+	for rows.Next() {
+		var policy model.MfaPolicy
+		var updatedAt time.Time
+		var updatedBy sql.NullString
+		if err := rows.Scan(&policy.Operation, &policy.Required, &updatedAt, &updatedBy); err != nil {
+			log.Printf("Error reading MFA policies: %s", err.Error())
+			return policies, fmt.Errorf("Error reading MFA policies: %w", DatabaseError)
+		}
+		policy.UpdatedAt = &updatedAt
+		policy.UpdatedBy = updatedBy.String
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// SavePolicy implements MfaRepository
+func (mr mfaRepo) SavePolicy(policy *model.MfaPolicy) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	policy.UpdatedAt = &now
+	_, err := mr.db.Exec(`INSERT INTO mfa_policy (operation, required, updatedAt, updatedBy) VALUES (?, ?, ?, ?)
+    ON DUPLICATE KEY UPDATE required = VALUES(required), updatedAt = VALUES(updatedAt), updatedBy = VALUES(updatedBy)`,
+		policy.Operation, policy.Required, now, nullString(policy.UpdatedBy))
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error saving MFA policy: %w", DatabaseError)
+	}
+	return nil
+}
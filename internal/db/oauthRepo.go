@@ -0,0 +1,229 @@
+package db
+
+import (
+	"apr-backend/internal/model"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var NoSuchOAuthClientError = errors.New("OAuth client doesn't exist")
+var OAuthClientExistsError = errors.New("OAuth client with this id already exists")
+var NoSuchAuthorizationCodeError = errors.New("Authorization code doesn't exist, was used or has expired")
+var AuthorizationCodeReusedError = errors.New("Authorization code was already used")
+
+// OAuthRepository stores OAuth client applications and the authorization
+// codes issued to them
+//
+//	CREATE TABLE oauth_client (
+//	    clientId     VARCHAR(64) PRIMARY KEY,
+//	    name         VARCHAR(100) NOT NULL,
+//	    redirectUris TEXT NOT NULL,
+//	    createdAt    DATETIME NOT NULL
+//	);
+//
+//	CREATE TABLE oauth_code (
+//	    hash          CHAR(64) PRIMARY KEY,
+//	    clientId      VARCHAR(64) NOT NULL,
+//	    redirectUri   VARCHAR(2000) NOT NULL,
+//	    pib           INT NOT NULL,
+//	    scope         VARCHAR(200) NOT NULL,
+//	    nonce         VARCHAR(200) NOT NULL,
+//	    codeChallenge VARCHAR(128) NOT NULL,
+//	    authTime      DATETIME NOT NULL,
+//	    expiresAt     DATETIME NOT NULL,
+//	    usedAt        DATETIME NULL
+//	);
+//
+//	ALTER TABLE oauth_code ADD COLUMN family CHAR(32) NULL,
+//	    ADD COLUMN jti VARCHAR(64) NULL,
+//	    ADD COLUMN tokenExpiresAt DATETIME NULL,
+//	    ADD COLUMN reusedAt DATETIME NULL;
+//
+//	ALTER TABLE oauth_code ADD COLUMN mfa BOOLEAN NOT NULL DEFAULT FALSE;
+type OAuthRepository interface {
+	SaveClient(client *model.OAuthClient) error
+	FindClient(clientId string) (model.OAuthClient, error)
+	FindClients() ([]model.OAuthClient, error)
+	DeleteClient(clientId string) error
+	SaveCode(code model.AuthorizationCode) error
+	// Marks the code with hash as used and returns it. Codes can be used
+	// once, before they expire. Using a code twice returns it with the
+	// tokens issued for it and AuthorizationCodeReusedError.
+	UseCode(hash string) (model.AuthorizationCode, error)
+	// Records the tokens issued for the code with hash,
+	// AuthorizationCodeReusedError if the code was used again meanwhile
+	SaveCodeTokens(hash string, tokens model.ClientTokens) error
+}
+
+func NewOAuthRepository(db *sql.DB) OAuthRepository {
+	return oauthRepo{db: db}
+}
+
+type oauthRepo struct {
+	db *sql.DB
+}
+
+// SaveClient implements OAuthRepository
+func (or oauthRepo) SaveClient(client *model.OAuthClient) error {
+	uris, err := json.Marshal(client.RedirectUris)
+	if err != nil {
+		return fmt.Errorf("Error encoding redirect URIs: %w", err)
+	}
+	client.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	_, err = or.db.Exec(`INSERT INTO oauth_client (clientId, name, redirectUris, createdAt) VALUES (?, ?, ?, ?)`,
+		client.ClientId, client.Name, uris, client.CreatedAt)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		return OAuthClientExistsError
+	}
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error saving OAuth client: %w", DatabaseError)
+	}
+	return nil
+}
+
+func scanOAuthClient(row interface{ Scan(...any) error }) (model.OAuthClient, error) {
+	var client model.OAuthClient
+	var uris []byte
+	if err := row.Scan(&client.ClientId, &client.Name, &uris, &client.CreatedAt); err != nil {
+		return client, err
+	}
+	if err := json.Unmarshal(uris, &client.RedirectUris); err != nil {
+		return client, fmt.Errorf("Error decoding redirect URIs of %s: %w", client.ClientId, err)
+	}
+	return client, nil
+}
+
+// FindClient implements OAuthRepository
+func (or oauthRepo) FindClient(clientId string) (model.OAuthClient, error) {
+	row := or.db.QueryRow(`SELECT clientId, name, redirectUris, createdAt FROM oauth_client WHERE clientId = ?`, clientId)
+	client, err := scanOAuthClient(row)
+	if err == sql.ErrNoRows {
+		return client, fmt.Errorf("%s: %w", clientId, NoSuchOAuthClientError)
+	}
+	if err != nil {
+		log.Printf("Error reading OAuth client: %s", err.Error())
+		return client, fmt.Errorf("Error reading OAuth client: %w", DatabaseError)
+	}
+	return client, nil
+}
+
+// FindClients implements OAuthRepository
+func (or oauthRepo) FindClients() ([]model.OAuthClient, error) {
+	rows, err := or.db.Query(`SELECT clientId, name, redirectUris, createdAt FROM oauth_client ORDER BY clientId`)
+	if err != nil {
+		log.Printf("Error reading OAuth clients: %s", err.Error())
+		return nil, fmt.Errorf("Error reading OAuth clients: %w", DatabaseError)
+	}
+	defer rows.Close()
+
+	clients := []model.OAuthClient{}
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			log.Printf("Error reading OAuth clients: %s", err.Error())
+			return clients, fmt.Errorf("Error reading OAuth clients: %w", DatabaseError)
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// DeleteClient implements OAuthRepository
+func (or oauthRepo) DeleteClient(clientId string) error {
+	res, err := or.db.Exec(`DELETE FROM oauth_client WHERE clientId = ?`, clientId)
+	if err != nil {
+		log.Printf("Delete error: %s", err.Error())
+		return fmt.Errorf("Error deleting OAuth client: %w", DatabaseError)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s: %w", clientId, NoSuchOAuthClientError)
+	}
+	return nil
+}
+
+// SaveCode implements OAuthRepository
+func (or oauthRepo) SaveCode(code model.AuthorizationCode) error {
+	_, err := or.db.Exec(`INSERT INTO oauth_code
+    (hash, clientId, redirectUri, pib, scope, nonce, codeChallenge, authTime, mfa, expiresAt)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		code.Hash, code.ClientId, code.RedirectUri, code.PIB, code.Scope, code.Nonce, code.CodeChallenge,
+		code.AuthTime.UTC(), code.Mfa, code.ExpiresAt.UTC())
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error saving authorization code: %w", DatabaseError)
+	}
+	return nil
+}
+
+// UseCode implements OAuthRepository
+func (or oauthRepo) UseCode(hash string) (model.AuthorizationCode, error) {
+	code := model.AuthorizationCode{Hash: hash}
+	tx, err := or.db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err.Error())
+		return code, fmt.Errorf("Error using authorization code: %w", DatabaseError)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	var usedAt, tokenExpiresAt sql.NullTime
+	var family, jti sql.NullString
+	err = tx.QueryRow(`SELECT clientId, redirectUri, pib, scope, nonce, codeChallenge, authTime, mfa, expiresAt, usedAt, family, jti, tokenExpiresAt
+    FROM oauth_code WHERE hash = ? FOR UPDATE`, hash).
+		Scan(&code.ClientId, &code.RedirectUri, &code.PIB, &code.Scope, &code.Nonce, &code.CodeChallenge, &code.AuthTime, &code.Mfa, &code.ExpiresAt,
+			&usedAt, &family, &jti, &tokenExpiresAt)
+	if err == sql.ErrNoRows {
+		return code, NoSuchAuthorizationCodeError
+	}
+	if err != nil {
+		log.Printf("Error reading authorization code: %s", err.Error())
+		return code, fmt.Errorf("Error using authorization code: %w", DatabaseError)
+	}
+	if usedAt.Valid {
+		// Tokens issued later for the code are revoked too
+		if _, err := tx.Exec(`UPDATE oauth_code SET reusedAt = ? WHERE hash = ?`, now, hash); err != nil {
+			log.Printf("Error using authorization code: %s", err.Error())
+			return code, fmt.Errorf("Error using authorization code: %w", DatabaseError)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Commit error: %s", err.Error())
+			return code, fmt.Errorf("Error using authorization code: %w", DatabaseError)
+		}
+		code.Tokens = model.ClientTokens{Family: family.String, Jti: jti.String, ExpiresAt: tokenExpiresAt.Time}
+		return code, AuthorizationCodeReusedError
+	}
+	if !code.ExpiresAt.After(now) {
+		return code, NoSuchAuthorizationCodeError
+	}
+	if _, err := tx.Exec(`UPDATE oauth_code SET usedAt = ? WHERE hash = ?`, now, hash); err != nil {
+		log.Printf("Error using authorization code: %s", err.Error())
+		return code, fmt.Errorf("Error using authorization code: %w", DatabaseError)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return code, fmt.Errorf("Error using authorization code: %w", DatabaseError)
+	}
+	return code, nil
+}
+
+// SaveCodeTokens implements OAuthRepository
+func (or oauthRepo) SaveCodeTokens(hash string, tokens model.ClientTokens) error {
+	res, err := or.db.Exec(`UPDATE oauth_code SET family = ?, jti = ?, tokenExpiresAt = ? WHERE hash = ? AND reusedAt IS NULL`,
+		nullString(tokens.Family), tokens.Jti, tokens.ExpiresAt.UTC(), hash)
+	if err != nil {
+		log.Printf("Error saving tokens of authorization code: %s", err.Error())
+		return fmt.Errorf("Error saving tokens of authorization code: %w", DatabaseError)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return AuthorizationCodeReusedError
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"apr-backend/internal/model"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var NoSuchOfficialRoleError = errors.New("Person doesn't have this role")
+
+// OfficialRepository stores roles of registrar officials
+//
+//	CREATE TABLE official_role (
+//	    jmbg      CHAR(13) NOT NULL,
+//	    role      VARCHAR(20) NOT NULL,
+//	    grantedAt DATETIME NOT NULL,
+//	    grantedBy VARCHAR(20) NULL,
+//	    PRIMARY KEY (jmbg, role),
+//	    FOREIGN KEY (jmbg) REFERENCES person (jmbg)
+//	);
+type OfficialRepository interface {
+	FindAll() ([]model.OfficialRole, error)
+	FindRoles(jmbg string) ([]string, error)
+	// Granting a role twice keeps the original grant
+	Grant(role model.OfficialRole) error
+	Revoke(jmbg string, role string) error
+}
+
+func NewOfficialRepository(db *sql.DB) OfficialRepository {
+	return officialRepo{db: db}
+}
+
+type officialRepo struct {
+	db *sql.DB
+}
+
+// FindAll implements OfficialRepository
+func (or officialRepo) FindAll() ([]model.OfficialRole, error) {
+	rows, err := or.db.Query(`SELECT jmbg, role, grantedAt, grantedBy FROM official_role ORDER BY jmbg, role`)
+	if err != nil {
+		log.Printf("Error reading official roles: %s", err.Error())
+		return nil, fmt.Errorf("Error reading official roles: %w", DatabaseError)
+	}
+	defer rows.Close()
+
+	roles := []model.OfficialRole{}
+	for rows.Next() {
+		var role model.OfficialRole
+		var grantedBy sql.NullString
+		if err := rows.Scan(&role.Jmbg, &role.Role, &role.GrantedAt, &grantedBy); err != nil {
+			log.Printf("Error reading official roles: %s", err.Error())
+			return roles, fmt.Errorf("Error reading official roles: %w", DatabaseError)
+		}
+		role.GrantedBy = grantedBy.String
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// FindRoles implements OfficialRepository
+func (or officialRepo) FindRoles(jmbg string) ([]string, error) {
+	rows, err := or.db.Query(`SELECT role FROM official_role WHERE jmbg = ? ORDER BY role`, jmbg)
+	if err != nil {
+		log.Printf("Error reading official roles: %s", err.Error())
+		return nil, fmt.Errorf("Error reading official roles: %w", DatabaseError)
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			log.Printf("Error reading official roles: %s", err.Error())
+			return roles, fmt.Errorf("Error reading official roles: %w", DatabaseError)
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// Grant implements OfficialRepository
+func (or officialRepo) Grant(role model.OfficialRole) error {
+	res, err := or.db.Exec(`INSERT INTO official_role (jmbg, role, grantedAt, grantedBy)
+    SELECT jmbg, ?, ?, ? FROM person WHERE jmbg = ?
+    ON DUPLICATE KEY UPDATE role = role`, role.Role, time.Now().UTC(), nullString(role.GrantedBy), role.Jmbg)
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error granting role: %w", DatabaseError)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		var exists bool
+		err := or.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM person WHERE jmbg = ?)`, role.Jmbg).Scan(&exists)
+		if err != nil {
+			log.Printf("Error reading person: %s", err.Error())
+			return fmt.Errorf("Error granting role: %w", DatabaseError)
+		}
+		if !exists {
+			return fmt.Errorf("Person with jmbg %s does not exist: %w", role.Jmbg, NoSuchJmbgError)
+		}
+	}
+	return nil
+}
+
+// Revoke implements OfficialRepository
+func (or officialRepo) Revoke(jmbg string, role string) error {
+	res, err := or.db.Exec(`DELETE FROM official_role WHERE jmbg = ? AND role = ?`, jmbg, role)
+	if err != nil {
+		log.Printf("Delete error: %s", err.Error())
+		return fmt.Errorf("Error revoking role: %w", DatabaseError)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s of %s: %w", role, jmbg, NoSuchOfficialRoleError)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"apr-backend/internal/model"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// OutboxRepository reads registry events written to the outbox and tracks
+// how far each consumer has got.
+//
+// Ids of events come from outbox_sequence rather than AUTO_INCREMENT. The
+// sequence row stays locked until the writing transaction ends, so events
+// commit in the order of their ids, and a rolled back transaction gives its
+// ids back. Consumers can therefore read past their position by id without
+// missing events of transactions which were still open.
+//
+//	CREATE TABLE outbox (
+//	    id        BIGINT AUTO_INCREMENT PRIMARY KEY,
+//	    type      VARCHAR(50) NOT NULL,
+//	    pib       INT NOT NULL,
+//	    payload   JSON NULL,
+//	    createdAt DATETIME(6) NOT NULL
+//	);
+//
+//	CREATE TABLE outbox_sequence (
+//	    id   TINYINT PRIMARY KEY,
+//	    last BIGINT NOT NULL
+//	);
+//	INSERT INTO outbox_sequence SELECT 1, COALESCE(MAX(id), 0) FROM outbox;
+//
+//	CREATE TABLE outbox_consumer (
+//	    name     VARCHAR(100) PRIMARY KEY,
+//	    position BIGINT NOT NULL
+//	);
+type OutboxRepository interface {
+	// Id of the newest event, 0 if the outbox is empty
+	LastId() (int64, error)
+	FindAfter(after int64, limit int) ([]model.Event, error)
+	// Calls fn with the stored position of consumer while holding a lock on
+	// it and stores the position fn returns. Replicas running the same
+	// consumer therefore never process the same events concurrently.
+	WithPosition(consumer string, fn func(position int64) (int64, error)) error
+	FindPositions() (map[string]int64, error)
+}
+
+func NewOutboxRepository(db *sql.DB) OutboxRepository {
+	return outboxRepo{db: db}
+}
+
+type outboxRepo struct {
+	db *sql.DB
+}
+
+// saveEvent writes event to the outbox as part of tx, so the event exists if
+// and only if the change it describes is committed.
+func saveEvent(tx *sql.Tx, event model.Event) error {
+	var payload []byte
+	if event.Company != nil {
+		masked := event.Company.Masked()
+		var err error
+		if payload, err = json.Marshal(masked); err != nil {
+			return fmt.Errorf("Error encoding event payload: %w", err)
+		}
+	}
+	// LAST_INSERT_ID(expr) hands the new value back without another query
+	res, err := tx.Exec(`UPDATE outbox_sequence SET last = LAST_INSERT_ID(last + 1) WHERE id = 1`)
+	if err != nil {
+		log.Printf("Error taking outbox id: %s", err.Error())
+		return fmt.Errorf("Error writing to outbox: %w", DatabaseError)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("Error when getting outbox id: %w", DatabaseError)
+	}
+	_, err = tx.Exec(`INSERT INTO outbox (id, type, pib, payload, createdAt) VALUES (?, ?, ?, ?, ?)`,
+		id, event.Type, event.PIB, payload, time.Now().UTC())
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error writing to outbox: %w", DatabaseError)
+	}
+	return nil
+}
+
+// LastId implements OutboxRepository
+func (ob outboxRepo) LastId() (int64, error) {
+	var id int64
+	err := ob.db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM outbox`).Scan(&id)
+	if err != nil {
+		log.Printf("Error reading outbox: %s", err.Error())
+		return 0, fmt.Errorf("Error reading outbox: %w", DatabaseError)
+	}
+	return id, nil
+}
+
+// FindAfter implements OutboxRepository
+func (ob outboxRepo) FindAfter(after int64, limit int) ([]model.Event, error) {
+	rows, err := ob.db.Query(`SELECT id, type, pib, payload, createdAt FROM outbox WHERE id > ? ORDER BY id LIMIT ?`, after, limit)
+	if err != nil {
+		log.Printf("Error reading outbox: %s", err.Error())
+		return nil, fmt.Errorf("Error reading outbox: %w", DatabaseError)
+	}
+	defer rows.Close()
+
+	events := make([]model.Event, 0, limit)
+	for rows.Next() {
+		var event model.Event
+		var payload []byte
+		if err := rows.Scan(&event.Id, &event.Type, &event.PIB, &payload, &event.Time); err != nil {
+			return events, fmt.Errorf("%w: couldn't scan outbox entry", DatabaseError)
+		}
+		if payload != nil {
+			event.Company = &model.Company{}
+			if err := json.Unmarshal(payload, event.Company); err != nil {
+				return events, fmt.Errorf("Error decoding outbox entry %d: %w", event.Id, err)
+			}
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// WithPosition implements OutboxRepository
+func (ob outboxRepo) WithPosition(consumer string, fn func(position int64) (int64, error)) error {
+	if _, err := ob.db.Exec(`INSERT IGNORE INTO outbox_consumer (name, position) VALUES (?, 0)`, consumer); err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error creating consumer %s: %w", consumer, DatabaseError)
+	}
+
+	tx, err := ob.db.Begin()
+	if err != nil {
+		return DatabaseError
+	}
+	defer tx.Rollback()
+
+	var position int64
+	err = tx.QueryRow(`SELECT position FROM outbox_consumer WHERE name = ? FOR UPDATE`, consumer).Scan(&position)
+	if err != nil {
+		log.Printf("Error locking consumer %s: %s", consumer, err.Error())
+		return fmt.Errorf("Error locking consumer %s: %w", consumer, DatabaseError)
+	}
+
+	newPosition, fnErr := fn(position)
+	if newPosition != position {
+		if _, err := tx.Exec(`UPDATE outbox_consumer SET position = ? WHERE name = ?`, newPosition, consumer); err != nil {
+			log.Printf("Error updating consumer %s: %s", consumer, err.Error())
+			return fmt.Errorf("Error updating consumer %s: %w", consumer, DatabaseError)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return fmt.Errorf("Error updating consumer %s: %w", consumer, DatabaseError)
+	}
+	return fnErr
+}
+
+// FindPositions implements OutboxRepository
+func (ob outboxRepo) FindPositions() (map[string]int64, error) {
+	rows, err := ob.db.Query(`SELECT name, position FROM outbox_consumer`)
+	if err != nil {
+		log.Printf("Error reading consumers: %s", err.Error())
+		return nil, fmt.Errorf("Error reading consumers: %w", DatabaseError)
+	}
+	defer rows.Close()
+
+	positions := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var position int64
+		if err := rows.Scan(&name, &position); err != nil {
+			return positions, fmt.Errorf("%w: couldn't scan consumer", DatabaseError)
+		}
+		positions[name] = position
+	}
+	return positions, rows.Err()
+}
@@ -0,0 +1,248 @@
+package db
+
+import (
+	"apr-backend/internal/model"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var PersonAccountExistsError = errors.New("Person already has an account")
+var NoSuchPersonAccountError = errors.New("Person doesn't have an account")
+var NotRepresentativeError = errors.New("Person neither owns nor represents the company")
+var NoSuchActivationError = errors.New("Activation code doesn't exist, was used or has expired")
+
+// mysqlDuplicateEntry is the error number of unique key violations
+const mysqlDuplicateEntry = 1062
+
+// PersonAccountRepository stores accounts of persons and the companies they
+// represent. Owners of a company, its vlasnik, can always act for it.
+//
+//	CREATE TABLE person_account (
+//	    jmbg      CHAR(13) PRIMARY KEY,
+//	    password  VARCHAR(60) NOT NULL,
+//	    createdAt DATETIME NOT NULL,
+//	    FOREIGN KEY (jmbg) REFERENCES person (jmbg)
+//	);
+//
+//	CREATE TABLE company_representative (
+//	    pib     INT NOT NULL,
+//	    jmbg    CHAR(13) NOT NULL,
+//	    addedAt DATETIME NOT NULL,
+//	    addedBy CHAR(13) NULL,
+//	    PRIMARY KEY (pib, jmbg),
+//	    INDEX (jmbg)
+//	);
+//
+//	-- Accounts created before activation have to be activated as well
+//	ALTER TABLE person_account ADD COLUMN activatedAt DATETIME NULL;
+//
+//	CREATE TABLE person_activation (
+//	    hash      CHAR(64) PRIMARY KEY,
+//	    jmbg      CHAR(13) NOT NULL,
+//	    expiresAt DATETIME NOT NULL,
+//	    usedAt    DATETIME NULL
+//	);
+type PersonAccountRepository interface {
+	// Saves an account which can't be used until it is activated
+	SaveAccount(jmbg string, passwordHash string) error
+	// Returns the password hash of the account and whether it was activated
+	FindPassword(jmbg string) (string, bool, error)
+	SaveActivation(hash string, jmbg string, expiresAt time.Time) error
+	// Activates the account of jmbg with the activation code with hash,
+	// which is used up
+	Activate(hash string, jmbg string) error
+	// Active companies the person owns or represents
+	FindCompanies(jmbg string) ([]model.PersonCompany, error)
+	// Role of the person in an active company, NotRepresentativeError if it
+	// has none
+	FindRole(pib int, jmbg string) (string, error)
+	// Representatives of pib, not including its owner
+	FindRepresentatives(pib int) ([]model.Person, error)
+	// Adds a representative, by is the JMBG of the person adding them and
+	// empty if the company did
+	AddRepresentative(pib int, jmbg string, by string) error
+	RemoveRepresentative(pib int, jmbg string) error
+}
+
+func NewPersonAccountRepository(db *sql.DB) PersonAccountRepository {
+	return personAccountRepo{db: db}
+}
+
+type personAccountRepo struct {
+	db *sql.DB
+}
+
+// SaveAccount implements PersonAccountRepository
+func (pr personAccountRepo) SaveAccount(jmbg string, passwordHash string) error {
+	_, err := pr.db.Exec(`INSERT INTO person_account (jmbg, password, createdAt) VALUES (?, ?, ?)`,
+		jmbg, passwordHash, time.Now().UTC())
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		return PersonAccountExistsError
+	}
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error saving person account: %w", DatabaseError)
+	}
+	return nil
+}
+
+// FindPassword implements PersonAccountRepository
+func (pr personAccountRepo) FindPassword(jmbg string) (string, bool, error) {
+	var hash string
+	var activatedAt sql.NullTime
+	err := pr.db.QueryRow(`SELECT password, activatedAt FROM person_account WHERE jmbg = ?`, jmbg).Scan(&hash, &activatedAt)
+	if err == sql.ErrNoRows {
+		return "", false, fmt.Errorf("No account for %s: %w", jmbg, NoSuchPersonAccountError)
+	}
+	if err != nil {
+		log.Printf("Error reading person account: %s", err.Error())
+		return "", false, fmt.Errorf("Error reading person account: %w", DatabaseError)
+	}
+	return hash, activatedAt.Valid, nil
+}
+
+// SaveActivation implements PersonAccountRepository
+func (pr personAccountRepo) SaveActivation(hash string, jmbg string, expiresAt time.Time) error {
+	_, err := pr.db.Exec(`INSERT INTO person_activation (hash, jmbg, expiresAt) VALUES (?, ?, ?)`, hash, jmbg, expiresAt.UTC())
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error saving activation code: %w", DatabaseError)
+	}
+	return nil
+}
+
+// Activate implements PersonAccountRepository
+func (pr personAccountRepo) Activate(hash string, jmbg string) error {
+	tx, err := pr.db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err.Error())
+		return fmt.Errorf("Error activating account: %w", DatabaseError)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	res, err := tx.Exec(`UPDATE person_activation SET usedAt = ? WHERE hash = ? AND jmbg = ? AND usedAt IS NULL AND expiresAt > ?`, now, hash, jmbg, now)
+	if err != nil {
+		log.Printf("Error using activation code: %s", err.Error())
+		return fmt.Errorf("Error activating account: %w", DatabaseError)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return NoSuchActivationError
+	}
+	if _, err := tx.Exec(`UPDATE person_account SET activatedAt = ? WHERE jmbg = ? AND activatedAt IS NULL`, now, jmbg); err != nil {
+		log.Printf("Error activating account of %s: %s", jmbg, err.Error())
+		return fmt.Errorf("Error activating account: %w", DatabaseError)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return fmt.Errorf("Error activating account: %w", DatabaseError)
+	}
+	return nil
+}
+
+// FindCompanies implements PersonAccountRepository
+func (pr personAccountRepo) FindCompanies(jmbg string) ([]model.PersonCompany, error) {
+	rows, err := pr.db.Query(`SELECT PIB, naziv, ? FROM company WHERE vlasnik = ? AND likvidirana = 0
+    UNION
+    SELECT c.PIB, c.naziv, ? FROM company_representative r JOIN company c ON c.PIB = r.pib
+    WHERE r.jmbg = ? AND c.vlasnik <> r.jmbg AND c.likvidirana = 0
+    ORDER BY PIB`, model.RoleOwner, jmbg, model.RoleRepresentative, jmbg)
+	if err != nil {
+		log.Printf("Error reading companies of person: %s", err.Error())
+		return nil, fmt.Errorf("Error reading companies of person: %w", DatabaseError)
+	}
+	defer rows.Close()
+
+	companies := []model.PersonCompany{}
+	for rows.Next() {
+		var com model.PersonCompany
+		if err := rows.Scan(&com.PIB, &com.Naziv, &com.Role); err != nil {
+			log.Printf("Error reading companies of person: %s", err.Error())
+			return companies, fmt.Errorf("Error reading companies of person: %w", DatabaseError)
+		}
+		companies = append(companies, com)
+	}
+	return companies, nil
+}
+
+// FindRole implements PersonAccountRepository
+func (pr personAccountRepo) FindRole(pib int, jmbg string) (string, error) {
+	var role string
+	err := pr.db.QueryRow(`SELECT CASE WHEN c.vlasnik = ? THEN ? ELSE ? END FROM company c
+    LEFT JOIN company_representative r ON r.pib = c.PIB AND r.jmbg = ?
+    WHERE c.PIB = ? AND c.likvidirana = 0 AND (c.vlasnik = ? OR r.jmbg IS NOT NULL)`,
+		jmbg, model.RoleOwner, model.RoleRepresentative, jmbg, pib, jmbg).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("%s for %d: %w", jmbg, pib, NotRepresentativeError)
+	}
+	if err != nil {
+		log.Printf("Error reading role of person: %s", err.Error())
+		return "", fmt.Errorf("Error reading role of person: %w", DatabaseError)
+	}
+	return role, nil
+}
+
+// FindRepresentatives implements PersonAccountRepository
+func (pr personAccountRepo) FindRepresentatives(pib int) ([]model.Person, error) {
+	rows, err := pr.db.Query(`SELECT p.jmbg, p.name, p.lastname FROM company_representative r
+    JOIN person p ON p.jmbg = r.jmbg WHERE r.pib = ? ORDER BY r.addedAt`, pib)
+	if err != nil {
+		log.Printf("Error reading representatives: %s", err.Error())
+		return nil, fmt.Errorf("Error reading representatives: %w", DatabaseError)
+	}
+	defer rows.Close()
+
+	persons := []model.Person{}
+	for rows.Next() {
+		var person model.Person
+		if err := rows.Scan(&person.Jmbg, &person.Name, &person.Lastname); err != nil {
+			log.Printf("Error reading representatives: %s", err.Error())
+			return persons, fmt.Errorf("Error reading representatives: %w", DatabaseError)
+		}
+		persons = append(persons, person)
+	}
+	return persons, nil
+}
+
+// AddRepresentative implements PersonAccountRepository
+func (pr personAccountRepo) AddRepresentative(pib int, jmbg string, by string) error {
+	// Adding someone twice keeps the original record
+	res, err := pr.db.Exec(`INSERT INTO company_representative (pib, jmbg, addedAt, addedBy)
+    SELECT ?, jmbg, ?, ? FROM person WHERE jmbg = ?
+    ON DUPLICATE KEY UPDATE pib = pib`, pib, time.Now().UTC(), nullString(by), jmbg)
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error saving representative: %w", DatabaseError)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		var exists bool
+		err := pr.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM person WHERE jmbg = ?)`, jmbg).Scan(&exists)
+		if err != nil {
+			log.Printf("Error reading person: %s", err.Error())
+			return fmt.Errorf("Error saving representative: %w", DatabaseError)
+		}
+		if !exists {
+			return fmt.Errorf("Person with jmbg %s does not exist: %w", jmbg, NoSuchJmbgError)
+		}
+	}
+	return nil
+}
+
+// RemoveRepresentative implements PersonAccountRepository
+func (pr personAccountRepo) RemoveRepresentative(pib int, jmbg string) error {
+	res, err := pr.db.Exec(`DELETE FROM company_representative WHERE pib = ? AND jmbg = ?`, pib, jmbg)
+	if err != nil {
+		log.Printf("Delete error: %s", err.Error())
+		return fmt.Errorf("Error removing representative: %w", DatabaseError)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s for %d: %w", jmbg, pib, NotRepresentativeError)
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 )
 
 func NewPersonRepo(db *sql.DB) PersonRepository {
@@ -14,6 +15,9 @@ func NewPersonRepo(db *sql.DB) PersonRepository {
 
 type PersonRepository interface {
 	GetOne(jmbg string, tx *sql.Tx) (model.Person, error)
+	// Finds every person with one of jmbgs, JMBGs which don't exist are
+	// skipped.
+	FindMany(jmbgs []string) ([]model.Person, error)
 }
 
 type personRepo struct {
@@ -45,3 +49,32 @@ func (pr personRepo) GetOne(jmbg string, tx *sql.Tx) (model.Person, error) {
 
 	return person, nil
 }
+
+// FindMany implements PersonRepository
+func (pr personRepo) FindMany(jmbgs []string) ([]model.Person, error) {
+	if len(jmbgs) == 0 {
+		return []model.Person{}, nil
+	}
+	args := make([]any, len(jmbgs))
+	for i, jmbg := range jmbgs {
+		args[i] = jmbg
+	}
+	query := "SELECT jmbg, name, lastname FROM person WHERE jmbg IN (" + strings.Repeat(",?", len(jmbgs))[1:] + ")"
+	rows, err := pr.db.Query(query, args...)
+	if err != nil {
+		log.Printf("Error: %s", err.Error())
+		return nil, DatabaseError
+	}
+	defer rows.Close()
+
+	persons := make([]model.Person, 0, len(jmbgs))
+	for rows.Next() {
+		var person model.Person
+		if err := rows.Scan(&person.Jmbg, &person.Name, &person.Lastname); err != nil {
+			log.Printf("Error: %s", err.Error())
+			return persons, DatabaseError
+		}
+		persons = append(persons, person)
+	}
+	return persons, nil
+}
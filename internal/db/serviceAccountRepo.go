@@ -0,0 +1,195 @@
+package db
+
+import (
+	"apr-backend/internal/model"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+var NoSuchServiceAccountError = errors.New("Service account doesn't exist")
+var ServiceAccountChangedError = errors.New("Service account was disabled or its credentials replaced")
+
+// ServiceAccountRepository stores service accounts of other systems. Only
+// the hash of a client secret is stored.
+//
+//	CREATE TABLE service_account (
+//	    clientId   VARCHAR(64) PRIMARY KEY,
+//	    name       VARCHAR(100) NOT NULL,
+//	    scopes     VARCHAR(200) NOT NULL,
+//	    authMethod VARCHAR(20) NOT NULL,
+//	    secretHash CHAR(64) NULL,
+//	    publicKey  TEXT NULL,
+//	    disabled   BOOLEAN NOT NULL DEFAULT FALSE,
+//	    createdAt  DATETIME NOT NULL,
+//	    createdBy  VARCHAR(20) NULL
+//	);
+//
+//	-- Access tokens issued to accounts, revoked when they are disabled or
+//	-- get new credentials
+//	CREATE TABLE service_account_token (
+//	    jti       VARCHAR(64) PRIMARY KEY,
+//	    clientId  VARCHAR(64) NOT NULL,
+//	    expiresAt DATETIME NOT NULL,
+//	    INDEX (clientId)
+//	);
+type ServiceAccountRepository interface {
+	FindAll() ([]model.ServiceAccount, error)
+	// Returns the account and the hash of its secret
+	FindOne(clientId string) (model.ServiceAccount, string, error)
+	Save(account *model.ServiceAccount, secretHash string) error
+	// Replaces the secret hash or the public key of the account and
+	// revokes the tokens issued with the old ones
+	UpdateCredentials(clientId string, secretHash string, publicKey string) error
+	// Disabling an account revokes its tokens
+	SetDisabled(clientId string, disabled bool) error
+	// Records a token issued to account, which authenticated with
+	// secretHash or its public key. ServiceAccountChangedError if the
+	// account was disabled or got new credentials since.
+	SaveToken(account model.ServiceAccount, secretHash string, jti string, expiresAt time.Time) error
+}
+
+func NewServiceAccountRepository(db *sql.DB) ServiceAccountRepository {
+	return serviceAccountRepo{db: db}
+}
+
+type serviceAccountRepo struct {
+	db *sql.DB
+}
+
+const serviceAccountColumns = `clientId, name, scopes, authMethod, secretHash, publicKey, disabled, createdAt, createdBy`
+
+func scanServiceAccount(row interface{ Scan(...any) error }) (model.ServiceAccount, string, error) {
+	var account model.ServiceAccount
+	var scopes string
+	var secretHash, publicKey, createdBy sql.NullString
+	err := row.Scan(&account.ClientId, &account.Name, &scopes, &account.AuthMethod, &secretHash, &publicKey,
+		&account.Disabled, &account.CreatedAt, &createdBy)
+	account.Scopes = strings.Fields(scopes)
+	account.PublicKey = publicKey.String
+	account.CreatedBy = createdBy.String
+	return account, secretHash.String, err
+}
+
+// FindAll implements ServiceAccountRepository
+func (sar serviceAccountRepo) FindAll() ([]model.ServiceAccount, error) {
+	rows, err := sar.db.Query(`SELECT ` + serviceAccountColumns + ` FROM service_account ORDER BY clientId`)
+	if err != nil {
+		log.Printf("Error reading service accounts: %s", err.Error())
+		return nil, fmt.Errorf("Error reading service accounts: %w", DatabaseError)
+	}
+	defer rows.Close()
+
+	accounts := []model.ServiceAccount{}
+	for rows.Next() {
+		account, _, err := scanServiceAccount(rows)
+		if err != nil {
+			log.Printf("Error reading service accounts: %s", err.Error())
+			return accounts, fmt.Errorf("Error reading service accounts: %w", DatabaseError)
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// FindOne implements ServiceAccountRepository
+func (sar serviceAccountRepo) FindOne(clientId string) (model.ServiceAccount, string, error) {
+	row := sar.db.QueryRow(`SELECT `+serviceAccountColumns+` FROM service_account WHERE clientId = ?`, clientId)
+	account, secretHash, err := scanServiceAccount(row)
+	if err == sql.ErrNoRows {
+		return account, "", fmt.Errorf("%s: %w", clientId, NoSuchServiceAccountError)
+	}
+	if err != nil {
+		log.Printf("Error reading service account: %s", err.Error())
+		return account, "", fmt.Errorf("Error reading service account: %w", DatabaseError)
+	}
+	return account, secretHash, nil
+}
+
+// Save implements ServiceAccountRepository
+func (sar serviceAccountRepo) Save(account *model.ServiceAccount, secretHash string) error {
+	account.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	_, err := sar.db.Exec(`INSERT INTO service_account (`+serviceAccountColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		account.ClientId, account.Name, strings.Join(account.Scopes, " "), account.AuthMethod, nullString(secretHash),
+		nullString(account.PublicKey), account.Disabled, account.CreatedAt, nullString(account.CreatedBy))
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error saving service account: %w", DatabaseError)
+	}
+	return nil
+}
+
+// update runs query for the account with clientId and, if revoke is set,
+// revokes its tokens in the same transaction
+func (sar serviceAccountRepo) update(clientId string, revoke bool, query string, args ...any) error {
+	tx, err := sar.db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err.Error())
+		return fmt.Errorf("Error updating service account: %w", DatabaseError)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(query, append(args, clientId)...)
+	if err != nil {
+		log.Printf("Update error: %s", err.Error())
+		return fmt.Errorf("Error updating service account: %w", DatabaseError)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		// Rows which didn't change aren't counted, so make sure it's missing
+		if _, _, err := sar.FindOne(clientId); err != nil {
+			return err
+		}
+	}
+	if revoke {
+		_, err := tx.Exec(`INSERT IGNORE INTO revoked_token (jti, expiresAt)
+    SELECT jti, expiresAt FROM service_account_token WHERE clientId = ? AND expiresAt > ?`, clientId, time.Now().UTC())
+		if err != nil {
+			log.Printf("Error revoking tokens of %s: %s", clientId, err.Error())
+			return fmt.Errorf("Error updating service account: %w", DatabaseError)
+		}
+		if _, err := tx.Exec(`DELETE FROM service_account_token WHERE clientId = ?`, clientId); err != nil {
+			log.Printf("Error revoking tokens of %s: %s", clientId, err.Error())
+			return fmt.Errorf("Error updating service account: %w", DatabaseError)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return fmt.Errorf("Error updating service account: %w", DatabaseError)
+	}
+	return nil
+}
+
+// UpdateCredentials implements ServiceAccountRepository
+func (sar serviceAccountRepo) UpdateCredentials(clientId string, secretHash string, publicKey string) error {
+	return sar.update(clientId, true, `UPDATE service_account SET secretHash = ?, publicKey = ? WHERE clientId = ?`,
+		nullString(secretHash), nullString(publicKey))
+}
+
+// SetDisabled implements ServiceAccountRepository
+func (sar serviceAccountRepo) SetDisabled(clientId string, disabled bool) error {
+	return sar.update(clientId, disabled, `UPDATE service_account SET disabled = ? WHERE clientId = ?`, disabled)
+}
+
+// SaveToken implements ServiceAccountRepository
+func (sar serviceAccountRepo) SaveToken(account model.ServiceAccount, secretHash string, jti string, expiresAt time.Time) error {
+	now := time.Now().UTC()
+	// Expired tokens needn't be revoked anymore
+	if _, err := sar.db.Exec(`DELETE FROM service_account_token WHERE clientId = ? AND expiresAt < ?`, account.ClientId, now); err != nil {
+		log.Printf("Error deleting tokens of %s: %s", account.ClientId, err.Error())
+	}
+	res, err := sar.db.Exec(`INSERT INTO service_account_token (jti, clientId, expiresAt)
+    SELECT ?, clientId, ? FROM service_account
+    WHERE clientId = ? AND NOT disabled AND secretHash <=> ? AND publicKey <=> ?`,
+		jti, expiresAt.UTC(), account.ClientId, nullString(secretHash), nullString(account.PublicKey))
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error saving service account token: %w", DatabaseError)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s: %w", account.ClientId, ServiceAccountChangedError)
+	}
+	return nil
+}
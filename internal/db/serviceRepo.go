@@ -0,0 +1,211 @@
+package db
+
+import (
+	"apr-backend/internal/model"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var NoSuchServiceError = errors.New("Service isn't registered")
+var ServiceExistsError = errors.New("Service with this name is already registered")
+
+// ServiceRepository stores the catalogue of services SSO tokens are issued
+// for. Lists are stored as JSON.
+//
+//	CREATE TABLE sso_service (
+//	    name          VARCHAR(64) PRIMARY KEY,
+//	    audiences     TEXT NOT NULL,
+//	    tokenLifetime INT NOT NULL,
+//	    allowedRoles  TEXT NOT NULL,
+//	    allowedPibs   TEXT NOT NULL,
+//	    createdAt     DATETIME NOT NULL,
+//	    updatedAt     DATETIME NOT NULL
+//	);
+//
+//	ALTER TABLE sso_service ADD COLUMN introspectionSecret CHAR(64) NULL;
+type ServiceRepository interface {
+	FindAll() ([]model.RegisteredService, error)
+	FindOne(name string) (model.RegisteredService, error)
+	Save(service *model.RegisteredService) error
+	Update(service *model.RegisteredService) error
+	Delete(name string) error
+	// Replaces the hash of the service's introspection secret
+	SetIntrospectionSecret(name string, hash string) error
+	// Returns the hash of the service's introspection secret, empty if it
+	// has none
+	FindIntrospectionSecret(name string) (string, error)
+}
+
+func NewServiceRepository(db *sql.DB) ServiceRepository {
+	return serviceRepo{db: db}
+}
+
+type serviceRepo struct {
+	db *sql.DB
+}
+
+const serviceColumns = `name, audiences, tokenLifetime, allowedRoles, allowedPibs, createdAt, updatedAt`
+
+func scanService(row interface{ Scan(...any) error }) (model.RegisteredService, error) {
+	var service model.RegisteredService
+	var audiences, roles, pibs []byte
+	err := row.Scan(&service.Name, &audiences, &service.TokenLifetime, &roles, &pibs, &service.CreatedAt, &service.UpdatedAt)
+	if err != nil {
+		return service, err
+	}
+	for _, field := range []struct {
+		raw []byte
+		v   any
+	}{{audiences, &service.Audiences}, {roles, &service.AllowedRoles}, {pibs, &service.AllowedPibs}} {
+		if err := json.Unmarshal(field.raw, field.v); err != nil {
+			return service, fmt.Errorf("Error decoding service %s: %w", service.Name, err)
+		}
+	}
+	return service, nil
+}
+
+// encodeService returns the lists of service as JSON
+func encodeService(service *model.RegisteredService) ([]byte, []byte, []byte, error) {
+	if service.AllowedPibs == nil {
+		service.AllowedPibs = []int{}
+	}
+	audiences, err := json.Marshal(service.Audiences)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Error encoding audiences: %w", err)
+	}
+	roles, err := json.Marshal(service.AllowedRoles)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Error encoding roles: %w", err)
+	}
+	pibs, err := json.Marshal(service.AllowedPibs)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Error encoding PIBs: %w", err)
+	}
+	return audiences, roles, pibs, nil
+}
+
+// FindAll implements ServiceRepository
+func (sr serviceRepo) FindAll() ([]model.RegisteredService, error) {
+	rows, err := sr.db.Query(`SELECT ` + serviceColumns + ` FROM sso_service ORDER BY name`)
+	if err != nil {
+		log.Printf("Error reading services: %s", err.Error())
+		return nil, fmt.Errorf("Error reading services: %w", DatabaseError)
+	}
+	defer rows.Close()
+
+	services := []model.RegisteredService{}
+	for rows.Next() {
+		service, err := scanService(rows)
+		if err != nil {
+			log.Printf("Error reading services: %s", err.Error())
+			return services, fmt.Errorf("Error reading services: %w", DatabaseError)
+		}
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+// FindOne implements ServiceRepository
+func (sr serviceRepo) FindOne(name string) (model.RegisteredService, error) {
+	row := sr.db.QueryRow(`SELECT `+serviceColumns+` FROM sso_service WHERE name = ?`, name)
+	service, err := scanService(row)
+	if err == sql.ErrNoRows {
+		return service, fmt.Errorf("%s: %w", name, NoSuchServiceError)
+	}
+	if err != nil {
+		log.Printf("Error reading service: %s", err.Error())
+		return service, fmt.Errorf("Error reading service: %w", DatabaseError)
+	}
+	return service, nil
+}
+
+// Save implements ServiceRepository
+func (sr serviceRepo) Save(service *model.RegisteredService) error {
+	audiences, roles, pibs, err := encodeService(service)
+	if err != nil {
+		return err
+	}
+	service.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	service.UpdatedAt = service.CreatedAt
+	_, err = sr.db.Exec(`INSERT INTO sso_service (`+serviceColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		service.Name, audiences, service.TokenLifetime, roles, pibs, service.CreatedAt, service.UpdatedAt)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		return ServiceExistsError
+	}
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error saving service: %w", DatabaseError)
+	}
+	return nil
+}
+
+// Update implements ServiceRepository
+func (sr serviceRepo) Update(service *model.RegisteredService) error {
+	audiences, roles, pibs, err := encodeService(service)
+	if err != nil {
+		return err
+	}
+	service.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	_, err = sr.db.Exec(`UPDATE sso_service SET audiences = ?, tokenLifetime = ?, allowedRoles = ?, allowedPibs = ?, updatedAt = ?
+    WHERE name = ?`, audiences, service.TokenLifetime, roles, pibs, service.UpdatedAt, service.Name)
+	if err != nil {
+		log.Printf("Update error: %s", err.Error())
+		return fmt.Errorf("Error updating service: %w", DatabaseError)
+	}
+	// Rows which didn't change aren't counted as affected, so whether the
+	// service exists is found out by reading it back
+	saved, err := sr.FindOne(service.Name)
+	if err != nil {
+		return err
+	}
+	*service = saved
+	return nil
+}
+
+// Delete implements ServiceRepository
+func (sr serviceRepo) Delete(name string) error {
+	res, err := sr.db.Exec(`DELETE FROM sso_service WHERE name = ?`, name)
+	if err != nil {
+		log.Printf("Delete error: %s", err.Error())
+		return fmt.Errorf("Error deleting service: %w", DatabaseError)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s: %w", name, NoSuchServiceError)
+	}
+	return nil
+}
+
+// SetIntrospectionSecret implements ServiceRepository
+func (sr serviceRepo) SetIntrospectionSecret(name string, hash string) error {
+	res, err := sr.db.Exec(`UPDATE sso_service SET introspectionSecret = ? WHERE name = ?`, hash, name)
+	if err != nil {
+		log.Printf("Update error: %s", err.Error())
+		return fmt.Errorf("Error saving introspection secret: %w", DatabaseError)
+	}
+	// A new secret always changes the row
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s: %w", name, NoSuchServiceError)
+	}
+	return nil
+}
+
+// FindIntrospectionSecret implements ServiceRepository
+func (sr serviceRepo) FindIntrospectionSecret(name string) (string, error) {
+	var hash sql.NullString
+	err := sr.db.QueryRow(`SELECT introspectionSecret FROM sso_service WHERE name = ?`, name).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("%s: %w", name, NoSuchServiceError)
+	}
+	if err != nil {
+		log.Printf("Error reading introspection secret: %s", err.Error())
+		return "", fmt.Errorf("Error reading introspection secret: %w", DatabaseError)
+	}
+	return hash.String, nil
+}
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"apr-backend/internal/model"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlDeadlock is the error number of transactions rolled back to resolve
+// a deadlock
+const mysqlDeadlock = 1213
+
+// Attempts at a rotation which keeps deadlocking with other replicas
+const rotateAttempts = 3
+
+// SigningKeyRepository stores the keys APR signs tokens with, so that every
+// replica and every restart uses the same ones. Keys are stored encrypted.
+// The newest key which hasn't expired is the active one once it activates,
+// until then it only verifies and the key it replaces signs. Once a key has
+// expired its private part is erased, but its public part is kept, since
+// registry extracts it signed have to stay verifiable. Keys stored before
+// public parts were kept have no public part, so their private part stays.
+//
+//	CREATE TABLE signing_key (
+//	    kid          VARCHAR(64) PRIMARY KEY,
+//	    encryptedKey BLOB NOT NULL,
+//	    createdAt    DATETIME(6) NOT NULL,
+//	    expiresAt    DATETIME(6) NULL
+//	);
+//
+//	ALTER TABLE signing_key
+//	    MODIFY encryptedKey BLOB NULL,
+//	    ADD COLUMN publicKey BLOB NULL; -- PKIX, DER encoded
+//
+//	ALTER TABLE signing_key ADD COLUMN activatesAt DATETIME(6) NULL;
+type SigningKeyRepository interface {
+	// Returns keys which haven't expired, the active one first
+	FindValid() ([]model.SigningKey, error)
+	// Returns keys which have expired, newest first. Encrypted is only set
+	// for keys without a public part.
+	FindExpired() ([]model.SigningKey, error)
+	// Stores key as the active key, unless the current active key was created
+	// after notAfter. The replaced key expires grace after key activates.
+	// Returns false if the current key was kept.
+	Rotate(key model.SigningKey, notAfter time.Time, grace time.Duration) (bool, error)
+}
+
+func NewSigningKeyRepository(db *sql.DB) SigningKeyRepository {
+	return signingKeyRepo{db: db}
+}
+
+type signingKeyRepo struct {
+	db *sql.DB
+}
+
+func (skr signingKeyRepo) find(where string) ([]model.SigningKey, error) {
+	rows, err := skr.db.Query(`SELECT kid, encryptedKey, publicKey, createdAt, activatesAt, expiresAt FROM signing_key
+    WHERE `+where+`
+    ORDER BY expiresAt IS NULL DESC, createdAt DESC, kid`, time.Now().UTC())
+	if err != nil {
+		log.Printf("Error reading signing keys: %s", err.Error())
+		return nil, fmt.Errorf("Error reading signing keys: %w", DatabaseError)
+	}
+	defer rows.Close()
+
+	keys := []model.SigningKey{}
+	for rows.Next() {
+		var key model.SigningKey
+		var activatesAt, expiresAt sql.NullTime
+		if err := rows.Scan(&key.Kid, &key.Encrypted, &key.Public, &key.CreatedAt, &activatesAt, &expiresAt); err != nil {
+			log.Printf("Error scanning signing key: %s", err.Error())
+			return nil, fmt.Errorf("Error reading signing keys: %w", DatabaseError)
+		}
+		key.ActivatesAt, key.ExpiresAt = activatesAt.Time, expiresAt.Time
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error reading signing keys: %s", err.Error())
+		return nil, fmt.Errorf("Error reading signing keys: %w", DatabaseError)
+	}
+	return keys, nil
+}
+
+// FindValid implements SigningKeyRepository
+func (skr signingKeyRepo) FindValid() ([]model.SigningKey, error) {
+	return skr.find(`expiresAt IS NULL OR expiresAt > ?`)
+}
+
+// FindExpired implements SigningKeyRepository
+func (skr signingKeyRepo) FindExpired() ([]model.SigningKey, error) {
+	return skr.find(`expiresAt <= ?`)
+}
+
+// Rotate implements SigningKeyRepository
+func (skr signingKeyRepo) Rotate(key model.SigningKey, notAfter time.Time, grace time.Duration) (bool, error) {
+	for attempt := 1; ; attempt++ {
+		rotated, err := skr.rotate(key, notAfter, grace)
+		var mysqlErr *mysql.MySQLError
+		// Replicas which start on an empty table lock the same gap, and all
+		// but one are rolled back when they insert
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDeadlock && attempt < rotateAttempts {
+			log.Printf("Signing key rotation deadlocked, retrying")
+			continue
+		}
+		if err != nil {
+			log.Printf("Error rotating signing key: %s", err.Error())
+			return false, fmt.Errorf("Error rotating signing key: %w", DatabaseError)
+		}
+		return rotated, nil
+	}
+}
+
+func (skr signingKeyRepo) rotate(key model.SigningKey, notAfter time.Time, grace time.Duration) (bool, error) {
+	tx, err := skr.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Locking the active key makes replicas which rotate at the same time
+	// wait for each other, and all but the first keep the new key.
+	var createdAt time.Time
+	err = tx.QueryRow(`SELECT createdAt FROM signing_key WHERE expiresAt IS NULL
+    ORDER BY createdAt DESC LIMIT 1 FOR UPDATE`).Scan(&createdAt)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	if err == nil && createdAt.After(notAfter) {
+		return false, nil
+	}
+
+	now := time.Now().UTC()
+	if _, err := tx.Exec(`UPDATE signing_key SET encryptedKey = NULL
+    WHERE expiresAt <= ? AND publicKey IS NOT NULL AND encryptedKey IS NOT NULL`, now); err != nil {
+		return false, err
+	}
+	// The replaced key signs until key activates
+	activatesAt := now
+	if key.ActivatesAt.After(now) {
+		activatesAt = key.ActivatesAt.UTC()
+	}
+	if _, err := tx.Exec(`UPDATE signing_key SET expiresAt = ? WHERE expiresAt IS NULL`, activatesAt.Add(grace)); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`INSERT INTO signing_key (kid, encryptedKey, publicKey, createdAt, activatesAt) VALUES (?, ?, ?, ?, ?)`,
+		key.Kid, key.Encrypted, key.Public, key.CreatedAt, activatesAt); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,392 @@
+package db
+
+import (
+	"apr-backend/internal/model"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var NoSuchRefreshTokenError = errors.New("Refresh token doesn't exist or has expired")
+var RefreshTokenReusedError = errors.New("Refresh token was already used")
+var NoSuchResetTokenError = errors.New("Password reset token doesn't exist, was used or has expired")
+
+// TokenRepository stores refresh tokens and access tokens revoked before
+// they expire
+//
+//	CREATE TABLE refresh_token (
+//	    hash      CHAR(64) PRIMARY KEY,
+//	    family    CHAR(32) NOT NULL,
+//	    pib       INT NOT NULL,
+//	    jmbg      CHAR(13) NULL,
+//	    createdAt DATETIME NOT NULL,
+//	    expiresAt DATETIME NOT NULL,
+//	    usedAt    DATETIME NULL,
+//	    revokedAt DATETIME NULL,
+//	    INDEX (family)
+//	);
+//
+//	ALTER TABLE refresh_token ADD COLUMN mfa BOOLEAN NOT NULL DEFAULT FALSE;
+//
+//	-- Sessions of officials used to be kept under pib 0
+//	ALTER TABLE refresh_token ADD COLUMN kind VARCHAR(10) NOT NULL DEFAULT 'company',
+//	    MODIFY pib INT NULL;
+//	UPDATE refresh_token SET kind = 'official', pib = NULL WHERE pib = 0;
+//
+//	ALTER TABLE refresh_token ADD COLUMN clientId VARCHAR(64) NULL,
+//	    ADD COLUMN scope VARCHAR(200) NULL;
+//
+//	ALTER TABLE refresh_token ADD INDEX (revokedAt);
+//
+//	CREATE TABLE revoked_token (
+//	    jti       VARCHAR(64) PRIMARY KEY,
+//	    expiresAt DATETIME NOT NULL
+//	);
+//
+//	CREATE TABLE password_reset (
+//	    hash      CHAR(64) PRIMARY KEY,
+//	    pib       INT NOT NULL,
+//	    expiresAt DATETIME NOT NULL,
+//	    usedAt    DATETIME NULL
+//	);
+type TokenRepository interface {
+	SaveRefreshToken(token model.RefreshToken) error
+	// Marks the token with hash as used and stores next in its family. Using
+	// a token twice means it was stolen, so its whole family is revoked and
+	// RefreshTokenReusedError returned.
+	RotateRefreshToken(hash string, next *model.RefreshToken) error
+	// Revokes the family of the token with hash, if it is a session of
+	// kind of principal, a PIB for company sessions and a JMBG for those
+	// of officials
+	RevokeFamily(hash string, kind string, principal string) error
+	// Revokes the refresh tokens of the family
+	RevokeSession(family string) error
+	// Implements client.SessionRevocationStore, sessions are refresh token
+	// families
+	IsSessionRevoked(family string) (bool, error)
+	// Returns the families revoked since
+	FindRevokedSessions(since time.Time) ([]string, error)
+	// Revokes every refresh token of the company pib
+	RevokeAll(pib int) error
+	// Revokes the refresh tokens of the person with jmbg acting for pib
+	RevokeActing(pib int, jmbg string) error
+	// Revokes the refresh tokens of the official with jmbg
+	RevokeOfficial(jmbg string) error
+	SaveResetToken(hash string, pib int, expiresAt time.Time) error
+	// Sets the password of the company a reset token was issued for and
+	// revokes its refresh tokens. The token is used up only if the password
+	// was changed.
+	ResetPassword(hash string, passwordHash string) error
+	// Revokes an access token until it expires
+	RevokeAccessToken(jti string, expiresAt time.Time) error
+	// Revokes jti like RevokeAccessToken, false if it was revoked already.
+	// It marks ids which may only be used once.
+	RevokeOnce(jti string, expiresAt time.Time) (bool, error)
+	// Implements client.RevocationStore
+	IsRevoked(jti string) (bool, error)
+	// Returns the jtis of revoked access tokens which haven't expired yet
+	FindRevoked() ([]string, error)
+}
+
+func NewTokenRepository(db *sql.DB) TokenRepository {
+	return tokenRepo{db: db}
+}
+
+type tokenRepo struct {
+	db *sql.DB
+}
+
+// sessionPib is NULL for sessions of officials
+func sessionPib(token model.RefreshToken) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(token.PIB), Valid: token.Kind == model.SessionCompany}
+}
+
+// SaveRefreshToken implements TokenRepository
+func (tr tokenRepo) SaveRefreshToken(token model.RefreshToken) error {
+	_, err := tr.db.Exec(`INSERT INTO refresh_token (hash, family, kind, pib, jmbg, mfa, clientId, scope, createdAt, expiresAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		token.Hash, token.Family, token.Kind, sessionPib(token), nullString(token.Jmbg), token.Mfa, nullString(token.ClientId), nullString(token.Scope), token.CreatedAt, token.ExpiresAt)
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error saving refresh token: %w", DatabaseError)
+	}
+	return nil
+}
+
+// RotateRefreshToken implements TokenRepository
+func (tr tokenRepo) RotateRefreshToken(hash string, next *model.RefreshToken) error {
+	tx, err := tr.db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err.Error())
+		return fmt.Errorf("Error rotating refresh token: %w", DatabaseError)
+	}
+	defer tx.Rollback()
+
+	var usedAt, revokedAt sql.NullTime
+	var pib sql.NullInt64
+	var jmbg, clientId, scope sql.NullString
+	var expiresAt time.Time
+	err = tx.QueryRow(`SELECT family, kind, pib, jmbg, mfa, clientId, scope, expiresAt, usedAt, revokedAt FROM refresh_token WHERE hash = ? FOR UPDATE`, hash).
+		Scan(&next.Family, &next.Kind, &pib, &jmbg, &next.Mfa, &clientId, &scope, &expiresAt, &usedAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return NoSuchRefreshTokenError
+	}
+	if err != nil {
+		log.Printf("Error reading refresh token: %s", err.Error())
+		return fmt.Errorf("Error rotating refresh token: %w", DatabaseError)
+	}
+	if revokedAt.Valid || !expiresAt.After(time.Now()) {
+		return NoSuchRefreshTokenError
+	}
+	next.PIB = int(pib.Int64)
+	next.Jmbg = jmbg.String
+	next.ClientId = clientId.String
+	next.Scope = scope.String
+
+	now := time.Now().UTC()
+	if usedAt.Valid {
+		if _, err := tx.Exec(`UPDATE refresh_token SET revokedAt = ? WHERE family = ? AND revokedAt IS NULL`, now, next.Family); err != nil {
+			log.Printf("Error revoking refresh tokens: %s", err.Error())
+			return fmt.Errorf("Error revoking refresh tokens: %w", DatabaseError)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Commit error: %s", err.Error())
+			return fmt.Errorf("Error revoking refresh tokens: %w", DatabaseError)
+		}
+		return RefreshTokenReusedError
+	}
+
+	if _, err := tx.Exec(`UPDATE refresh_token SET usedAt = ? WHERE hash = ?`, now, hash); err != nil {
+		log.Printf("Error using refresh token: %s", err.Error())
+		return fmt.Errorf("Error rotating refresh token: %w", DatabaseError)
+	}
+	if _, err := tx.Exec(`INSERT INTO refresh_token (hash, family, kind, pib, jmbg, mfa, clientId, scope, createdAt, expiresAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		next.Hash, next.Family, next.Kind, sessionPib(*next), nullString(next.Jmbg), next.Mfa, nullString(next.ClientId), nullString(next.Scope), next.CreatedAt, next.ExpiresAt); err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error rotating refresh token: %w", DatabaseError)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return fmt.Errorf("Error rotating refresh token: %w", DatabaseError)
+	}
+	return nil
+}
+
+// RevokeFamily implements TokenRepository
+func (tr tokenRepo) RevokeFamily(hash string, kind string, principal string) error {
+	res, err := tr.db.Exec(`UPDATE refresh_token SET revokedAt = ?
+    WHERE revokedAt IS NULL AND family = (SELECT family FROM (SELECT family FROM refresh_token
+        WHERE hash = ? AND kind = ? AND IF(kind = ?, jmbg, CAST(pib AS CHAR)) = ?) f)`,
+		time.Now().UTC(), hash, kind, model.SessionOfficial, principal)
+	if err != nil {
+		log.Printf("Error revoking refresh tokens: %s", err.Error())
+		return fmt.Errorf("Error revoking refresh tokens: %w", DatabaseError)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return NoSuchRefreshTokenError
+	}
+	return nil
+}
+
+// RevokeSession implements TokenRepository
+func (tr tokenRepo) RevokeSession(family string) error {
+	_, err := tr.db.Exec(`UPDATE refresh_token SET revokedAt = ? WHERE family = ? AND revokedAt IS NULL`, time.Now().UTC(), family)
+	if err != nil {
+		log.Printf("Error revoking refresh tokens: %s", err.Error())
+		return fmt.Errorf("Error revoking refresh tokens: %w", DatabaseError)
+	}
+	return nil
+}
+
+// IsSessionRevoked implements TokenRepository
+func (tr tokenRepo) IsSessionRevoked(family string) (bool, error) {
+	var revoked bool
+	err := tr.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM refresh_token WHERE family = ? AND revokedAt IS NOT NULL)`, family).Scan(&revoked)
+	if err != nil {
+		log.Printf("Error reading refresh tokens: %s", err.Error())
+		return false, fmt.Errorf("Error checking session revocation: %w", DatabaseError)
+	}
+	return revoked, nil
+}
+
+// FindRevokedSessions implements TokenRepository
+func (tr tokenRepo) FindRevokedSessions(since time.Time) ([]string, error) {
+	rows, err := tr.db.Query(`SELECT DISTINCT family FROM refresh_token WHERE revokedAt > ?`, since.UTC())
+	if err != nil {
+		log.Printf("Error reading revoked sessions: %s", err.Error())
+		return nil, fmt.Errorf("Error reading revoked sessions: %w", DatabaseError)
+	}
+	defer rows.Close()
+
+	families := []string{}
+	for rows.Next() {
+		var family string
+		if err := rows.Scan(&family); err != nil {
+			log.Printf("Error reading revoked sessions: %s", err.Error())
+			return nil, fmt.Errorf("Error reading revoked sessions: %w", DatabaseError)
+		}
+		families = append(families, family)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error reading revoked sessions: %s", err.Error())
+		return nil, fmt.Errorf("Error reading revoked sessions: %w", DatabaseError)
+	}
+	return families, nil
+}
+
+// RevokeAll implements TokenRepository
+func (tr tokenRepo) RevokeAll(pib int) error {
+	_, err := tr.db.Exec(`UPDATE refresh_token SET revokedAt = ? WHERE kind = ? AND pib = ? AND revokedAt IS NULL`,
+		time.Now().UTC(), model.SessionCompany, pib)
+	if err != nil {
+		log.Printf("Error revoking refresh tokens: %s", err.Error())
+		return fmt.Errorf("Error revoking refresh tokens: %w", DatabaseError)
+	}
+	return nil
+}
+
+// RevokeActing implements TokenRepository
+func (tr tokenRepo) RevokeActing(pib int, jmbg string) error {
+	_, err := tr.db.Exec(`UPDATE refresh_token SET revokedAt = ? WHERE kind = ? AND pib = ? AND jmbg = ? AND revokedAt IS NULL`,
+		time.Now().UTC(), model.SessionCompany, pib, jmbg)
+	if err != nil {
+		log.Printf("Error revoking refresh tokens: %s", err.Error())
+		return fmt.Errorf("Error revoking refresh tokens: %w", DatabaseError)
+	}
+	return nil
+}
+
+// RevokeOfficial implements TokenRepository
+func (tr tokenRepo) RevokeOfficial(jmbg string) error {
+	_, err := tr.db.Exec(`UPDATE refresh_token SET revokedAt = ? WHERE kind = ? AND jmbg = ? AND revokedAt IS NULL`,
+		time.Now().UTC(), model.SessionOfficial, jmbg)
+	if err != nil {
+		log.Printf("Error revoking refresh tokens: %s", err.Error())
+		return fmt.Errorf("Error revoking refresh tokens: %w", DatabaseError)
+	}
+	return nil
+}
+
+// SaveResetToken implements TokenRepository
+func (tr tokenRepo) SaveResetToken(hash string, pib int, expiresAt time.Time) error {
+	_, err := tr.db.Exec(`INSERT INTO password_reset (hash, pib, expiresAt) VALUES (?, ?, ?)`, hash, pib, expiresAt.UTC())
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error saving password reset token: %w", DatabaseError)
+	}
+	return nil
+}
+
+// ResetPassword implements TokenRepository
+func (tr tokenRepo) ResetPassword(hash string, passwordHash string) error {
+	tx, err := tr.db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err.Error())
+		return fmt.Errorf("Error resetting password: %w", DatabaseError)
+	}
+	defer tx.Rollback()
+
+	var pib int
+	now := time.Now().UTC()
+	err = tx.QueryRow(`SELECT pib FROM password_reset WHERE hash = ? AND usedAt IS NULL AND expiresAt > ? FOR UPDATE`, hash, now).Scan(&pib)
+	if err == sql.ErrNoRows {
+		return NoSuchResetTokenError
+	}
+	if err != nil {
+		log.Printf("Error reading password reset token: %s", err.Error())
+		return fmt.Errorf("Error resetting password: %w", DatabaseError)
+	}
+	res, err := tx.Exec(`UPDATE company SET password = ? WHERE PIB = ? AND likvidirana = 0`, passwordHash, pib)
+	if err != nil {
+		log.Printf("Error updating password of %d: %s", pib, err.Error())
+		return fmt.Errorf("Error resetting password: %w", DatabaseError)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("Company with PIB %d not found: %w", pib, NoSuchPibError)
+	}
+	if err := saveChanged(tx, pib); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE password_reset SET usedAt = ? WHERE hash = ?`, now, hash); err != nil {
+		log.Printf("Error using password reset token: %s", err.Error())
+		return fmt.Errorf("Error resetting password: %w", DatabaseError)
+	}
+	// Whoever knew the old password mustn't stay logged in
+	if _, err := tx.Exec(`UPDATE refresh_token SET revokedAt = ? WHERE kind = ? AND pib = ? AND revokedAt IS NULL`, now, model.SessionCompany, pib); err != nil {
+		log.Printf("Error revoking refresh tokens: %s", err.Error())
+		return fmt.Errorf("Error resetting password: %w", DatabaseError)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return fmt.Errorf("Error resetting password: %w", DatabaseError)
+	}
+	return nil
+}
+
+// RevokeAccessToken implements TokenRepository
+func (tr tokenRepo) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	_, err := tr.RevokeOnce(jti, expiresAt)
+	return err
+}
+
+// RevokeOnce implements TokenRepository
+func (tr tokenRepo) RevokeOnce(jti string, expiresAt time.Time) (bool, error) {
+	now := time.Now().UTC()
+	// Expired tokens are rejected anyway, no need to remember them
+	if _, err := tr.db.Exec(`DELETE FROM revoked_token WHERE expiresAt < ?`, now); err != nil {
+		log.Printf("Error deleting revoked tokens: %s", err.Error())
+	}
+	res, err := tr.db.Exec(`INSERT IGNORE INTO revoked_token (jti, expiresAt) VALUES (?, ?)`, jti, expiresAt.UTC())
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return false, fmt.Errorf("Error revoking token: %w", DatabaseError)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return false, fmt.Errorf("Error revoking token: %w", DatabaseError)
+	}
+	return n == 1, nil
+}
+
+// IsRevoked implements TokenRepository
+func (tr tokenRepo) IsRevoked(jti string) (bool, error) {
+	var revoked bool
+	err := tr.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM revoked_token WHERE jti = ?)`, jti).Scan(&revoked)
+	if err != nil {
+		log.Printf("Error checking revoked token: %s", err.Error())
+		return false, fmt.Errorf("Error checking revoked token: %w", DatabaseError)
+	}
+	return revoked, nil
+}
+
+// FindRevoked implements TokenRepository
+func (tr tokenRepo) FindRevoked() ([]string, error) {
+	rows, err := tr.db.Query(`SELECT jti FROM revoked_token WHERE expiresAt > ?`, time.Now().UTC())
+	if err != nil {
+		log.Printf("Error reading revoked tokens: %s", err.Error())
+		return nil, fmt.Errorf("Error reading revoked tokens: %w", DatabaseError)
+	}
+	defer rows.Close()
+
+	jtis := []string{}
+	for rows.Next() {
+		var jti string
+		if err := rows.Scan(&jti); err != nil {
+			log.Printf("Error reading revoked tokens: %s", err.Error())
+			return nil, fmt.Errorf("Error reading revoked tokens: %w", DatabaseError)
+		}
+		jtis = append(jtis, jti)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error reading revoked tokens: %s", err.Error())
+		return nil, fmt.Errorf("Error reading revoked tokens: %w", DatabaseError)
+	}
+	return jtis, nil
+}
+
+// nullString stores empty strings as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
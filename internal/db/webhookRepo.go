@@ -0,0 +1,379 @@
+package db
+
+import (
+	"apr-backend/internal/model"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+var NoSuchWebhookError = errors.New("Webhook subscription doesn't exist")
+var NoSuchDeadLetterError = errors.New("Dead letter doesn't exist")
+
+// WebhookRepository stores webhook subscriptions, deliveries which haven't
+// succeeded yet and those which failed on every attempt
+//
+//	CREATE TABLE webhook_subscription (
+//	    id        INT AUTO_INCREMENT PRIMARY KEY,
+//	    url       VARCHAR(2048) NOT NULL,
+//	    types     VARCHAR(255) NOT NULL,
+//	    pib       INT NOT NULL DEFAULT 0,
+//	    secret    VARCHAR(64) NOT NULL,
+//	    createdAt DATETIME NOT NULL
+//	);
+//
+//	CREATE TABLE webhook_dead_letter (
+//	    id             INT AUTO_INCREMENT PRIMARY KEY,
+//	    subscriptionId INT NOT NULL,
+//	    eventId        BIGINT NOT NULL,
+//	    eventType      VARCHAR(50) NOT NULL,
+//	    payload        JSON NOT NULL,
+//	    attempts       INT NOT NULL,
+//	    lastError      TEXT NOT NULL,
+//	    failedAt       DATETIME NOT NULL,
+//	    FOREIGN KEY (subscriptionId) REFERENCES webhook_subscription(id) ON DELETE CASCADE
+//	);
+//
+//	CREATE TABLE webhook_delivery (
+//	    id             INT AUTO_INCREMENT PRIMARY KEY,
+//	    subscriptionId INT NOT NULL,
+//	    eventId        BIGINT NOT NULL,
+//	    eventType      VARCHAR(50) NOT NULL,
+//	    payload        JSON NOT NULL,
+//	    attempts       INT NOT NULL DEFAULT 0,
+//	    lastError      TEXT NULL,
+//	    nextAttemptAt  DATETIME(6) NOT NULL,
+//	    UNIQUE (subscriptionId, eventId),
+//	    INDEX (nextAttemptAt),
+//	    FOREIGN KEY (subscriptionId) REFERENCES webhook_subscription(id) ON DELETE CASCADE
+//	);
+type WebhookRepository interface {
+	Save(sub *model.WebhookSubscription) error
+	// Returns every subscription, including its secret
+	FindAll() ([]model.WebhookSubscription, error)
+	FindOne(id int) (model.WebhookSubscription, error)
+	Delete(id int) error
+	FindDeadLetters() ([]model.DeadLetter, error)
+	FindDeadLetter(id int) (model.DeadLetter, error)
+	// Queues deliveries. A delivery of the same event to the same
+	// subscription which is already queued is kept as it is.
+	SaveDeliveries(deliveries []model.WebhookDelivery) error
+	// Returns up to limit deliveries which are due and postpones their next
+	// attempt by lease, so that other replicas leave them alone while they
+	// are being sent
+	ClaimDeliveries(limit int, lease time.Duration) ([]model.WebhookDelivery, error)
+	// Removes a delivery which succeeded
+	DeleteDelivery(id int) error
+	// Stores the attempts, last error and next attempt of a delivery
+	RetryDelivery(delivery model.WebhookDelivery) error
+	// Replaces a delivery with dl, once every attempt has failed
+	DeadLetterDelivery(delivery model.WebhookDelivery, dl *model.DeadLetter) error
+	// Replaces a dead letter with a delivery due at
+	RequeueDeadLetter(id int, at time.Time) error
+}
+
+func NewWebhookRepository(db *sql.DB) WebhookRepository {
+	return webhookRepo{db: db}
+}
+
+type webhookRepo struct {
+	db *sql.DB
+}
+
+// Save implements WebhookRepository
+func (wr webhookRepo) Save(sub *model.WebhookSubscription) error {
+	res, err := wr.db.Exec(`INSERT INTO webhook_subscription (url, types, pib, secret, createdAt) VALUES (?, ?, ?, ?, ?)`,
+		sub.URL, strings.Join(sub.Types, ","), sub.PIB, sub.Secret, sub.CreatedAt)
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error saving webhook: %w", DatabaseError)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("Error when getting id of webhook: %w", DatabaseError)
+	}
+	sub.Id = int(id)
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanWebhook(row scanner) (model.WebhookSubscription, error) {
+	var sub model.WebhookSubscription
+	var types string
+	err := row.Scan(&sub.Id, &sub.URL, &types, &sub.PIB, &sub.Secret, &sub.CreatedAt)
+	sub.Types = []string{}
+	if types != "" {
+		sub.Types = strings.Split(types, ",")
+	}
+	return sub, err
+}
+
+// FindAll implements WebhookRepository
+func (wr webhookRepo) FindAll() ([]model.WebhookSubscription, error) {
+	rows, err := wr.db.Query(`SELECT id, url, types, pib, secret, createdAt FROM webhook_subscription ORDER BY id`)
+	if err != nil {
+		log.Printf("Error getting webhooks: %s", err.Error())
+		return nil, fmt.Errorf("Error getting webhooks: %w", DatabaseError)
+	}
+	defer rows.Close()
+
+	subs := make([]model.WebhookSubscription, 0)
+	for rows.Next() {
+		sub, err := scanWebhook(rows)
+		if err != nil {
+			return subs, fmt.Errorf("%w: couldn't scan webhook", DatabaseError)
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+// FindOne implements WebhookRepository
+func (wr webhookRepo) FindOne(id int) (model.WebhookSubscription, error) {
+	sub, err := scanWebhook(wr.db.QueryRow(`SELECT id, url, types, pib, secret, createdAt FROM webhook_subscription WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return sub, fmt.Errorf("Webhook %d not found: %w", id, NoSuchWebhookError)
+	}
+	if err != nil {
+		log.Printf("Error getting webhook %d: %s", id, err.Error())
+		return sub, DatabaseError
+	}
+	return sub, nil
+}
+
+func deleteById(db *sql.DB, query string, id int, notFound error) error {
+	res, err := db.Exec(query, id)
+	if err != nil {
+		log.Printf("err: %s\n", err.Error())
+		return fmt.Errorf("Error executing query: %w", DatabaseError)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Error getting rows affected %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("Cannot delete %d: %w", id, notFound)
+	}
+	return nil
+}
+
+// Delete implements WebhookRepository
+func (wr webhookRepo) Delete(id int) error {
+	return deleteById(wr.db, `DELETE FROM webhook_subscription WHERE id = ?`, id, NoSuchWebhookError)
+}
+
+func scanDeadLetter(row scanner) (model.DeadLetter, error) {
+	var dl model.DeadLetter
+	var payload []byte
+	err := row.Scan(&dl.Id, &dl.SubscriptionId, &dl.EventId, &dl.EventType, &payload, &dl.Attempts, &dl.LastError, &dl.FailedAt)
+	dl.Payload = payload
+	return dl, err
+}
+
+// FindDeadLetters implements WebhookRepository
+func (wr webhookRepo) FindDeadLetters() ([]model.DeadLetter, error) {
+	rows, err := wr.db.Query(`SELECT id, subscriptionId, eventId, eventType, payload, attempts, lastError, failedAt
+        FROM webhook_dead_letter ORDER BY id`)
+	if err != nil {
+		log.Printf("Error getting dead letters: %s", err.Error())
+		return nil, fmt.Errorf("Error getting dead letters: %w", DatabaseError)
+	}
+	defer rows.Close()
+
+	dls := make([]model.DeadLetter, 0)
+	for rows.Next() {
+		dl, err := scanDeadLetter(rows)
+		if err != nil {
+			return dls, fmt.Errorf("%w: couldn't scan dead letter", DatabaseError)
+		}
+		dls = append(dls, dl)
+	}
+	return dls, nil
+}
+
+// FindDeadLetter implements WebhookRepository
+func (wr webhookRepo) FindDeadLetter(id int) (model.DeadLetter, error) {
+	dl, err := scanDeadLetter(wr.db.QueryRow(`SELECT id, subscriptionId, eventId, eventType, payload, attempts, lastError, failedAt
+        FROM webhook_dead_letter WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return dl, fmt.Errorf("Dead letter %d not found: %w", id, NoSuchDeadLetterError)
+	}
+	if err != nil {
+		log.Printf("Error getting dead letter %d: %s", id, err.Error())
+		return dl, DatabaseError
+	}
+	return dl, nil
+}
+
+func scanDelivery(row scanner) (model.WebhookDelivery, error) {
+	var d model.WebhookDelivery
+	var payload []byte
+	var lastError sql.NullString
+	err := row.Scan(&d.Id, &d.SubscriptionId, &d.EventId, &d.EventType, &payload, &d.Attempts, &lastError, &d.NextAttemptAt)
+	d.Payload = payload
+	d.LastError = lastError.String
+	return d, err
+}
+
+// SaveDeliveries implements WebhookRepository
+func (wr webhookRepo) SaveDeliveries(deliveries []model.WebhookDelivery) error {
+	tx, err := wr.db.Begin()
+	if err != nil {
+		return DatabaseError
+	}
+	defer tx.Rollback()
+
+	for _, d := range deliveries {
+		// The relay hands an event over again if it died before storing its
+		// position, the deliveries queued the first time stay
+		_, err := tx.Exec(`INSERT IGNORE INTO webhook_delivery (subscriptionId, eventId, eventType, payload, attempts, nextAttemptAt)
+            VALUES (?, ?, ?, ?, 0, ?)`, d.SubscriptionId, d.EventId, d.EventType, []byte(d.Payload), d.NextAttemptAt)
+		if err != nil {
+			log.Printf("Insert error: %s", err.Error())
+			return fmt.Errorf("Error queueing webhook delivery: %w", DatabaseError)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return fmt.Errorf("Error queueing webhook deliveries: %w", DatabaseError)
+	}
+	return nil
+}
+
+// ClaimDeliveries implements WebhookRepository
+func (wr webhookRepo) ClaimDeliveries(limit int, lease time.Duration) ([]model.WebhookDelivery, error) {
+	tx, err := wr.db.Begin()
+	if err != nil {
+		return nil, DatabaseError
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	// Deliveries another replica is claiming right now are skipped instead
+	// of waited for
+	rows, err := tx.Query(`SELECT id, subscriptionId, eventId, eventType, payload, attempts, lastError, nextAttemptAt
+        FROM webhook_delivery WHERE nextAttemptAt <= ? ORDER BY nextAttemptAt, id LIMIT ? FOR UPDATE SKIP LOCKED`, now, limit)
+	if err != nil {
+		log.Printf("Error getting webhook deliveries: %s", err.Error())
+		return nil, fmt.Errorf("Error getting webhook deliveries: %w", DatabaseError)
+	}
+	deliveries := make([]model.WebhookDelivery, 0, limit)
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("%w: couldn't scan webhook delivery", DatabaseError)
+		}
+		deliveries = append(deliveries, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("Error getting webhook deliveries: %s", err.Error())
+		return nil, fmt.Errorf("Error getting webhook deliveries: %w", DatabaseError)
+	}
+
+	for _, d := range deliveries {
+		if _, err := tx.Exec(`UPDATE webhook_delivery SET nextAttemptAt = ? WHERE id = ?`, now.Add(lease), d.Id); err != nil {
+			log.Printf("Error claiming webhook delivery %d: %s", d.Id, err.Error())
+			return nil, fmt.Errorf("Error claiming webhook deliveries: %w", DatabaseError)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return nil, fmt.Errorf("Error claiming webhook deliveries: %w", DatabaseError)
+	}
+	return deliveries, nil
+}
+
+// DeleteDelivery implements WebhookRepository
+func (wr webhookRepo) DeleteDelivery(id int) error {
+	if _, err := wr.db.Exec(`DELETE FROM webhook_delivery WHERE id = ?`, id); err != nil {
+		log.Printf("Error deleting webhook delivery %d: %s", id, err.Error())
+		return fmt.Errorf("Error deleting webhook delivery: %w", DatabaseError)
+	}
+	return nil
+}
+
+// RetryDelivery implements WebhookRepository
+func (wr webhookRepo) RetryDelivery(d model.WebhookDelivery) error {
+	_, err := wr.db.Exec(`UPDATE webhook_delivery SET attempts = ?, lastError = ?, nextAttemptAt = ? WHERE id = ?`,
+		d.Attempts, d.LastError, d.NextAttemptAt, d.Id)
+	if err != nil {
+		log.Printf("Error updating webhook delivery %d: %s", d.Id, err.Error())
+		return fmt.Errorf("Error updating webhook delivery: %w", DatabaseError)
+	}
+	return nil
+}
+
+// DeadLetterDelivery implements WebhookRepository
+func (wr webhookRepo) DeadLetterDelivery(d model.WebhookDelivery, dl *model.DeadLetter) error {
+	tx, err := wr.db.Begin()
+	if err != nil {
+		return DatabaseError
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM webhook_delivery WHERE id = ?`, d.Id); err != nil {
+		log.Printf("Error deleting webhook delivery %d: %s", d.Id, err.Error())
+		return fmt.Errorf("Error saving dead letter: %w", DatabaseError)
+	}
+	res, err := tx.Exec(`INSERT INTO webhook_dead_letter
+        (subscriptionId, eventId, eventType, payload, attempts, lastError, failedAt)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		dl.SubscriptionId, dl.EventId, dl.EventType, []byte(dl.Payload), dl.Attempts, dl.LastError, dl.FailedAt)
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error saving dead letter: %w", DatabaseError)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("Error when getting id of dead letter: %w", DatabaseError)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return fmt.Errorf("Error saving dead letter: %w", DatabaseError)
+	}
+	dl.Id = int(id)
+	return nil
+}
+
+// RequeueDeadLetter implements WebhookRepository
+func (wr webhookRepo) RequeueDeadLetter(id int, at time.Time) error {
+	tx, err := wr.db.Begin()
+	if err != nil {
+		return DatabaseError
+	}
+	defer tx.Rollback()
+
+	dl, err := scanDeadLetter(tx.QueryRow(`SELECT id, subscriptionId, eventId, eventType, payload, attempts, lastError, failedAt
+        FROM webhook_dead_letter WHERE id = ? FOR UPDATE`, id))
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("Dead letter %d not found: %w", id, NoSuchDeadLetterError)
+	}
+	if err != nil {
+		log.Printf("Error getting dead letter %d: %s", id, err.Error())
+		return DatabaseError
+	}
+	_, err = tx.Exec(`INSERT INTO webhook_delivery (subscriptionId, eventId, eventType, payload, attempts, nextAttemptAt)
+        VALUES (?, ?, ?, ?, 0, ?) ON DUPLICATE KEY UPDATE attempts = 0, nextAttemptAt = VALUES(nextAttemptAt)`,
+		dl.SubscriptionId, dl.EventId, dl.EventType, []byte(dl.Payload), at)
+	if err != nil {
+		log.Printf("Insert error: %s", err.Error())
+		return fmt.Errorf("Error queueing dead letter %d: %w", id, DatabaseError)
+	}
+	if _, err := tx.Exec(`DELETE FROM webhook_dead_letter WHERE id = ?`, id); err != nil {
+		log.Printf("Error deleting dead letter %d: %s", id, err.Error())
+		return fmt.Errorf("Error queueing dead letter %d: %w", id, DatabaseError)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Commit error: %s", err.Error())
+		return fmt.Errorf("Error queueing dead letter %d: %w", id, DatabaseError)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Package events relays registry events from the outbox to their consumers
+// and distributes them to subscribers inside the process.
+package events
+
+import (
+	"apr-backend/internal/model"
+	"context"
+	"sync"
+)
+
+// Subscription receives events published after it was created. C is closed
+// when the subscription is cancelled or when the subscriber falls too far
+// behind, in which case it should resubscribe from the last event it saw.
+type Subscription struct {
+	C      <-chan model.Event
+	filter model.EventFilter
+	ch     chan model.Event
+}
+
+// Broker keeps the most recent events so that subscribers can resume, and
+// fans them out to subscribers. Events keep the ids they got in the outbox.
+type Broker struct {
+	mu      sync.Mutex
+	lastId  int64
+	history []model.Event
+	size    int
+	subs    map[*Subscription]struct{}
+}
+
+const subscriptionBuffer = 64
+
+// NewBroker returns a broker which remembers the last historySize events.
+func NewBroker(historySize int) *Broker {
+	return &Broker{
+		history: make([]model.Event, 0, historySize),
+		size:    historySize,
+		subs:    make(map[*Subscription]struct{}),
+	}
+}
+
+// Consume implements Consumer
+func (b *Broker) Consume(ctx context.Context, event model.Event) error {
+	b.Publish(event)
+	return nil
+}
+
+// Publish hands event to subscribers. Events which aren't newer than the last
+// published one are ignored, so redelivered events reach subscribers once.
+func (b *Broker) Publish(event model.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if event.Id <= b.lastId {
+		return
+	}
+	b.lastId = event.Id
+	if len(b.history) == b.size {
+		copy(b.history, b.history[1:])
+		b.history = b.history[:b.size-1]
+	}
+	b.history = append(b.history, event)
+
+	for sub := range b.subs {
+		if !sub.filter.Matches(event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			// Never block publishers on a slow subscriber
+			delete(b.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// Subscribe returns the remembered events after lastId which match filter,
+// and a subscription for the events that follow them.
+func (b *Broker) Subscribe(lastId int64, filter model.EventFilter) ([]model.Event, *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	replay := make([]model.Event, 0)
+	for _, event := range b.history {
+		if event.Id > lastId && filter.Matches(event) {
+			replay = append(replay, event)
+		}
+	}
+
+	ch := make(chan model.Event, subscriptionBuffer)
+	sub := &Subscription{C: ch, filter: filter, ch: ch}
+	b.subs[sub] = struct{}{}
+	return replay, sub
+}
+
+// Unsubscribe cancels sub. It is safe to call more than once.
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
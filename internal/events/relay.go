@@ -0,0 +1,162 @@
+package events
+
+import (
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	relayBatchSize    = 100
+	relayPollInterval = 500 * time.Millisecond
+)
+
+// Consumer receives outbox events in order. When Consume returns an error the
+// event is offered again on the next poll.
+type Consumer interface {
+	Consume(ctx context.Context, event model.Event) error
+}
+
+type relayConsumer struct {
+	name     string
+	consumer Consumer
+	durable  bool
+	// Position of a volatile consumer, durable ones keep it in the database
+	position int64
+}
+
+// Relay polls the outbox and hands every event to each registered consumer
+// in order. Events commit in the order of their ids, so nothing committed
+// later can appear behind a consumer's position. Durable consumers have
+// their position stored in the database, in the same transaction which is
+// locked while they consume, so replicas never hand them the same events
+// concurrently. Delivery is at least once: if the process dies between
+// consuming events and committing the position, those events are handed
+// over again after the restart, so consumers have to tolerate duplicates.
+// Volatile consumers, like the in-process Broker, start near the end of the
+// outbox every time the process starts.
+type Relay struct {
+	outboxRepo db.OutboxRepository
+	mu         sync.Mutex
+	consumers  []*relayConsumer
+}
+
+func NewRelay(outboxRepo db.OutboxRepository) *Relay {
+	return &Relay{outboxRepo: outboxRepo}
+}
+
+// Register adds a consumer. A volatile consumer first receives the last
+// backlog events of the outbox.
+func (r *Relay) Register(name string, consumer Consumer, durable bool, backlog int64) error {
+	rc := &relayConsumer{name: name, consumer: consumer, durable: durable}
+	if !durable {
+		lastId, err := r.outboxRepo.LastId()
+		if err != nil {
+			return err
+		}
+		if rc.position = lastId - backlog; rc.position < 0 {
+			rc.position = 0
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.consumers = append(r.consumers, rc)
+	return nil
+}
+
+// consume hands events after position to rc until one fails, and returns
+// the position of the last consumed event.
+func (r *Relay) consume(ctx context.Context, rc *relayConsumer, position int64) (int64, error) {
+	events, err := r.outboxRepo.FindAfter(position, relayBatchSize)
+	if err != nil {
+		return position, err
+	}
+	for _, event := range events {
+		if err := rc.consumer.Consume(ctx, event); err != nil {
+			return position, err
+		}
+		position = event.Id
+	}
+	return position, nil
+}
+
+func (r *Relay) poll(ctx context.Context) {
+	r.mu.Lock()
+	consumers := append([]*relayConsumer{}, r.consumers...)
+	r.mu.Unlock()
+
+	for _, rc := range consumers {
+		var err error
+		if rc.durable {
+			err = r.outboxRepo.WithPosition(rc.name, func(position int64) (int64, error) {
+				return r.consume(ctx, rc, position)
+			})
+		} else {
+			var position int64
+			position, err = r.consume(ctx, rc, rc.position)
+			r.mu.Lock()
+			rc.position = position
+			r.mu.Unlock()
+		}
+		if err != nil {
+			log.Printf("Relay to %s failed: %s", rc.name, err.Error())
+		}
+	}
+}
+
+// Run polls the outbox until ctx is done.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(relayPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.poll(ctx)
+		}
+	}
+}
+
+// Status reports the position and lag of every consumer.
+func (r *Relay) Status() (model.RelayStatus, error) {
+	lastId, err := r.outboxRepo.LastId()
+	if err != nil {
+		return model.RelayStatus{}, err
+	}
+	positions, err := r.outboxRepo.FindPositions()
+	if err != nil {
+		return model.RelayStatus{}, err
+	}
+
+	r.mu.Lock()
+	consumers := append([]*relayConsumer{}, r.consumers...)
+	r.mu.Unlock()
+
+	status := model.RelayStatus{LastId: lastId, Consumers: make([]model.ConsumerStatus, 0, len(consumers))}
+	for _, rc := range consumers {
+		cs := model.ConsumerStatus{Name: rc.name, Durable: rc.durable}
+		if rc.durable {
+			cs.Position = positions[rc.name]
+		} else {
+			r.mu.Lock()
+			cs.Position = rc.position
+			r.mu.Unlock()
+		}
+		cs.Lag = lastId - cs.Position
+		if cs.Lag > 0 {
+			pending, err := r.outboxRepo.FindAfter(cs.Position, 1)
+			if err != nil {
+				return status, err
+			}
+			if len(pending) > 0 {
+				cs.OldestPending = &pending[0].Time
+			}
+		}
+		status.Consumers = append(status.Consumers, cs)
+	}
+	return status, nil
+}
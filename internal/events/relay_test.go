@@ -0,0 +1,137 @@
+package events
+
+import (
+	"apr-backend/internal/model"
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryOutbox keeps events and consumer positions in memory
+type memoryOutbox struct {
+	mu        sync.Mutex
+	events    []model.Event
+	positions map[string]int64
+}
+
+func (mo *memoryOutbox) add(ids ...int64) {
+	for _, id := range ids {
+		mo.events = append(mo.events, model.Event{Id: id, Type: model.EventCompanyRegistered, Time: time.Unix(id, 0)})
+	}
+}
+
+func (mo *memoryOutbox) LastId() (int64, error) {
+	if len(mo.events) == 0 {
+		return 0, nil
+	}
+	return mo.events[len(mo.events)-1].Id, nil
+}
+
+func (mo *memoryOutbox) FindAfter(after int64, limit int) ([]model.Event, error) {
+	found := []model.Event{}
+	for _, event := range mo.events {
+		if event.Id > after && len(found) < limit {
+			found = append(found, event)
+		}
+	}
+	return found, nil
+}
+
+func (mo *memoryOutbox) WithPosition(consumer string, fn func(position int64) (int64, error)) error {
+	mo.mu.Lock()
+	defer mo.mu.Unlock()
+	position, err := fn(mo.positions[consumer])
+	mo.positions[consumer] = position
+	return err
+}
+
+func (mo *memoryOutbox) FindPositions() (map[string]int64, error) {
+	return mo.positions, nil
+}
+
+// recorder remembers the ids of the events it consumed and fails once on
+// the event with id failOn
+type recorder struct {
+	ids    []int64
+	failOn int64
+}
+
+func (r *recorder) Consume(ctx context.Context, event model.Event) error {
+	if event.Id == r.failOn {
+		r.failOn = 0
+		return errors.New("consumer unavailable")
+	}
+	r.ids = append(r.ids, event.Id)
+	return nil
+}
+
+func TestRelayOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		durable bool
+		backlog int64
+		// Events in the outbox when the consumer registers, and after the
+		// first poll
+		before, after []int64
+		failOn        int64
+		// Ids consumed after each of two polls
+		wantFirst, wantSecond []int64
+	}{
+		{"durable from the start", true, 0, []int64{1, 2, 3}, []int64{4, 5}, 0, []int64{1, 2, 3}, []int64{1, 2, 3, 4, 5}},
+		{"durable retries a failed event", true, 0, []int64{1, 2, 3}, nil, 2, []int64{1}, []int64{1, 2, 3}},
+		{"volatile with backlog", false, 2, []int64{1, 2, 3, 4}, []int64{5}, 0, []int64{3, 4}, []int64{3, 4, 5}},
+		{"volatile retries a failed event", false, 2, []int64{1, 2}, nil, 1, []int64{}, []int64{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outbox := &memoryOutbox{positions: map[string]int64{}}
+			outbox.add(tt.before...)
+			relay := NewRelay(outbox)
+			consumer := &recorder{ids: []int64{}, failOn: tt.failOn}
+			if err := relay.Register("test", consumer, tt.durable, tt.backlog); err != nil {
+				t.Fatal(err)
+			}
+
+			relay.poll(context.Background())
+			if !reflect.DeepEqual(consumer.ids, tt.wantFirst) {
+				t.Errorf("first poll consumed %v, want %v", consumer.ids, tt.wantFirst)
+			}
+			outbox.add(tt.after...)
+			relay.poll(context.Background())
+			if !reflect.DeepEqual(consumer.ids, tt.wantSecond) {
+				t.Errorf("second poll consumed %v, want %v", consumer.ids, tt.wantSecond)
+			}
+
+			status, err := relay.Status()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(status.Consumers) != 1 || status.Consumers[0].Lag != 0 || status.Consumers[0].OldestPending != nil {
+				t.Errorf("consumer isn't caught up: %+v", status.Consumers)
+			}
+		})
+	}
+}
+
+func TestRelayStatusLag(t *testing.T) {
+	outbox := &memoryOutbox{positions: map[string]int64{"webhooks": 2}}
+	outbox.add(1, 2, 3, 4)
+	relay := NewRelay(outbox)
+	if err := relay.Register("webhooks", &recorder{}, true, 0); err != nil {
+		t.Fatal(err)
+	}
+	status, err := relay.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := status.Consumers[0]
+	if status.LastId != 4 || cs.Position != 2 || cs.Lag != 2 {
+		t.Errorf("got last id %d, position %d and lag %d, want 4, 2 and 2", status.LastId, cs.Position, cs.Lag)
+	}
+	if cs.OldestPending == nil || !cs.OldestPending.Equal(time.Unix(3, 0)) {
+		t.Errorf("oldest pending event at %v, want the time of event 3", cs.OldestPending)
+	}
+}
@@ -0,0 +1,119 @@
+// Package export writes companies in formats suited for bulk download.
+//
+// Every writer streams: a company is encoded and flushed to the underlying
+// writer as soon as it is written, so exports of the whole registry never
+// have to be held in memory.
+package export
+
+import (
+	"apr-backend/internal/model"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+var ErrUnknownFormat = errors.New("Unknown export format")
+
+const (
+	CSV   = "csv"
+	XLSX  = "xlsx"
+	JSONL = "jsonl"
+)
+
+// CompanyWriter encodes companies one by one. Close must be called after the
+// last company, it writes whatever trailer the format needs.
+type CompanyWriter interface {
+	Write(com model.Company) error
+	Close() error
+}
+
+// Header is the column order used by the tabular formats.
+var Header = []string{
+	"pib", "naziv", "adresaSedista", "mesto", "postanskiBroj", "delatnost",
+	"sediste", "sedisteNaziv", "vlasnikIme", "vlasnikPrezime",
+}
+
+func record(com model.Company) []string {
+	return []string{
+		strconv.Itoa(com.PIB), com.Naziv, com.AdresaSedista, com.Mesto, com.PostanskiBroj, com.Delatnost.String(),
+		com.Sediste.Oznaka, com.Sediste.Naziv, com.Vlasnik.Name, com.Vlasnik.Lastname,
+	}
+}
+
+// IsFormat reports whether format is one of the supported export formats.
+func IsFormat(format string) bool {
+	return format == CSV || format == XLSX || format == JSONL
+}
+
+// ContentType returns the MIME type of format.
+func ContentType(format string) string {
+	switch format {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case JSONL:
+		return "application/x-ndjson"
+	}
+	return "application/octet-stream"
+}
+
+// NewCompanyWriter returns a CompanyWriter for format which writes to w.
+func NewCompanyWriter(format string, w io.Writer) (CompanyWriter, error) {
+	switch format {
+	case CSV:
+		return newCsvWriter(w)
+	case XLSX:
+		return newXlsxWriter(w)
+	case JSONL:
+		return jsonlWriter{enc: json.NewEncoder(w)}, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCsvWriter(w io.Writer) (CompanyWriter, error) {
+	cw := csvWriter{w: csv.NewWriter(w)}
+	if err := cw.w.Write(Header); err != nil {
+		return nil, fmt.Errorf("Error writing csv header: %w", err)
+	}
+	return cw, nil
+}
+
+// Write implements CompanyWriter
+func (cw csvWriter) Write(com model.Company) error {
+	if err := cw.w.Write(record(com)); err != nil {
+		return fmt.Errorf("Error writing csv record: %w", err)
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// Close implements CompanyWriter
+func (cw csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+// Write implements CompanyWriter
+func (jw jsonlWriter) Write(com model.Company) error {
+	if err := jw.enc.Encode(com); err != nil {
+		return fmt.Errorf("Error encoding company: %w", err)
+	}
+	return nil
+}
+
+// Close implements CompanyWriter
+func (jw jsonlWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,100 @@
+package export
+
+import (
+	"apr-backend/internal/model"
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Static parts of a minimal SpreadsheetML package with a single sheet.
+var xlsxParts = []struct {
+	name    string
+	content string
+}{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Companies" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`},
+}
+
+const sheetHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+const sheetFooter = `</sheetData></worksheet>`
+
+// xlsxWriter writes the static parts up front and then streams rows into the
+// sheet using inline strings, so no shared string table has to be built.
+type xlsxWriter struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	row   int
+}
+
+func newXlsxWriter(w io.Writer) (CompanyWriter, error) {
+	zw := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating %s: %w", part.name, err)
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return nil, fmt.Errorf("Error writing %s: %w", part.name, err)
+		}
+	}
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, fmt.Errorf("Error creating sheet: %w", err)
+	}
+	if _, err := io.WriteString(sheet, sheetHeader); err != nil {
+		return nil, fmt.Errorf("Error writing sheet: %w", err)
+	}
+	xw := &xlsxWriter{zw: zw, sheet: sheet}
+	return xw, xw.writeRow(Header)
+}
+
+func (xw *xlsxWriter) writeRow(cells []string) error {
+	xw.row++
+	var buf bytes.Buffer
+	buf.WriteString(`<row r="` + strconv.Itoa(xw.row) + `">`)
+	for _, cell := range cells {
+		buf.WriteString(`<c t="inlineStr"><is><t>`)
+		xml.EscapeText(&buf, []byte(cell))
+		buf.WriteString(`</t></is></c>`)
+	}
+	buf.WriteString(`</row>`)
+	if _, err := xw.sheet.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("Error writing row %d: %w", xw.row, err)
+	}
+	return xw.zw.Flush()
+}
+
+// Write implements CompanyWriter
+func (xw *xlsxWriter) Write(com model.Company) error {
+	return xw.writeRow(record(com))
+}
+
+// Close implements CompanyWriter
+func (xw *xlsxWriter) Close() error {
+	if _, err := io.WriteString(xw.sheet, sheetFooter); err != nil {
+		return fmt.Errorf("Error writing sheet: %w", err)
+	}
+	return xw.zw.Close()
+}
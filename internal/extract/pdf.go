@@ -0,0 +1,116 @@
+// Package extract renders registry extracts (izvodi) of companies.
+package extract
+
+import (
+	"apr-backend/internal/model"
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"strconv"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+	"github.com/go-pdf/fpdf"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+const (
+	// The core PDF fonts can't encode Serbian latin, so Go fonts are embedded
+	font     = "go"
+	qrSize   = 40.0
+	qrPixels = 400
+	labelW   = 60.0
+	lineH    = 8.0
+)
+
+// WritePDF renders ext as an A4 document. verifyUrl is where the extract
+// can be checked, it is encoded into the QR code and printed below it.
+func WritePDF(w io.Writer, ext model.Extract, verifyUrl string) error {
+	qrPng, err := qrCode(verifyUrl)
+	if err != nil {
+		return err
+	}
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.AddUTF8FontFromBytes(font, "", goregular.TTF)
+	pdf.AddUTF8FontFromBytes(font, "B", gobold.TTF)
+	pdf.SetTitle(fmt.Sprintf("Izvod iz registra - PIB %d", ext.Company.PIB), true)
+	pdf.SetCreator("APR", true)
+	pdf.SetCreationDate(ext.IssuedAt)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddPage()
+
+	pdf.SetFont(font, "B", 16)
+	pdf.CellFormat(0, 10, "Agencija za privredne registre", "", 1, "C", false, 0, "")
+	pdf.SetFont(font, "", 13)
+	pdf.CellFormat(0, 8, "Izvod iz registra privrednih subjekata", "", 1, "C", false, 0, "")
+	pdf.Ln(10)
+
+	com := ext.Company
+	status := "Aktivan"
+	if com.Likvidirana {
+		status = "Likvidiran"
+	}
+	sediste := com.Sediste.Oznaka
+	if com.Sediste.Naziv != "" {
+		sediste = fmt.Sprintf("%s (%s)", com.Sediste.Naziv, com.Sediste.Oznaka)
+	}
+	rows := [][2]string{
+		{"Poslovno ime", com.Naziv},
+		{"PIB", strconv.Itoa(com.PIB)},
+		{"Status", status},
+		{"Delatnost", string(com.Delatnost)},
+		{"Adresa sedišta", com.AdresaSedista},
+		{"Mesto", fmt.Sprintf("%s %s", com.PostanskiBroj, com.Mesto)},
+		{"Sedište (NSTJ)", sediste},
+		{"Vlasnik", fmt.Sprintf("%s %s", com.Vlasnik.Name, com.Vlasnik.Lastname)},
+	}
+	for _, row := range rows {
+		pdf.SetFont(font, "B", 11)
+		pdf.CellFormat(labelW, lineH, row[0], "B", 0, "L", false, 0, "")
+		pdf.SetFont(font, "", 11)
+		pdf.CellFormat(0, lineH, row[1], "B", 1, "L", false, 0, "")
+	}
+	pdf.Ln(8)
+	pdf.SetFont(font, "", 10)
+	pdf.MultiCell(0, 5, fmt.Sprintf("Izvod sadrži podatke upisane u registar na dan %s u %s (UTC).",
+		ext.IssuedAt.Format("02.01.2006."), ext.IssuedAt.Format("15:04:05")), "", "L", false)
+
+	_, y := pdf.GetXY()
+	y += 10
+	pdf.RegisterImageOptionsReader("qr", fpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(qrPng))
+	pdf.ImageOptions("qr", 10, y, qrSize, qrSize, false, fpdf.ImageOptions{ImageType: "PNG"}, 0, verifyUrl)
+	pdf.SetXY(10+qrSize+5, y+8)
+	pdf.SetFont(font, "B", 11)
+	pdf.CellFormat(0, 6, "Kod za proveru: "+ext.Code, "", 2, "L", false, 0, "")
+	pdf.SetFont(font, "", 9)
+	pdf.MultiCell(0, 5, "Verodostojnost izvoda se može proveriti skeniranjem QR koda ili na adresi "+verifyUrl, "", "L", false)
+
+	if err := pdf.Error(); err != nil {
+		return fmt.Errorf("Error rendering extract: %w", err)
+	}
+	return pdf.Output(w)
+}
+
+func qrCode(content string) ([]byte, error) {
+	code, err := qr.Encode(content, qr.M, qr.Auto)
+	if err != nil {
+		return nil, fmt.Errorf("Error encoding QR code: %w", err)
+	}
+	code, err = barcode.Scale(code, qrPixels, qrPixels)
+	if err != nil {
+		return nil, fmt.Errorf("Error scaling QR code: %w", err)
+	}
+	// fpdf only reads 8 bit PNGs
+	img := image.NewGray(code.Bounds())
+	draw.Draw(img, img.Bounds(), code, code.Bounds().Min, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("Error encoding QR code: %w", err)
+	}
+	return buf.Bytes(), nil
+}
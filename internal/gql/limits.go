@@ -0,0 +1,90 @@
+package gql
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+var ErrQueryTooDeep = errors.New("Query is too deep")
+var ErrQueryTooComplex = errors.New("Query is too complex")
+
+// Fields which return lists count as this many items when estimating
+// complexity.
+var listCost = map[string]int{
+	"companies":      50,
+	"companiesByPib": 50,
+	"nstj":           50,
+	"delatnosti":     5,
+}
+
+type limitChecker struct {
+	fragments map[string]*ast.FragmentDefinition
+	// Fragments being expanded, guards against fragment cycles
+	visiting map[string]bool
+}
+
+// checkLimits rejects documents whose operations nest deeper than maxDepth
+// or whose estimated cost exceeds maxComplexity. Every field costs 1 plus
+// the cost of its selections, multiplied by listCost for list fields.
+func checkLimits(doc *ast.Document, maxDepth, maxComplexity int) error {
+	lc := limitChecker{
+		fragments: make(map[string]*ast.FragmentDefinition),
+		visiting:  make(map[string]bool),
+	}
+	for _, def := range doc.Definitions {
+		if frag, ok := def.(*ast.FragmentDefinition); ok {
+			lc.fragments[frag.Name.Value] = frag
+		}
+	}
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		depth, cost := lc.measure(op.SelectionSet)
+		if depth > maxDepth {
+			return fmt.Errorf("%w: depth %d exceeds %d", ErrQueryTooDeep, depth, maxDepth)
+		}
+		if cost > maxComplexity {
+			return fmt.Errorf("%w: complexity %d exceeds %d", ErrQueryTooComplex, cost, maxComplexity)
+		}
+	}
+	return nil
+}
+
+func (lc limitChecker) measure(set *ast.SelectionSet) (depth int, cost int) {
+	if set == nil {
+		return 0, 0
+	}
+	for _, sel := range set.Selections {
+		var d, c int
+		switch sel := sel.(type) {
+		case *ast.Field:
+			d, c = lc.measure(sel.SelectionSet)
+			d++
+			c++
+			if n, ok := listCost[sel.Name.Value]; ok {
+				c *= n
+			}
+		case *ast.InlineFragment:
+			d, c = lc.measure(sel.SelectionSet)
+		case *ast.FragmentSpread:
+			name := sel.Name.Value
+			frag, ok := lc.fragments[name]
+			if !ok || lc.visiting[name] {
+				// Unknown fragments and cycles are reported by validation
+				continue
+			}
+			lc.visiting[name] = true
+			d, c = lc.measure(frag.SelectionSet)
+			delete(lc.visiting, name)
+		}
+		if d > depth {
+			depth = d
+		}
+		cost += c
+	}
+	return depth, cost
+}
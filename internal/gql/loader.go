@@ -0,0 +1,62 @@
+package gql
+
+import (
+	"apr-backend/internal/model"
+	"context"
+	"sync"
+)
+
+// loader batches lookups by key. Resolvers call load, which only queues the
+// key and returns a thunk. The executor calls thunks after it has resolved
+// every sibling field, so the first thunk fetches all queued keys at once.
+type loader[K comparable, V any] struct {
+	mu      sync.Mutex
+	fetch   func(keys []K) (map[K]V, error)
+	pending []K
+	cache   map[K]V
+}
+
+func newLoader[K comparable, V any](fetch func(keys []K) (map[K]V, error)) *loader[K, V] {
+	return &loader[K, V]{fetch: fetch, cache: make(map[K]V)}
+}
+
+func (l *loader[K, V]) load(key K) func() (any, error) {
+	l.mu.Lock()
+	if _, ok := l.cache[key]; !ok {
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (any, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if len(l.pending) > 0 {
+			keys := l.pending
+			l.pending = nil
+			values, err := l.fetch(keys)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range values {
+				l.cache[k] = v
+			}
+		}
+		value, ok := l.cache[key]
+		if !ok {
+			return nil, nil
+		}
+		return value, nil
+	}
+}
+
+type loadersKey struct{}
+
+// loaders live for a single request, so cached values are never stale.
+type loaders struct {
+	companies *loader[int, model.Company]
+	nstj      *loader[string, model.Nstj]
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
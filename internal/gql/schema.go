@@ -0,0 +1,254 @@
+// Package gql serves a GraphQL view of companies, their owners and regions,
+// backed by the same services as the REST API.
+package gql
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/model"
+	"apr-backend/internal/services"
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+const (
+	MaxDepth      = 6
+	MaxComplexity = 2000
+)
+
+// Request is the body of a GraphQL request.
+type Request struct {
+	Query         string         `json:"query" binding:"required"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+type claimsKey struct{}
+
+type Schema struct {
+	schema   graphql.Schema
+	comServ  services.CompanyService
+	nstjServ services.NstjService
+}
+
+// scoped fails fields resolved by resolve for service accounts without
+// scope, other principals aren't limited by scopes
+func scoped(scope string, resolve graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		claims, _ := p.Context.Value(claimsKey{}).(client.TokenClaims)
+		if claims.HasRole(client.RoleService) && !claims.HasScope(scope) {
+			return nil, fmt.Errorf("Requires scope %s", scope)
+		}
+		return resolve(p)
+	}
+}
+
+func NewSchema(comServ services.CompanyService, nstjServ services.NstjService) (*Schema, error) {
+	s := &Schema{comServ: comServ, nstjServ: nstjServ}
+
+	nstjType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Nstj",
+		Description: "Statistical region in which companies have their seat",
+		Fields: graphql.Fields{
+			"oznaka": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"naziv":  &graphql.Field{Type: graphql.String},
+		},
+	})
+	personType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Person",
+		Description: "Owner of a company, without their JMBG",
+		Fields: graphql.Fields{
+			"name":     &graphql.Field{Type: graphql.String},
+			"lastname": &graphql.Field{Type: graphql.String},
+		},
+	})
+	companyType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Company",
+		Fields: graphql.Fields{
+			"pib":           &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"naziv":         &graphql.Field{Type: graphql.String},
+			"adresaSedista": &graphql.Field{Type: graphql.String},
+			"mesto":         &graphql.Field{Type: graphql.String},
+			"postanskiBroj": &graphql.Field{Type: graphql.String},
+			"delatnost": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return p.Source.(model.Company).Delatnost.String(), nil
+				},
+			},
+			"sediste": &graphql.Field{
+				Type: nstjType,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return loadersFrom(p.Context).nstj.load(p.Source.(model.Company).Sediste.Oznaka), nil
+				},
+			},
+			"vlasnik": &graphql.Field{Type: personType},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"me": &graphql.Field{
+				Type:        companyType,
+				Description: "Company of the logged in principal, an error for officials and services",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					claims := p.Context.Value(claimsKey{}).(client.TokenClaims)
+					if !claims.HasRole(client.RoleCompany) {
+						return nil, fmt.Errorf("Only companies and persons acting for them have a company")
+					}
+					pib, err := strconv.Atoi(claims.Subject)
+					if err != nil {
+						return nil, fmt.Errorf("Invalid principal")
+					}
+					return loadersFrom(p.Context).companies.load(pib), nil
+				},
+			},
+			"company": &graphql.Field{
+				Type: companyType,
+				Args: graphql.FieldConfigArgument{
+					"pib": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: scoped(client.ScopeCompaniesRead, func(p graphql.ResolveParams) (any, error) {
+					return loadersFrom(p.Context).companies.load(p.Args["pib"].(int)), nil
+				}),
+			},
+			"companiesByPib": &graphql.Field{
+				Type:        graphql.NewList(companyType),
+				Description: "Companies with the given PIBs, null for PIBs which don't exist",
+				Args: graphql.FieldConfigArgument{
+					"pibs": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.Int)))},
+				},
+				Resolve: scoped(client.ScopeCompaniesRead, func(p graphql.ResolveParams) (any, error) {
+					pibs := p.Args["pibs"].([]any)
+					if len(pibs) > listCost["companiesByPib"] {
+						return nil, fmt.Errorf("At most %d pibs can be requested at once", listCost["companiesByPib"])
+					}
+					thunks := make([]func() (any, error), len(pibs))
+					for i, pib := range pibs {
+						thunks[i] = loadersFrom(p.Context).companies.load(pib.(int))
+					}
+					return func() (any, error) {
+						companies := make([]any, len(thunks))
+						for i, thunk := range thunks {
+							var err error
+							if companies[i], err = thunk(); err != nil {
+								return nil, err
+							}
+						}
+						return companies, nil
+					}, nil
+				}),
+			},
+			"companies": &graphql.Field{
+				Type:        graphql.NewList(companyType),
+				Description: "Filters, sorts and paginates companies, like GET /api/company/",
+				Args: graphql.FieldConfigArgument{
+					"page":      &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+					"order":     &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "PIB"},
+					"asc":       &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: true},
+					"delatnost": &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: ""},
+					"sediste":   &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: ""},
+					"mesto":     &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: ""},
+				},
+				Resolve: scoped(client.ScopeCompaniesRead, s.resolveCompanies),
+			},
+			"nstj": &graphql.Field{
+				Type: graphql.NewList(nstjType),
+				Resolve: scoped(client.ScopeNstjRead, func(p graphql.ResolveParams) (any, error) {
+					return s.nstjServ.FindAll()
+				}),
+			},
+			"delatnosti": &graphql.Field{
+				Type: graphql.NewList(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return model.Delatnosti.List(), nil
+				},
+			},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query})
+	if err != nil {
+		return nil, fmt.Errorf("Error building GraphQL schema: %w", err)
+	}
+	s.schema = schema
+	return s, nil
+}
+
+func (s *Schema) resolveCompanies(p graphql.ResolveParams) (any, error) {
+	page := p.Args["page"].(int)
+	if page < 0 {
+		page = 0
+	}
+	companies, err := s.comServ.FindCompanies(model.CompanyFilter{
+		OrderBy:   p.Args["order"].(string),
+		Asc:       p.Args["asc"].(bool),
+		Page:      page,
+		Mesto:     p.Args["mesto"].(string),
+		Sediste:   p.Args["sediste"].(string),
+		Delatnost: p.Args["delatnost"].(string),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return companies, nil
+}
+
+func (s *Schema) newLoaders() *loaders {
+	return &loaders{
+		companies: newLoader(func(pibs []int) (map[int]model.Company, error) {
+			companies, err := s.comServ.FindMany(pibs)
+			if err != nil {
+				return nil, err
+			}
+			byPib := make(map[int]model.Company, len(companies))
+			for _, com := range companies {
+				byPib[com.PIB] = com
+			}
+			return byPib, nil
+		}),
+		// There are few regions, so they are loaded all at once
+		nstj: newLoader(func([]string) (map[string]model.Nstj, error) {
+			nstjs, err := s.nstjServ.FindAll()
+			if err != nil {
+				return nil, err
+			}
+			byOznaka := make(map[string]model.Nstj, len(nstjs))
+			for _, nstj := range nstjs {
+				byOznaka[nstj.Oznaka] = nstj
+			}
+			return byOznaka, nil
+		}),
+	}
+}
+
+// Execute runs req on behalf of the holder of the token with claims, after
+// checking it against the depth and complexity limits.
+func (s *Schema) Execute(ctx context.Context, claims client.TokenClaims, req Request) *graphql.Result {
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"})})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+	if validation := graphql.ValidateDocument(&s.schema, doc, nil); !validation.IsValid {
+		return &graphql.Result{Errors: validation.Errors}
+	}
+	if err := checkLimits(doc, MaxDepth, MaxComplexity); err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+
+	ctx = context.WithValue(ctx, claimsKey{}, claims)
+	ctx = context.WithValue(ctx, loadersKey{}, s.newLoaders())
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        s.schema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       ctx,
+	})
+}
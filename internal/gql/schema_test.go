@@ -0,0 +1,99 @@
+package gql
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/model"
+	"apr-backend/internal/services"
+	"context"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+type meCompanies struct {
+	services.CompanyService
+}
+
+func (meCompanies) FindMany(pibs []int) ([]model.Company, error) {
+	companies := []model.Company{}
+	for _, pib := range pibs {
+		companies = append(companies, model.Company{PIB: pib, Naziv: "Firma"})
+	}
+	return companies, nil
+}
+
+func TestMe(t *testing.T) {
+	schema, err := NewSchema(meCompanies{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		subject string
+		roles   []string
+		wantPib int
+	}{
+		{"company", "100000001", []string{client.RoleCompany}, 100000001},
+		// An official's subject is a JMBG, which mustn't be read as a PIB
+		{"official", "0101990710006", []string{client.RoleAdmin}, 0},
+		{"service", "100000002", []string{client.RoleService}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := client.TokenClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: tt.subject}, Roles: tt.roles}
+			res := schema.Execute(context.Background(), claims, Request{Query: "{ me { pib } }"})
+			me, _ := res.Data.(map[string]any)["me"].(map[string]any)
+			if tt.wantPib == 0 {
+				if me != nil || len(res.Errors) == 0 {
+					t.Errorf("got %v with errors %v, want an error", me, res.Errors)
+				}
+				return
+			}
+			if len(res.Errors) > 0 {
+				t.Fatal(res.Errors)
+			}
+			if me["pib"] != tt.wantPib {
+				t.Errorf("got pib %v, want %d", me["pib"], tt.wantPib)
+			}
+		})
+	}
+}
+
+type scopedNstj struct {
+	services.NstjService
+}
+
+func (scopedNstj) FindAll() ([]model.Nstj, error) {
+	return []model.Nstj{{Oznaka: "RS11", Naziv: "Beograd"}}, nil
+}
+
+func TestScopes(t *testing.T) {
+	schema, err := NewSchema(meCompanies{}, scopedNstj{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		roles   []string
+		scope   string
+		query   string
+		wantErr bool
+	}{
+		{"nstj with nstj:read", []string{client.RoleService}, client.ScopeNstjRead, "{ nstj { oznaka } }", false},
+		// companies:read doesn't cover regions
+		{"nstj with companies:read", []string{client.RoleService}, client.ScopeCompaniesRead, "{ nstj { oznaka } }", true},
+		{"company without companies:read", []string{client.RoleService}, client.ScopeNstjRead, "{ company(pib: 100000001) { pib } }", true},
+		{"company with companies:read", []string{client.RoleService}, client.ScopeCompaniesRead, "{ company(pib: 100000001) { pib } }", false},
+		{"delatnosti without scopes", []string{client.RoleService}, "", "{ delatnosti }", false},
+		{"company for a company", []string{client.RoleCompany}, "", "{ company(pib: 100000001) { pib } }", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := client.TokenClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "100000002"}, Roles: tt.roles, Scope: tt.scope}
+			res := schema.Execute(context.Background(), claims, Request{Query: tt.query})
+			if gotErr := len(res.Errors) > 0; gotErr != tt.wantErr {
+				t.Errorf("got errors %v, want errors: %t", res.Errors, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+// Package lockout protects logins against brute forcing, by delaying and
+// eventually locking out PIBs, persons and IP addresses with many failed
+// attempts.
+package lockout
+
+import (
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+var ErrTooManyAttempts = errors.New("Too many failed login attempts")
+var ErrUnknownKind = errors.New("Lockouts are of kind pib, person or ip")
+
+// RetryError is returned for attempts which aren't allowed yet
+type RetryError struct {
+	RetryAfter time.Duration
+	// True for lockouts, false for progressive delays
+	Locked bool
+}
+
+func (e RetryError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("%s, locked for %s", ErrTooManyAttempts.Error(), e.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("%s, retry in %s", ErrTooManyAttempts.Error(), e.RetryAfter.Round(time.Second))
+}
+
+func (e RetryError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+// Store keeps failed attempts by key. Replicas have to share the store for
+// limits to apply across them.
+type Store interface {
+	Get(key string) (model.LoginAttempts, error)
+	// Passes the attempts of key to check and, unless it returns an error,
+	// counts a failure. Both happen atomically, so concurrent attempts can't
+	// all pass the check. Failures older than window are forgotten first.
+	Attempt(key string, window time.Duration, check func(model.LoginAttempts) error) error
+	// Takes back a failure counted by Attempt
+	Release(key string) error
+	// Locks key until until, false if it already was locked
+	Lock(key string, until time.Time) (bool, error)
+	Reset(key string) error
+}
+
+// Policy for one kind of key
+type Policy struct {
+	// Failures after which each attempt has to wait, doubling every time
+	DelayAfter int
+	FirstDelay time.Duration
+	MaxDelay   time.Duration
+	// Failures after which the key is locked out for LockFor
+	LockAfter int
+	LockFor   time.Duration
+	// How long failures are remembered
+	Window time.Duration
+}
+
+var (
+	// Accounts are attacked by guessing their password
+	DefaultPibPolicy = Policy{DelayAfter: 3, FirstDelay: time.Second, MaxDelay: time.Minute, LockAfter: 10, LockFor: 15 * time.Minute, Window: 15 * time.Minute}
+	// One address may be a whole office behind NAT
+	DefaultIpPolicy = Policy{DelayAfter: 10, FirstDelay: time.Second, MaxDelay: time.Minute, LockAfter: 50, LockFor: 15 * time.Minute, Window: 15 * time.Minute}
+)
+
+func (p Policy) delay(failures int) time.Duration {
+	if failures < p.DelayAfter {
+		return 0
+	}
+	delay := p.FirstDelay
+	for i := p.DelayAfter; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// Guard applies policies to login attempts by PIB and by IP address
+type Guard struct {
+	store     Store
+	lockRepo  db.LockoutRepository
+	pibPolicy Policy
+	ipPolicy  Policy
+}
+
+func NewGuard(store Store, lockRepo db.LockoutRepository, pibPolicy, ipPolicy Policy) Guard {
+	return Guard{store: store, lockRepo: lockRepo, pibPolicy: pibPolicy, ipPolicy: ipPolicy}
+}
+
+type subject struct {
+	kind   string
+	value  string
+	policy Policy
+}
+
+func (s subject) key() string {
+	return s.kind + ":" + s.value
+}
+
+func (g Guard) pibSubject(pib int) subject {
+	return subject{kind: model.LockoutPib, value: strconv.Itoa(pib), policy: g.pibPolicy}
+}
+
+// Person accounts are guessed the same way company accounts are
+func (g Guard) personSubject(jmbg string) subject {
+	return subject{kind: model.LockoutPerson, value: jmbg, policy: g.pibPolicy}
+}
+
+func (g Guard) subjects(account subject, ip string) []subject {
+	subjects := []subject{account}
+	if ip != "" {
+		subjects = append(subjects, subject{kind: model.LockoutIp, value: ip, policy: g.ipPolicy})
+	}
+	return subjects
+}
+
+// Attempt starts a login for pib from ip, or returns a RetryError if it
+// isn't allowed yet. The attempt counts as failed until Passed is called,
+// so that concurrent attempts are limited as well.
+func (g Guard) Attempt(pib int, ip string) (Attempt, error) {
+	return g.attempt(g.subjects(g.pibSubject(pib), ip))
+}
+
+// AttemptPerson is Attempt for the account of the person with jmbg
+func (g Guard) AttemptPerson(jmbg string, ip string) (Attempt, error) {
+	return g.attempt(g.subjects(g.personSubject(jmbg), ip))
+}
+
+func (g Guard) attempt(subjects []subject) (Attempt, error) {
+	a := Attempt{guard: g}
+	for _, s := range subjects {
+		if err := g.store.Attempt(s.key(), s.policy.Window, s.policy.check); err != nil {
+			// Refused attempts don't count
+			if err := a.Passed(); err != nil {
+				log.Printf("Error releasing login attempt: %s", err.Error())
+			}
+			return Attempt{}, err
+		}
+		a.subjects = append(a.subjects, s)
+	}
+	return a, nil
+}
+
+// check returns a RetryError if an attempt after attempts isn't allowed yet
+func (p Policy) check(attempts model.LoginAttempts) error {
+	now := time.Now()
+	if now.Before(attempts.LockedUntil) {
+		return RetryError{RetryAfter: attempts.LockedUntil.Sub(now), Locked: true}
+	}
+	if now.Sub(attempts.LastFailure) > p.Window {
+		return nil
+	}
+	next := attempts.LastFailure.Add(p.delay(attempts.Failures))
+	if now.Before(next) {
+		return RetryError{RetryAfter: next.Sub(now)}
+	}
+	return nil
+}
+
+// Attempt is a login attempt allowed by Guard
+type Attempt struct {
+	guard    Guard
+	subjects []subject
+}
+
+// Passed takes back the failure counted for the attempt, because the
+// credentials were right or couldn't be checked
+func (a Attempt) Passed() error {
+	for _, s := range a.subjects {
+		if err := a.guard.store.Release(s.key()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Failed locks out the account or address of the attempt once they reach
+// their limit
+func (a Attempt) Failed() error {
+	for _, s := range a.subjects {
+		attempts, err := a.guard.store.Get(s.key())
+		if err != nil {
+			return err
+		}
+		if attempts.Failures < s.policy.LockAfter {
+			continue
+		}
+		now := time.Now().UTC()
+		until := now.Add(s.policy.LockFor)
+		// Concurrent failures record the lockout once
+		locked, err := a.guard.store.Lock(s.key(), until)
+		if err != nil || !locked {
+			return err
+		}
+		log.Printf("Locked out %s %s after %d failed logins", s.kind, s.value, attempts.Failures)
+		err = a.guard.lockRepo.Save(&model.Lockout{
+			Kind:        s.kind,
+			Subject:     s.value,
+			Failures:    attempts.Failures,
+			LockedAt:    now,
+			LockedUntil: until,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Succeeded forgets the failures of pib. Failures of the address are kept,
+// logging into an own account mustn't clear them.
+func (g Guard) Succeeded(pib int) error {
+	return g.store.Reset(g.pibSubject(pib).key())
+}
+
+// SucceededPerson is Succeeded for the account of the person with jmbg
+func (g Guard) SucceededPerson(jmbg string) error {
+	return g.store.Reset(g.personSubject(jmbg).key())
+}
+
+// Unlock lifts the lockout of a PIB, person or address and forgets its
+// failures. kind is one of model.LockoutPib, LockoutPerson and LockoutIp.
+func (g Guard) Unlock(kind, value, by string) error {
+	switch kind {
+	case model.LockoutPib, model.LockoutPerson, model.LockoutIp:
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownKind, kind)
+	}
+	if err := g.store.Reset(subject{kind: kind, value: value}.key()); err != nil {
+		return err
+	}
+	return g.lockRepo.Unlock(kind, value, by)
+}
+
+// Lockouts returns lockouts which are still in effect
+func (g Guard) Lockouts() ([]model.Lockout, error) {
+	return g.lockRepo.FindActive()
+}
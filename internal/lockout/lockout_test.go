@@ -0,0 +1,232 @@
+package lockout
+
+import (
+	"apr-backend/internal/model"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockoutLog remembers recorded lockouts
+type lockoutLog struct {
+	mu       sync.Mutex
+	lockouts []model.Lockout
+	unlocked []string
+}
+
+func (ll *lockoutLog) Save(lockout *model.Lockout) error {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+	ll.lockouts = append(ll.lockouts, *lockout)
+	return nil
+}
+
+func (ll *lockoutLog) FindActive() ([]model.Lockout, error) {
+	return ll.lockouts, nil
+}
+
+func (ll *lockoutLog) Unlock(kind, subject, by string) error {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+	ll.unlocked = append(ll.unlocked, kind+":"+subject)
+	return nil
+}
+
+var (
+	testPibPolicy = Policy{DelayAfter: 2, FirstDelay: time.Hour, MaxDelay: 4 * time.Hour, LockAfter: 4, LockFor: 24 * time.Hour, Window: 24 * time.Hour}
+	testIpPolicy  = Policy{DelayAfter: 6, FirstDelay: time.Hour, MaxDelay: 4 * time.Hour, LockAfter: 8, LockFor: 24 * time.Hour, Window: 24 * time.Hour}
+)
+
+// fail counts a failed attempt of account from ip, skipping the delays
+func fail(t *testing.T, g Guard, account subject, ip string) {
+	t.Helper()
+	a := Attempt{guard: g}
+	for _, s := range g.subjects(account, ip) {
+		if err := g.store.Attempt(s.key(), s.policy.Window, func(model.LoginAttempts) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		a.subjects = append(a.subjects, s)
+	}
+	if err := a.Failed(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// allowed returns whether an attempt of pib from ip is allowed, taking it
+// back if so
+func allowed(t *testing.T, g Guard, pib int, ip string) (bool, RetryError) {
+	t.Helper()
+	a, err := g.Attempt(pib, ip)
+	var retry RetryError
+	if errors.As(err, &retry) {
+		if !errors.Is(err, ErrTooManyAttempts) {
+			t.Errorf("%v isn't ErrTooManyAttempts", err)
+		}
+		return false, retry
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Passed(); err != nil {
+		t.Fatal(err)
+	}
+	return true, retry
+}
+
+func TestGuard(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		// Failures from other PIBs at the same address
+		otherFailures int
+		succeeded     bool
+		wantDelayed   bool
+		wantLocked    bool
+		wantLockouts  int
+	}{
+		{"no failures", 0, 0, false, false, false, 0},
+		{"below the delay", 1, 0, false, false, false, 0},
+		{"delayed", 2, 0, false, true, false, 0},
+		{"locked", 4, 0, false, true, true, 1},
+		{"succeeded", 3, 0, true, false, false, 0},
+		{"address delayed", 0, 6, false, true, false, 0},
+		{"address locked", 1, 7, false, true, true, 1},
+		// Logging into an own account doesn't clear the address
+		{"address kept after success", 1, 6, true, true, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lockouts := &lockoutLog{}
+			guard := NewGuard(NewMemoryStore(), lockouts, testPibPolicy, testIpPolicy)
+			for i := 0; i < tt.failures; i++ {
+				fail(t, guard, guard.pibSubject(1), "10.0.0.1")
+			}
+			for i := 0; i < tt.otherFailures; i++ {
+				fail(t, guard, guard.pibSubject(100+i), "10.0.0.1")
+			}
+			if tt.succeeded {
+				if err := guard.Succeeded(1); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ok, retry := allowed(t, guard, 1, "10.0.0.1")
+			if ok == tt.wantDelayed || retry.Locked != tt.wantLocked {
+				t.Errorf("got %v, want delayed %v and locked %v", retry, tt.wantDelayed, tt.wantLocked)
+			}
+			if len(lockouts.lockouts) != tt.wantLockouts {
+				t.Errorf("recorded %d lockouts, want %d", len(lockouts.lockouts), tt.wantLockouts)
+			}
+
+			// Another address isn't held up by the failures of this one
+			if tt.failures == 0 {
+				if ok, retry := allowed(t, guard, 1, "10.0.0.2"); !ok {
+					t.Errorf("other address: %v", retry)
+				}
+			}
+		})
+	}
+}
+
+func TestGuardConcurrent(t *testing.T) {
+	lockouts := &lockoutLog{}
+	guard := NewGuard(NewMemoryStore(), lockouts, testPibPolicy, testIpPolicy)
+	// Parallel guesses all start before any of them fails
+	const guesses = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var attempts []Attempt
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if a, err := guard.Attempt(1, "10.0.0.1"); err == nil {
+				mu.Lock()
+				attempts = append(attempts, a)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(attempts) != testPibPolicy.DelayAfter {
+		t.Fatalf("%d of %d parallel attempts were allowed, want %d", len(attempts), guesses, testPibPolicy.DelayAfter)
+	}
+	for _, a := range attempts {
+		if err := a.Failed(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAttemptPassed(t *testing.T) {
+	guard := NewGuard(NewMemoryStore(), &lockoutLog{}, testPibPolicy, testIpPolicy)
+	// Right passwords don't count, however many there are
+	for i := 0; i < 2*testIpPolicy.LockAfter; i++ {
+		if ok, retry := allowed(t, guard, 100+i, "10.0.0.1"); !ok {
+			t.Fatalf("attempt %d: %v", i, retry)
+		}
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		value   string
+		account subject
+		ip      string
+	}{
+		{"pib", model.LockoutPib, "1", subject{kind: model.LockoutPib, value: "1", policy: testPibPolicy}, ""},
+		{"person", model.LockoutPerson, "0101990710006", subject{kind: model.LockoutPerson, value: "0101990710006", policy: testPibPolicy}, ""},
+		// Accounts at the address stay unlocked
+		{"address", model.LockoutIp, "10.0.0.1", subject{kind: model.LockoutPib, value: "1", policy: Policy{LockAfter: 100}}, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lockouts := &lockoutLog{}
+			guard := NewGuard(NewMemoryStore(), lockouts, testPibPolicy, testIpPolicy)
+			for i := 0; i < testIpPolicy.LockAfter; i++ {
+				fail(t, guard, tt.account, tt.ip)
+			}
+			key := tt.kind + ":" + tt.value
+			if attempts, _ := guard.store.Get(key); !time.Now().Before(attempts.LockedUntil) {
+				t.Fatalf("%s isn't locked", key)
+			}
+
+			if err := guard.Unlock(tt.kind, tt.value, "admin"); err != nil {
+				t.Fatal(err)
+			}
+			if attempts, _ := guard.store.Get(key); attempts.Failures != 0 || !attempts.LockedUntil.IsZero() {
+				t.Errorf("%s still has %+v", key, attempts)
+			}
+			if len(lockouts.unlocked) != 1 || lockouts.unlocked[0] != key {
+				t.Errorf("lifted %v, want %s", lockouts.unlocked, key)
+			}
+		})
+	}
+
+	guard := NewGuard(NewMemoryStore(), &lockoutLog{}, testPibPolicy, testIpPolicy)
+	if err := guard.Unlock("company", "1", "admin"); !errors.Is(err, ErrUnknownKind) {
+		t.Errorf("got %v, want %v", err, ErrUnknownKind)
+	}
+}
+
+func TestPolicyDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{2, time.Hour},
+		{3, 2 * time.Hour},
+		{4, 4 * time.Hour},
+		{10, 4 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := testPibPolicy.delay(tt.failures); got != tt.want {
+			t.Errorf("delay after %d failures = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
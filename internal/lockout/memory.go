@@ -0,0 +1,86 @@
+package lockout
+
+import (
+	"apr-backend/internal/model"
+	"sync"
+	"time"
+)
+
+// Above this many keys, forgotten ones are swept on the next attempt
+const sweepSize = 10000
+
+// NewMemoryStore keeps attempts in process, for a single replica
+func NewMemoryStore() Store {
+	return &memoryStore{attempts: make(map[string]model.LoginAttempts)}
+}
+
+type memoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]model.LoginAttempts
+}
+
+// Get implements Store
+func (ms *memoryStore) Get(key string) (model.LoginAttempts, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.attempts[key], nil
+}
+
+// Attempt implements Store
+func (ms *memoryStore) Attempt(key string, window time.Duration, check func(model.LoginAttempts) error) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	now := time.Now()
+	if len(ms.attempts) >= sweepSize {
+		for k, a := range ms.attempts {
+			if now.Sub(a.LastFailure) > window && now.After(a.LockedUntil) {
+				delete(ms.attempts, k)
+			}
+		}
+	}
+
+	attempts := ms.attempts[key]
+	if now.Sub(attempts.LastFailure) > window {
+		attempts.Failures = 0
+	}
+	if err := check(attempts); err != nil {
+		return err
+	}
+	attempts.Failures++
+	attempts.LastFailure = now
+	ms.attempts[key] = attempts
+	return nil
+}
+
+// Release implements Store
+func (ms *memoryStore) Release(key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	attempts, ok := ms.attempts[key]
+	if ok && attempts.Failures > 0 {
+		attempts.Failures--
+		ms.attempts[key] = attempts
+	}
+	return nil
+}
+
+// Lock implements Store
+func (ms *memoryStore) Lock(key string, until time.Time) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	attempts := ms.attempts[key]
+	if time.Now().Before(attempts.LockedUntil) {
+		return false, nil
+	}
+	attempts.LockedUntil = until
+	ms.attempts[key] = attempts
+	return true, nil
+}
+
+// Reset implements Store
+func (ms *memoryStore) Reset(key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.attempts, key)
+	return nil
+}
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type Credentials struct {
 	Username     string
 	PasswordHash []byte
@@ -15,3 +17,87 @@ type CredentialsDto struct {
 	// Required: true
 	Password string `json:"password"`
 }
+
+// Tokens issued on login
+//
+// The access token is short lived. The refresh token is exchanged for a new
+// pair before it expires and can only be used once.
+// swagger:model tokenPair
+type TokenPair struct {
+	// Access token, a JWT
+	Jwt          string `json:"jwt"`
+	RefreshToken string `json:"refreshToken"`
+	// Seconds until the access token expires
+	// Example: 900
+	ExpiresIn int `json:"expiresIn"`
+}
+
+// swagger:model refreshRequest
+type RefreshRequest struct {
+	// Required: true
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// swagger:model logoutRequest
+type LogoutRequest struct {
+	// Refresh token of the session to end, every token rotated from it is
+	// revoked too
+	RefreshToken string `json:"refreshToken"`
+}
+
+// RefreshToken is a refresh token as it is stored. Tokens rotated from one
+// login share a family.
+type RefreshToken struct {
+	// SHA-256 of the token, the token itself is never stored
+	Hash   string
+	Family string
+	// SessionCompany or SessionOfficial
+	Kind string
+	// Company of the session, 0 for sessions of officials
+	PIB int
+	// JMBG of the person acting for the company or of the official, empty
+	// for company logins
+	Jmbg string
+	// Whether the session was started with a second factor
+	Mfa bool
+	// OAuth client the session was authorized for and its scopes, empty
+	// for APR's own logins
+	ClientId  string
+	Scope     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Kinds of sessions
+const (
+	SessionCompany  = "company"
+	SessionOfficial = "official"
+)
+
+// swagger:model changePasswordRequest
+type ChangePasswordRequest struct {
+	// Required: true
+	OldPassword string `json:"oldPassword" binding:"required"`
+	// Required: true
+	// Minimum length: 12
+	// Maximum length: 72
+	NewPassword string `json:"newPassword" binding:"required"`
+}
+
+// swagger:model passwordResetRequest
+type PasswordResetRequest struct {
+	// Required: true
+	// Example: 15
+	PIB int `json:"pib" binding:"required"`
+}
+
+// swagger:model passwordResetConfirmation
+type PasswordResetConfirmation struct {
+	// Token delivered to the company
+	// Required: true
+	Token string `json:"token" binding:"required"`
+	// Required: true
+	// Minimum length: 12
+	// Maximum length: 72
+	NewPassword string `json:"newPassword" binding:"required"`
+}
@@ -19,9 +19,9 @@ var CompanyErrors = map[string]string{
 // It must have a physical place where its headquarters are, denoted by fields Mesto, PostanskiBroj and  Sediste.
 // swagger:model company
 type Company struct {
-	//JMBG of the person who is the owner of the company
-	//Example: 1234567891234
-	//Read Only: true
+	// Person who is the owner of the company. Their JMBG is only given on
+	// registration, it is never served.
+	// Read Only: true
 	Vlasnik Person `json:"vlasnik,omitempty"`
 	// Unique number which identifies the company for taxes.
 	// Required: true
@@ -48,7 +48,7 @@ type Company struct {
 	Mesto string `json:"mesto" binding:"min=1,max=100"`
 	// Area code of this company's address.
 	// Required: true
-	// Pattern: ^\d{,20}$
+	// Pattern: ^\d{1,20}$
 	// Example: 21000
 	PostanskiBroj string `json:"postanskiBroj" binding:"required,number,max=20"`
 	// Required: true
@@ -65,6 +65,15 @@ type Company struct {
 	Likvidirana bool `json:"likvidirana"`
 }
 
+// Masked returns a copy of the company with fields that must never leave the
+// service cleared. Everything served publicly goes through it. The owner's
+// JMBG would let anyone knowing their name register as them.
+func (com Company) Masked() Company {
+	com.Password = ""
+	com.Vlasnik.Jmbg = ""
+	return com
+}
+
 // swagger:model nstj
 type Nstj struct {
 	//Example: RS123
@@ -0,0 +1,70 @@
+package model
+
+import "time"
+
+const (
+	EventCompanyRegistered = "company.registered"
+	// The company or its password changed
+	EventCompanyChanged    = "company.changed"
+	EventCompanyLiquidated = "company.liquidated"
+)
+
+// Registry event
+//
+// Event is a change to the registry which other services can react to.
+// swagger:model event
+type Event struct {
+	// Increasing identifier, used to resume a stream
+	Id int64 `json:"id"`
+	// Example: company.registered
+	Type string    `json:"type"`
+	PIB  int       `json:"pib"`
+	Time time.Time `json:"time"`
+	// Company after the change, not set for liquidations
+	Company *Company `json:"company,omitempty"`
+}
+
+// EventFilter selects events by type and company. Zero values match all
+// events.
+type EventFilter struct {
+	Types []string
+	PIB   int
+}
+
+func (filter EventFilter) Matches(event Event) bool {
+	if filter.PIB != 0 && filter.PIB != event.PIB {
+		return false
+	}
+	if len(filter.Types) == 0 {
+		return true
+	}
+	for _, t := range filter.Types {
+		if t == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// swagger:model consumerStatus
+type ConsumerStatus struct {
+	Name string `json:"name"`
+	// Durable consumers keep their position in the database
+	Durable bool `json:"durable"`
+	// Id of the last event the consumer received
+	Position int64 `json:"position"`
+	// Number of events the consumer hasn't received yet
+	Lag int64 `json:"lag"`
+	// When the oldest event the consumer hasn't received was written
+	OldestPending *time.Time `json:"oldestPending,omitempty"`
+}
+
+// Relay status
+//
+// RelayStatus shows how far behind the outbox each event consumer is.
+// swagger:model relayStatus
+type RelayStatus struct {
+	// Id of the newest event in the outbox
+	LastId    int64            `json:"lastId"`
+	Consumers []ConsumerStatus `json:"consumers"`
+}
@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// Extract is a registry extract (izvod) as it was issued. The company is
+// stored exactly as it was printed, so that the extract can be verified
+// later on.
+type Extract struct {
+	Code     string    `json:"code"`
+	IssuedAt time.Time `json:"issuedAt"`
+	Company  Company   `json:"company"`
+}
+
+// Verified registry extract
+//
+// ExtractVerification is returned to anyone holding the code printed on an
+// extract. It contains the data the extract was issued with.
+// swagger:model extractVerification
+type ExtractVerification struct {
+	// Example: 7QK2M4XHZR9DWB3N
+	Code     string    `json:"code"`
+	IssuedAt time.Time `json:"issuedAt"`
+	Company  Company   `json:"company"`
+	// False if the company has changed since the extract was issued, in
+	// which case the extract is no longer an accurate record.
+	Current bool `json:"current"`
+}
@@ -0,0 +1,72 @@
+package model
+
+import "time"
+
+const (
+	// Validates and checks every row against the database, saves nothing
+	ImportDryRun = "dry-run"
+	// Saves every row or, if any row fails, none of them
+	ImportAtomic = "atomic"
+	// Commits rows in chunks and records progress, so an interrupted import
+	// can be resumed
+	ImportChunked = "chunked"
+)
+
+// ImportRow is a single parsed row of an import file. Errors holds binding
+// validation errors found while parsing, keyed by field like CompanyErrors.
+type ImportRow struct {
+	Row     int
+	Company Company
+	Errors  map[string]string
+}
+
+// Import job
+//
+// ImportJob tracks the progress of an atomic or chunked import, which runs
+// in the background.
+// swagger:model importJob
+type ImportJob struct {
+	Id int `json:"id"`
+	// atomic or chunked
+	// Example: chunked
+	Mode string `json:"mode"`
+	// SHA-256 of the imported file, a job can only be resumed with the same file
+	Checksum string `json:"checksum"`
+	// Number of data rows in the file
+	Total int `json:"total"`
+	// Number of rows which have been processed and committed
+	RowsDone int `json:"rowsDone"`
+	// Number of companies which have been saved
+	Imported int `json:"imported"`
+	// Set once every row was processed. Atomic jobs with failed rows are
+	// finished without importing anything.
+	Finished bool `json:"finished"`
+	// Why the job stopped or imported nothing. Chunked jobs which aren't
+	// finished can be resumed.
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Rows which couldn't be imported so far
+	Errors []ImportRowError `json:"errors"`
+}
+
+// swagger:model importRowError
+type ImportRowError struct {
+	// Line of the file, the header is line 1
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+// Import report
+//
+// ImportReport lists every row which couldn't be imported and why. Atomic
+// and chunked imports only return their job, which reports the rows when
+// it finishes.
+// swagger:model importReport
+type ImportReport struct {
+	Mode string `json:"mode"`
+	// Set for atomic and chunked imports
+	Job      *ImportJob       `json:"job,omitempty"`
+	Rows     int              `json:"rows"`
+	Imported int              `json:"imported"`
+	Errors   []ImportRowError `json:"errors"`
+}
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// SigningKey is a JWT signing key as it is stored, encrypted.
+type SigningKey struct {
+	Kid       string
+	Encrypted []byte
+	// PKIX public key, nil for keys stored before it was kept
+	Public    []byte
+	CreatedAt time.Time
+	// Rotated keys are published before they sign, zero for keys which
+	// sign as soon as they are stored
+	ActivatesAt time.Time
+	// Zero for the newest key. Retired keys verify until they expire.
+	ExpiresAt time.Time
+}
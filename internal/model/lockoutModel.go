@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+// LoginAttempts tracks failed logins of one PIB, person or IP address
+type LoginAttempts struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// Lockout of an account or address
+//
+// Lockout is recorded whenever too many failed logins lock out a PIB, a
+// person or an IP address.
+// swagger:model lockout
+type Lockout struct {
+	Id int `json:"id"`
+	// One of pib, person or ip
+	// Example: pib
+	Kind string `json:"kind"`
+	// The PIB, JMBG or IP address
+	// Example: 15
+	Subject     string    `json:"subject"`
+	Failures    int       `json:"failures"`
+	LockedAt    time.Time `json:"lockedAt"`
+	LockedUntil time.Time `json:"lockedUntil"`
+	// Set if an admin lifted the lockout early
+	UnlockedAt *time.Time `json:"unlockedAt,omitempty"`
+	UnlockedBy string     `json:"unlockedBy,omitempty"`
+}
+
+const (
+	LockoutPib    = "pib"
+	LockoutPerson = "person"
+	LockoutIp     = "ip"
+)
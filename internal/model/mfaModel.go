@@ -0,0 +1,115 @@
+package model
+
+import "time"
+
+// Operations for which admins can require a second factor
+const (
+	// Liquidating the company through DELETE /api/company/:pib
+	MfaLiquidate       = "liquidate"
+	MfaChangePassword  = "changePassword"
+	MfaRepresentatives = "representatives"
+)
+
+// Totp is the TOTP secret of a company as it is stored. It is used for
+// logins once it is confirmed.
+type Totp struct {
+	PIB    int
+	Secret string
+	// Nil until the first code is confirmed
+	ConfirmedAt *time.Time
+	// Time step of the last accepted code, codes can't be used twice
+	LastStep  int64
+	CreatedAt time.Time
+}
+
+// Second factor of a company
+//
+// swagger:model mfaStatus
+type MfaStatus struct {
+	// Whether logins need a TOTP code
+	Enrolled    bool       `json:"enrolled"`
+	ConfirmedAt *time.Time `json:"confirmedAt,omitempty"`
+	// Recovery codes which weren't used yet
+	RecoveryCodesLeft int `json:"recoveryCodesLeft"`
+}
+
+// TOTP enrolment
+//
+// The secret is shown once. Authenticator apps are set up by scanning the
+// QR code or entering the secret, the first code they show confirms the
+// enrolment.
+// swagger:model totpEnrollment
+type TotpEnrollment struct {
+	// Base32 encoded secret
+	// Example: JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP
+	Secret string `json:"secret"`
+	// otpauth URI of the secret
+	// Example: otpauth://totp/APR:100000001?algorithm=SHA1&digits=6&issuer=APR&period=30&secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP
+	Uri string `json:"uri"`
+	// Base64 encoded PNG of the URI as a QR code
+	// swagger:strfmt byte
+	QRCode []byte `json:"qrCode"`
+}
+
+// swagger:model mfaCodeRequest
+type MfaCodeRequest struct {
+	// Code of the authenticator app, or a recovery code where one is
+	// accepted
+	// Required: true
+	// Example: 492039
+	Code string `json:"code" binding:"required"`
+}
+
+// Recovery codes
+//
+// Each code can be used once instead of a TOTP code, for example when the
+// device with the authenticator app is lost. They are only shown when they
+// are generated, earlier codes stop working then.
+// swagger:model recoveryCodes
+type RecoveryCodes struct {
+	// Example: ["k3m9-x2pq","7hvd-ca4n"]
+	Codes []string `json:"codes"`
+}
+
+// Second factor challenge
+//
+// Returned by login when the company has a second factor. The challenge is
+// completed with a code at /api/auth/login/mfa.
+// swagger:model mfaChallenge
+type MfaChallenge struct {
+	// Single use token of the challenge
+	Challenge string `json:"challenge"`
+	// Second factors which complete it
+	// Example: ["totp","recovery_code"]
+	Methods []string `json:"methods"`
+	// Seconds until the challenge expires
+	// Example: 300
+	ExpiresIn int `json:"expiresIn"`
+}
+
+// swagger:model mfaLoginRequest
+type MfaLoginRequest struct {
+	// Required: true
+	Challenge string `json:"challenge" binding:"required"`
+	// TOTP or recovery code
+	// Required: true
+	// Example: 492039
+	Code string `json:"code" binding:"required"`
+}
+
+// Second factor policy
+//
+// Whether an operation needs a token from a login with a second factor.
+// Companies without one can't do the operation until they enrol.
+// swagger:model mfaPolicy
+type MfaPolicy struct {
+	// liquidate, changePassword or representatives
+	// Read Only: true
+	// Example: liquidate
+	Operation string `json:"operation"`
+	// Required: true
+	Required  bool       `json:"required"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	// Principal of the admin who last changed it
+	UpdatedBy string `json:"updatedBy,omitempty"`
+}
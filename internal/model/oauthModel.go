@@ -0,0 +1,154 @@
+package model
+
+import "time"
+
+// OAuth client application
+//
+// Client applications sign companies in with the OpenID Connect
+// authorization code flow. They are public clients, so PKCE is required
+// instead of a secret.
+// swagger:model oauthClient
+type OAuthClient struct {
+	// Required: true
+	// Example: registry-portal
+	ClientId string `json:"clientId" binding:"required,max=64"`
+	// Shown on the login page
+	// Required: true
+	// Example: Registry portal
+	Name string `json:"name" binding:"required,max=100"`
+	// Redirect URIs the client may use, they have to match exactly
+	// Required: true
+	// Example: ["http://localhost:4200/callback"]
+	RedirectUris []string  `json:"redirectUris" binding:"required,min=1"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// AuthorizationRequest holds the parameters of the authorization endpoint
+type AuthorizationRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientId            string `form:"client_id"`
+	RedirectUri         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	Nonce               string `form:"nonce"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+	Prompt              string `form:"prompt"`
+}
+
+// AuthorizationCode is an authorization code as it is stored
+type AuthorizationCode struct {
+	// SHA-256 of the code, the code itself is never stored
+	Hash          string
+	ClientId      string
+	RedirectUri   string
+	PIB           int
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+	AuthTime      time.Time
+	// Whether the SSO session was started with a second factor
+	Mfa       bool
+	ExpiresAt time.Time
+	// Tokens issued for the code
+	Tokens ClientTokens
+}
+
+// SsoSession is the login of a company at the authorization endpoint
+type SsoSession struct {
+	PIB      int
+	AuthTime time.Time
+	// Whether the company logged in with a second factor
+	Mfa bool
+}
+
+// ClientTokens identify the tokens issued for an authorization code, which
+// are revoked if the code is used again
+type ClientTokens struct {
+	// Family of the refresh token, empty without offline_access
+	Family string
+	// jti of the access token
+	Jti       string
+	ExpiresAt time.Time
+}
+
+// TokenRequest holds the parameters of the token endpoint
+type TokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectUri  string `form:"redirect_uri"`
+	ClientId     string `form:"client_id"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	// Client authentication of service accounts, the secret may also come
+	// with HTTP Basic
+	ClientSecret        string `form:"client_secret"`
+	ClientAssertionType string `form:"client_assertion_type"`
+	ClientAssertion     string `form:"client_assertion"`
+	Scope               string `form:"scope"`
+}
+
+// Token response
+//
+// swagger:model oidcTokenResponse
+type OidcTokenResponse struct {
+	// Same as the jwt of tokenPair
+	AccessToken string `json:"access_token"`
+	// Example: Bearer
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// Only for the authorization code grant with the openid scope
+	IdToken string `json:"id_token,omitempty"`
+	Scope   string `json:"scope,omitempty"`
+}
+
+// OAuth error
+//
+// Error of the OAuth endpoints, as in RFC 6749.
+// swagger:model oauthError
+type OAuthError struct {
+	// Example: invalid_grant
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// OpenID Provider configuration
+//
+// swagger:model oidcConfiguration
+type OidcConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksUri                           string   `json:"jwks_uri"`
+	EndSessionEndpoint                string   `json:"end_session_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// Claims about the logged-in company
+//
+// swagger:model userInfo
+type UserInfo struct {
+	// The PIB, as a string
+	// Example: 15
+	Sub string `json:"sub"`
+	// Naziv of the company
+	Name string `json:"name,omitempty"`
+	PIB  int    `json:"pib"`
+}
@@ -0,0 +1,51 @@
+package model
+
+import "time"
+
+// Snapshot of the public registry
+//
+// Snapshot is a dated, gzip compressed dump of the public registry together
+// with the diff against the snapshot taken before it.
+// swagger:model snapshot
+type Snapshot struct {
+	// Example: 2023-05-01T03-00-00Z
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	// File name of the snapshot, relative to /api/opendata/
+	// Example: 2023-05-01T03-00-00Z.snapshot.json.gz
+	File string `json:"file"`
+	// File name of the diff, relative to /api/opendata/, omitted if the
+	// snapshot has none
+	// Example: 2023-05-01T03-00-00Z.diff.json.gz
+	DiffFile string `json:"diffFile,omitempty"`
+	Size     int64  `json:"size"`
+}
+
+// SnapshotContent is the document stored inside a snapshot file.
+type SnapshotContent struct {
+	CreatedAt  time.Time   `json:"createdAt"`
+	Nstj       []Nstj      `json:"nstj"`
+	Delatnosti []Delatnost `json:"delatnosti"`
+	Companies  []Company   `json:"companies"`
+}
+
+// CompanyChange holds both versions of a company which changed between two
+// snapshots.
+type CompanyChange struct {
+	Before Company `json:"before"`
+	After  Company `json:"after"`
+}
+
+// Diff between two snapshots
+//
+// Companies which are present in the previous snapshot but missing from the
+// current one have been liquidated and are listed as struck off.
+// swagger:model snapshotDiff
+type SnapshotDiff struct {
+	// Empty for the very first snapshot
+	Previous  string          `json:"previous,omitempty"`
+	Current   string          `json:"current"`
+	Added     []Company       `json:"added"`
+	Changed   []CompanyChange `json:"changed"`
+	StruckOff []Company       `json:"struckOff"`
+}
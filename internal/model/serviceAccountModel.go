@@ -0,0 +1,63 @@
+package model
+
+import "time"
+
+// Ways service accounts authenticate at the token endpoint, as in OpenID
+// Connect
+const (
+	// client_id and client_secret, with HTTP Basic or in the form
+	AuthClientSecret = "client_secret"
+	// A JWT signed with the account's private key, RFC 7523
+	AuthPrivateKeyJwt = "private_key_jwt"
+)
+
+// Service account
+//
+// Account of another system, which gets tokens with the client credentials
+// grant of /oauth/token. Its tokens have the service role and can only be
+// used for what its scopes allow.
+// swagger:model serviceAccount
+type ServiceAccount struct {
+	// Generated when the account is created
+	// Read Only: true
+	// Example: sa-3f9c2a7d51e04b8e
+	ClientId string `json:"clientId"`
+	// Required: true
+	// Example: Tax batch export
+	Name string `json:"name" binding:"required,max=100"`
+	// Any of companies:read and nstj:read
+	// Required: true
+	// Example: ["companies:read"]
+	Scopes []string `json:"scopes" binding:"required,min=1"`
+	// client_secret or private_key_jwt
+	// Required: true
+	// Example: client_secret
+	AuthMethod string `json:"authMethod" binding:"required,oneof=client_secret private_key_jwt"`
+	// PEM encoded RSA or EC public key, required for private_key_jwt
+	PublicKey string `json:"publicKey,omitempty"`
+	// Disabled accounts get no tokens
+	// Read Only: true
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Principal of the admin who created the account
+	CreatedBy string `json:"createdBy,omitempty"`
+}
+
+// Service account credentials
+//
+// The secret is only shown when it is generated.
+// swagger:model serviceAccountCredentials
+type ServiceAccountCredentials struct {
+	Account ServiceAccount `json:"account"`
+	// Only for accounts with client_secret
+	ClientSecret string `json:"clientSecret,omitempty"`
+}
+
+// Rotation of service account credentials
+//
+// swagger:model rotateCredentialsRequest
+type RotateCredentialsRequest struct {
+	// New PEM encoded public key of a private_key_jwt account, accounts with
+	// client_secret get a new secret instead
+	PublicKey string `json:"publicKey"`
+}
@@ -0,0 +1,69 @@
+package model
+
+import "time"
+
+// Registered service
+//
+// Downstream service which SSO tokens can be issued for.
+// swagger:model registeredService
+type RegisteredService struct {
+	// Name used in /api/auth/login/{service}
+	// Required: true
+	// Example: eporezi
+	Name string `json:"name" binding:"required,max=64"`
+	// Audiences of the issued tokens
+	// Required: true
+	// Example: ["eporezi", "eporezi-api"]
+	Audiences []string `json:"audiences" binding:"required,min=1,dive,required,max=100"`
+	// How long issued tokens are valid, in seconds
+	// Required: true
+	// Minimum: 60
+	// Maximum: 86400
+	// Example: 900
+	TokenLifetime int `json:"tokenLifetime" binding:"required,min=60,max=86400"`
+	// Tokens are only issued to callers with one of these roles
+	// Required: true
+	// Example: ["company"]
+	AllowedRoles []string `json:"allowedRoles" binding:"required,min=1"`
+	// If not empty, only these companies get tokens. Officials aren't
+	// limited by it.
+	// Example: [100000001]
+	AllowedPibs []int     `json:"allowedPibs"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// Introspection credentials
+//
+// Secret a service authenticates to the introspection endpoint with, using
+// HTTP Basic with its name as the username. It is only shown once.
+// swagger:model introspectionSecret
+type IntrospectionSecret struct {
+	// Example: eporezi
+	Service string `json:"service"`
+	Secret  string `json:"secret"`
+}
+
+// Introspection response
+//
+// State of a token as in RFC 7662. Inactive tokens only have active set.
+// swagger:model introspection
+type Introspection struct {
+	Active bool `json:"active"`
+	// Example: Bearer
+	TokenType string   `json:"token_type,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       []string `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	// JMBG of the person acting for the company in sub
+	ActingPerson string `json:"acting_person,omitempty"`
+	// Naziv of the company in sub
+	CompanyName string `json:"company_name,omitempty"`
+	// active or liquidated, as the company is now
+	CompanyStatus string `json:"company_status,omitempty"`
+}
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 var UserErrors = map[string]string{
 	"Phone":    "Phone must follow the E.164 standard",
 	"Email":    "Must be a valid email",
@@ -26,5 +28,96 @@ type Person struct {
 	// Maximum length: 100
 	// Example: Petrovic
 	Lastname string `json:"lastname,omitempty"`
-	Jmbg     string `json:"jmbg" binding:"number,len=13,required"`
+	// Left out of companies, see Company.Masked
+	Jmbg string `json:"jmbg,omitempty" binding:"number,len=13,required"`
+}
+
+// Person registration
+//
+// A person registers an account with their JMBG, name and lastname as they
+// are kept in the registry.
+// swagger:model personRegistration
+type PersonRegistration struct {
+	// Required: true
+	// Example: 0101990710000
+	Jmbg string `json:"jmbg" binding:"number,len=13,required"`
+	// Required: true
+	// Example: Petar
+	Name string `json:"name" binding:"required,max=100"`
+	// Required: true
+	// Example: Petrovic
+	Lastname string `json:"lastname" binding:"required,max=100"`
+	// Required: true
+	// Minimum length: 12
+	// Maximum length: 72
+	Password string `json:"password" binding:"required"`
+}
+
+// swagger:model personActivationRequest
+type PersonActivationRequest struct {
+	// Required: true
+	// Example: 0101990710000
+	Jmbg string `json:"jmbg" binding:"number,len=13,required"`
+}
+
+// Activation of a person account
+//
+// The code is sent to the companies the person owns or represents, which
+// hand it over to them.
+// swagger:model personActivation
+type PersonActivation struct {
+	// Required: true
+	// Example: 0101990710000
+	Jmbg string `json:"jmbg" binding:"number,len=13,required"`
+	// Required: true
+	Code string `json:"code" binding:"required"`
+}
+
+// Person login credentials
+//
+// swagger:model personCredentials
+type PersonCredentials struct {
+	// Required: true
+	Jmbg string `json:"jmbg" binding:"required"`
+	// Required: true
+	Password string `json:"password" binding:"required"`
+}
+
+const (
+	RoleOwner          = "owner"
+	RoleRepresentative = "representative"
+)
+
+// Company a person can act for
+//
+// swagger:model personCompany
+type PersonCompany struct {
+	PIB   int    `json:"pib"`
+	Naziv string `json:"naziv"`
+	// Either owner or representative
+	// Example: owner
+	Role string `json:"role"`
+}
+
+// swagger:model representativeRequest
+type RepresentativeRequest struct {
+	// JMBG of the person who will represent the company
+	// Required: true
+	Jmbg string `json:"jmbg" binding:"number,len=13,required"`
+}
+
+// Role of a registrar official
+//
+// swagger:model officialRole
+type OfficialRole struct {
+	// Required: true
+	// Example: 0101990710000
+	Jmbg string `json:"jmbg" binding:"number,len=13,required"`
+	// One of clerk, supervisor, admin or auditor
+	// Required: true
+	// Example: clerk
+	Role      string    `json:"role" binding:"required"`
+	GrantedAt time.Time `json:"grantedAt"`
+	// Principal of the admin who granted the role
+	GrantedBy string `json:"grantedBy,omitempty"`
 }
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+var WebhookErrors = map[string]string{
+	"URL":   "Must be a valid absolute URL",
+	"Types": "Must be known event types",
+}
+
+// Webhook subscription
+//
+// WebhookSubscription asks for events to be POSTed to URL.
+// swagger:model webhookSubscription
+type WebhookSubscription struct {
+	// Read Only: true
+	Id int `json:"id"`
+	// Required: true
+	// Example: https://partner.example/apr-events
+	URL string `json:"url" binding:"required,url"`
+	// Event types to deliver, all types if empty
+	// Example: ["company.registered"]
+	Types []string `json:"types" binding:"dive,oneof=company.registered company.changed company.liquidated"`
+	// Only deliver events of this company, all companies if 0
+	PIB int `json:"pib"`
+	// Key for the HMAC signature of deliveries, only returned on creation
+	// Read Only: true
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (sub WebhookSubscription) Filter() EventFilter {
+	return EventFilter{Types: sub.Types, PIB: sub.PIB}
+}
+
+// WebhookDelivery is an event waiting to be delivered to a subscription.
+type WebhookDelivery struct {
+	Id             int
+	SubscriptionId int
+	EventId        int64
+	EventType      string
+	Payload        json.RawMessage
+	// Failed attempts so far
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+}
+
+// Dead letter
+//
+// DeadLetter is a delivery which failed on every retry.
+// swagger:model deadLetter
+type DeadLetter struct {
+	Id             int             `json:"id"`
+	SubscriptionId int             `json:"subscriptionId"`
+	EventId        int64           `json:"eventId"`
+	EventType      string          `json:"eventType"`
+	Payload        json.RawMessage `json:"payload"`
+	Attempts       int             `json:"attempts"`
+	LastError      string          `json:"lastError"`
+	FailedAt       time.Time       `json:"failedAt"`
+}
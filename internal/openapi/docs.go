@@ -0,0 +1,45 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	swaggerFiles "github.com/swaggo/files"
+)
+
+const initializer = `window.onload = function() {
+  window.ui = SwaggerUIBundle({
+    url: %q,
+    dom_id: '#swagger-ui',
+    deepLinking: true,
+    presets: [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset],
+    plugins: [SwaggerUIBundle.plugins.DownloadUrl],
+    layout: "StandaloneLayout"
+  });
+};
+`
+
+// Spec serves the raw spec.
+func Spec(spec []byte) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", spec)
+	}
+}
+
+// Docs serves the Swagger UI under a route ending with *filepath, pointing
+// it at specUrl.
+func Docs(specUrl string) gin.HandlerFunc {
+	script := []byte(fmt.Sprintf(initializer, specUrl))
+	files := http.FileServer(swaggerFiles.HTTP)
+	return func(c *gin.Context) {
+		switch c.Param("filepath") {
+		case "/swagger-initializer.js":
+			c.Data(http.StatusOK, "application/javascript", script)
+		default:
+			// The file server only sees the part of the path after the prefix
+			c.Request.URL.Path = c.Param("filepath")
+			files.ServeHTTP(c.Writer, c.Request)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Package openapi serves the OpenAPI spec of the HTTP API together with a
+// docs UI, and validates traffic against it.
+package openapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi2"
+	"github.com/getkin/kin-openapi/openapi2conv"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	// Keep the errors short enough to be sent back to clients
+	openapi3.SchemaErrorDetailsDisabled = true
+}
+
+// Responses bigger than this are not validated.
+const maxValidatedResponse = 1 << 20
+
+// Validator checks requests and responses against the spec.
+//
+// Requests which don't match the spec are rejected with 400. In development
+// mode they are let through instead, and every mismatch, including ones in
+// responses and undocumented routes, is logged and reported to the client
+// in the X-OpenAPI-Mismatch header where the response allows it.
+type Validator struct {
+	router routers.Router
+	dev    bool
+}
+
+// NewValidator loads a Swagger 2.0 spec.
+func NewValidator(spec []byte, dev bool) (*Validator, error) {
+	var doc2 openapi2.T
+	if err := json.Unmarshal(spec, &doc2); err != nil {
+		return nil, fmt.Errorf("Error parsing OpenAPI spec: %w", err)
+	}
+	doc, err := openapi2conv.ToV3(&doc2)
+	if err != nil {
+		return nil, fmt.Errorf("Error converting OpenAPI spec: %w", err)
+	}
+	// Match requests regardless of the host they were sent to
+	doc.Servers = nil
+
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("Error building OpenAPI router: %w", err)
+	}
+	return &Validator{router: router, dev: dev}, nil
+}
+
+func (v *Validator) report(c *gin.Context, kind string, err error) {
+	msg := strings.ReplaceAll(err.Error(), "\n", " ")
+	log.Printf("OpenAPI %s mismatch on %s %s: %s", kind, c.Request.Method, c.Request.URL.Path, msg)
+	if !c.Writer.Written() {
+		c.Writer.Header().Add("X-OpenAPI-Mismatch", kind+": "+msg)
+	}
+}
+
+// recorder keeps a copy of JSON response bodies so they can be validated
+// after the handler has run. Everything else, like event streams and
+// exports, is passed through untouched.
+type recorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) record(data []byte) {
+	if !strings.HasPrefix(r.Header().Get("Content-Type"), "application/json") {
+		return
+	}
+	if r.body.Len()+len(data) <= maxValidatedResponse {
+		r.body.Write(data)
+	}
+}
+
+func (r *recorder) Write(data []byte) (int, error) {
+	r.record(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *recorder) WriteString(s string) (int, error) {
+	r.record([]byte(s))
+	return r.ResponseWriter.WriteString(s)
+}
+
+// Middleware validates requests and, in development mode, responses.
+func (v *Validator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route, pathParams, err := v.router.FindRoute(c.Request)
+		if err != nil {
+			if v.dev {
+				v.report(c, "route", err)
+			}
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				// Authentication is left to client.CheckAuth
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+				MultiError:         true,
+			},
+		}
+		if err := openapi3filter.ValidateRequest(context.Background(), input); err != nil {
+			if !v.dev {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			v.report(c, "request", err)
+		}
+		if !v.dev {
+			return
+		}
+
+		rec := &recorder{ResponseWriter: c.Writer}
+		c.Writer = rec
+		c.Next()
+		c.Writer = rec.ResponseWriter
+
+		if rec.body.Len() == 0 && strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+			// Too big to validate
+			return
+		}
+		err = openapi3filter.ValidateResponse(context.Background(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 rec.Status(),
+			Header:                 rec.Header(),
+			Body:                   io.NopCloser(bytes.NewReader(rec.body.Bytes())),
+			Options: &openapi3filter.Options{
+				IncludeResponseStatus: true,
+				MultiError:            true,
+			},
+		})
+		if err != nil {
+			v.report(c, "response", err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Package ratelimit limits how often callers may use an endpoint, with a
+// token bucket per caller.
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+var ErrRateLimited = errors.New("Rate limit exceeded")
+
+// LimitError is returned for requests over the limit
+type LimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e LimitError) Error() string {
+	return fmt.Sprintf("%s, retry in %s", ErrRateLimited.Error(), e.RetryAfter.Round(time.Second))
+}
+
+func (e LimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// Above this many keys, full buckets are swept on the next request
+const sweepSize = 10000
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter allows each key perMinute requests a minute, in bursts of up to
+// burst. Buckets are kept in process, so every replica has its own.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]bucket
+}
+
+func NewLimiter(perMinute int, burst int) *Limiter {
+	return &Limiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]bucket),
+	}
+}
+
+// Allow takes a token from the bucket of key, or returns a LimitError if it
+// is empty
+func (l *Limiter) Allow(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if len(l.buckets) >= sweepSize {
+		for k, b := range l.buckets {
+			if l.fill(b, now) >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	b, ok := l.buckets[key]
+	if ok {
+		b.tokens = l.fill(b, now)
+	} else {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		l.buckets[key] = b
+		wait := (1 - b.tokens) / l.rate
+		return LimitError{RetryAfter: time.Duration(math.Ceil(wait)) * time.Second}
+	}
+	b.tokens--
+	l.buckets[key] = b
+	return nil
+}
+
+// fill returns the tokens of b at now
+func (l *Limiter) fill(b bucket, now time.Time) float64 {
+	return math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+}
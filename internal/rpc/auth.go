@@ -0,0 +1,90 @@
+package rpc
+
+import (
+	"apr-backend/client"
+	"context"
+	"log"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type principalKey struct{}
+type actingPersonKey struct{}
+type claimsKey struct{}
+
+// Principal returns the subject of the JWT the call was authenticated with.
+func Principal(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+// ActingPerson returns the JMBG of the person acting for the principal, or an
+// empty string if the company itself is calling.
+func ActingPerson(ctx context.Context) string {
+	actor, _ := ctx.Value(actingPersonKey{}).(string)
+	return actor
+}
+
+// Claims returns the claims of the JWT the call was authenticated with, ok
+// is false outside of CheckAuth.
+func Claims(ctx context.Context) (client.TokenClaims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(client.TokenClaims)
+	return claims, ok
+}
+
+// CheckAuth is the gRPC counterpart of client.CheckAuth: it requires a valid
+// JWT for serviceName in the "authorization" metadata.
+func CheckAuth(verifier client.JwtVerifier, serviceName string, options ...client.AuthOption) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get("authorization")
+		if len(values) == 0 {
+			return nil, status.Error(codes.Unauthenticated, "authorization metadata not provided")
+		}
+
+		bearer, tokenStr, found := strings.Cut(values[0], " ")
+		if !found || bearer != "Bearer" {
+			return nil, status.Error(codes.Unauthenticated, "invalid format for bearer token")
+		}
+
+		claims, err := client.ValidateToken(verifier, tokenStr, serviceName, options...)
+		if err != nil {
+			log.Println(err)
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+
+		ctx = context.WithValue(ctx, principalKey{}, claims.Subject)
+		ctx = context.WithValue(ctx, actingPersonKey{}, claims.ActingPerson())
+		ctx = context.WithValue(ctx, claimsKey{}, claims)
+		return handler(ctx, req)
+	}
+}
+
+// RequireScopesFor is the gRPC counterpart of client.RequireScopesFor, with
+// the scopes each method requires. Methods which aren't listed are refused
+// to tokens with role. It has to run after CheckAuth.
+func RequireScopesFor(role string, methodScopes map[string][]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		claims, ok := Claims(ctx)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "not authenticated")
+		}
+		if !claims.HasRole(role) {
+			return handler(ctx, req)
+		}
+		scopes, ok := methodScopes[info.FullMethod]
+		if !ok {
+			return nil, status.Error(codes.PermissionDenied, "method isn't available to role "+role)
+		}
+		for _, scope := range scopes {
+			if !claims.HasScope(scope) {
+				return nil, status.Error(codes.PermissionDenied, "requires scope "+scope)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
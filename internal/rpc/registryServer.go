@@ -0,0 +1,141 @@
+// Package rpc implements the gRPC API defined in api/apr.proto on top of the
+// same services the HTTP controllers use.
+package rpc
+
+import (
+	"apr-backend/api/aprpb"
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"apr-backend/internal/services"
+	"context"
+	"errors"
+	"log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const maxBatchSize = 1000
+
+type RegistryServer struct {
+	aprpb.UnimplementedRegistryServer
+	comServ  services.CompanyService
+	nstjServ services.NstjService
+}
+
+func NewRegistryServer(comServ services.CompanyService, nstjServ services.NstjService) *RegistryServer {
+	return &RegistryServer{comServ: comServ, nstjServ: nstjServ}
+}
+
+func toNstj(nstj model.Nstj) *aprpb.Nstj {
+	return &aprpb.Nstj{Oznaka: nstj.Oznaka, Naziv: nstj.Naziv}
+}
+
+func toCompany(com model.Company) *aprpb.Company {
+	return &aprpb.Company{
+		Pib:           int64(com.PIB),
+		Naziv:         com.Naziv,
+		AdresaSedista: com.AdresaSedista,
+		Mesto:         com.Mesto,
+		PostanskiBroj: com.PostanskiBroj,
+		Delatnost:     com.Delatnost.String(),
+		Sediste:       toNstj(com.Sediste),
+		// Companies come masked, without the owner's JMBG
+		Vlasnik: &aprpb.Person{
+			Name:     com.Vlasnik.Name,
+			Lastname: com.Vlasnik.Lastname,
+		},
+	}
+}
+
+func internalError(err error) error {
+	log.Println(err.Error())
+	return status.Error(codes.Internal, "internal server error")
+}
+
+// FindOne implements aprpb.RegistryServer
+func (rs *RegistryServer) FindOne(ctx context.Context, req *aprpb.FindOneRequest) (*aprpb.Company, error) {
+	company, err := rs.comServ.FindOne(int(req.GetPib()))
+	if errors.Is(err, db.NoSuchPibError) {
+		return nil, status.Errorf(codes.NotFound, "couldn't find company with pib %d", req.GetPib())
+	}
+	if err != nil {
+		return nil, internalError(err)
+	}
+	return toCompany(company), nil
+}
+
+// FindMany implements aprpb.RegistryServer
+func (rs *RegistryServer) FindMany(ctx context.Context, req *aprpb.FindManyRequest) (*aprpb.FindManyResponse, error) {
+	if len(req.GetPibs()) > maxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d pibs can be requested at once", maxBatchSize)
+	}
+	pibs := make([]int, len(req.GetPibs()))
+	for i, pib := range req.GetPibs() {
+		pibs[i] = int(pib)
+	}
+
+	companies, err := rs.comServ.FindMany(pibs)
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	found := make(map[int64]bool, len(companies))
+	res := &aprpb.FindManyResponse{Companies: make([]*aprpb.Company, 0, len(companies))}
+	for _, com := range companies {
+		found[int64(com.PIB)] = true
+		res.Companies = append(res.Companies, toCompany(com))
+	}
+	for _, pib := range req.GetPibs() {
+		if !found[pib] {
+			res.Missing = append(res.Missing, pib)
+		}
+	}
+	return res, nil
+}
+
+// FindCompanies implements aprpb.RegistryServer
+func (rs *RegistryServer) FindCompanies(ctx context.Context, req *aprpb.FindCompaniesRequest) (*aprpb.FindCompaniesResponse, error) {
+	order := req.GetOrder()
+	if order == "" {
+		order = "PIB"
+	}
+	page := int(req.GetPage())
+	if page < 0 {
+		page = 0
+	}
+
+	companies, err := rs.comServ.FindCompanies(model.CompanyFilter{
+		OrderBy:   order,
+		Asc:       !req.GetDesc(),
+		Page:      page,
+		Mesto:     req.GetMesto(),
+		Sediste:   req.GetSediste(),
+		Delatnost: req.GetDelatnost(),
+	})
+	if errors.Is(err, db.InvalidFilter) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	res := &aprpb.FindCompaniesResponse{Companies: make([]*aprpb.Company, 0, len(companies))}
+	for _, com := range companies {
+		res.Companies = append(res.Companies, toCompany(com))
+	}
+	return res, nil
+}
+
+// ListNstj implements aprpb.RegistryServer
+func (rs *RegistryServer) ListNstj(ctx context.Context, req *aprpb.ListNstjRequest) (*aprpb.ListNstjResponse, error) {
+	nstjs, err := rs.nstjServ.FindAll()
+	if err != nil {
+		return nil, internalError(err)
+	}
+	res := &aprpb.ListNstjResponse{Nstj: make([]*aprpb.Nstj, 0, len(nstjs))}
+	for _, nstj := range nstjs {
+		res.Nstj = append(res.Nstj, toNstj(nstj))
+	}
+	return res, nil
+}
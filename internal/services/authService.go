@@ -1,41 +1,607 @@
 package services
 
 import (
+	"apr-backend/client"
+	"apr-backend/internal/auth"
 	"apr-backend/internal/db"
+	"apr-backend/internal/lockout"
 	"apr-backend/internal/model"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"log"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/golang-jwt/jwt/v4"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var validPassword = regexp.MustCompile("^.{12,72}$")
 var ErrInvalidPassword = errors.New("Password must be between 12 and 72 characters long")
+var ErrWrongPassword = errors.New("Wrong password")
 
 const bcryptCost = 10
 
+var ErrInvalidChallenge = errors.New("Second factor challenge is invalid, expired or was completed")
+
+const (
+	refreshTokenLifetime = 30 * 24 * time.Hour
+	resetTokenLifetime   = time.Hour
+	// Time to enter the code of the second factor after the password
+	mfaChallengeLifetime = 5 * time.Minute
+	// Audience of second factor challenges
+	mfaAudience = "apr-mfa"
+	// Longest lifetime of tokens with a sid, those issued for registered
+	// services
+	maxSessionTokenLifetime = 24 * time.Hour
+)
+
 func validatePassword(pass string) bool {
 	return validPassword.Match([]byte(pass))
 }
 
 type AuthService interface {
 	CheckCredentials(creds model.CredentialsDto) error
+	// Checks credentials and starts a session. Companies with a second
+	// factor get a challenge instead, which CompleteLogin finishes. Failed
+	// logins are counted by PIB and by ip, too many of them return a
+	// lockout.RetryError.
+	Login(creds model.CredentialsDto, ip string) (model.TokenPair, *model.MfaChallenge, error)
+	// Starts the session of a challenge returned by Login, if code is a
+	// code of the company's second factor. Wrong codes count as failed
+	// logins.
+	CompleteLogin(challenge, code, ip string) (model.TokenPair, error)
+	// Checks credentials like Login, without starting a session
+	Authenticate(creds model.CredentialsDto, ip string) error
+	// Checks credentials and, for companies with a second factor, code,
+	// and reports whether the second factor was checked. ErrMfaRequired if
+	// code is needed but empty.
+	AuthenticateWithCode(creds model.CredentialsDto, code, ip string) (bool, error)
+	// Starts a session for pib, for example right after registration
+	IssueTokens(pib int) (model.TokenPair, error)
+	// Starts a session of the person with jmbg acting for pib. Callers
+	// check that the person may act for it.
+	IssueActingTokens(pib int, jmbg string) (model.TokenPair, error)
+	// Starts a session of a registrar official, ErrNotOfficial if they
+	// have no roles
+	IssueOfficialTokens(jmbg string) (model.TokenPair, error)
+	// Starts a session of the company of code at its OAuth client. The
+	// access token only has the scopes of code and is only accepted by the
+	// client and the userinfo endpoint. A refresh token is issued only for
+	// offline_access.
+	IssueClientTokens(code model.AuthorizationCode) (model.TokenPair, model.ClientTokens, error)
+	// Exchanges a refresh token for a new pair, the old one can't be used
+	// again. Persons have to still own or represent the company, officials
+	// get their current roles. Sessions of liquidated or deleted companies
+	// end, like those of former representatives. clientId is the OAuth client refreshing,
+	// empty for APR's own sessions. Sessions of other clients are revoked.
+	Refresh(refreshToken, clientId string) (model.TokenPair, error)
+	// Revokes the access token with claims and, if given, the session of
+	// refreshToken
+	Logout(claims client.TokenClaims, refreshToken string) error
+	// Changes the password of pib if oldPassword is right and revokes its
+	// sessions, the caller's too. Wrong old passwords count as failed
+	// logins.
+	ChangePassword(pib int, oldPassword, newPassword, ip string) error
+	// Sends a single use reset token through the notifier. Callers limit
+	// how often it is used, every call notifies the company. Unknown PIBs
+	// and failed deliveries aren't reported, so callers can't find out
+	// which companies exist.
+	RequestPasswordReset(pib int) error
+	// Sets a new password with a reset token and revokes the refresh tokens
+	// of the company. The token stays usable if the password isn't set.
+	ResetPassword(token, newPassword string) error
+	// Lists access tokens revoked before they expire and sessions ended
+	// while their tokens may be valid, for services which check
+	// revocations through client.NewRevocationList
+	Revocations() (client.RevocationList, error)
 }
 
-func NewAuthService(db db.CompanyRepository) AuthService {
-	return authService{comRepo: db}
+// notifier may be nil, password resets are disabled then
+func NewAuthService(db db.CompanyRepository, accountRepo db.PersonAccountRepository, tokenRepo db.TokenRepository, roleServ RoleService, mfaServ MfaService, jwtGen auth.JwtGenerator, notifier Notifier, guard lockout.Guard) AuthService {
+	return authService{comRepo: db, accountRepo: accountRepo, tokenRepo: tokenRepo, roleServ: roleServ, mfaServ: mfaServ, jwtGen: jwtGen, notifier: notifier, guard: guard}
 }
 
 type authService struct {
-	comRepo db.CompanyRepository
+	comRepo     db.CompanyRepository
+	accountRepo db.PersonAccountRepository
+	tokenRepo   db.TokenRepository
+	roleServ    RoleService
+	mfaServ     MfaService
+	jwtGen      auth.JwtGenerator
+	notifier    Notifier
+	guard       lockout.Guard
 }
 
-// Login implements AuthService
+// CheckCredentials implements AuthService. The error is nil only when the
+// password matches the stored hash.
 func (authServ authService) CheckCredentials(creds model.CredentialsDto) error {
 	savedCreds, err := authServ.comRepo.FindOneCredentials(creds.PIB)
-	if err != db.DatabaseError {
+	if err != nil {
+		return err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(savedCreds.Password), []byte(creds.Password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrWrongPassword
+	}
+	return err
+}
+
+// checkPassword is CheckCredentials behind the brute force guard. Failures
+// aren't reset, so that a right password doesn't give more attempts at the
+// second factor.
+func (authServ authService) checkPassword(creds model.CredentialsDto, ip string) error {
+	attempt, err := authServ.guard.Attempt(creds.PIB, ip)
+	if err != nil {
+		return err
+	}
+	err = authServ.CheckCredentials(creds)
+	finish(attempt, errors.Is(err, ErrWrongPassword) || errors.Is(err, db.NoSuchPibError))
+	return err
+}
+
+// finish ends an attempt of the brute force guard
+func finish(attempt lockout.Attempt, failed bool) {
+	if failed {
+		if err := attempt.Failed(); err != nil {
+			log.Printf("Error counting failed login: %s", err.Error())
+		}
+	} else if err := attempt.Passed(); err != nil {
+		log.Printf("Error releasing login attempt: %s", err.Error())
+	}
+}
+
+func (authServ authService) succeeded(pib int) {
+	if err := authServ.guard.Succeeded(pib); err != nil {
+		log.Printf("Error resetting failed logins: %s", err.Error())
+	}
+}
+
+// checkCode checks a code of the second factor of pib, counting wrong ones
+// as failed logins
+func (authServ authService) checkCode(pib int, code, ip string) error {
+	attempt, err := authServ.guard.Attempt(pib, ip)
+	if err != nil {
 		return err
 	}
+	err = authServ.mfaServ.Verify(pib, code)
+	finish(attempt, errors.Is(err, ErrInvalidCode))
+	return err
+}
+
+// Authenticate implements AuthService, it is CheckCredentials behind the
+// brute force guard
+func (authServ authService) Authenticate(creds model.CredentialsDto, ip string) error {
+	if err := authServ.checkPassword(creds, ip); err != nil {
+		return err
+	}
+	authServ.succeeded(creds.PIB)
+	return nil
+}
+
+// AuthenticateWithCode implements AuthService
+func (authServ authService) AuthenticateWithCode(creds model.CredentialsDto, code, ip string) (bool, error) {
+	if err := authServ.checkPassword(creds, ip); err != nil {
+		return false, err
+	}
+	enrolled, err := authServ.mfaServ.Enrolled(creds.PIB)
+	if err != nil {
+		return false, err
+	}
+	if enrolled {
+		if code == "" {
+			return false, ErrMfaRequired
+		}
+		if err := authServ.checkCode(creds.PIB, code, ip); err != nil {
+			return false, err
+		}
+	}
+	authServ.succeeded(creds.PIB)
+	return enrolled, nil
+}
+
+// Login implements AuthService
+func (authServ authService) Login(creds model.CredentialsDto, ip string) (model.TokenPair, *model.MfaChallenge, error) {
+	if err := authServ.checkPassword(creds, ip); err != nil {
+		return model.TokenPair{}, nil, err
+	}
+	enrolled, err := authServ.mfaServ.Enrolled(creds.PIB)
+	if err != nil {
+		return model.TokenPair{}, nil, err
+	}
+	if enrolled {
+		challenge, err := authServ.challenge(creds.PIB)
+		return model.TokenPair{}, challenge, err
+	}
+	authServ.succeeded(creds.PIB)
+	tokens, err := authServ.IssueTokens(creds.PIB)
+	return tokens, nil, err
+}
+
+// challenge returns a second factor challenge for pib, a token which only
+// CompleteLogin accepts
+func (authServ authService) challenge(pib int) (*model.MfaChallenge, error) {
+	jti, err := auth.NewTokenId()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	token, err := authServ.jwtGen.SignJwt(client.TokenClaims{RegisteredClaims: jwt.RegisteredClaims{
+		ID:        jti,
+		Issuer:    client.Apr,
+		Subject:   strconv.Itoa(pib),
+		Audience:  []string{mfaAudience},
+		ExpiresAt: jwt.NewNumericDate(now.Add(mfaChallengeLifetime)),
+		IssuedAt:  jwt.NewNumericDate(now),
+	}})
+	if err != nil {
+		return nil, err
+	}
+	return &model.MfaChallenge{
+		Challenge: token,
+		Methods:   mfaMethods,
+		ExpiresIn: int(mfaChallengeLifetime.Seconds()),
+	}, nil
+}
+
+// CompleteLogin implements AuthService
+func (authServ authService) CompleteLogin(challenge, code, ip string) (model.TokenPair, error) {
+	claims, err := client.ValidateToken(authServ.jwtGen, challenge, mfaAudience, client.WithRevocationStore(authServ.tokenRepo))
+	if err != nil || claims.ID == "" || claims.ExpiresAt == nil {
+		return model.TokenPair{}, ErrInvalidChallenge
+	}
+	pib, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return model.TokenPair{}, ErrInvalidChallenge
+	}
+	if err := authServ.checkCode(pib, code, ip); err != nil {
+		return model.TokenPair{}, err
+	}
+	authServ.succeeded(pib)
+	// Challenges are completed once, by whichever concurrent completion
+	// uses them up first
+	first, err := authServ.tokenRepo.RevokeOnce(claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+	if !first {
+		return model.TokenPair{}, ErrInvalidChallenge
+	}
+	return authServ.issue(model.RefreshToken{Kind: model.SessionCompany, PIB: pib, Mfa: true})
+}
+
+// randomToken returns a random URL safe token and its hash
+func randomToken() (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", fmt.Errorf("Error generating token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRefreshToken returns a random refresh token and its record, without a
+// family. The session is filled in from session, if given.
+func newRefreshToken(session model.RefreshToken) (string, model.RefreshToken, error) {
+	token, hash, err := randomToken()
+	if err != nil {
+		return "", model.RefreshToken{}, err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	session.Hash = hash
+	session.CreatedAt = now
+	session.ExpiresAt = now.Add(refreshTokenLifetime)
+	return token, session, nil
+}
+
+// newFamily returns a random id of a new session
+func newFamily() (string, error) {
+	family := make([]byte, 16)
+	if _, err := rand.Read(family); err != nil {
+		return "", fmt.Errorf("Error generating token family: %w", err)
+	}
+	return hex.EncodeToString(family), nil
+}
+
+// sessionAmr returns the authentication methods of a session
+func sessionAmr(mfa bool) []string {
+	if mfa {
+		return []string{client.AmrPassword, client.AmrOtp}
+	}
+	return []string{client.AmrPassword}
+}
+
+// identity carried by the access tokens of session, with the current roles
+func (authServ authService) identity(session model.RefreshToken) (auth.Identity, error) {
+	if session.Kind == model.SessionOfficial {
+		roles, err := authServ.roleServ.OfficialRoles(session.Jmbg)
+		if err != nil {
+			return auth.Identity{}, err
+		}
+		return auth.Identity{Subject: session.Jmbg, Roles: roles, Amr: sessionAmr(session.Mfa)}, nil
+	}
+	company, err := companyClaims(authServ.comRepo, session.PIB)
+	if err != nil {
+		return auth.Identity{}, err
+	}
+	roles := authServ.roleServ.CompanyRoles(session.PIB, session.Jmbg)
+	var scopes []string
+	if session.ClientId != "" {
+		// OAuth clients get what the company consented to, never admin
+		roles, scopes = []string{client.RoleCompany}, strings.Fields(session.Scope)
+	}
+	return auth.Identity{
+		Subject: strconv.Itoa(session.PIB),
+		Actor:   session.Jmbg,
+		Roles:   roles,
+		Company: &company,
+		Scopes:  scopes,
+		Amr:     sessionAmr(session.Mfa),
+	}, nil
+}
 
-	return bcrypt.CompareHashAndPassword([]byte(savedCreds.Password), []byte(creds.Password))
+// companyClaims describe pib in its tokens, liquidated companies too
+func companyClaims(comRepo db.CompanyRepository, pib int) (client.CompanyClaims, error) {
+	com, err := comRepo.FindOneAny(pib)
+	if err != nil {
+		return client.CompanyClaims{}, err
+	}
+	status := client.CompanyActive
+	if com.Likvidirana {
+		status = client.CompanyLiquidated
+	}
+	return client.CompanyClaims{Name: com.Naziv, Status: status}, nil
+}
+
+// tokenPair issues an access token of session for id
+func (authServ authService) tokenPair(session model.RefreshToken, id auth.Identity, refreshToken string) (model.TokenPair, error) {
+	id.Session = session.Family
+	var access string
+	var err error
+	if session.ClientId != "" {
+		access, err = authServ.jwtGen.GenerateServiceJWT(id, []string{session.ClientId, UserInfoAudience}, auth.AccessTokenLifetime)
+	} else {
+		access, err = authServ.jwtGen.GenerateJWT(id, client.Apr)
+	}
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+	return model.TokenPair{
+		Jwt:          access,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(auth.AccessTokenLifetime.Seconds()),
+	}, nil
+}
+
+// IssueTokens implements AuthService
+func (authServ authService) IssueTokens(pib int) (model.TokenPair, error) {
+	return authServ.IssueActingTokens(pib, "")
+}
+
+// IssueActingTokens implements AuthService
+func (authServ authService) IssueActingTokens(pib int, jmbg string) (model.TokenPair, error) {
+	return authServ.issue(model.RefreshToken{Kind: model.SessionCompany, PIB: pib, Jmbg: jmbg})
+}
+
+// IssueOfficialTokens implements AuthService
+func (authServ authService) IssueOfficialTokens(jmbg string) (model.TokenPair, error) {
+	return authServ.issue(model.RefreshToken{Kind: model.SessionOfficial, Jmbg: jmbg})
+}
+
+// IssueClientTokens implements AuthService
+func (authServ authService) IssueClientTokens(code model.AuthorizationCode) (model.TokenPair, model.ClientTokens, error) {
+	session := model.RefreshToken{Kind: model.SessionCompany, PIB: code.PIB, Mfa: code.Mfa, ClientId: code.ClientId, Scope: code.Scope}
+	id, err := authServ.identity(session)
+	if err != nil {
+		return model.TokenPair{}, model.ClientTokens{}, err
+	}
+	var issued model.ClientTokens
+	var refreshToken string
+	if contains(strings.Fields(code.Scope), "offline_access") {
+		if refreshToken, session, err = authServ.saveSession(session); err != nil {
+			return model.TokenPair{}, model.ClientTokens{}, err
+		}
+		issued.Family = session.Family
+	}
+	tokens, err := authServ.tokenPair(session, id, refreshToken)
+	if err != nil {
+		return model.TokenPair{}, model.ClientTokens{}, err
+	}
+	issued.Jti, issued.ExpiresAt, err = tokenId(tokens.Jwt)
+	if err != nil {
+		return model.TokenPair{}, model.ClientTokens{}, err
+	}
+	return tokens, issued, nil
+}
+
+// tokenId returns the jti and expiry of token, which was just signed and
+// needn't be verified
+func tokenId(token string) (string, time.Time, error) {
+	var claims client.TokenClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err != nil {
+		return "", time.Time{}, fmt.Errorf("Error reading issued token: %w", err)
+	}
+	return claims.ID, claims.ExpiresAt.Time, nil
+}
+
+// issue starts session, of which Kind, PIB, Jmbg and Mfa are set
+func (authServ authService) issue(session model.RefreshToken) (model.TokenPair, error) {
+	id, err := authServ.identity(session)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+	token, record, err := authServ.saveSession(session)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+	return authServ.tokenPair(record, id, token)
+}
+
+// saveSession stores the first refresh token of session in a new family
+func (authServ authService) saveSession(session model.RefreshToken) (string, model.RefreshToken, error) {
+	token, record, err := newRefreshToken(session)
+	if err != nil {
+		return "", record, err
+	}
+	if record.Family, err = newFamily(); err != nil {
+		return "", record, err
+	}
+	if err := authServ.tokenRepo.SaveRefreshToken(record); err != nil {
+		return "", record, err
+	}
+	return token, record, nil
+}
+
+// Refresh implements AuthService
+func (authServ authService) Refresh(refreshToken, clientId string) (model.TokenPair, error) {
+	token, next, err := newRefreshToken(model.RefreshToken{})
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+	if err := authServ.tokenRepo.RotateRefreshToken(hashToken(refreshToken), &next); err != nil {
+		return model.TokenPair{}, err
+	}
+	if next.ClientId != clientId {
+		// Whoever holds it isn't the client it was issued to
+		if err := authServ.tokenRepo.RevokeSession(next.Family); err != nil {
+			log.Printf("Error ending session: %s", err.Error())
+		}
+		return model.TokenPair{}, fmt.Errorf("Refresh token of another client: %w", db.NoSuchRefreshTokenError)
+	}
+	if next.Kind == model.SessionCompany && next.Jmbg != "" {
+		// Ownership may have changed since the session started
+		_, err = authServ.accountRepo.FindRole(next.PIB, next.Jmbg)
+	}
+	var id auth.Identity
+	if err == nil {
+		id, err = authServ.identity(next)
+	}
+	if err == nil && id.Company != nil && id.Company.Status == client.CompanyLiquidated {
+		err = fmt.Errorf("Company %d was liquidated: %w", next.PIB, db.NoSuchPibError)
+	}
+	if errors.Is(err, db.NotRepresentativeError) || errors.Is(err, ErrNotOfficial) || errors.Is(err, db.NoSuchPibError) {
+		if err := authServ.endSession(next, err); err != nil {
+			log.Printf("Error ending session: %s", err.Error())
+		}
+		return model.TokenPair{}, fmt.Errorf("Session can't be refreshed: %w", db.NoSuchRefreshTokenError)
+	}
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+	return authServ.tokenPair(next, id, token)
+}
+
+// endSession revokes the sessions which reason ends along with session,
+// those of the company if it is gone or else of the person or official
+func (authServ authService) endSession(session model.RefreshToken, reason error) error {
+	switch {
+	case session.Kind == model.SessionOfficial:
+		return authServ.tokenRepo.RevokeOfficial(session.Jmbg)
+	case errors.Is(reason, db.NoSuchPibError):
+		return authServ.tokenRepo.RevokeAll(session.PIB)
+	}
+	return authServ.tokenRepo.RevokeActing(session.PIB, session.Jmbg)
+}
+
+// Logout implements AuthService
+func (authServ authService) Logout(claims client.TokenClaims, refreshToken string) error {
+	if refreshToken != "" {
+		kind := model.SessionOfficial
+		if claims.HasRole(client.RoleCompany) {
+			kind = model.SessionCompany
+		}
+		err := authServ.tokenRepo.RevokeFamily(hashToken(refreshToken), kind, claims.Subject)
+		if err != nil && !errors.Is(err, db.NoSuchRefreshTokenError) {
+			return err
+		}
+	}
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+	return authServ.tokenRepo.RevokeAccessToken(claims.ID, claims.ExpiresAt.Time)
+}
+
+func (authServ authService) setPassword(pib int, password string) error {
+	if !validatePassword(password) {
+		return ErrInvalidPassword
+	}
+	hash, err := bcryptHash(password)
+	if err != nil {
+		return err
+	}
+	if err := authServ.comRepo.UpdatePassword(pib, hash); err != nil {
+		return err
+	}
+	// Whoever knew the old password mustn't stay logged in
+	return authServ.tokenRepo.RevokeAll(pib)
+}
+
+// ChangePassword implements AuthService
+func (authServ authService) ChangePassword(pib int, oldPassword, newPassword, ip string) error {
+	if err := authServ.Authenticate(model.CredentialsDto{PIB: pib, Password: oldPassword}, ip); err != nil {
+		return err
+	}
+	return authServ.setPassword(pib, newPassword)
+}
+
+// RequestPasswordReset implements AuthService
+func (authServ authService) RequestPasswordReset(pib int) error {
+	if authServ.notifier == nil {
+		return ErrNotifierDisabled
+	}
+	if _, err := authServ.comRepo.FindOneCredentials(pib); err != nil {
+		if errors.Is(err, db.NoSuchPibError) {
+			return nil
+		}
+		return err
+	}
+	token, hash, err := randomToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().UTC().Add(resetTokenLifetime).Truncate(time.Second)
+	if err := authServ.tokenRepo.SaveResetToken(hash, pib, expiresAt); err != nil {
+		return err
+	}
+	if err := authServ.notifier.NotifyPasswordReset(pib, token, expiresAt); err != nil {
+		log.Printf("Error delivering password reset for %d: %s", pib, err.Error())
+	}
+	return nil
+}
+
+// ResetPassword implements AuthService
+func (authServ authService) ResetPassword(token, newPassword string) error {
+	if !validatePassword(newPassword) {
+		return ErrInvalidPassword
+	}
+	hash, err := bcryptHash(newPassword)
+	if err != nil {
+		return err
+	}
+	return authServ.tokenRepo.ResetPassword(hashToken(token), hash)
+}
+
+// Revocations implements AuthService
+func (authServ authService) Revocations() (client.RevocationList, error) {
+	jtis, err := authServ.tokenRepo.FindRevoked()
+	if err != nil {
+		return client.RevocationList{}, err
+	}
+	sessions, err := authServ.tokenRepo.FindRevokedSessions(time.Now().Add(-maxSessionTokenLifetime))
+	if err != nil {
+		return client.RevocationList{}, err
+	}
+	return client.RevocationList{Jtis: jtis, Sessions: sessions}, nil
 }
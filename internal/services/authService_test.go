@@ -0,0 +1,517 @@
+package services
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/auth"
+	"apr-backend/internal/db"
+	"apr-backend/internal/lockout"
+	"apr-backend/internal/model"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// tokenStore keeps refresh tokens in memory, following the contract of
+// db.TokenRepository
+type tokenStore struct {
+	db.TokenRepository
+	mu      sync.Mutex
+	tokens  map[string]*storedToken
+	revoked map[string]bool
+	// Reset tokens by hash, and the password hashes they set
+	resets    map[string]int
+	passwords map[int]string
+}
+
+type storedToken struct {
+	model.RefreshToken
+	used, revoked bool
+}
+
+func newTokenStore() *tokenStore {
+	return &tokenStore{tokens: map[string]*storedToken{}, revoked: map[string]bool{}, resets: map[string]int{}, passwords: map[int]string{}}
+}
+
+func (ts *tokenStore) SaveRefreshToken(token model.RefreshToken) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.tokens[token.Hash] = &storedToken{RefreshToken: token}
+	return nil
+}
+
+func (ts *tokenStore) revokeWhere(match func(*storedToken) bool) {
+	for _, token := range ts.tokens {
+		if match(token) {
+			token.revoked = true
+		}
+	}
+}
+
+func (ts *tokenStore) RotateRefreshToken(hash string, next *model.RefreshToken) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	token, ok := ts.tokens[hash]
+	if !ok || token.revoked || !token.ExpiresAt.After(time.Now()) {
+		return db.NoSuchRefreshTokenError
+	}
+	if token.used {
+		ts.revokeWhere(func(t *storedToken) bool { return t.Family == token.Family })
+		return db.RefreshTokenReusedError
+	}
+	token.used = true
+	next.Family, next.Kind, next.PIB, next.Jmbg, next.Mfa = token.Family, token.Kind, token.PIB, token.Jmbg, token.Mfa
+	next.ClientId, next.Scope = token.ClientId, token.Scope
+	ts.tokens[next.Hash] = &storedToken{RefreshToken: *next}
+	return nil
+}
+
+func (ts *tokenStore) RevokeSession(family string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.revokeWhere(func(t *storedToken) bool { return t.Family == family })
+	return nil
+}
+
+func (ts *tokenStore) RevokeFamily(hash string, kind string, principal string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	token, ok := ts.tokens[hash]
+	if !ok || token.Kind != kind || (kind == model.SessionOfficial && token.Jmbg != principal) ||
+		(kind == model.SessionCompany && strconv.Itoa(token.PIB) != principal) {
+		return db.NoSuchRefreshTokenError
+	}
+	ts.revokeWhere(func(t *storedToken) bool { return t.Family == token.Family })
+	return nil
+}
+
+func (ts *tokenStore) IsSessionRevoked(family string) (bool, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for _, token := range ts.tokens {
+		if token.Family == family && token.revoked {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (ts *tokenStore) RevokeAll(pib int) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.revokeWhere(func(t *storedToken) bool { return t.Kind == model.SessionCompany && t.PIB == pib })
+	return nil
+}
+
+func (ts *tokenStore) RevokeActing(pib int, jmbg string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.revokeWhere(func(t *storedToken) bool { return t.Kind == model.SessionCompany && t.PIB == pib && t.Jmbg == jmbg })
+	return nil
+}
+
+func (ts *tokenStore) RevokeOfficial(jmbg string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.revokeWhere(func(t *storedToken) bool { return t.Kind == model.SessionOfficial && t.Jmbg == jmbg })
+	return nil
+}
+
+func (ts *tokenStore) ResetPassword(hash string, passwordHash string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	pib, ok := ts.resets[hash]
+	if !ok {
+		return db.NoSuchResetTokenError
+	}
+	delete(ts.resets, hash)
+	ts.passwords[pib] = passwordHash
+	ts.revokeWhere(func(t *storedToken) bool { return t.PIB == pib })
+	return nil
+}
+
+func (ts *tokenStore) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.revoked[jti] = true
+	return nil
+}
+
+func (ts *tokenStore) RevokeOnce(jti string, expiresAt time.Time) (bool, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.revoked[jti] {
+		return false, nil
+	}
+	ts.revoked[jti] = true
+	return true, nil
+}
+
+func (ts *tokenStore) IsRevoked(jti string) (bool, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.revoked[jti], nil
+}
+
+// authCompanies has company 100000001 and the liquidated company
+// 100000003, which person "1" represents
+type authCompanies struct {
+	db.CompanyRepository
+}
+
+func (ac authCompanies) FindOne(pib int) (model.Company, error) {
+	com, err := ac.FindOneAny(pib)
+	if err == nil && com.Likvidirana {
+		return model.Company{}, db.NoSuchPibError
+	}
+	return com, err
+}
+
+func (authCompanies) FindOneAny(pib int) (model.Company, error) {
+	switch pib {
+	case 100000001:
+		return model.Company{PIB: pib, Naziv: "Firma"}, nil
+	case 100000003:
+		return model.Company{PIB: pib, Naziv: "Likvidirana firma", Likvidirana: true}, nil
+	}
+	return model.Company{}, db.NoSuchPibError
+}
+
+// credentialCompanies stores the password of company 100000001 and fails
+// for company 100000002
+type credentialCompanies struct {
+	db.CompanyRepository
+	hash []byte
+}
+
+func (cc credentialCompanies) FindOneCredentials(pib int) (model.Company, error) {
+	switch pib {
+	case 100000001:
+		return model.Company{PIB: pib, Password: string(cc.hash)}, nil
+	case 100000002:
+		return model.Company{}, fmt.Errorf("Couldn't read credentials: %w", db.DatabaseError)
+	}
+	return model.Company{}, db.NoSuchPibError
+}
+
+type authAccounts struct {
+	db.PersonAccountRepository
+}
+
+func (authAccounts) FindRole(pib int, jmbg string) (string, error) {
+	if (pib == 100000001 || pib == 100000003) && jmbg == "1" {
+		return "representative", nil
+	}
+	return "", db.NotRepresentativeError
+}
+
+type authRoles struct {
+	RoleService
+}
+
+func (authRoles) CompanyRoles(pib int, actor string) []string {
+	return []string{client.RoleCompany}
+}
+
+// OfficialRoles has official "9" as a clerk
+func (authRoles) OfficialRoles(jmbg string) ([]string, error) {
+	if jmbg != "9" {
+		return nil, ErrNotOfficial
+	}
+	return []string{client.RoleClerk}, nil
+}
+
+func testJwtGenerator(t *testing.T) auth.JwtGenerator {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth.NewJwtGenerator(auth.NewKeyring(key))
+}
+
+func newTestAuthService(t *testing.T, tokens *tokenStore) authService {
+	return NewAuthService(authCompanies{}, authAccounts{}, tokens, authRoles{}, nil, testJwtGenerator(t), nil, lockout.Guard{}).(authService)
+}
+
+func TestCheckCredentials(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("lozinka"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serv := NewAuthService(credentialCompanies{hash: hash}, authAccounts{}, newTokenStore(), authRoles{}, nil, testJwtGenerator(t), nil, lockout.Guard{})
+	tests := []struct {
+		name    string
+		creds   model.CredentialsDto
+		wantErr error
+	}{
+		{"right password", model.CredentialsDto{PIB: 100000001, Password: "lozinka"}, nil},
+		// A found company used to be let in without comparing the password
+		{"wrong password", model.CredentialsDto{PIB: 100000001, Password: "pogresna"}, ErrWrongPassword},
+		{"empty password", model.CredentialsDto{PIB: 100000001}, ErrWrongPassword},
+		{"unknown company", model.CredentialsDto{PIB: 100000003, Password: "lozinka"}, db.NoSuchPibError},
+		{"database error", model.CredentialsDto{PIB: 100000002, Password: "lozinka"}, db.DatabaseError},
+	}
+	for _, tt := range tests {
+		if err := serv.CheckCredentials(tt.creds); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		pib     int
+		jmbg    string
+		mfa     bool
+		wantErr error
+	}{
+		{"company", model.SessionCompany, 100000001, "", false, nil},
+		{"company with a second factor", model.SessionCompany, 100000001, "", true, nil},
+		{"representative", model.SessionCompany, 100000001, "1", false, nil},
+		// Person 2 stopped representing the company after logging in
+		{"removed representative", model.SessionCompany, 100000001, "2", false, db.NoSuchRefreshTokenError},
+		// Refreshing used to fail with a server error after the rotation
+		{"liquidated company", model.SessionCompany, 100000003, "", false, db.NoSuchRefreshTokenError},
+		{"deleted company", model.SessionCompany, 100000004, "", false, db.NoSuchRefreshTokenError},
+		{"representative of a liquidated company", model.SessionCompany, 100000003, "1", false, db.NoSuchRefreshTokenError},
+		{"official", model.SessionOfficial, 0, "9", false, nil},
+		// Official 8 lost their roles after logging in
+		{"official without roles", model.SessionOfficial, 0, "8", false, db.NoSuchRefreshTokenError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := newTokenStore()
+			serv := newTestAuthService(t, tokens)
+			// Started directly, the roles may have been lost since
+			refreshToken, session, err := newRefreshToken(model.RefreshToken{Family: "f", Kind: tt.kind, PIB: tt.pib, Jmbg: tt.jmbg, Mfa: tt.mfa})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens.SaveRefreshToken(session)
+
+			next, err := serv.Refresh(refreshToken, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				// The session is over, not just this refresh
+				for _, token := range tokens.tokens {
+					if !token.revoked {
+						t.Errorf("token of %s is still valid", token.Jmbg)
+					}
+				}
+				return
+			}
+			if next.RefreshToken == refreshToken {
+				t.Error("refresh token wasn't rotated")
+			}
+			claims, err := client.ValidateToken(serv.jwtGen, next.Jwt, client.Apr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.HasAmr(client.AmrOtp) != tt.mfa {
+				t.Errorf("refreshed token has amr %v, session had a second factor: %v", claims.Amr, tt.mfa)
+			}
+			subject, actor := strconv.Itoa(tt.pib), tt.jmbg
+			if tt.kind == model.SessionOfficial {
+				subject, actor = tt.jmbg, ""
+			}
+			gotActor := ""
+			if claims.Act != nil {
+				gotActor = claims.Act.Subject
+			}
+			if claims.Subject != subject || gotActor != actor {
+				t.Errorf("refreshed token is %q acting as %q, want %q acting as %q", claims.Subject, gotActor, subject, actor)
+			}
+		})
+	}
+}
+
+func TestCompanyStatus(t *testing.T) {
+	serv := newTestAuthService(t, newTokenStore())
+	for pib, want := range map[int]string{100000001: client.CompanyActive, 100000003: client.CompanyLiquidated} {
+		tokens, err := serv.IssueTokens(pib)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := client.ValidateToken(serv.jwtGen, tokens.Jwt, client.Apr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims.Company == nil || claims.Company.Status != want {
+			t.Errorf("%d: got company %+v, want status %s", pib, claims.Company, want)
+		}
+	}
+}
+
+func TestRefreshReuse(t *testing.T) {
+	tokens := newTokenStore()
+	serv := newTestAuthService(t, tokens)
+	first, err := serv.IssueTokens(100000001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := serv.Refresh(first.RefreshToken, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		// Whoever uses a rotated token again may have stolen it
+		{"reused token", first.RefreshToken, db.RefreshTokenReusedError},
+		// which ends the session of the legitimate holder too
+		{"token of the revoked family", second.RefreshToken, db.NoSuchRefreshTokenError},
+		{"unknown token", "unknown", db.NoSuchRefreshTokenError},
+	}
+	for _, tt := range tests {
+		if _, err := serv.Refresh(tt.token, ""); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestClientTokens(t *testing.T) {
+	tokens := newTokenStore()
+	serv := newTestAuthService(t, tokens)
+	code := model.AuthorizationCode{ClientId: "portal", PIB: 100000001, Scope: "openid profile"}
+
+	online, issued, err := serv.IssueClientTokens(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if online.RefreshToken != "" || issued.Family != "" {
+		t.Error("refresh token issued without offline_access")
+	}
+	// Clients can't call APR's API with the company's rights
+	if _, err := client.ValidateToken(serv.jwtGen, online.Jwt, client.Apr); err == nil {
+		t.Error("client token is accepted by APR")
+	}
+	for _, aud := range []string{"portal", UserInfoAudience} {
+		claims, err := client.ValidateToken(serv.jwtGen, online.Jwt, aud)
+		if err != nil {
+			t.Fatalf("%s: %v", aud, err)
+		}
+		if claims.ID != issued.Jti || claims.Scope != code.Scope || len(claims.Roles) != 1 || !claims.HasRole(client.RoleCompany) {
+			t.Errorf("%s: got jti %q, scope %q and roles %v", aud, claims.ID, claims.Scope, claims.Roles)
+		}
+	}
+
+	code.Scope = "openid offline_access"
+	offline, issued, err := serv.IssueClientTokens(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offline.RefreshToken == "" || issued.Family == "" {
+		t.Fatal("no refresh token issued for offline_access")
+	}
+	next, err := serv.Refresh(offline.RefreshToken, "portal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ValidateToken(serv.jwtGen, next.Jwt, "portal"); err != nil {
+		t.Errorf("refreshed token isn't for the client: %v", err)
+	}
+	// Only the client may refresh, the session ends otherwise
+	if _, err := serv.Refresh(next.RefreshToken, ""); !errors.Is(err, db.NoSuchRefreshTokenError) {
+		t.Errorf("refreshed at APR: got %v, want %v", err, db.NoSuchRefreshTokenError)
+	}
+	for _, token := range tokens.tokens {
+		if !token.revoked {
+			t.Error("session of the client is still valid")
+		}
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	tokens := newTokenStore()
+	serv := newTestAuthService(t, tokens)
+	session, err := serv.IssueTokens(100000001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens.resets[hashToken("reset")] = 100000001
+
+	tests := []struct {
+		name     string
+		token    string
+		password string
+		wantErr  error
+	}{
+		// A rejected password doesn't use up the token
+		{"short password", "reset", "kratka", ErrInvalidPassword},
+		{"unknown token", "unknown", "nova lozinka 123", db.NoSuchResetTokenError},
+		{"reset", "reset", "nova lozinka 123", nil},
+		{"used token", "reset", "druga lozinka 123", db.NoSuchResetTokenError},
+	}
+	for _, tt := range tests {
+		if err := serv.ResetPassword(tt.token, tt.password); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if bcrypt.CompareHashAndPassword([]byte(tokens.passwords[100000001]), []byte("nova lozinka 123")) != nil {
+		t.Error("password wasn't set")
+	}
+	if _, err := serv.Refresh(session.RefreshToken, ""); !errors.Is(err, db.NoSuchRefreshTokenError) {
+		t.Errorf("session before the reset can still be refreshed: %v", err)
+	}
+}
+
+// barrierMfa accepts every code, once both of two concurrent completions
+// have checked theirs
+type barrierMfa struct {
+	MfaService
+	checked *sync.WaitGroup
+}
+
+func (bm barrierMfa) Verify(pib int, code string) error {
+	bm.checked.Done()
+	bm.checked.Wait()
+	return nil
+}
+
+func TestCompleteLoginOnce(t *testing.T) {
+	tokens := newTokenStore()
+	checked := &sync.WaitGroup{}
+	checked.Add(2)
+	guard := lockout.NewGuard(lockout.NewMemoryStore(), nil, lockout.DefaultPibPolicy, lockout.DefaultIpPolicy)
+	serv := NewAuthService(authCompanies{}, authAccounts{}, tokens, authRoles{}, barrierMfa{checked: checked}, testJwtGenerator(t), nil, guard).(authService)
+	challenge, err := serv.challenge(100000001)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A TOTP code and a recovery code sent at the same time
+	errs := make(chan error, 2)
+	for _, code := range []string{"123456", "abcd-efgh"} {
+		go func(code string) {
+			_, err := serv.CompleteLogin(challenge.Challenge, code, "127.0.0.1")
+			errs <- err
+		}(code)
+	}
+	completed := 0
+	for i := 0; i < 2; i++ {
+		err := <-errs
+		switch {
+		case err == nil:
+			completed++
+		case !errors.Is(err, ErrInvalidChallenge):
+			t.Errorf("got %v, want %v", err, ErrInvalidChallenge)
+		}
+	}
+	if completed != 1 {
+		t.Errorf("challenge was completed %d times, want once", completed)
+	}
+}
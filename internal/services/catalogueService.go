@@ -0,0 +1,161 @@
+package services
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/auth"
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidService = errors.New("Invalid service")
+var ErrServiceForbidden = errors.New("Tokens for this service aren't issued to the caller")
+var ErrAudienceTaken = errors.New("Audience belongs to another service")
+
+var validServiceName = regexp.MustCompile("^[a-z0-9][a-z0-9._-]{0,63}$")
+
+// Audiences of APR itself, tokens for them are only issued by logging in
+var reservedAudiences = []string{client.Apr, client.AprPerson, ssoAudience, mfaAudience, UserInfoAudience}
+
+type CatalogueService interface {
+	FindAll() ([]model.RegisteredService, error)
+	// Registers service, ErrAudienceTaken if another service has one of
+	// its audiences
+	Save(service *model.RegisteredService) error
+	// Changes service, ErrAudienceTaken like Save
+	Update(service *model.RegisteredService) error
+	Delete(name string) error
+	// Issues a token for the service called name to whoever claims were
+	// issued to. Unknown services are db.NoSuchServiceError, callers the
+	// service doesn't allow ErrServiceForbidden.
+	Issue(claims client.TokenClaims, name string) (string, error)
+}
+
+func NewCatalogueService(serviceRepo db.ServiceRepository, jwtGen auth.JwtGenerator) CatalogueService {
+	return catalogueService{serviceRepo: serviceRepo, jwtGen: jwtGen}
+}
+
+type catalogueService struct {
+	serviceRepo db.ServiceRepository
+	jwtGen      auth.JwtGenerator
+}
+
+func validateService(service model.RegisteredService) error {
+	if !validServiceName.MatchString(service.Name) {
+		return fmt.Errorf("%w: name must be lowercase letters, digits, '.', '_' or '-'", ErrInvalidService)
+	}
+	for _, aud := range service.Audiences {
+		if contains(reservedAudiences, aud) {
+			return fmt.Errorf("%w: audience %s is reserved", ErrInvalidService, aud)
+		}
+	}
+	for _, role := range service.AllowedRoles {
+		if role != client.RoleCompany && !officialRoles[role] {
+			return fmt.Errorf("%w: unknown role %s", ErrInvalidService, role)
+		}
+	}
+	return nil
+}
+
+// checkAudiences returns ErrAudienceTaken if another service has one of the
+// audiences of service. Its tokens would be accepted by that service, to
+// callers it may not allow.
+func (cs catalogueService) checkAudiences(service model.RegisteredService) error {
+	registered, err := cs.serviceRepo.FindAll()
+	if err != nil {
+		return err
+	}
+	for _, other := range registered {
+		if other.Name == service.Name {
+			continue
+		}
+		for _, aud := range service.Audiences {
+			if contains(other.Audiences, aud) {
+				return fmt.Errorf("%w: %s has audience %s", ErrAudienceTaken, other.Name, aud)
+			}
+		}
+	}
+	return nil
+}
+
+// FindAll implements CatalogueService
+func (cs catalogueService) FindAll() ([]model.RegisteredService, error) {
+	return cs.serviceRepo.FindAll()
+}
+
+// Save implements CatalogueService
+func (cs catalogueService) Save(service *model.RegisteredService) error {
+	if err := validateService(*service); err != nil {
+		return err
+	}
+	if err := cs.checkAudiences(*service); err != nil {
+		return err
+	}
+	return cs.serviceRepo.Save(service)
+}
+
+// Update implements CatalogueService
+func (cs catalogueService) Update(service *model.RegisteredService) error {
+	if err := validateService(*service); err != nil {
+		return err
+	}
+	if err := cs.checkAudiences(*service); err != nil {
+		return err
+	}
+	return cs.serviceRepo.Update(service)
+}
+
+// Delete implements CatalogueService
+func (cs catalogueService) Delete(name string) error {
+	return cs.serviceRepo.Delete(name)
+}
+
+// allows tells whether service may get a token of claims
+func allows(service model.RegisteredService, claims client.TokenClaims) bool {
+	allowedRole := false
+	for _, role := range service.AllowedRoles {
+		allowedRole = allowedRole || claims.HasRole(role)
+	}
+	if !allowedRole {
+		return false
+	}
+	if len(service.AllowedPibs) == 0 || !claims.HasRole(client.RoleCompany) {
+		return true
+	}
+	pib, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range service.AllowedPibs {
+		if allowed == pib {
+			return true
+		}
+	}
+	return false
+}
+
+// Issue implements CatalogueService
+func (cs catalogueService) Issue(claims client.TokenClaims, name string) (string, error) {
+	service, err := cs.serviceRepo.FindOne(name)
+	if err != nil {
+		return "", err
+	}
+	if !allows(service, claims) {
+		return "", fmt.Errorf("%s: %w", name, ErrServiceForbidden)
+	}
+	return cs.jwtGen.GenerateServiceJWT(auth.Identity{
+		Subject: claims.Subject,
+		Actor:   claims.ActingPerson(),
+		Roles:   claims.Roles,
+		Company: claims.Company,
+		Scopes:  claims.Scopes(),
+		// Services may require the second factor too
+		Amr: claims.Amr,
+		// Ends with the session it was issued in
+		Session: claims.Sid,
+	}, service.Audiences, time.Duration(service.TokenLifetime)*time.Second)
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"errors"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// serviceCatalogue has service eporezi with audiences eporezi and
+// eporezi-api
+type serviceCatalogue struct {
+	db.ServiceRepository
+}
+
+func (serviceCatalogue) FindAll() ([]model.RegisteredService, error) {
+	return []model.RegisteredService{{Name: "eporezi", Audiences: []string{"eporezi", "eporezi-api"}, AllowedRoles: []string{client.RoleCompany}}}, nil
+}
+
+func (serviceCatalogue) FindOne(name string) (model.RegisteredService, error) {
+	return model.RegisteredService{Name: name, Audiences: []string{name}, AllowedRoles: []string{client.RoleCompany}, TokenLifetime: 300}, nil
+}
+
+func (serviceCatalogue) Save(service *model.RegisteredService) error {
+	return nil
+}
+
+func (serviceCatalogue) Update(service *model.RegisteredService) error {
+	return nil
+}
+
+func TestServiceAudiences(t *testing.T) {
+	serv := NewCatalogueService(serviceCatalogue{}, nil)
+	tests := []struct {
+		name    string
+		service model.RegisteredService
+		update  bool
+		wantErr error
+	}{
+		{"new audience", model.RegisteredService{Name: "ecarina", Audiences: []string{"ecarina"}}, false, nil},
+		// Tokens for ecarina would be accepted by eporezi
+		{"audience of another service", model.RegisteredService{Name: "ecarina", Audiences: []string{"ecarina", "eporezi-api"}}, false, ErrAudienceTaken},
+		{"audience of another service on update", model.RegisteredService{Name: "ecarina", Audiences: []string{"eporezi"}}, true, ErrAudienceTaken},
+		{"own audiences on update", model.RegisteredService{Name: "eporezi", Audiences: []string{"eporezi-api"}}, true, nil},
+		{"audience of APR", model.RegisteredService{Name: "ecarina", Audiences: []string{client.Apr}}, false, ErrInvalidService},
+		{"audience of userinfo", model.RegisteredService{Name: "ecarina", Audiences: []string{UserInfoAudience}}, false, ErrInvalidService},
+	}
+	for _, tt := range tests {
+		service := tt.service
+		service.AllowedRoles = []string{client.RoleCompany}
+		save := serv.Save
+		if tt.update {
+			save = serv.Update
+		}
+		if err := save(&service); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestIssueAmr(t *testing.T) {
+	serv := NewCatalogueService(serviceCatalogue{}, testJwtGenerator(t))
+	claims := client.TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "100000001"},
+		Roles:            []string{client.RoleCompany},
+		Amr:              []string{client.AmrPassword, client.AmrOtp},
+		Sid:              "f",
+	}
+	token, err := serv.Issue(claims, "eporezi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var issued client.TokenClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &issued); err != nil {
+		t.Fatal(err)
+	}
+	// Services which require the second factor check the amr of the token
+	if !issued.HasAmr(client.AmrOtp) || issued.Sid != "f" {
+		t.Errorf("got amr %v and sid %q, want those of the session", issued.Amr, issued.Sid)
+	}
+}
@@ -3,7 +3,9 @@ package services
 import (
 	"apr-backend/internal/db"
 	"apr-backend/internal/model"
+	"errors"
 	"fmt"
+	"log"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -11,10 +13,21 @@ import (
 type CompanyService interface {
 	SaveCompany(com *model.Company) error
 	FindCompanies(filter model.CompanyFilter) ([]model.Company, error)
+	// Calls fn with every masked company matching filter, page is ignored.
+	ExportCompanies(filter model.CompanyFilter, fn func(model.Company) error) error
 	FindOne(pib int) (model.Company, error)
-	LiquidateById(pib int) error
+	// Finds many companies at once, PIBs which don't exist are skipped
+	FindMany(pibs []int) ([]model.Company, error)
+	// Liquidates pib on behalf of the person with JMBG actor, who has to be
+	// its owner. An empty actor is the company itself.
+	LiquidateById(pib int, actor string) error
+	// Liquidates pib by decision of the registrar official with JMBG
+	// official, regardless of who owns it
+	ForceLiquidate(pib int, official string) error
 }
 
+var ErrNotOwner = errors.New("Only the owner of the company can do this")
+
 const passwordCost = 12
 
 func NewCompanyService(comRepo db.CompanyRepository) CompanyService {
@@ -28,24 +41,84 @@ type companyService struct {
 }
 
 // LiquidateById implements CompanyService
-func (cs companyService) LiquidateById(pib int) error {
-	return cs.comRepo.LiquidateById(pib)
+func (cs companyService) LiquidateById(pib int, actor string) error {
+	if actor != "" {
+		com, err := cs.comRepo.FindOne(pib)
+		if err != nil {
+			return err
+		}
+		if com.Vlasnik.Jmbg != actor {
+			return fmt.Errorf("%s can't liquidate %d: %w", actor, pib, ErrNotOwner)
+		}
+	}
+	if err := cs.comRepo.LiquidateById(pib, actor); err != nil {
+		return err
+	}
+	if actor != "" {
+		log.Printf("Company %d liquidated by %s", pib, actor)
+	}
+	return nil
+}
+
+// ForceLiquidate implements CompanyService
+func (cs companyService) ForceLiquidate(pib int, official string) error {
+	if err := cs.comRepo.LiquidateById(pib, official); err != nil {
+		return err
+	}
+	log.Printf("Company %d liquidated by official %s", pib, official)
+	return nil
 }
 
 // FindCompanies implements CompanyService
 func (cs companyService) FindCompanies(filter model.CompanyFilter) ([]model.Company, error) {
-	return cs.comRepo.FindCompanies(filter)
+	companies, err := cs.comRepo.FindCompanies(filter)
+	for i := range companies {
+		companies[i] = companies[i].Masked()
+	}
+	return companies, err
 }
 
-func (cs companyService) SaveCompany(com *model.Company) error {
-	pass, err := bcrypt.GenerateFromPassword([]byte(com.Password), passwordCost)
+// ExportCompanies implements CompanyService
+func (cs companyService) ExportCompanies(filter model.CompanyFilter, fn func(model.Company) error) error {
+	return cs.comRepo.StreamCompanies(filter, func(com model.Company) error {
+		return fn(com.Masked())
+	})
+}
+
+func bcryptHash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
 	if err != nil {
-		return fmt.Errorf("Error generating password: %w", err)
+		return "", fmt.Errorf("Error generating password: %w", err)
+	}
+	return string(hash), nil
+}
+
+func hashPassword(com *model.Company) error {
+	pass, err := bcryptHash(com.Password)
+	if err != nil {
+		return err
+	}
+	com.Password = pass
+	return nil
+}
+
+func (cs companyService) SaveCompany(com *model.Company) error {
+	if err := hashPassword(com); err != nil {
+		return err
 	}
-	com.Password = string(pass)
 	return cs.comRepo.SaveCompany(com)
 }
 
 func (cs companyService) FindOne(pib int) (model.Company, error) {
-	return cs.comRepo.FindOne(pib)
+	company, err := cs.comRepo.FindOne(pib)
+	return company.Masked(), err
+}
+
+// FindMany implements CompanyService
+func (cs companyService) FindMany(pibs []int) ([]model.Company, error) {
+	companies, err := cs.comRepo.FindMany(pibs)
+	for i := range companies {
+		companies[i] = companies[i].Masked()
+	}
+	return companies, err
 }
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 10 random bytes, printed as 16 base32 characters
+const extractCodeBytes = 10
+
+var extractEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type ExtractService interface {
+	// Issues an extract with the current data of a company, liquidated
+	// companies included, and records it under a new verification code
+	Issue(pib int) (model.Extract, error)
+	// Looks up an issued extract and checks it against the current data
+	Verify(code string) (model.ExtractVerification, error)
+}
+
+func NewExtractService(extractRepo db.ExtractRepository, comRepo db.CompanyRepository) ExtractService {
+	return extractService{
+		extractRepo: extractRepo,
+		comRepo:     comRepo,
+	}
+}
+
+type extractService struct {
+	extractRepo db.ExtractRepository
+	comRepo     db.CompanyRepository
+}
+
+// Issue implements ExtractService
+func (es extractService) Issue(pib int) (model.Extract, error) {
+	company, err := es.comRepo.FindOneAny(pib)
+	if err != nil {
+		return model.Extract{}, err
+	}
+	code := make([]byte, extractCodeBytes)
+	if _, err := rand.Read(code); err != nil {
+		return model.Extract{}, fmt.Errorf("Error generating extract code: %w", err)
+	}
+	ext := model.Extract{
+		Code:     extractEncoding.EncodeToString(code),
+		IssuedAt: time.Now().UTC().Truncate(time.Second),
+		Company:  company.Masked(),
+	}
+	if err := es.extractRepo.Save(ext); err != nil {
+		return model.Extract{}, err
+	}
+	return ext, nil
+}
+
+// Verify implements ExtractService
+func (es extractService) Verify(code string) (model.ExtractVerification, error) {
+	ext, err := es.extractRepo.FindOne(strings.ToUpper(strings.TrimSpace(code)))
+	if err != nil {
+		return model.ExtractVerification{}, err
+	}
+	current, err := es.comRepo.FindOneAny(ext.Company.PIB)
+	if err != nil && !errors.Is(err, db.NoSuchPibError) {
+		return model.ExtractVerification{}, err
+	}
+	// Extracts issued before owners' JMBGs were masked still hold them
+	return model.ExtractVerification{
+		Code:     ext.Code,
+		IssuedAt: ext.IssuedAt,
+		Company:  ext.Company.Masked(),
+		Current:  err == nil && current.Masked() == ext.Company.Masked(),
+	}, nil
+}
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+var ErrInvalidImportMode = errors.New("Import mode must be one of dry-run, atomic or chunked")
+var ErrImportMismatch = errors.New("File doesn't match the import job being resumed")
+var ErrImportFinished = errors.New("Import job has already finished")
+var ErrImportRunning = errors.New("Import job is still running")
+
+const importChunkSize = 500
+
+type ImportService interface {
+	// Imports parsed rows of a file with the given checksum. Rows which
+	// already carry binding errors are reported and never saved. Dry runs
+	// are checked right away, atomic and chunked imports start a job which
+	// runs in the background. resumeJob continues a chunked job which
+	// didn't finish and is ignored when 0.
+	Import(rows []model.ImportRow, checksum string, mode string, resumeJob int) (model.ImportReport, error)
+	FindJob(id int) (model.ImportJob, error)
+}
+
+func NewImportService(importRepo db.ImportRepository) ImportService {
+	return importService{importRepo: importRepo, running: &sync.Map{}}
+}
+
+type importService struct {
+	importRepo db.ImportRepository
+	// Ids of the jobs running in this process
+	running *sync.Map
+}
+
+// FindJob implements ImportService
+func (is importService) FindJob(id int) (model.ImportJob, error) {
+	return is.importRepo.FindJob(id)
+}
+
+func importErrors(err error) map[string]string {
+	switch {
+	case errors.Is(err, db.NoSuchJmbgError):
+		return map[string]string{"Vlasnik": "Person with this JMBG doesn't exist"}
+	case errors.Is(err, db.NoSuchNstjError):
+		return map[string]string{"Sediste": "NSTJ with this oznaka doesn't exist"}
+	}
+	return map[string]string{"error": err.Error()}
+}
+
+// validRows returns the companies of the rows without binding errors, with
+// their rows, and the errors of the others
+func validRows(rows []model.ImportRow) ([]*model.Company, []int, []model.ImportRowError) {
+	coms := make([]*model.Company, 0, len(rows))
+	comRows := make([]int, 0, len(rows))
+	rowErrors := []model.ImportRowError{}
+	for _, row := range rows {
+		if len(row.Errors) > 0 {
+			rowErrors = append(rowErrors, model.ImportRowError{Row: row.Row, Errors: row.Errors})
+			continue
+		}
+		com := row.Company
+		coms = append(coms, &com)
+		comRows = append(comRows, row.Row)
+	}
+	return coms, comRows, rowErrors
+}
+
+// checkRows checks rows against the database without saving them
+func (is importService) checkRows(report *model.ImportReport, rows []model.ImportRow) error {
+	coms, comRows, rowErrors := validRows(rows)
+	for _, com := range coms {
+		// Nothing will be saved, so don't spend time hashing
+		com.Password = ""
+	}
+	errs, err := is.importRepo.CheckChunk(coms)
+	if err != nil {
+		return err
+	}
+	for i, err := range errs {
+		if err != nil {
+			rowErrors = append(rowErrors, model.ImportRowError{Row: comRows[i], Errors: importErrors(err)})
+		}
+	}
+	report.Errors = append(report.Errors, rowErrors...)
+	return nil
+}
+
+// saveRows tries to save the valid rows among rows. commit receives the
+// number of saved companies and whether any row failed, and decides whether
+// they are kept. It returns the errors of the rows and whether the rows
+// were committed.
+func (is importService) saveRows(rows []model.ImportRow, job *model.ImportJob, commit func(saved int, failed bool) bool) ([]model.ImportRowError, bool, error) {
+	coms, comRows, rowErrors := validRows(rows)
+	for _, com := range coms {
+		if err := hashPassword(com); err != nil {
+			return nil, false, err
+		}
+	}
+
+	_, committed, err := is.importRepo.SaveChunk(job, coms, func(errs []error) ([]model.ImportRowError, bool) {
+		saved := 0
+		for i, err := range errs {
+			if err == nil {
+				saved++
+			} else {
+				rowErrors = append(rowErrors, model.ImportRowError{Row: comRows[i], Errors: importErrors(err)})
+			}
+		}
+		return rowErrors, commit(saved, len(rowErrors) > 0)
+	})
+	return rowErrors, committed, err
+}
+
+// Import implements ImportService
+func (is importService) Import(rows []model.ImportRow, checksum string, mode string, resumeJob int) (model.ImportReport, error) {
+	report := model.ImportReport{
+		Mode:   mode,
+		Rows:   len(rows),
+		Errors: []model.ImportRowError{},
+	}
+
+	switch mode {
+	case model.ImportDryRun:
+		err := is.checkRows(&report, rows)
+		return report, err
+	case model.ImportAtomic, model.ImportChunked:
+	default:
+		return report, ErrInvalidImportMode
+	}
+
+	var job model.ImportJob
+	if resumeJob != 0 {
+		var err error
+		job, err = is.importRepo.FindJob(resumeJob)
+		if err != nil {
+			return report, err
+		}
+		if job.Mode != model.ImportChunked || mode != model.ImportChunked || job.Checksum != checksum || job.Total != len(rows) {
+			return report, fmt.Errorf("%w: job %d", ErrImportMismatch, job.Id)
+		}
+		if job.Finished {
+			return report, fmt.Errorf("%w: job %d", ErrImportFinished, job.Id)
+		}
+		job.Error = ""
+	} else {
+		job = model.ImportJob{
+			Mode:      mode,
+			Checksum:  checksum,
+			Total:     len(rows),
+			CreatedAt: time.Now().UTC().Truncate(time.Second),
+			Errors:    []model.ImportRowError{},
+		}
+		if err := is.importRepo.CreateJob(&job); err != nil {
+			return report, err
+		}
+	}
+	if _, running := is.running.LoadOrStore(job.Id, true); running {
+		return report, fmt.Errorf("%w: job %d", ErrImportRunning, job.Id)
+	}
+	report.Job = &job
+
+	go is.run(job, rows)
+	return report, nil
+}
+
+// run imports rows in the background, recording why the job stopped if it
+// didn't finish
+func (is importService) run(job model.ImportJob, rows []model.ImportRow) {
+	defer is.running.Delete(job.Id)
+
+	var err error
+	if job.Mode == model.ImportAtomic {
+		err = is.runAtomic(&job, rows)
+	} else {
+		err = is.runChunked(&job, rows)
+	}
+	if err != nil {
+		log.Printf("Import job %d stopped: %s", job.Id, err.Error())
+		job.Error = "Import stopped after " + fmt.Sprint(job.RowsDone) + " rows because of an internal error"
+		if err := is.importRepo.UpdateJob(&job, nil); err != nil {
+			log.Printf("Error recording failure of import job %d: %s", job.Id, err.Error())
+		}
+	}
+}
+
+// runAtomic saves every row or, if any row fails, none of them
+func (is importService) runAtomic(job *model.ImportJob, rows []model.ImportRow) error {
+	rowErrors, committed, err := is.saveRows(rows, job, func(saved int, failed bool) bool {
+		if failed {
+			return false
+		}
+		job.RowsDone = len(rows)
+		job.Imported = saved
+		job.Finished = true
+		return true
+	})
+	if err != nil || committed {
+		return err
+	}
+	job.RowsDone = len(rows)
+	job.Finished = true
+	job.Error = fmt.Sprintf("Nothing was imported because %d rows have errors", len(rowErrors))
+	return is.importRepo.UpdateJob(job, rowErrors)
+}
+
+// runChunked commits rows in chunks, continuing after the rows the job
+// already did
+func (is importService) runChunked(job *model.ImportJob, rows []model.ImportRow) error {
+	for start := job.RowsDone; start < len(rows) || !job.Finished; start += importChunkSize {
+		end := start + importChunkSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		progress := *job
+		_, _, err := is.saveRows(rows[start:end], &progress, func(saved int, _ bool) bool {
+			progress.RowsDone = end
+			progress.Imported += saved
+			progress.Finished = end == len(rows)
+			progress.Error = ""
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		*job = progress
+	}
+	return nil
+}
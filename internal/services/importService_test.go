@@ -0,0 +1,205 @@
+package services
+
+import (
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// importStore keeps import jobs in memory. Companies owned by JMBG
+// "missing" fail, like unknown owners in the database.
+type importStore struct {
+	mu      sync.Mutex
+	jobs    map[int]model.ImportJob
+	saved   int
+	inserts int
+}
+
+func (is *importStore) CreateJob(job *model.ImportJob) error {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	job.Id = len(is.jobs) + 1
+	is.jobs[job.Id] = *job
+	return nil
+}
+
+func (is *importStore) FindJob(id int) (model.ImportJob, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	job, ok := is.jobs[id]
+	if !ok {
+		return job, db.NoSuchImportJobError
+	}
+	return job, nil
+}
+
+func (is *importStore) UpdateJob(job *model.ImportJob, rowErrors []model.ImportRowError) error {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	stored := *job
+	stored.Errors = append(is.jobs[job.Id].Errors, rowErrors...)
+	is.jobs[job.Id] = stored
+	return nil
+}
+
+func checkCompany(com *model.Company) error {
+	if com.Vlasnik.Jmbg == "missing" {
+		return db.NoSuchJmbgError
+	}
+	return nil
+}
+
+func (is *importStore) SaveChunk(job *model.ImportJob, coms []*model.Company, commit func(errs []error) ([]model.ImportRowError, bool)) ([]error, bool, error) {
+	errs := make([]error, len(coms))
+	for i, com := range coms {
+		errs[i] = checkCompany(com)
+	}
+	is.mu.Lock()
+	is.inserts += len(coms)
+	is.mu.Unlock()
+	rowErrors, ok := commit(errs)
+	if !ok {
+		return errs, false, nil
+	}
+	for _, err := range errs {
+		if err == nil {
+			is.saved++
+		}
+	}
+	if job != nil {
+		is.UpdateJob(job, rowErrors)
+	}
+	return errs, true, nil
+}
+
+func (is *importStore) CheckChunk(coms []*model.Company) ([]error, error) {
+	errs := make([]error, len(coms))
+	for i, com := range coms {
+		errs[i] = checkCompany(com)
+	}
+	return errs, nil
+}
+
+func importRows(owners ...string) []model.ImportRow {
+	rows := make([]model.ImportRow, len(owners))
+	for i, owner := range owners {
+		rows[i] = model.ImportRow{Row: i + 2, Company: model.Company{Vlasnik: model.Person{Jmbg: owner}, Password: "long enough password"}}
+		if owner == "" {
+			rows[i].Errors = map[string]string{"Vlasnik": "Vlasnik is required"}
+		}
+	}
+	return rows
+}
+
+// waitForJob waits until the background job of serv is done
+func waitForJob(t *testing.T, serv importService, id int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Minute); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, running := serv.running.Load(id); !running {
+			return
+		}
+	}
+	t.Fatalf("import job %d didn't finish", id)
+}
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		owners     []string
+		wantErrors []int
+		wantSaved  int
+		// Whether rows reach the database as inserts, dry runs only read
+		wantInserts bool
+		wantJobErr  bool
+	}{
+		{"dry run", model.ImportDryRun, []string{"1", "missing", ""}, []int{4, 3}, 0, false, false},
+		{"atomic", model.ImportAtomic, []string{"1", "2"}, nil, 2, true, false},
+		{"atomic with a failing row", model.ImportAtomic, []string{"1", "missing"}, []int{3}, 0, true, true},
+		{"chunked", model.ImportChunked, []string{"1", "missing", "", "2"}, []int{4, 3}, 2, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &importStore{jobs: map[int]model.ImportJob{}}
+			serv := NewImportService(store).(importService)
+			report, err := serv.Import(importRows(tt.owners...), "checksum", tt.mode, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rowErrors := report.Errors
+			if report.Job != nil {
+				waitForJob(t, serv, report.Job.Id)
+				job, _ := store.FindJob(report.Job.Id)
+				if !job.Finished || job.RowsDone != len(tt.owners) {
+					t.Errorf("job finished %v after %d rows, want finished after %d", job.Finished, job.RowsDone, len(tt.owners))
+				}
+				if (job.Error != "") != tt.wantJobErr {
+					t.Errorf("job error %q", job.Error)
+				}
+				if job.Imported != tt.wantSaved {
+					t.Errorf("job imported %d, want %d", job.Imported, tt.wantSaved)
+				}
+				rowErrors = job.Errors
+			}
+
+			if len(rowErrors) != len(tt.wantErrors) {
+				t.Fatalf("got errors %v, want rows %v", rowErrors, tt.wantErrors)
+			}
+			for i, row := range tt.wantErrors {
+				if rowErrors[i].Row != row {
+					t.Errorf("error %d is for row %d, want %d", i, rowErrors[i].Row, row)
+				}
+			}
+			if store.saved != tt.wantSaved {
+				t.Errorf("saved %d companies, want %d", store.saved, tt.wantSaved)
+			}
+			if (store.inserts > 0) != tt.wantInserts {
+				t.Errorf("%d companies were inserted", store.inserts)
+			}
+		})
+	}
+}
+
+func TestResumeImport(t *testing.T) {
+	store := &importStore{jobs: map[int]model.ImportJob{}}
+	serv := NewImportService(store).(importService)
+	rows := importRows("1", "2", "3")
+	store.jobs[1] = model.ImportJob{Id: 1, Mode: model.ImportChunked, Checksum: "checksum", Total: 3, RowsDone: 1, Imported: 1}
+	store.jobs[2] = model.ImportJob{Id: 2, Mode: model.ImportAtomic, Checksum: "checksum", Total: 3}
+
+	tests := []struct {
+		name    string
+		job     int
+		wantErr error
+	}{
+		{"other file", 1, ErrImportMismatch},
+		{"atomic job", 2, ErrImportMismatch},
+		{"chunked job", 1, nil},
+		{"finished job", 1, ErrImportFinished},
+	}
+	for _, tt := range tests {
+		checksum := "checksum"
+		if tt.name == "other file" {
+			checksum = "other"
+		}
+		report, err := serv.Import(rows, checksum, model.ImportChunked, tt.job)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		waitForJob(t, serv, report.Job.Id)
+		job, _ := store.FindJob(tt.job)
+		if !job.Finished || job.Imported != 3 || store.saved != 2 {
+			t.Errorf("%s: job %+v after saving %d companies, want 2 more saved", tt.name, job, store.saved)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"apr-backend/internal/ratelimit"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var ErrInvalidServiceCredentials = errors.New("Invalid service credentials")
+
+type IntrospectionService interface {
+	// Generates a new introspection secret for the service called name,
+	// the old one stops working
+	RotateSecret(name string) (model.IntrospectionSecret, error)
+	// Introspects token for the service called name, which authenticates
+	// with secret. Services only learn about tokens issued for their
+	// audiences, other tokens are inactive for them, as are revoked tokens
+	// and tokens of ended sessions. The company status is the current one.
+	// Services calling too often get a ratelimit.LimitError.
+	Introspect(name, secret, token string) (model.Introspection, error)
+}
+
+// limiter is shared by every service, keyed by the service name
+func NewIntrospectionService(serviceRepo db.ServiceRepository, tokenRepo db.TokenRepository, comRepo db.CompanyRepository, verifier client.JwtVerifier, limiter *ratelimit.Limiter) IntrospectionService {
+	return introspectionService{serviceRepo: serviceRepo, tokenRepo: tokenRepo, comRepo: comRepo, verifier: verifier, limiter: limiter}
+}
+
+type introspectionService struct {
+	serviceRepo db.ServiceRepository
+	tokenRepo   db.TokenRepository
+	comRepo     db.CompanyRepository
+	verifier    client.JwtVerifier
+	limiter     *ratelimit.Limiter
+}
+
+// RotateSecret implements IntrospectionService
+func (is introspectionService) RotateSecret(name string) (model.IntrospectionSecret, error) {
+	secret, hash, err := randomToken()
+	if err != nil {
+		return model.IntrospectionSecret{}, err
+	}
+	if err := is.serviceRepo.SetIntrospectionSecret(name, hash); err != nil {
+		return model.IntrospectionSecret{}, err
+	}
+	return model.IntrospectionSecret{Service: name, Secret: secret}, nil
+}
+
+func (is introspectionService) authenticate(name, secret string) (model.RegisteredService, error) {
+	hash, err := is.serviceRepo.FindIntrospectionSecret(name)
+	if errors.Is(err, db.NoSuchServiceError) {
+		return model.RegisteredService{}, ErrInvalidServiceCredentials
+	}
+	if err != nil {
+		return model.RegisteredService{}, err
+	}
+	if hash == "" || subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(hash)) != 1 {
+		return model.RegisteredService{}, ErrInvalidServiceCredentials
+	}
+	return is.serviceRepo.FindOne(name)
+}
+
+// Introspect implements IntrospectionService
+func (is introspectionService) Introspect(name, secret, token string) (model.Introspection, error) {
+	// Before the database is asked about the secret
+	if err := is.limiter.Allow(name); err != nil {
+		return model.Introspection{}, fmt.Errorf("%s: %w", name, err)
+	}
+	service, err := is.authenticate(name, secret)
+	if err != nil {
+		return model.Introspection{}, err
+	}
+
+	// Reasons a token isn't active aren't told, as in RFC 7662
+	inactive := model.Introspection{Active: false}
+	claims, err := is.verifier.ParseJwt(token)
+	if err != nil || !claims.VerifyIssuer(client.Apr, true) {
+		return inactive, nil
+	}
+	audience := false
+	for _, aud := range service.Audiences {
+		audience = audience || claims.VerifyAudience(aud, true)
+	}
+	if !audience {
+		return inactive, nil
+	}
+	if claims.ID != "" {
+		revoked, err := is.tokenRepo.IsRevoked(claims.ID)
+		if err != nil {
+			return model.Introspection{}, err
+		}
+		if revoked {
+			return inactive, nil
+		}
+	}
+	if claims.Sid != "" {
+		revoked, err := is.tokenRepo.IsSessionRevoked(claims.Sid)
+		if err != nil {
+			return model.Introspection{}, err
+		}
+		if revoked {
+			return inactive, nil
+		}
+	}
+
+	res := model.Introspection{
+		Active:       true,
+		TokenType:    "Bearer",
+		Scope:        claims.Scope,
+		Sub:          claims.Subject,
+		Aud:          claims.Audience,
+		Iss:          claims.Issuer,
+		Jti:          claims.ID,
+		Roles:        claims.Roles,
+		ActingPerson: claims.ActingPerson(),
+	}
+	if claims.ExpiresAt != nil {
+		res.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		res.Iat = claims.IssuedAt.Unix()
+	}
+	if claims.Company != nil {
+		// The company may have been liquidated since the token was issued
+		pib, err := strconv.Atoi(claims.Subject)
+		if err != nil {
+			return inactive, nil
+		}
+		company, err := companyClaims(is.comRepo, pib)
+		if errors.Is(err, db.NoSuchPibError) {
+			return inactive, nil
+		}
+		if err != nil {
+			return model.Introspection{}, err
+		}
+		res.CompanyName = company.Name
+		res.CompanyStatus = company.Status
+	}
+	return res, nil
+}
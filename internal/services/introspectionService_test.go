@@ -0,0 +1,104 @@
+package services
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/auth"
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"apr-backend/internal/ratelimit"
+	"errors"
+	"testing"
+	"time"
+)
+
+// introspectingServices has service eporezi, with introspection secret
+// "tajna", and counts the secrets looked up
+type introspectingServices struct {
+	serviceCatalogue
+	lookups *int
+}
+
+func (is introspectingServices) FindOne(name string) (model.RegisteredService, error) {
+	return model.RegisteredService{Name: name, Audiences: []string{"eporezi"}, TokenLifetime: 900, AllowedRoles: []string{client.RoleCompany}}, nil
+}
+
+func (is introspectingServices) FindIntrospectionSecret(name string) (string, error) {
+	*is.lookups++
+	if name != "eporezi" {
+		return "", db.NoSuchServiceError
+	}
+	return hashToken("tajna"), nil
+}
+
+func TestIntrospect(t *testing.T) {
+	tokens := newTokenStore()
+	authServ := newTestAuthService(t, tokens)
+	lookups := 0
+	services := introspectingServices{lookups: &lookups}
+	catalogueServ := NewCatalogueService(services, authServ.jwtGen)
+	serv := NewIntrospectionService(services, tokens, authCompanies{}, authServ.jwtGen, ratelimit.NewLimiter(60, 10))
+
+	session, err := authServ.IssueTokens(100000001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := client.ValidateToken(authServ.jwtGen, session.Jwt, client.Apr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serviceToken, err := catalogueServ.Issue(claims, "eporezi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Claims issued while the company was active
+	company := func(pib string) string {
+		token, err := authServ.jwtGen.GenerateServiceJWT(auth.Identity{
+			Subject: pib,
+			Roles:   []string{client.RoleCompany},
+			Company: &client.CompanyClaims{Name: "Firma", Status: client.CompanyActive},
+		}, []string{"eporezi"}, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	check := func(name, token string, wantActive bool, wantStatus string) {
+		t.Helper()
+		res, err := serv.Introspect("eporezi", "tajna", token)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if res.Active != wantActive || res.CompanyStatus != wantStatus {
+			t.Errorf("%s: got active %v with status %q, want %v with %q", name, res.Active, res.CompanyStatus, wantActive, wantStatus)
+		}
+	}
+	check("token of the service", serviceToken, true, client.CompanyActive)
+	check("token of APR", session.Jwt, false, "")
+	check("liquidated company", company("100000003"), true, client.CompanyLiquidated)
+	check("deleted company", company("100000004"), false, "")
+
+	// Logging out ends the tokens issued for services in the session
+	if err := authServ.Logout(claims, session.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	check("token of an ended session", serviceToken, false, "")
+}
+
+func TestIntrospectLimit(t *testing.T) {
+	lookups := 0
+	services := introspectingServices{lookups: &lookups}
+	serv := NewIntrospectionService(services, newTokenStore(), authCompanies{}, testJwtGenerator(t), ratelimit.NewLimiter(1, 1))
+
+	if _, err := serv.Introspect("eporezi", "pogresna", "token"); !errors.Is(err, ErrInvalidServiceCredentials) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidServiceCredentials)
+	}
+	// Guessing secrets is limited before the database is asked
+	var limitErr ratelimit.LimitError
+	if _, err := serv.Introspect("eporezi", "tajna", "token"); !errors.As(err, &limitErr) {
+		t.Errorf("got %v, want a ratelimit.LimitError", err)
+	}
+	if lookups != 1 {
+		t.Errorf("secret looked up %d times, want 1", lookups)
+	}
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"apr-backend/internal/totp"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrMfaEnrolled = errors.New("Company already has a second factor, disable it first")
+var ErrMfaNotEnrolled = errors.New("Company has no second factor")
+var ErrInvalidCode = errors.New("Invalid code")
+var ErrMfaRequired = errors.New("A code of the second factor is required")
+var ErrUnknownOperation = errors.New("Operation must be liquidate, changePassword or representatives")
+
+// Operations admins can require a second factor for
+var mfaOperations = []string{model.MfaLiquidate, model.MfaChangePassword, model.MfaRepresentatives}
+
+// Second factors which complete a login challenge
+var mfaMethods = []string{"totp", "recovery_code"}
+
+const (
+	// Shown in authenticator apps next to the PIB
+	totpIssuer        = "APR"
+	recoveryCodeCount = 10
+)
+
+var totpCode = regexp.MustCompile(`^[0-9 ]+$`)
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type MfaService interface {
+	Status(pib int) (model.MfaStatus, error)
+	// Starts enrolment with a new secret, ErrMfaEnrolled if pib has a
+	// confirmed one
+	Enroll(pib int) (model.TotpEnrollment, error)
+	// Confirms the enrolment with the first code and returns the recovery
+	// codes. Sessions of pib end, so that they log in with the new factor.
+	Confirm(pib int, code string) (model.RecoveryCodes, error)
+	// Removes the second factor, with a code of it, and ends the sessions
+	// of pib
+	Disable(pib int, code string) error
+	// Replaces the recovery codes, with a code of the second factor
+	RegenerateRecoveryCodes(pib int, code string) (model.RecoveryCodes, error)
+	// Removes the second factor of pib without a code, for companies which
+	// lost it, and ends the sessions of pib
+	Reset(pib int) error
+	Enrolled(pib int) (bool, error)
+	// Checks a TOTP or recovery code of pib, every code can be used once.
+	// ErrInvalidCode if it is wrong.
+	Verify(pib int, code string) error
+	Policies() ([]model.MfaPolicy, error)
+	// ErrUnknownOperation for operations which can't require a second
+	// factor
+	SetPolicy(policy model.MfaPolicy) (model.MfaPolicy, error)
+	// Reports whether operation requires a second factor
+	Required(operation string) (bool, error)
+}
+
+func NewMfaService(mfaRepo db.MfaRepository, tokenRepo db.TokenRepository) MfaService {
+	return mfaService{mfaRepo: mfaRepo, tokenRepo: tokenRepo}
+}
+
+type mfaService struct {
+	mfaRepo   db.MfaRepository
+	tokenRepo db.TokenRepository
+}
+
+// Status implements MfaService
+func (ms mfaService) Status(pib int) (model.MfaStatus, error) {
+	secret, err := ms.mfaRepo.FindTotp(pib)
+	if errors.Is(err, db.NoSuchTotpError) || err == nil && secret.ConfirmedAt == nil {
+		return model.MfaStatus{}, nil
+	}
+	if err != nil {
+		return model.MfaStatus{}, err
+	}
+	left, err := ms.mfaRepo.CountRecoveryCodes(pib)
+	if err != nil {
+		return model.MfaStatus{}, err
+	}
+	return model.MfaStatus{Enrolled: true, ConfirmedAt: secret.ConfirmedAt, RecoveryCodesLeft: left}, nil
+}
+
+// Enroll implements MfaService
+func (ms mfaService) Enroll(pib int) (model.TotpEnrollment, error) {
+	enrolled, err := ms.Enrolled(pib)
+	if err != nil {
+		return model.TotpEnrollment{}, err
+	}
+	if enrolled {
+		return model.TotpEnrollment{}, ErrMfaEnrolled
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return model.TotpEnrollment{}, err
+	}
+	uri := totp.ProvisioningUri(totpIssuer, strconv.Itoa(pib), secret)
+	qr, err := totp.QRCode(uri)
+	if err != nil {
+		return model.TotpEnrollment{}, err
+	}
+	if err := ms.mfaRepo.SaveTotp(pib, secret); err != nil {
+		return model.TotpEnrollment{}, err
+	}
+	return model.TotpEnrollment{Secret: secret, Uri: uri, QRCode: qr}, nil
+}
+
+// recoveryCodes returns new recovery codes and their hashes
+func recoveryCodes() (model.RecoveryCodes, []string, error) {
+	codes := model.RecoveryCodes{Codes: make([]string, recoveryCodeCount)}
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes.Codes {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return codes, nil, fmt.Errorf("Error generating recovery code: %w", err)
+		}
+		code := strings.ToLower(recoveryEncoding.EncodeToString(raw))
+		codes.Codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashToken(code)
+	}
+	return codes, hashes, nil
+}
+
+// Confirm implements MfaService
+func (ms mfaService) Confirm(pib int, code string) (model.RecoveryCodes, error) {
+	secret, err := ms.mfaRepo.FindTotp(pib)
+	if errors.Is(err, db.NoSuchTotpError) {
+		return model.RecoveryCodes{}, ErrMfaNotEnrolled
+	}
+	if err != nil {
+		return model.RecoveryCodes{}, err
+	}
+	if secret.ConfirmedAt != nil {
+		return model.RecoveryCodes{}, ErrMfaEnrolled
+	}
+	step, ok := totp.Verify(secret.Secret, code, time.Now())
+	if !ok {
+		return model.RecoveryCodes{}, ErrInvalidCode
+	}
+	codes, hashes, err := recoveryCodes()
+	if err != nil {
+		return codes, err
+	}
+	if err := ms.mfaRepo.ConfirmTotp(pib, step, hashes); err != nil {
+		if errors.Is(err, db.NoSuchTotpError) {
+			// Confirmed by a concurrent request
+			return model.RecoveryCodes{}, ErrMfaEnrolled
+		}
+		return model.RecoveryCodes{}, err
+	}
+	// Sessions started with the password only end with the enrolment
+	if err := ms.tokenRepo.RevokeAll(pib); err != nil {
+		return model.RecoveryCodes{}, err
+	}
+	return codes, nil
+}
+
+// Disable implements MfaService
+func (ms mfaService) Disable(pib int, code string) error {
+	if err := ms.Verify(pib, code); err != nil {
+		return err
+	}
+	return ms.Reset(pib)
+}
+
+// RegenerateRecoveryCodes implements MfaService
+func (ms mfaService) RegenerateRecoveryCodes(pib int, code string) (model.RecoveryCodes, error) {
+	if err := ms.Verify(pib, code); err != nil {
+		return model.RecoveryCodes{}, err
+	}
+	codes, hashes, err := recoveryCodes()
+	if err != nil {
+		return codes, err
+	}
+	if err := ms.mfaRepo.ReplaceRecoveryCodes(pib, hashes); err != nil {
+		return model.RecoveryCodes{}, err
+	}
+	return codes, nil
+}
+
+// Reset implements MfaService
+func (ms mfaService) Reset(pib int) error {
+	err := ms.mfaRepo.DeleteTotp(pib)
+	if errors.Is(err, db.NoSuchTotpError) {
+		return ErrMfaNotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	// Sessions keep the amr they were started with, which no longer holds
+	return ms.tokenRepo.RevokeAll(pib)
+}
+
+// Enrolled implements MfaService
+func (ms mfaService) Enrolled(pib int) (bool, error) {
+	secret, err := ms.mfaRepo.FindTotp(pib)
+	if errors.Is(err, db.NoSuchTotpError) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return secret.ConfirmedAt != nil, nil
+}
+
+// Verify implements MfaService
+func (ms mfaService) Verify(pib int, code string) error {
+	secret, err := ms.mfaRepo.FindTotp(pib)
+	if errors.Is(err, db.NoSuchTotpError) || err == nil && secret.ConfirmedAt == nil {
+		return ErrMfaNotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+
+	if totpCode.MatchString(code) {
+		step, ok := totp.Verify(secret.Secret, code, time.Now())
+		if !ok {
+			return ErrInvalidCode
+		}
+		err = ms.mfaRepo.UseStep(pib, step)
+		if errors.Is(err, db.TotpStepUsedError) {
+			return ErrInvalidCode
+		}
+		return err
+	}
+
+	// Recovery codes are accepted with or without the dash
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	err = ms.mfaRepo.UseRecoveryCode(pib, hashToken(code))
+	if errors.Is(err, db.NoSuchRecoveryCodeError) {
+		return ErrInvalidCode
+	}
+	return err
+}
+
+// Policies implements MfaService, operations without a stored policy don't
+// require a second factor
+func (ms mfaService) Policies() ([]model.MfaPolicy, error) {
+	stored, err := ms.mfaRepo.FindPolicies()
+	if err != nil {
+		return nil, err
+	}
+	policies := make([]model.MfaPolicy, 0, len(mfaOperations))
+	for _, operation := range mfaOperations {
+		policy := model.MfaPolicy{Operation: operation}
+		for _, s := range stored {
+			if s.Operation == operation {
+				policy = s
+			}
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// SetPolicy implements MfaService
+func (ms mfaService) SetPolicy(policy model.MfaPolicy) (model.MfaPolicy, error) {
+	if !contains(mfaOperations, policy.Operation) {
+		return policy, ErrUnknownOperation
+	}
+	if err := ms.mfaRepo.SavePolicy(&policy); err != nil {
+		return policy, err
+	}
+	return policy, nil
+}
+
+// Required implements MfaService
+func (ms mfaService) Required(operation string) (bool, error) {
+	policies, err := ms.mfaRepo.FindPolicies()
+	if err != nil {
+		return false, err
+	}
+	for _, policy := range policies {
+		if policy.Operation == operation {
+			return policy.Required, nil
+		}
+	}
+	return false, nil
+}
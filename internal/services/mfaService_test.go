@@ -0,0 +1,250 @@
+package services
+
+import (
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"apr-backend/internal/totp"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mfaStore keeps second factors in memory, following the contract of
+// db.MfaRepository
+type mfaStore struct {
+	db.MfaRepository
+	mu       sync.Mutex
+	secrets  map[int]*model.Totp
+	recovery map[string]int
+}
+
+func newMfaStore() *mfaStore {
+	return &mfaStore{secrets: map[int]*model.Totp{}, recovery: map[string]int{}}
+}
+
+func (ms *mfaStore) FindTotp(pib int) (model.Totp, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	secret, ok := ms.secrets[pib]
+	if !ok {
+		return model.Totp{}, db.NoSuchTotpError
+	}
+	return *secret, nil
+}
+
+func (ms *mfaStore) SaveTotp(pib int, secret string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.secrets[pib] = &model.Totp{PIB: pib, Secret: secret}
+	return nil
+}
+
+func (ms *mfaStore) replaceRecoveryCodes(pib int, hashes []string) {
+	for hash, owner := range ms.recovery {
+		if owner == pib {
+			delete(ms.recovery, hash)
+		}
+	}
+	for _, hash := range hashes {
+		ms.recovery[hash] = pib
+	}
+}
+
+func (ms *mfaStore) ConfirmTotp(pib int, step int64, hashes []string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	secret, ok := ms.secrets[pib]
+	if !ok || secret.ConfirmedAt != nil {
+		return db.NoSuchTotpError
+	}
+	now := time.Now()
+	secret.ConfirmedAt, secret.LastStep = &now, step
+	ms.replaceRecoveryCodes(pib, hashes)
+	return nil
+}
+
+func (ms *mfaStore) UseStep(pib int, step int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if step <= ms.secrets[pib].LastStep {
+		return db.TotpStepUsedError
+	}
+	ms.secrets[pib].LastStep = step
+	return nil
+}
+
+func (ms *mfaStore) DeleteTotp(pib int) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.secrets[pib]; !ok {
+		return db.NoSuchTotpError
+	}
+	delete(ms.secrets, pib)
+	ms.replaceRecoveryCodes(pib, nil)
+	return nil
+}
+
+func (ms *mfaStore) ReplaceRecoveryCodes(pib int, hashes []string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.replaceRecoveryCodes(pib, hashes)
+	return nil
+}
+
+func (ms *mfaStore) UseRecoveryCode(pib int, hash string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if owner, ok := ms.recovery[hash]; !ok || owner != pib {
+		return db.NoSuchRecoveryCodeError
+	}
+	delete(ms.recovery, hash)
+	return nil
+}
+
+func (ms *mfaStore) CountRecoveryCodes(pib int) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	left := 0
+	for _, owner := range ms.recovery {
+		if owner == pib {
+			left++
+		}
+	}
+	return left, nil
+}
+
+// enrolled returns an MfaService with pib enrolled, the secret and recovery
+// codes of pib and the session of pib started before it enrolled
+func enrolled(t *testing.T, pib int) (MfaService, *tokenStore, string, model.RecoveryCodes, *storedToken) {
+	t.Helper()
+	tokens := newTokenStore()
+	_, session, err := newRefreshToken(model.RefreshToken{Family: "f", Kind: model.SessionCompany, PIB: pib})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens.SaveRefreshToken(session)
+
+	serv := NewMfaService(newMfaStore(), tokens)
+	enrolment, err := serv.Enroll(pib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.Code(enrolment.Secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := serv.Confirm(pib, "000000"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("wrong code: got %v, want %v", err, ErrInvalidCode)
+	}
+	codes, err := serv.Confirm(pib, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serv, tokens, enrolment.Secret, codes, tokens.tokens[session.Hash]
+}
+
+func TestTotpVerify(t *testing.T) {
+	serv, _, secret, _, session := enrolled(t, 100000001)
+	// Sessions started with the password only end with the enrolment
+	if !session.revoked {
+		t.Error("session started before the enrolment is still valid")
+	}
+
+	now := time.Now()
+	confirmed, err := totp.Code(secret, totp.Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := totp.Code(secret, totp.Step(now)+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		code    string
+		wantErr error
+	}{
+		// The code of the confirmation was used already
+		{"replayed step", confirmed, ErrInvalidCode},
+		{"next step", next, nil},
+		{"replayed next step", next, ErrInvalidCode},
+		// Codes of earlier steps can't be used after a later one
+		{"earlier step", confirmed, ErrInvalidCode},
+		{"wrong code", "000000", ErrInvalidCode},
+	}
+	for _, tt := range tests {
+		if err := serv.Verify(100000001, tt.code); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if err := serv.Verify(100000002, next); !errors.Is(err, ErrMfaNotEnrolled) {
+		t.Errorf("company without a second factor: got %v, want %v", err, ErrMfaNotEnrolled)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	serv, _, _, codes, _ := enrolled(t, 100000001)
+	if len(codes.Codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes.Codes), recoveryCodeCount)
+	}
+	if err := serv.Verify(100000001, codes.Codes[0]); err != nil {
+		t.Errorf("recovery code: %v", err)
+	}
+	if err := serv.Verify(100000001, codes.Codes[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("used recovery code: got %v, want %v", err, ErrInvalidCode)
+	}
+	// Codes may be typed without the dash and in upper case
+	typed := strings.ToUpper(strings.ReplaceAll(codes.Codes[1], "-", ""))
+	if err := serv.Verify(100000001, typed); err != nil {
+		t.Errorf("recovery code without the dash: %v", err)
+	}
+	if err := serv.Verify(100000002, codes.Codes[2]); !errors.Is(err, ErrMfaNotEnrolled) {
+		t.Errorf("recovery code of another company: got %v, want %v", err, ErrMfaNotEnrolled)
+	}
+	status, err := serv.Status(100000001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.RecoveryCodesLeft != recoveryCodeCount-2 {
+		t.Errorf("got %d recovery codes left, want %d", status.RecoveryCodesLeft, recoveryCodeCount-2)
+	}
+
+	regenerated, err := serv.RegenerateRecoveryCodes(100000001, codes.Codes[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := serv.Verify(100000001, codes.Codes[4]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("replaced recovery code: got %v, want %v", err, ErrInvalidCode)
+	}
+	if err := serv.Verify(100000001, regenerated.Codes[0]); err != nil {
+		t.Errorf("new recovery code: %v", err)
+	}
+}
+
+func TestDisableMfa(t *testing.T) {
+	serv, tokens, _, codes, _ := enrolled(t, 100000001)
+	_, session, err := newRefreshToken(model.RefreshToken{Family: "g", Kind: model.SessionCompany, PIB: 100000001, Mfa: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens.SaveRefreshToken(session)
+
+	if err := serv.Disable(100000001, "000000"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("wrong code: got %v, want %v", err, ErrInvalidCode)
+	}
+	if tokens.tokens[session.Hash].revoked {
+		t.Error("session ended without disabling the second factor")
+	}
+	if err := serv.Disable(100000001, codes.Codes[0]); err != nil {
+		t.Fatal(err)
+	}
+	// The session claims a second factor the company no longer has
+	if !tokens.tokens[session.Hash].revoked {
+		t.Error("session started with the second factor is still valid")
+	}
+	if err := serv.Reset(100000001); !errors.Is(err, ErrMfaNotEnrolled) {
+		t.Errorf("reset without a second factor: got %v, want %v", err, ErrMfaNotEnrolled)
+	}
+}
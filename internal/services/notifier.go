@@ -0,0 +1,89 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+var ErrNotifierDisabled = errors.New("No notifier is configured")
+
+// Notifier delivers messages to the owners of companies, through whatever
+// channel they are reachable by.
+type Notifier interface {
+	// Sends a password reset token for pib, which expires at expiresAt
+	NotifyPasswordReset(pib int, token string, expiresAt time.Time) error
+	// Sends pib the activation code of the account of the person with jmbg,
+	// for the company to hand over if it knows them
+	NotifyPersonActivation(pib int, jmbg string, code string, expiresAt time.Time) error
+}
+
+// NewLogNotifier writes notifications to the log, only for development.
+func NewLogNotifier() Notifier {
+	return logNotifier{}
+}
+
+type logNotifier struct{}
+
+// NotifyPasswordReset implements Notifier
+func (logNotifier) NotifyPasswordReset(pib int, token string, expiresAt time.Time) error {
+	log.Printf("Password reset token for %d, valid until %s: %s", pib, expiresAt.Format(time.RFC3339), token)
+	return nil
+}
+
+// NotifyPersonActivation implements Notifier
+func (logNotifier) NotifyPersonActivation(pib int, jmbg string, code string, expiresAt time.Time) error {
+	log.Printf("Activation code of %s for %d, valid until %s: %s", jmbg, pib, expiresAt.Format(time.RFC3339), code)
+	return nil
+}
+
+// Notification posted by the HTTP notifier
+type notification struct {
+	Type      string    `json:"type"`
+	PIB       int       `json:"pib"`
+	Jmbg      string    `json:"jmbg,omitempty"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// NewHttpNotifier posts notifications as JSON to url, for example to the
+// service which sends emails. Tokens are sent in the body, so url should
+// not leave the internal network.
+func NewHttpNotifier(url string) Notifier {
+	return httpNotifier{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type httpNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NotifyPasswordReset implements Notifier
+func (hn httpNotifier) NotifyPasswordReset(pib int, token string, expiresAt time.Time) error {
+	return hn.post(notification{Type: "password_reset", PIB: pib, Token: token, ExpiresAt: expiresAt})
+}
+
+// NotifyPersonActivation implements Notifier
+func (hn httpNotifier) NotifyPersonActivation(pib int, jmbg string, code string, expiresAt time.Time) error {
+	return hn.post(notification{Type: "person_activation", PIB: pib, Jmbg: jmbg, Token: code, ExpiresAt: expiresAt})
+}
+
+func (hn httpNotifier) post(n notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("Error encoding notification: %w", err)
+	}
+	res, err := hn.client.Post(hn.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Error sending notification: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("Error sending notification: status %s", res.Status)
+	}
+	return nil
+}
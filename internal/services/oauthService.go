@@ -0,0 +1,477 @@
+package services
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/auth"
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	authorizationCodeLifetime = time.Minute
+	// Companies stay logged in at the authorization endpoint this long,
+	// across every client
+	SsoSessionLifetime = 8 * time.Hour
+	// Audience of SSO session tokens
+	ssoAudience = "apr-sso"
+	// Access tokens of OAuth clients are for the client and this audience,
+	// the userinfo endpoint
+	UserInfoAudience = "apr-userinfo"
+)
+
+// Paths of the OpenID Connect endpoints, relative to the issuer
+const (
+	OidcConfigurationPath = "/.well-known/openid-configuration"
+	OAuthAuthorizePath    = "/oauth/authorize"
+	OAuthTokenPath        = "/oauth/token"
+	OAuthUserInfoPath     = "/oauth/userinfo"
+	OAuthLogoutPath       = "/oauth/logout"
+	OAuthIntrospectPath   = "/oauth/introspect"
+)
+
+// Requests with these errors mustn't be redirected back to the client, the
+// redirect URI can't be trusted
+var ErrUnknownClient = errors.New("Unknown client_id")
+var ErrInvalidRedirectUri = errors.New("redirect_uri isn't registered for the client")
+
+var ErrInvalidRedirectUris = errors.New("Redirect URIs must be absolute https URLs without a fragment, or http on localhost")
+
+var supportedScopes = []string{"openid", "profile", "offline_access"}
+
+type OAuthService interface {
+	Configuration() model.OidcConfiguration
+	SaveClient(client *model.OAuthClient) error
+	FindClients() ([]model.OAuthClient, error)
+	DeleteClient(clientId string) error
+	// Checks an authorization request. Other problems than
+	// ErrUnknownClient and ErrInvalidRedirectUri are a model.OAuthError,
+	// to be sent back to the redirect URI with AuthorizationErrorUrl.
+	CheckAuthorization(req model.AuthorizationRequest) (model.OAuthClient, error)
+	// Checks credentials and starts an SSO session, returning its token.
+	// Companies with a second factor need its code too.
+	StartSession(creds model.CredentialsDto, code, ip string) (string, model.SsoSession, error)
+	// Returns the SSO session of token, ok is false if it isn't valid
+	Session(token string) (session model.SsoSession, ok bool)
+	EndSession(token string) error
+	// Returns where to send the browser after logout, ok is false unless
+	// uri is registered for the client
+	LogoutRedirect(clientId, uri, state string) (string, bool)
+	// Issues a code for a checked request of the company logged in with
+	// session and returns the URL to redirect to. Liquidated companies get
+	// a model.OAuthError.
+	Authorize(req model.AuthorizationRequest, session model.SsoSession) (string, error)
+	// Implements the token endpoint, errors of the request are a
+	// model.OAuthError
+	Exchange(req model.TokenRequest) (model.OidcTokenResponse, error)
+	UserInfo(pib int) (model.UserInfo, error)
+}
+
+// issuer is the public URL of APR, which clients discover the endpoints
+// from
+func NewOAuthService(issuer string, oauthRepo db.OAuthRepository, comRepo db.CompanyRepository, tokenRepo db.TokenRepository, authServ AuthService, accountServ ServiceAccountService, jwtGen auth.JwtGenerator) OAuthService {
+	return oauthService{
+		accountServ: accountServ,
+		issuer:      strings.TrimSuffix(issuer, "/"),
+		oauthRepo:   oauthRepo,
+		comRepo:     comRepo,
+		tokenRepo:   tokenRepo,
+		authServ:    authServ,
+		jwtGen:      jwtGen,
+	}
+}
+
+type oauthService struct {
+	issuer    string
+	oauthRepo db.OAuthRepository
+	comRepo   db.CompanyRepository
+	tokenRepo db.TokenRepository
+	authServ  AuthService
+	// Service accounts use the client credentials grant
+	accountServ ServiceAccountService
+	jwtGen      auth.JwtGenerator
+}
+
+// idTokenClaims are the claims of OpenID Connect ID tokens
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce    string   `json:"nonce,omitempty"`
+	AuthTime int64    `json:"auth_time"`
+	Amr      []string `json:"amr,omitempty"`
+	Name     string   `json:"name,omitempty"`
+}
+
+// Configuration implements OAuthService
+func (oas oauthService) Configuration() model.OidcConfiguration {
+	return model.OidcConfiguration{
+		Issuer:                            oas.issuer,
+		AuthorizationEndpoint:             oas.issuer + OAuthAuthorizePath,
+		TokenEndpoint:                     oas.issuer + OAuthTokenPath,
+		UserinfoEndpoint:                  oas.issuer + OAuthUserInfoPath,
+		JwksUri:                           oas.issuer + client.JwksPath,
+		EndSessionEndpoint:                oas.issuer + OAuthLogoutPath,
+		IntrospectionEndpoint:             oas.issuer + OAuthIntrospectPath,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", "client_credentials"},
+		SubjectTypesSupported:             []string{"public"},
+		IdTokenSigningAlgValuesSupported:  []string{jwt.SigningMethodRS512.Alg()},
+		ScopesSupported:                   append(supportedScopes, serviceAccountScopes...),
+		TokenEndpointAuthMethodsSupported: []string{"none", "client_secret_basic", "client_secret_post", "private_key_jwt"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"sub", "name", "nonce", "auth_time", "amr"},
+	}
+}
+
+func validRedirectUri(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Fragment != "" || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return false
+}
+
+// SaveClient implements OAuthService
+func (oas oauthService) SaveClient(client *model.OAuthClient) error {
+	for _, uri := range client.RedirectUris {
+		if !validRedirectUri(uri) {
+			return fmt.Errorf("%w: %s", ErrInvalidRedirectUris, uri)
+		}
+	}
+	return oas.oauthRepo.SaveClient(client)
+}
+
+// FindClients implements OAuthService
+func (oas oauthService) FindClients() ([]model.OAuthClient, error) {
+	return oas.oauthRepo.FindClients()
+}
+
+// DeleteClient implements OAuthService
+func (oas oauthService) DeleteClient(clientId string) error {
+	return oas.oauthRepo.DeleteClient(clientId)
+}
+
+// CheckAuthorization implements OAuthService
+func (oas oauthService) CheckAuthorization(req model.AuthorizationRequest) (model.OAuthClient, error) {
+	oauthClient, err := oas.oauthRepo.FindClient(req.ClientId)
+	if errors.Is(err, db.NoSuchOAuthClientError) {
+		return oauthClient, ErrUnknownClient
+	}
+	if err != nil {
+		return oauthClient, err
+	}
+	if !contains(oauthClient.RedirectUris, req.RedirectUri) {
+		return oauthClient, ErrInvalidRedirectUri
+	}
+
+	if req.ResponseType != "code" {
+		return oauthClient, model.OAuthError{Code: "unsupported_response_type", Description: "Only the code response type is supported"}
+	}
+	if req.CodeChallenge == "" {
+		return oauthClient, model.OAuthError{Code: "invalid_request", Description: "code_challenge is required"}
+	}
+	if req.CodeChallengeMethod != "S256" {
+		return oauthClient, model.OAuthError{Code: "invalid_request", Description: "code_challenge_method must be S256"}
+	}
+	for _, scope := range strings.Fields(req.Scope) {
+		if !contains(supportedScopes, scope) {
+			return oauthClient, model.OAuthError{Code: "invalid_scope", Description: "Unsupported scope " + scope}
+		}
+	}
+	return oauthClient, nil
+}
+
+// AuthorizationErrorUrl returns the redirect URI of a checked request with
+// an error
+func AuthorizationErrorUrl(req model.AuthorizationRequest, oauthErr model.OAuthError) string {
+	params := url.Values{}
+	params.Set("error", oauthErr.Code)
+	if oauthErr.Description != "" {
+		params.Set("error_description", oauthErr.Description)
+	}
+	return redirectUrl(req, params)
+}
+
+func redirectUrl(req model.AuthorizationRequest, params url.Values) string {
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	// Registered redirect URIs may have a query of their own
+	u, _ := url.Parse(req.RedirectUri)
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// StartSession implements OAuthService
+func (oas oauthService) StartSession(creds model.CredentialsDto, code, ip string) (string, model.SsoSession, error) {
+	mfa, err := oas.authServ.AuthenticateWithCode(creds, code, ip)
+	if err != nil {
+		return "", model.SsoSession{}, err
+	}
+	jti, err := auth.NewTokenId()
+	if err != nil {
+		return "", model.SsoSession{}, err
+	}
+	// The session is kept as a refresh token family, so that it ends with
+	// the other sessions of the company, for example when its password
+	// changes or it is liquidated. The refresh token itself is never handed
+	// out.
+	_, record, err := newRefreshToken(model.RefreshToken{Kind: model.SessionCompany, PIB: creds.PIB, Mfa: mfa, ClientId: ssoAudience})
+	if err != nil {
+		return "", model.SsoSession{}, err
+	}
+	if record.Family, err = newFamily(); err != nil {
+		return "", model.SsoSession{}, err
+	}
+	now := time.Now()
+	record.ExpiresAt = record.CreatedAt.Add(SsoSessionLifetime)
+	if err := oas.tokenRepo.SaveRefreshToken(record); err != nil {
+		return "", model.SsoSession{}, err
+	}
+	token, err := oas.jwtGen.SignJwt(client.TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    client.Apr,
+			Subject:   strconv.Itoa(creds.PIB),
+			Audience:  []string{ssoAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(SsoSessionLifetime)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		Amr: sessionAmr(mfa),
+		Sid: record.Family,
+	})
+	if err != nil {
+		return "", model.SsoSession{}, err
+	}
+	return token, model.SsoSession{PIB: creds.PIB, AuthTime: now, Mfa: mfa}, nil
+}
+
+func (oas oauthService) session(token string) (client.TokenClaims, bool) {
+	if token == "" {
+		return client.TokenClaims{}, false
+	}
+	// Revoked with the session, tokens without one predate revocable
+	// sessions
+	claims, err := client.ValidateToken(oas.jwtGen, token, ssoAudience, client.WithRevocationStore(oas.tokenRepo))
+	return claims, err == nil && claims.IssuedAt != nil && claims.Sid != ""
+}
+
+// Session implements OAuthService
+func (oas oauthService) Session(token string) (model.SsoSession, bool) {
+	claims, ok := oas.session(token)
+	if !ok {
+		return model.SsoSession{}, false
+	}
+	pib, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return model.SsoSession{}, false
+	}
+	return model.SsoSession{PIB: pib, AuthTime: claims.IssuedAt.Time, Mfa: claims.HasAmr(client.AmrOtp)}, true
+}
+
+// EndSession implements OAuthService
+func (oas oauthService) EndSession(token string) error {
+	claims, ok := oas.session(token)
+	if !ok {
+		return nil
+	}
+	return oas.tokenRepo.RevokeSession(claims.Sid)
+}
+
+// LogoutRedirect implements OAuthService
+func (oas oauthService) LogoutRedirect(clientId, uri, state string) (string, bool) {
+	oauthClient, err := oas.oauthRepo.FindClient(clientId)
+	if err != nil || !contains(oauthClient.RedirectUris, uri) {
+		return "", false
+	}
+	return redirectUrl(model.AuthorizationRequest{RedirectUri: uri, State: state}, url.Values{}), true
+}
+
+// Authorize implements OAuthService
+func (oas oauthService) Authorize(req model.AuthorizationRequest, session model.SsoSession) (string, error) {
+	// The session may have started before the company was liquidated
+	if _, err := oas.comRepo.FindOne(session.PIB); err != nil {
+		if errors.Is(err, db.NoSuchPibError) {
+			return "", model.OAuthError{Code: "access_denied", Description: "Company is liquidated or deleted"}
+		}
+		return "", err
+	}
+	code, hash, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	err = oas.oauthRepo.SaveCode(model.AuthorizationCode{
+		Hash:          hash,
+		ClientId:      req.ClientId,
+		RedirectUri:   req.RedirectUri,
+		PIB:           session.PIB,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		AuthTime:      session.AuthTime.Truncate(time.Second),
+		Mfa:           session.Mfa,
+		ExpiresAt:     time.Now().Add(authorizationCodeLifetime).Truncate(time.Second),
+	})
+	if err != nil {
+		return "", err
+	}
+	return redirectUrl(req, url.Values{"code": {code}}), nil
+}
+
+// verifyPkce checks verifier against a S256 challenge, RFC 7636
+func verifyPkce(verifier, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+var errInvalidGrant = model.OAuthError{Code: "invalid_grant", Description: "Invalid or expired code"}
+
+// Exchange implements OAuthService
+func (oas oauthService) Exchange(req model.TokenRequest) (model.OidcTokenResponse, error) {
+	switch req.GrantType {
+	case "authorization_code":
+		return oas.exchangeCode(req)
+	case "refresh_token":
+		tokens, err := oas.authServ.Refresh(req.RefreshToken, req.ClientId)
+		if errors.Is(err, db.NoSuchRefreshTokenError) || errors.Is(err, db.RefreshTokenReusedError) {
+			return model.OidcTokenResponse{}, model.OAuthError{Code: "invalid_grant", Description: "Invalid refresh token"}
+		}
+		if err != nil {
+			return model.OidcTokenResponse{}, err
+		}
+		return tokenResponse(tokens, ""), nil
+	case "client_credentials":
+		return oas.accountServ.IssueToken(req)
+	default:
+		return model.OidcTokenResponse{}, model.OAuthError{Code: "unsupported_grant_type"}
+	}
+}
+
+func tokenResponse(tokens model.TokenPair, scope string) model.OidcTokenResponse {
+	return model.OidcTokenResponse{
+		AccessToken:  tokens.Jwt,
+		TokenType:    "Bearer",
+		ExpiresIn:    tokens.ExpiresIn,
+		RefreshToken: tokens.RefreshToken,
+		Scope:        scope,
+	}
+}
+
+func (oas oauthService) exchangeCode(req model.TokenRequest) (model.OidcTokenResponse, error) {
+	code, err := oas.oauthRepo.UseCode(hashToken(req.Code))
+	if errors.Is(err, db.AuthorizationCodeReusedError) {
+		// The code leaked, RFC 6749 section 4.1.2
+		oas.revokeCodeTokens(code.Tokens)
+		return model.OidcTokenResponse{}, errInvalidGrant
+	}
+	if errors.Is(err, db.NoSuchAuthorizationCodeError) {
+		return model.OidcTokenResponse{}, errInvalidGrant
+	}
+	if err != nil {
+		return model.OidcTokenResponse{}, err
+	}
+	if code.ClientId != req.ClientId || code.RedirectUri != req.RedirectUri || !verifyPkce(req.CodeVerifier, code.CodeChallenge) {
+		return model.OidcTokenResponse{}, errInvalidGrant
+	}
+	// The client may have been removed since, and the company liquidated
+	if _, err := oas.oauthRepo.FindClient(code.ClientId); err != nil {
+		if errors.Is(err, db.NoSuchOAuthClientError) {
+			return model.OidcTokenResponse{}, model.OAuthError{Code: "invalid_client"}
+		}
+		return model.OidcTokenResponse{}, err
+	}
+	if _, err := oas.comRepo.FindOne(code.PIB); err != nil {
+		if errors.Is(err, db.NoSuchPibError) {
+			return model.OidcTokenResponse{}, errInvalidGrant
+		}
+		return model.OidcTokenResponse{}, err
+	}
+
+	tokens, issued, err := oas.authServ.IssueClientTokens(code)
+	if err != nil {
+		return model.OidcTokenResponse{}, err
+	}
+	if err := oas.oauthRepo.SaveCodeTokens(code.Hash, issued); err != nil {
+		// Used again while the tokens were issued
+		oas.revokeCodeTokens(issued)
+		if errors.Is(err, db.AuthorizationCodeReusedError) {
+			return model.OidcTokenResponse{}, errInvalidGrant
+		}
+		return model.OidcTokenResponse{}, err
+	}
+	res := tokenResponse(tokens, code.Scope)
+	scopes := strings.Fields(code.Scope)
+	if !contains(scopes, "openid") {
+		return res, nil
+	}
+
+	now := time.Now()
+	claims := idTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    oas.issuer,
+			Subject:   strconv.Itoa(code.PIB),
+			Audience:  []string{code.ClientId},
+			ExpiresAt: jwt.NewNumericDate(now.Add(auth.AccessTokenLifetime)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		Nonce:    code.Nonce,
+		AuthTime: code.AuthTime.Unix(),
+		Amr:      sessionAmr(code.Mfa),
+	}
+	if contains(scopes, "profile") {
+		com, err := oas.comRepo.FindOne(code.PIB)
+		if err != nil {
+			return model.OidcTokenResponse{}, err
+		}
+		claims.Name = com.Naziv
+	}
+	if res.IdToken, err = oas.jwtGen.SignJwt(claims); err != nil {
+		return model.OidcTokenResponse{}, err
+	}
+	return res, nil
+}
+
+// revokeCodeTokens revokes the tokens issued for an authorization code
+func (oas oauthService) revokeCodeTokens(tokens model.ClientTokens) {
+	if tokens.Family != "" {
+		if err := oas.tokenRepo.RevokeSession(tokens.Family); err != nil {
+			log.Printf("Error revoking tokens of authorization code: %s", err.Error())
+		}
+	}
+	if tokens.Jti != "" {
+		if err := oas.tokenRepo.RevokeAccessToken(tokens.Jti, tokens.ExpiresAt); err != nil {
+			log.Printf("Error revoking tokens of authorization code: %s", err.Error())
+		}
+	}
+}
+
+// UserInfo implements OAuthService
+func (oas oauthService) UserInfo(pib int) (model.UserInfo, error) {
+	com, err := oas.comRepo.FindOne(pib)
+	if err != nil {
+		return model.UserInfo{}, err
+	}
+	return model.UserInfo{Sub: strconv.Itoa(pib), Name: com.Naziv, PIB: pib}, nil
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// codeStore keeps authorization codes of client "portal" in memory,
+// following the contract of db.OAuthRepository
+type codeStore struct {
+	db.OAuthRepository
+	mu     sync.Mutex
+	codes  map[string]*model.AuthorizationCode
+	used   map[string]bool
+	reused map[string]bool
+}
+
+func (cs *codeStore) FindClient(clientId string) (model.OAuthClient, error) {
+	if clientId != "portal" {
+		return model.OAuthClient{}, db.NoSuchOAuthClientError
+	}
+	return model.OAuthClient{ClientId: clientId, RedirectUris: []string{"https://portal.example/callback"}}, nil
+}
+
+func (cs *codeStore) SaveCode(code model.AuthorizationCode) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.codes[code.Hash] = &code
+	return nil
+}
+
+func (cs *codeStore) UseCode(hash string) (model.AuthorizationCode, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	code, ok := cs.codes[hash]
+	if !ok {
+		return model.AuthorizationCode{}, db.NoSuchAuthorizationCodeError
+	}
+	if cs.used[hash] {
+		cs.reused[hash] = true
+		return *code, db.AuthorizationCodeReusedError
+	}
+	cs.used[hash] = true
+	return *code, nil
+}
+
+func (cs *codeStore) SaveCodeTokens(hash string, tokens model.ClientTokens) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.reused[hash] {
+		return db.AuthorizationCodeReusedError
+	}
+	cs.codes[hash].Tokens = tokens
+	return nil
+}
+
+// authorize returns an OAuthService and the request exchanging a code
+// issued to the portal for session
+func authorize(t *testing.T, tokens *tokenStore, codes *codeStore, session model.SsoSession) (OAuthService, model.TokenRequest) {
+	t.Helper()
+	authServ := newTestAuthService(t, tokens)
+	serv := NewOAuthService("https://apr.example", codes, authCompanies{}, tokens, authServ, nil, authServ.jwtGen)
+
+	verifier := "verifier of the portal, long enough for pkce"
+	sum := sha256.Sum256([]byte(verifier))
+	req := model.AuthorizationRequest{
+		ClientId:      "portal",
+		RedirectUri:   "https://portal.example/callback",
+		Scope:         "openid offline_access",
+		CodeChallenge: base64.RawURLEncoding.EncodeToString(sum[:]),
+	}
+	redirect, err := serv.Authorize(req, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serv, model.TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         u.Query().Get("code"),
+		ClientId:     "portal",
+		RedirectUri:  req.RedirectUri,
+		CodeVerifier: verifier,
+	}
+}
+
+func newCodeStore() *codeStore {
+	return &codeStore{codes: map[string]*model.AuthorizationCode{}, used: map[string]bool{}, reused: map[string]bool{}}
+}
+
+func TestExchangeCodeReuse(t *testing.T) {
+	tokens := newTokenStore()
+	codes := newCodeStore()
+	serv, exchange := authorize(t, tokens, codes, model.SsoSession{PIB: 100000001, AuthTime: time.Now()})
+
+	res, err := serv.Exchange(exchange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RefreshToken == "" || res.IdToken == "" {
+		t.Fatalf("got %+v, want refresh and ID tokens", res)
+	}
+	// Whoever uses the code again may have intercepted it
+	var oauthErr model.OAuthError
+	if _, err := serv.Exchange(exchange); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+		t.Errorf("reused code: got %v, want invalid_grant", err)
+	}
+	issued := codes.codes[hashToken(exchange.Code)].Tokens
+	if !tokens.revoked[issued.Jti] {
+		t.Error("access token of the reused code is still valid")
+	}
+	refresh := model.TokenRequest{GrantType: "refresh_token", RefreshToken: res.RefreshToken, ClientId: "portal"}
+	if _, err := serv.Exchange(refresh); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+		t.Errorf("refresh token of the reused code: got %v, want invalid_grant", err)
+	}
+}
+
+func TestExchangeCodeAmr(t *testing.T) {
+	for _, mfa := range []bool{false, true} {
+		tokens := newTokenStore()
+		serv, exchange := authorize(t, tokens, newCodeStore(), model.SsoSession{PIB: 100000001, AuthTime: time.Now(), Mfa: mfa})
+		res, err := serv.Exchange(exchange)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Refreshed tokens keep the amr of the login
+		refreshed, err := serv.Exchange(model.TokenRequest{GrantType: "refresh_token", RefreshToken: res.RefreshToken, ClientId: "portal"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, token := range map[string]string{"access token": res.AccessToken, "ID token": res.IdToken, "refreshed access token": refreshed.AccessToken} {
+			var claims client.TokenClaims
+			if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err != nil {
+				t.Fatal(err)
+			}
+			if claims.HasAmr(client.AmrOtp) != mfa || !claims.HasAmr(client.AmrPassword) {
+				t.Errorf("login with mfa %t: %s has amr %v", mfa, name, claims.Amr)
+			}
+		}
+	}
+}
+
+// ssoAuth accepts any credentials, companies 100000001 and 100000003 log
+// in with their password only
+type ssoAuth struct {
+	AuthService
+}
+
+func (ssoAuth) AuthenticateWithCode(creds model.CredentialsDto, code, ip string) (bool, error) {
+	return false, nil
+}
+
+func TestSsoSession(t *testing.T) {
+	tokens := newTokenStore()
+	jwtGen := testJwtGenerator(t)
+	serv := NewOAuthService("https://apr.example", newCodeStore(), authCompanies{}, tokens, ssoAuth{}, nil, jwtGen)
+
+	token, session, err := serv.StartSession(model.CredentialsDto{PIB: 100000001}, "", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := serv.Session(token); !ok || got.PIB != 100000001 {
+		t.Fatalf("got session %+v, %t, want that of 100000001", got, ok)
+	}
+	// A password change ends every session of the company, the SSO one too
+	if err := tokens.RevokeAll(100000001); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := serv.Session(token); ok {
+		t.Error("SSO session is still valid after the sessions of the company were revoked")
+	}
+
+	token, _, err = serv.StartSession(model.CredentialsDto{PIB: 100000001}, "", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := serv.EndSession(token); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := serv.Session(token); ok {
+		t.Error("SSO session is still valid after logout")
+	}
+
+	// The company was liquidated after it logged in
+	req := model.AuthorizationRequest{ClientId: "portal", RedirectUri: "https://portal.example/callback", Scope: "openid"}
+	session.PIB = 100000003
+	var oauthErr model.OAuthError
+	if _, err := serv.Authorize(req, session); !errors.As(err, &oauthErr) || oauthErr.Code != "access_denied" {
+		t.Errorf("liquidated company: got %v, want access_denied", err)
+	}
+}
+
+func TestExchangeCodeLiquidated(t *testing.T) {
+	tokens := newTokenStore()
+	codes := newCodeStore()
+	serv, exchange := authorize(t, tokens, codes, model.SsoSession{PIB: 100000001, AuthTime: time.Now()})
+	// Liquidated between the authorization and the exchange
+	codes.codes[hashToken(exchange.Code)].PIB = 100000003
+	var oauthErr model.OAuthError
+	if _, err := serv.Exchange(exchange); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+		t.Errorf("got %v, want invalid_grant", err)
+	}
+}
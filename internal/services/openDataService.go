@@ -0,0 +1,317 @@
+package services
+
+import (
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrNoSuchSnapshot = errors.New("Snapshot doesn't exist")
+
+const (
+	snapshotNameLayout = "2006-01-02T15-04-05Z"
+	snapshotSuffix     = ".snapshot.json.gz"
+	diffSuffix         = ".diff.json.gz"
+)
+
+var validSnapshotFile = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2}Z\.(snapshot|diff)\.json\.gz$`)
+
+type OpenDataService interface {
+	// Writes a new snapshot of the public registry and its diff against the
+	// latest existing snapshot.
+	CreateSnapshot() (model.Snapshot, error)
+	// Lists snapshots, newest first.
+	ListSnapshots() ([]model.Snapshot, error)
+	// Opens a snapshot or diff file by its name.
+	OpenFile(name string) (*os.File, error)
+	// Creates a snapshot every interval until ctx is done. A snapshot is taken
+	// immediately if the latest one is older than interval.
+	Run(ctx context.Context, interval time.Duration)
+}
+
+func NewOpenDataService(dir string, comRepo db.CompanyRepository, nstjRepo db.NstjRepository) (OpenDataService, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("Error creating open data directory: %w", err)
+	}
+	return openDataService{
+		dir:      dir,
+		comRepo:  comRepo,
+		nstjRepo: nstjRepo,
+	}, nil
+}
+
+type openDataService struct {
+	dir      string
+	comRepo  db.CompanyRepository
+	nstjRepo db.NstjRepository
+}
+
+// ListSnapshots implements OpenDataService
+func (ods openDataService) ListSnapshots() ([]model.Snapshot, error) {
+	entries, err := os.ReadDir(ods.dir)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading open data directory: %w", err)
+	}
+
+	snapshots := make([]model.Snapshot, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), snapshotSuffix) || !validSnapshotFile.MatchString(entry.Name()) {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), snapshotSuffix)
+		createdAt, err := time.Parse(snapshotNameLayout, name)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("Error reading snapshot %s: %w", name, err)
+		}
+		snapshot := model.Snapshot{
+			Name:      name,
+			CreatedAt: createdAt,
+			File:      name + snapshotSuffix,
+			Size:      info.Size(),
+		}
+		// Only advertised if it exists, snapshots of older versions may
+		// lack one
+		if _, err := os.Stat(filepath.Join(ods.dir, name+diffSuffix)); err == nil {
+			snapshot.DiffFile = name + diffSuffix
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// OpenFile implements OpenDataService
+func (ods openDataService) OpenFile(name string) (*os.File, error) {
+	if !validSnapshotFile.MatchString(name) {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchSnapshot, name)
+	}
+	f, err := os.Open(filepath.Join(ods.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchSnapshot, name)
+	}
+	return f, err
+}
+
+func (ods openDataService) readSnapshot(name string) (model.SnapshotContent, error) {
+	var content model.SnapshotContent
+	f, err := os.Open(filepath.Join(ods.dir, name+snapshotSuffix))
+	if err != nil {
+		return content, fmt.Errorf("Error opening snapshot %s: %w", name, err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return content, fmt.Errorf("Error decompressing snapshot %s: %w", name, err)
+	}
+	defer gz.Close()
+	if err := json.NewDecoder(gz).Decode(&content); err != nil {
+		return content, fmt.Errorf("Error decoding snapshot %s: %w", name, err)
+	}
+	return content, nil
+}
+
+// writeGzipFile writes to a temporary file first, so readers never see a
+// partially written snapshot.
+func (ods openDataService) writeGzipFile(name string, write func(w io.Writer) error) error {
+	tmp, err := ods.stageGzipFile(name, write)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return os.Rename(tmp, filepath.Join(ods.dir, name))
+}
+
+// stageGzipFile writes a temporary file, which isn't listed, and returns
+// its path. Callers rename it to publish it.
+func (ods openDataService) stageGzipFile(name string, write func(w io.Writer) error) (string, error) {
+	f, err := os.CreateTemp(ods.dir, ".tmp-"+name)
+	if err != nil {
+		return "", fmt.Errorf("Error creating %s: %w", name, err)
+	}
+	defer f.Close()
+	staged := false
+	defer func() {
+		if !staged {
+			os.Remove(f.Name())
+		}
+	}()
+
+	gz := gzip.NewWriter(f)
+	buf := bufio.NewWriter(gz)
+	if err := write(buf); err != nil {
+		return "", err
+	}
+	if err := buf.Flush(); err != nil {
+		return "", fmt.Errorf("Error writing %s: %w", name, err)
+	}
+	if err := gz.Close(); err != nil {
+		return "", fmt.Errorf("Error writing %s: %w", name, err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("Error writing %s: %w", name, err)
+	}
+	staged = true
+	return f.Name(), nil
+}
+
+// CreateSnapshot implements OpenDataService
+func (ods openDataService) CreateSnapshot() (model.Snapshot, error) {
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	name := createdAt.Format(snapshotNameLayout)
+	diff := model.SnapshotDiff{
+		Current:   name,
+		Added:     []model.Company{},
+		Changed:   []model.CompanyChange{},
+		StruckOff: []model.Company{},
+	}
+
+	previous := make(map[int]model.Company)
+	snapshots, err := ods.ListSnapshots()
+	if err != nil {
+		return model.Snapshot{}, err
+	}
+	if len(snapshots) > 0 {
+		diff.Previous = snapshots[0].Name
+		content, err := ods.readSnapshot(diff.Previous)
+		if err != nil {
+			return model.Snapshot{}, err
+		}
+		for _, com := range content.Companies {
+			previous[com.PIB] = com
+		}
+	}
+
+	nstj, err := ods.nstjRepo.FindAll()
+	if err != nil {
+		return model.Snapshot{}, err
+	}
+
+	// The snapshot is only published once its diff is written, so every
+	// listed snapshot has one
+	staged, err := ods.stageGzipFile(name+snapshotSuffix, func(w io.Writer) error {
+		// Companies are streamed into the document, the rest of
+		// model.SnapshotContent is small and encoded up front.
+		createdAtJson, _ := json.Marshal(createdAt)
+		nstjJson, err := json.Marshal(nstj)
+		if err != nil {
+			return fmt.Errorf("Error encoding NSTJ: %w", err)
+		}
+		delatnostiJson, _ := json.Marshal(model.Delatnosti.List())
+		_, err = fmt.Fprintf(w, `{"createdAt":%s,"nstj":%s,"delatnosti":%s,"companies":[`, createdAtJson, nstjJson, delatnostiJson)
+		if err != nil {
+			return err
+		}
+
+		first := true
+		filter := model.CompanyFilter{OrderBy: "PIB", Asc: true}
+		err = ods.comRepo.StreamCompanies(filter, func(com model.Company) error {
+			com = com.Masked()
+			if before, ok := previous[com.PIB]; !ok {
+				diff.Added = append(diff.Added, com)
+			} else if before != com {
+				diff.Changed = append(diff.Changed, model.CompanyChange{Before: before, After: com})
+			}
+			delete(previous, com.PIB)
+
+			data, err := json.Marshal(com)
+			if err != nil {
+				return fmt.Errorf("Error encoding company %d: %w", com.PIB, err)
+			}
+			if !first {
+				data = append([]byte{','}, data...)
+			}
+			first = false
+			_, err = w.Write(data)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, "]}")
+		return err
+	})
+	if err != nil {
+		return model.Snapshot{}, err
+	}
+	defer os.Remove(staged)
+
+	for _, com := range previous {
+		diff.StruckOff = append(diff.StruckOff, com)
+	}
+	sort.Slice(diff.StruckOff, func(i, j int) bool {
+		return diff.StruckOff[i].PIB < diff.StruckOff[j].PIB
+	})
+
+	err = ods.writeGzipFile(name+diffSuffix, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(diff)
+	})
+	if err != nil {
+		return model.Snapshot{}, err
+	}
+	if err := os.Rename(staged, filepath.Join(ods.dir, name+snapshotSuffix)); err != nil {
+		os.Remove(filepath.Join(ods.dir, name+diffSuffix))
+		return model.Snapshot{}, fmt.Errorf("Error publishing snapshot %s: %w", name, err)
+	}
+
+	info, err := os.Stat(filepath.Join(ods.dir, name+snapshotSuffix))
+	if err != nil {
+		return model.Snapshot{}, fmt.Errorf("Error reading snapshot %s: %w", name, err)
+	}
+	return model.Snapshot{
+		Name:      name,
+		CreatedAt: createdAt,
+		File:      name + snapshotSuffix,
+		DiffFile:  name + diffSuffix,
+		Size:      info.Size(),
+	}, nil
+}
+
+// Run implements OpenDataService
+func (ods openDataService) Run(ctx context.Context, interval time.Duration) {
+	snapshot := func() {
+		s, err := ods.CreateSnapshot()
+		if err != nil {
+			log.Printf("Error creating open data snapshot: %s", err.Error())
+			return
+		}
+		log.Printf("Created open data snapshot %s", s.Name)
+	}
+
+	snapshots, err := ods.ListSnapshots()
+	if err != nil {
+		log.Printf("Error listing open data snapshots: %s", err.Error())
+	}
+	if err == nil && (len(snapshots) == 0 || time.Since(snapshots[0].CreatedAt) >= interval) {
+		snapshot()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			snapshot()
+		}
+	}
+}
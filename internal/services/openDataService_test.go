@@ -0,0 +1,78 @@
+package services
+
+import (
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type snapshotCompanies struct {
+	db.CompanyRepository
+	companies []model.Company
+}
+
+func (sc snapshotCompanies) StreamCompanies(filter model.CompanyFilter, fn func(model.Company) error) error {
+	for _, com := range sc.companies {
+		if err := fn(com); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type snapshotNstj struct {
+	db.NstjRepository
+}
+
+func (snapshotNstj) FindAll() ([]model.Nstj, error) {
+	return []model.Nstj{}, nil
+}
+
+func TestListSnapshotsAdvertisesExistingDiffs(t *testing.T) {
+	dir := t.TempDir()
+	serv, err := NewOpenDataService(dir, snapshotCompanies{companies: []model.Company{{PIB: 100000001, Naziv: "A"}}}, snapshotNstj{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := serv.CreateSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, created.DiffFile)); err != nil {
+		t.Fatalf("diff of created snapshot: %v", err)
+	}
+
+	// A snapshot of an older version, without a diff
+	old := "2020-01-01T00-00-00Z"
+	if err := os.WriteFile(filepath.Join(dir, old+snapshotSuffix), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		diffFile string
+	}{
+		{created.Name, created.Name + diffSuffix},
+		{old, ""},
+	}
+	snapshots, err := serv.ListSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != len(tests) {
+		t.Fatalf("got %d snapshots, want %d", len(snapshots), len(tests))
+	}
+	for i, tt := range tests {
+		if snapshots[i].Name != tt.name || snapshots[i].DiffFile != tt.diffFile {
+			t.Errorf("snapshot %d = %s with diff %q, want %s with %q", i, snapshots[i].Name, snapshots[i].DiffFile, tt.name, tt.diffFile)
+		}
+	}
+
+	// Staged files aren't left behind
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("got %d files in the open data directory, want 3", len(entries))
+	}
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"apr-backend/internal/auth"
+	"apr-backend/internal/db"
+	"apr-backend/internal/lockout"
+	"apr-backend/internal/model"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Returned for JMBGs which don't exist and for names which don't match, so
+// registrations can't be used to find out who is in the registry
+var ErrUnknownPerson = errors.New("No person with this JMBG, name and lastname")
+var ErrAccountInactive = errors.New("Account hasn't been activated")
+
+// How long companies have to hand over an activation code
+const activationCodeLifetime = 72 * time.Hour
+
+type PersonService interface {
+	// Creates an account for a person already in the registry. The name
+	// and lastname have to match the registry, which anyone knowing the
+	// person could do, so the account stays inactive until the person
+	// proves who they are with an activation code.
+	Register(reg model.PersonRegistration) error
+	// Sends a new activation code for the inactive account of jmbg to
+	// every company the person owns or represents. Whoever registered
+	// can't receive it unless such a company hands it over. Nothing is
+	// reported for unknown or active accounts.
+	RequestActivation(jmbg string) error
+	// Activates the account of jmbg with a code sent by RequestActivation
+	Activate(jmbg string, code string) error
+	// Checks the credentials of a person and returns a token for
+	// client.AprPerson. Failed logins are counted like company logins,
+	// inactive accounts get ErrAccountInactive.
+	Login(creds model.PersonCredentials, ip string) (string, error)
+	// Companies the person can act for
+	FindCompanies(jmbg string) ([]model.PersonCompany, error)
+	// Starts a session of the person acting for pib, which they have to own
+	// or represent
+	Act(jmbg string, pib int) (model.TokenPair, error)
+	// Starts a session of the person as a registrar official, with their
+	// roles
+	ActAsOfficial(jmbg string) (model.TokenPair, error)
+	// Representatives are managed by the owner of the company or the
+	// company itself, when actor is empty.
+	FindRepresentatives(pib int, actor string) ([]model.Person, error)
+	AddRepresentative(pib int, actor string, jmbg string) error
+	// Removes a representative and ends their sessions for pib
+	RemoveRepresentative(pib int, actor string, jmbg string) error
+}
+
+// notifier delivers activation codes, registration is disabled without it
+func NewPersonService(personRepo db.PersonRepository, accountRepo db.PersonAccountRepository, tokenRepo db.TokenRepository, authServ AuthService, jwtGen auth.JwtGenerator, guard lockout.Guard, notifier Notifier) PersonService {
+	return personService{
+		personRepo:  personRepo,
+		accountRepo: accountRepo,
+		tokenRepo:   tokenRepo,
+		authServ:    authServ,
+		jwtGen:      jwtGen,
+		guard:       guard,
+		notifier:    notifier,
+	}
+}
+
+type personService struct {
+	personRepo  db.PersonRepository
+	accountRepo db.PersonAccountRepository
+	tokenRepo   db.TokenRepository
+	authServ    AuthService
+	jwtGen      auth.JwtGenerator
+	guard       lockout.Guard
+	notifier    Notifier
+}
+
+// Register implements PersonService
+func (ps personService) Register(reg model.PersonRegistration) error {
+	if ps.notifier == nil {
+		return ErrNotifierDisabled
+	}
+	if !validatePassword(reg.Password) {
+		return ErrInvalidPassword
+	}
+	persons, err := ps.personRepo.FindMany([]string{reg.Jmbg})
+	if err != nil {
+		return err
+	}
+	if len(persons) == 0 ||
+		!strings.EqualFold(strings.TrimSpace(reg.Name), persons[0].Name) ||
+		!strings.EqualFold(strings.TrimSpace(reg.Lastname), persons[0].Lastname) {
+		return ErrUnknownPerson
+	}
+	hash, err := bcryptHash(reg.Password)
+	if err != nil {
+		return err
+	}
+	if err := ps.accountRepo.SaveAccount(reg.Jmbg, hash); err != nil {
+		return err
+	}
+	// The person can ask for another code if this one doesn't arrive
+	if err := ps.sendActivation(reg.Jmbg); err != nil {
+		log.Printf("Error sending activation code of %s: %s", reg.Jmbg, err.Error())
+	}
+	return nil
+}
+
+// RequestActivation implements PersonService
+func (ps personService) RequestActivation(jmbg string) error {
+	if ps.notifier == nil {
+		return ErrNotifierDisabled
+	}
+	_, active, err := ps.accountRepo.FindPassword(jmbg)
+	if errors.Is(err, db.NoSuchPersonAccountError) || err == nil && active {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return ps.sendActivation(jmbg)
+}
+
+// sendActivation sends a new activation code of jmbg to the companies the
+// person owns or represents
+func (ps personService) sendActivation(jmbg string) error {
+	companies, err := ps.accountRepo.FindCompanies(jmbg)
+	if err != nil || len(companies) == 0 {
+		return err
+	}
+	code, hash, err := randomToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().UTC().Add(activationCodeLifetime).Truncate(time.Second)
+	if err := ps.accountRepo.SaveActivation(hash, jmbg, expiresAt); err != nil {
+		return err
+	}
+	for _, com := range companies {
+		if err := ps.notifier.NotifyPersonActivation(com.PIB, jmbg, code, expiresAt); err != nil {
+			log.Printf("Error delivering activation code of %s to %d: %s", jmbg, com.PIB, err.Error())
+		}
+	}
+	return nil
+}
+
+// Activate implements PersonService
+func (ps personService) Activate(jmbg string, code string) error {
+	return ps.accountRepo.Activate(hashToken(code), jmbg)
+}
+
+// Login implements PersonService
+func (ps personService) Login(creds model.PersonCredentials, ip string) (string, error) {
+	attempt, err := ps.guard.AttemptPerson(creds.Jmbg, ip)
+	if err != nil {
+		return "", err
+	}
+	hash, active, err := ps.accountRepo.FindPassword(creds.Jmbg)
+	if err == nil {
+		err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(creds.Password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			err = ErrWrongPassword
+		}
+	}
+	finish(attempt, errors.Is(err, ErrWrongPassword) || errors.Is(err, db.NoSuchPersonAccountError))
+	if err != nil {
+		return "", err
+	}
+	if err := ps.guard.SucceededPerson(creds.Jmbg); err != nil {
+		log.Printf("Error resetting failed logins: %s", err.Error())
+	}
+	// Only checked with the right password, so it doesn't tell others
+	// which accounts exist
+	if !active {
+		return "", ErrAccountInactive
+	}
+	return ps.jwtGen.GeneratePersonJWT(creds.Jmbg)
+}
+
+// FindCompanies implements PersonService
+func (ps personService) FindCompanies(jmbg string) ([]model.PersonCompany, error) {
+	return ps.accountRepo.FindCompanies(jmbg)
+}
+
+// Act implements PersonService
+func (ps personService) Act(jmbg string, pib int) (model.TokenPair, error) {
+	if _, err := ps.accountRepo.FindRole(pib, jmbg); err != nil {
+		return model.TokenPair{}, err
+	}
+	return ps.authServ.IssueActingTokens(pib, jmbg)
+}
+
+// ActAsOfficial implements PersonService
+func (ps personService) ActAsOfficial(jmbg string) (model.TokenPair, error) {
+	return ps.authServ.IssueOfficialTokens(jmbg)
+}
+
+// canManage checks that actor may manage the representatives of pib
+func (ps personService) canManage(pib int, actor string) error {
+	if actor == "" {
+		return nil
+	}
+	role, err := ps.accountRepo.FindRole(pib, actor)
+	if errors.Is(err, db.NotRepresentativeError) || (err == nil && role != model.RoleOwner) {
+		return fmt.Errorf("%s can't manage representatives of %d: %w", actor, pib, ErrNotOwner)
+	}
+	return err
+}
+
+// FindRepresentatives implements PersonService
+func (ps personService) FindRepresentatives(pib int, actor string) ([]model.Person, error) {
+	if err := ps.canManage(pib, actor); err != nil {
+		return nil, err
+	}
+	return ps.accountRepo.FindRepresentatives(pib)
+}
+
+// AddRepresentative implements PersonService
+func (ps personService) AddRepresentative(pib int, actor string, jmbg string) error {
+	if err := ps.canManage(pib, actor); err != nil {
+		return err
+	}
+	return ps.accountRepo.AddRepresentative(pib, jmbg, actor)
+}
+
+// RemoveRepresentative implements PersonService
+func (ps personService) RemoveRepresentative(pib int, actor string, jmbg string) error {
+	if err := ps.canManage(pib, actor); err != nil {
+		return err
+	}
+	if err := ps.accountRepo.RemoveRepresentative(pib, jmbg); err != nil {
+		return err
+	}
+	return ps.tokenRepo.RevokeActing(pib, jmbg)
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"apr-backend/internal/db"
+	"apr-backend/internal/lockout"
+	"apr-backend/internal/model"
+	"errors"
+	"testing"
+	"time"
+)
+
+// registryPersons has Petar Petrovic, who owns company 100000001
+type registryPersons struct {
+	db.PersonRepository
+}
+
+func (registryPersons) FindMany(jmbgs []string) ([]model.Person, error) {
+	if jmbgs[0] != "0101990710006" {
+		return nil, nil
+	}
+	return []model.Person{{Jmbg: "0101990710006", Name: "Petar", Lastname: "Petrovic"}}, nil
+}
+
+// personAccounts keeps accounts and activation codes in memory
+type personAccounts struct {
+	db.PersonAccountRepository
+	passwords   map[string]string
+	active      map[string]bool
+	activations map[string]string
+}
+
+func (pa *personAccounts) SaveAccount(jmbg string, passwordHash string) error {
+	if _, ok := pa.passwords[jmbg]; ok {
+		return db.PersonAccountExistsError
+	}
+	pa.passwords[jmbg] = passwordHash
+	return nil
+}
+
+func (pa *personAccounts) FindPassword(jmbg string) (string, bool, error) {
+	hash, ok := pa.passwords[jmbg]
+	if !ok {
+		return "", false, db.NoSuchPersonAccountError
+	}
+	return hash, pa.active[jmbg], nil
+}
+
+func (pa *personAccounts) FindCompanies(jmbg string) ([]model.PersonCompany, error) {
+	if jmbg != "0101990710006" {
+		return []model.PersonCompany{}, nil
+	}
+	return []model.PersonCompany{{PIB: 100000001, Naziv: "Firma", Role: model.RoleOwner}}, nil
+}
+
+func (pa *personAccounts) SaveActivation(hash string, jmbg string, expiresAt time.Time) error {
+	pa.activations[hash] = jmbg
+	return nil
+}
+
+func (pa *personAccounts) Activate(hash string, jmbg string) error {
+	if pa.activations[hash] != jmbg {
+		return db.NoSuchActivationError
+	}
+	delete(pa.activations, hash)
+	pa.active[jmbg] = true
+	return nil
+}
+
+// activationNotifier keeps the last activation code sent to each company
+type activationNotifier struct {
+	Notifier
+	codes map[int]string
+}
+
+func (an activationNotifier) NotifyPersonActivation(pib int, jmbg string, code string, expiresAt time.Time) error {
+	an.codes[pib] = code
+	return nil
+}
+
+func TestPersonActivation(t *testing.T) {
+	accounts := &personAccounts{passwords: map[string]string{}, active: map[string]bool{}, activations: map[string]string{}}
+	notifier := activationNotifier{codes: map[int]string{}}
+	guard := lockout.NewGuard(lockout.NewMemoryStore(), nil, lockout.DefaultPibPolicy, lockout.DefaultIpPolicy)
+	serv := NewPersonService(registryPersons{}, accounts, nil, nil, testJwtGenerator(t), guard, notifier)
+	creds := model.PersonCredentials{Jmbg: "0101990710006", Password: "lozinka osobe"}
+
+	err := serv.Register(model.PersonRegistration{Jmbg: creds.Jmbg, Name: "petar", Lastname: "Petrovic ", Password: creds.Password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Knowing the name and JMBG isn't enough to act for the company
+	if _, err := serv.Login(creds, "10.0.0.1"); !errors.Is(err, ErrAccountInactive) {
+		t.Fatalf("got %v, want %v", err, ErrAccountInactive)
+	}
+	code, ok := notifier.codes[100000001]
+	if !ok {
+		t.Fatal("the owned company didn't receive an activation code")
+	}
+
+	if err := serv.RequestActivation(creds.Jmbg); err != nil {
+		t.Fatal(err)
+	}
+	if notifier.codes[100000001] == code {
+		t.Error("requesting activation again sent the same code")
+	}
+	tests := []struct {
+		name    string
+		jmbg    string
+		code    string
+		wantErr error
+	}{
+		{"wrong code", creds.Jmbg, "guessed", db.NoSuchActivationError},
+		{"code of another person", "0101990710007", code, db.NoSuchActivationError},
+		{"first code", creds.Jmbg, code, nil},
+		{"used code", creds.Jmbg, code, db.NoSuchActivationError},
+	}
+	for _, tt := range tests {
+		if err := serv.Activate(tt.jmbg, tt.code); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if _, err := serv.Login(creds, "10.0.0.1"); err != nil {
+		t.Errorf("activated account can't log in: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+var ErrInvalidRole = errors.New("Role must be one of clerk, supervisor, admin or auditor")
+var ErrNotOfficial = errors.New("Person isn't a registrar official")
+
+// Roles which can be granted to officials
+var officialRoles = map[string]bool{
+	client.RoleClerk:      true,
+	client.RoleSupervisor: true,
+	client.RoleAdmin:      true,
+	client.RoleAuditor:    true,
+}
+
+type RoleService interface {
+	// Roles carried by tokens of pib, with the person acting for it or
+	// without one for company logins
+	CompanyRoles(pib int, actor string) []string
+	// Roles of the official with jmbg, ErrNotOfficial if they have none
+	OfficialRoles(jmbg string) ([]string, error)
+	FindOfficials() ([]model.OfficialRole, error)
+	Grant(role model.OfficialRole) error
+	// Revokes a role of an official and ends their sessions, so that tokens
+	// with the role are rejected by APR and aren't refreshed. Services which
+	// don't check revoked sessions accept them until they expire, for up to
+	// auth.AccessTokenLifetime.
+	Revoke(jmbg string, role string) error
+}
+
+// Principals listed in admins, PIBs or JMBGs, always get the admin role. It
+// is meant for granting the first roles. A listed PIB makes whoever knows
+// the company's password an admin, persons acting for the company aren't.
+// JMBGs of officials should be preferred.
+func NewRoleService(officialRepo db.OfficialRepository, tokenRepo db.TokenRepository, admins []string) RoleService {
+	allowed := make(map[string]bool, len(admins))
+	for _, admin := range admins {
+		allowed[admin] = true
+	}
+	return roleService{officialRepo: officialRepo, tokenRepo: tokenRepo, admins: allowed}
+}
+
+type roleService struct {
+	officialRepo db.OfficialRepository
+	tokenRepo    db.TokenRepository
+	admins       map[string]bool
+}
+
+// CompanyRoles implements RoleService
+func (rs roleService) CompanyRoles(pib int, actor string) []string {
+	roles := []string{client.RoleCompany}
+	if actor == "" && rs.admins[strconv.Itoa(pib)] {
+		roles = append(roles, client.RoleAdmin)
+	}
+	return roles
+}
+
+// OfficialRoles implements RoleService
+func (rs roleService) OfficialRoles(jmbg string) ([]string, error) {
+	roles, err := rs.officialRepo.FindRoles(jmbg)
+	if err != nil {
+		return nil, err
+	}
+	if rs.admins[jmbg] && !contains(roles, client.RoleAdmin) {
+		roles = append(roles, client.RoleAdmin)
+	}
+	if len(roles) == 0 {
+		return nil, fmt.Errorf("%s: %w", jmbg, ErrNotOfficial)
+	}
+	return roles, nil
+}
+
+// FindOfficials implements RoleService
+func (rs roleService) FindOfficials() ([]model.OfficialRole, error) {
+	return rs.officialRepo.FindAll()
+}
+
+// Grant implements RoleService
+func (rs roleService) Grant(role model.OfficialRole) error {
+	if !officialRoles[role.Role] {
+		return ErrInvalidRole
+	}
+	if err := rs.officialRepo.Grant(role); err != nil {
+		return err
+	}
+	log.Printf("Role %s granted to %s by %s", role.Role, role.Jmbg, role.GrantedBy)
+	return nil
+}
+
+// Revoke implements RoleService
+func (rs roleService) Revoke(jmbg string, role string) error {
+	if err := rs.officialRepo.Revoke(jmbg, role); err != nil {
+		return err
+	}
+	return rs.tokenRepo.RevokeOfficial(jmbg)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,281 @@
+package services
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/auth"
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"crypto"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var ErrInvalidScope = errors.New("Scopes must be companies:read or nstj:read")
+var ErrInvalidPublicKey = errors.New("Public key must be a PEM encoded RSA or EC key")
+
+// Scopes which can be granted to service accounts
+var serviceAccountScopes = []string{client.ScopeCompaniesRead, client.ScopeNstjRead}
+
+const (
+	jwtBearerAssertion = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	// Assertions valid for longer are refused, so that the replay cache
+	// stays small
+	maxAssertionLifetime = 5 * time.Minute
+)
+
+type ServiceAccountService interface {
+	FindAll() ([]model.ServiceAccount, error)
+	// Creates an account, with a secret if it authenticates with one
+	Create(account model.ServiceAccount) (model.ServiceAccountCredentials, error)
+	// Replaces the secret or public key of an account and revokes the
+	// tokens issued to it
+	Rotate(clientId string, req model.RotateCredentialsRequest) (model.ServiceAccountCredentials, error)
+	// Disabling an account revokes the tokens issued to it
+	SetDisabled(clientId string, disabled bool) error
+	// Implements the client credentials grant, errors of the request are a
+	// model.OAuthError
+	IssueToken(req model.TokenRequest) (model.OidcTokenResponse, error)
+}
+
+// Assertions have to be issued for issuer or its token endpoint
+func NewServiceAccountService(issuer string, accountRepo db.ServiceAccountRepository, tokenRepo db.TokenRepository, jwtGen auth.JwtGenerator) ServiceAccountService {
+	issuer = strings.TrimSuffix(issuer, "/")
+	return serviceAccountService{
+		audiences:   []string{issuer, issuer + OAuthTokenPath},
+		accountRepo: accountRepo,
+		tokenRepo:   tokenRepo,
+		jwtGen:      jwtGen,
+	}
+}
+
+type serviceAccountService struct {
+	audiences   []string
+	accountRepo db.ServiceAccountRepository
+	tokenRepo   db.TokenRepository
+	jwtGen      auth.JwtGenerator
+}
+
+func parsePublicKey(pem string) (crypto.PublicKey, error) {
+	if key, err := jwt.ParseRSAPublicKeyFromPEM([]byte(pem)); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseECPublicKeyFromPEM([]byte(pem)); err == nil {
+		return key, nil
+	}
+	return nil, ErrInvalidPublicKey
+}
+
+// credentials returns new credentials for account
+func credentials(account model.ServiceAccount) (model.ServiceAccountCredentials, string, error) {
+	creds := model.ServiceAccountCredentials{Account: account}
+	if account.AuthMethod == model.AuthPrivateKeyJwt {
+		if _, err := parsePublicKey(account.PublicKey); err != nil {
+			return creds, "", err
+		}
+		return creds, "", nil
+	}
+	creds.Account.PublicKey = ""
+	secret, hash, err := randomToken()
+	if err != nil {
+		return creds, "", err
+	}
+	creds.ClientSecret = secret
+	return creds, hash, nil
+}
+
+// FindAll implements ServiceAccountService
+func (sas serviceAccountService) FindAll() ([]model.ServiceAccount, error) {
+	return sas.accountRepo.FindAll()
+}
+
+// Create implements ServiceAccountService
+func (sas serviceAccountService) Create(account model.ServiceAccount) (model.ServiceAccountCredentials, error) {
+	for _, scope := range account.Scopes {
+		if !contains(serviceAccountScopes, scope) {
+			return model.ServiceAccountCredentials{}, ErrInvalidScope
+		}
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return model.ServiceAccountCredentials{}, fmt.Errorf("Error generating client id: %w", err)
+	}
+	account.ClientId = "sa-" + hex.EncodeToString(id)
+	account.Disabled = false
+
+	creds, hash, err := credentials(account)
+	if err != nil {
+		return creds, err
+	}
+	if err := sas.accountRepo.Save(&creds.Account, hash); err != nil {
+		return creds, err
+	}
+	return creds, nil
+}
+
+// Rotate implements ServiceAccountService
+func (sas serviceAccountService) Rotate(clientId string, req model.RotateCredentialsRequest) (model.ServiceAccountCredentials, error) {
+	account, _, err := sas.accountRepo.FindOne(clientId)
+	if err != nil {
+		return model.ServiceAccountCredentials{}, err
+	}
+	account.PublicKey = req.PublicKey
+	creds, hash, err := credentials(account)
+	if err != nil {
+		return creds, err
+	}
+	if err := sas.accountRepo.UpdateCredentials(clientId, hash, creds.Account.PublicKey); err != nil {
+		return creds, err
+	}
+	return creds, nil
+}
+
+// SetDisabled implements ServiceAccountService
+func (sas serviceAccountService) SetDisabled(clientId string, disabled bool) error {
+	return sas.accountRepo.SetDisabled(clientId, disabled)
+}
+
+func invalidClient(description string) model.OAuthError {
+	return model.OAuthError{Code: "invalid_client", Description: description}
+}
+
+// authenticate returns the account which made req and the hash of its
+// secret
+func (sas serviceAccountService) authenticate(req model.TokenRequest) (model.ServiceAccount, string, error) {
+	clientId := req.ClientId
+	if req.ClientAssertion != "" {
+		if req.ClientAssertionType != jwtBearerAssertion {
+			return model.ServiceAccount{}, "", invalidClient("client_assertion_type must be " + jwtBearerAssertion)
+		}
+		// The account is known by the issuer of the assertion
+		var unverified jwt.RegisteredClaims
+		if _, _, err := jwt.NewParser().ParseUnverified(req.ClientAssertion, &unverified); err != nil {
+			return model.ServiceAccount{}, "", invalidClient("Invalid client_assertion")
+		}
+		if clientId == "" {
+			clientId = unverified.Issuer
+		}
+	}
+
+	account, secretHash, err := sas.accountRepo.FindOne(clientId)
+	if errors.Is(err, db.NoSuchServiceAccountError) {
+		return account, "", invalidClient("Unknown client")
+	}
+	if err != nil {
+		return account, "", err
+	}
+	switch {
+	case account.AuthMethod == model.AuthPrivateKeyJwt && req.ClientAssertion != "":
+		err = sas.verifyAssertion(account, req.ClientAssertion)
+	case account.AuthMethod == model.AuthClientSecret && req.ClientAssertion == "":
+		if secretHash == "" || subtle.ConstantTimeCompare([]byte(hashToken(req.ClientSecret)), []byte(secretHash)) != 1 {
+			err = invalidClient("Invalid client credentials")
+		}
+	default:
+		err = invalidClient("Client must authenticate with " + account.AuthMethod)
+	}
+	if err != nil {
+		return account, "", err
+	}
+	if account.Disabled {
+		return account, "", invalidClient("Service account is disabled")
+	}
+	return account, secretHash, nil
+}
+
+// verifyAssertion checks a private_key_jwt assertion, RFC 7523. Every
+// assertion can be used once.
+func (sas serviceAccountService) verifyAssertion(account model.ServiceAccount, assertion string) error {
+	key, err := parsePublicKey(account.PublicKey)
+	if err != nil {
+		return err
+	}
+	var claims jwt.RegisteredClaims
+	_, err = jwt.ParseWithClaims(assertion, &claims, func(t *jwt.Token) (interface{}, error) {
+		switch t.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+			return key, nil
+		}
+		return nil, fmt.Errorf("Unexpected signing method: %v", t.Header["alg"])
+	})
+	if err != nil {
+		return invalidClient("Invalid client_assertion")
+	}
+	now := time.Now()
+	audience := false
+	for _, aud := range sas.audiences {
+		audience = audience || claims.VerifyAudience(aud, true)
+	}
+	switch {
+	case claims.Issuer != account.ClientId || claims.Subject != account.ClientId:
+		return invalidClient("iss and sub of client_assertion must be the client id")
+	case !audience:
+		return invalidClient("client_assertion has the wrong audience")
+	case claims.ExpiresAt == nil || claims.ExpiresAt.After(now.Add(maxAssertionLifetime)):
+		return invalidClient("client_assertion must expire within " + maxAssertionLifetime.String())
+	case claims.ID == "":
+		return invalidClient("client_assertion must have a jti")
+	}
+
+	// Used assertions are kept with revoked tokens until they expire. Only
+	// the request which stores it may use it, concurrent replays fail.
+	first, err := sas.tokenRepo.RevokeOnce(hashToken(account.ClientId+" "+claims.ID), claims.ExpiresAt.Time)
+	if err != nil {
+		return err
+	}
+	if !first {
+		return invalidClient("client_assertion was already used")
+	}
+	return nil
+}
+
+// IssueToken implements ServiceAccountService
+func (sas serviceAccountService) IssueToken(req model.TokenRequest) (model.OidcTokenResponse, error) {
+	account, secretHash, err := sas.authenticate(req)
+	if err != nil {
+		return model.OidcTokenResponse{}, err
+	}
+	scopes := account.Scopes
+	if req.Scope != "" {
+		scopes = strings.Fields(req.Scope)
+		for _, scope := range scopes {
+			if !contains(account.Scopes, scope) {
+				return model.OidcTokenResponse{}, model.OAuthError{Code: "invalid_scope", Description: "Scope " + scope + " isn't granted to the client"}
+			}
+		}
+	}
+
+	token, err := sas.jwtGen.GenerateJWT(auth.Identity{
+		Subject: account.ClientId,
+		Roles:   []string{client.RoleService},
+		Scopes:  scopes,
+	}, client.Apr)
+	if err != nil {
+		return model.OidcTokenResponse{}, err
+	}
+	// Recorded so that the token can be revoked with the account. The
+	// account may have been disabled or rotated since it authenticated.
+	jti, expiresAt, err := tokenId(token)
+	if err != nil {
+		return model.OidcTokenResponse{}, err
+	}
+	err = sas.accountRepo.SaveToken(account, secretHash, jti, expiresAt)
+	if errors.Is(err, db.ServiceAccountChangedError) {
+		return model.OidcTokenResponse{}, invalidClient("Service account was disabled or its credentials replaced")
+	}
+	if err != nil {
+		return model.OidcTokenResponse{}, err
+	}
+	return model.OidcTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(auth.AccessTokenLifetime.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// accountStore keeps service accounts in memory, following the contract
+// of db.ServiceAccountRepository. Tokens of accounts which are disabled or
+// rotated are revoked in tokens.
+type accountStore struct {
+	db.ServiceAccountRepository
+	mu       sync.Mutex
+	tokens   *tokenStore
+	accounts map[string]model.ServiceAccount
+	hashes   map[string]string
+	issued   map[string][]string
+}
+
+func newAccountStore(tokens *tokenStore) *accountStore {
+	return &accountStore{tokens: tokens, accounts: map[string]model.ServiceAccount{}, hashes: map[string]string{}, issued: map[string][]string{}}
+}
+
+func (as *accountStore) FindOne(clientId string) (model.ServiceAccount, string, error) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	account, ok := as.accounts[clientId]
+	if !ok {
+		return account, "", db.NoSuchServiceAccountError
+	}
+	return account, as.hashes[clientId], nil
+}
+
+func (as *accountStore) Save(account *model.ServiceAccount, secretHash string) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	as.accounts[account.ClientId] = *account
+	as.hashes[account.ClientId] = secretHash
+	return nil
+}
+
+func (as *accountStore) revoke(clientId string) {
+	for _, jti := range as.issued[clientId] {
+		as.tokens.RevokeAccessToken(jti, time.Now().Add(time.Hour))
+	}
+	delete(as.issued, clientId)
+}
+
+func (as *accountStore) UpdateCredentials(clientId string, secretHash string, publicKey string) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	account := as.accounts[clientId]
+	account.PublicKey = publicKey
+	as.accounts[clientId] = account
+	as.hashes[clientId] = secretHash
+	as.revoke(clientId)
+	return nil
+}
+
+func (as *accountStore) SetDisabled(clientId string, disabled bool) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	account := as.accounts[clientId]
+	account.Disabled = disabled
+	as.accounts[clientId] = account
+	if disabled {
+		as.revoke(clientId)
+	}
+	return nil
+}
+
+func (as *accountStore) SaveToken(account model.ServiceAccount, secretHash string, jti string, expiresAt time.Time) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	current := as.accounts[account.ClientId]
+	if current.Disabled || as.hashes[account.ClientId] != secretHash || current.PublicKey != account.PublicKey {
+		return db.ServiceAccountChangedError
+	}
+	as.issued[account.ClientId] = append(as.issued[account.ClientId], jti)
+	return nil
+}
+
+func wantInvalidClient(t *testing.T, err error) {
+	t.Helper()
+	var oauthErr model.OAuthError
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_client" {
+		t.Errorf("got %v, want invalid_client", err)
+	}
+}
+
+func TestAssertionReplay(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := newTokenStore()
+	accounts := newAccountStore(tokens)
+	serv := NewServiceAccountService("https://apr.example", accounts, tokens, testJwtGenerator(t))
+	creds, err := serv.Create(model.ServiceAccount{
+		Name:       "eporezi",
+		Scopes:     []string{"companies:read"},
+		AuthMethod: model.AuthPrivateKeyJwt,
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientId := creds.Account.ClientId
+
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+		Issuer:    clientId,
+		Subject:   clientId,
+		Audience:  jwt.ClaimStrings{"https://apr.example" + OAuthTokenPath},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		ID:        "assertion-1",
+	}).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := model.TokenRequest{GrantType: "client_credentials", ClientAssertionType: jwtBearerAssertion, ClientAssertion: assertion}
+	if _, err := serv.IssueToken(req); err != nil {
+		t.Fatal(err)
+	}
+	_, err = serv.IssueToken(req)
+	wantInvalidClient(t, err)
+}
+
+func TestServiceAccountRevocation(t *testing.T) {
+	tokens := newTokenStore()
+	accounts := newAccountStore(tokens)
+	serv := NewServiceAccountService("https://apr.example", accounts, tokens, testJwtGenerator(t))
+	creds, err := serv.Create(model.ServiceAccount{Name: "ecarina", Scopes: []string{"companies:read"}, AuthMethod: model.AuthClientSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientId := creds.Account.ClientId
+	issue := func(secret string) (string, error) {
+		res, err := serv.IssueToken(model.TokenRequest{GrantType: "client_credentials", ClientId: clientId, ClientSecret: secret})
+		if err != nil {
+			return "", err
+		}
+		jti, _, err := tokenId(res.AccessToken)
+		return jti, err
+	}
+
+	jti, err := issue(creds.ClientSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := serv.SetDisabled(clientId, true); err != nil {
+		t.Fatal(err)
+	}
+	if !tokens.revoked[jti] {
+		t.Error("token of a disabled account is still valid")
+	}
+	_, err = issue(creds.ClientSecret)
+	wantInvalidClient(t, err)
+
+	if err := serv.SetDisabled(clientId, false); err != nil {
+		t.Fatal(err)
+	}
+	jti, err = issue(creds.ClientSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := serv.Rotate(clientId, model.RotateCredentialsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tokens.revoked[jti] {
+		t.Error("token issued with the old secret is still valid")
+	}
+	_, err = issue(creds.ClientSecret)
+	wantInvalidClient(t, err)
+	if _, err := issue(rotated.ClientSecret); err != nil {
+		t.Errorf("new secret: %v", err)
+	}
+}
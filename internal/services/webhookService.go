@@ -0,0 +1,225 @@
+package services
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/db"
+	"apr-backend/internal/events"
+	"apr-backend/internal/model"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	webhookMaxAttempts  = 6
+	webhookFirstBackoff = time.Second
+	webhookTimeout      = 10 * time.Second
+	webhookPollInterval = time.Second
+	webhookBatchSize    = 20
+)
+
+type WebhookService interface {
+	// Saves a subscription with a freshly generated secret
+	Create(sub *model.WebhookSubscription) error
+	// Returns all subscriptions, without their secrets
+	FindAll() ([]model.WebhookSubscription, error)
+	Delete(id int) error
+	FindDeadLetters() ([]model.DeadLetter, error)
+	// Queues a dead letter for delivery again, with retries
+	Redeliver(id int) error
+	// Queues a delivery of event to every matching subscription
+	events.Consumer
+	// Sends queued deliveries until ctx is done
+	Run(ctx context.Context)
+}
+
+func NewWebhookService(webhookRepo db.WebhookRepository) WebhookService {
+	return webhookService{
+		webhookRepo: webhookRepo,
+		client:      &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+type webhookService struct {
+	webhookRepo db.WebhookRepository
+	client      *http.Client
+}
+
+// Create implements WebhookService
+func (ws webhookService) Create(sub *model.WebhookSubscription) error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("Error generating webhook secret: %w", err)
+	}
+	sub.Secret = hex.EncodeToString(secret)
+	sub.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	if sub.Types == nil {
+		sub.Types = []string{}
+	}
+	return ws.webhookRepo.Save(sub)
+}
+
+// FindAll implements WebhookService
+func (ws webhookService) FindAll() ([]model.WebhookSubscription, error) {
+	subs, err := ws.webhookRepo.FindAll()
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+	return subs, err
+}
+
+// Delete implements WebhookService
+func (ws webhookService) Delete(id int) error {
+	return ws.webhookRepo.Delete(id)
+}
+
+// FindDeadLetters implements WebhookService
+func (ws webhookService) FindDeadLetters() ([]model.DeadLetter, error) {
+	return ws.webhookRepo.FindDeadLetters()
+}
+
+// Redeliver implements WebhookService
+func (ws webhookService) Redeliver(id int) error {
+	return ws.webhookRepo.RequeueDeadLetter(id, time.Now().UTC())
+}
+
+// send makes a single delivery attempt.
+func (ws webhookService) send(ctx context.Context, sub model.WebhookSubscription, eventId int64, eventType string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(client.WebhookEventHeader, eventType)
+	req.Header.Set(client.WebhookDeliveryHeader, fmt.Sprintf("%d-%d", eventId, sub.Id))
+	req.Header.Set(client.WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(client.WebhookSignatureHeader, client.SignWebhook(sub.Secret, timestamp, payload))
+
+	res, err := ws.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Receiver responded with %s", res.Status)
+	}
+	return nil
+}
+
+// attempt sends a claimed delivery once. A failed delivery is retried with
+// exponential backoff, and becomes a dead letter once every attempt failed.
+func (ws webhookService) attempt(ctx context.Context, d model.WebhookDelivery) {
+	sub, err := ws.webhookRepo.FindOne(d.SubscriptionId)
+	if err != nil {
+		// Deliveries of deleted subscriptions are deleted with them
+		log.Printf("Couldn't get webhook %d: %s", d.SubscriptionId, err.Error())
+		return
+	}
+	err = ws.send(ctx, sub, d.EventId, d.EventType, d.Payload)
+	if err == nil {
+		if err := ws.webhookRepo.DeleteDelivery(d.Id); err != nil {
+			log.Printf("Couldn't remove webhook %d delivery of event %d: %s", sub.Id, d.EventId, err.Error())
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		// Shutting down, the delivery is tried again once its claim expires
+		return
+	}
+
+	d.Attempts++
+	d.LastError = err.Error()
+	log.Printf("Webhook %d delivery of event %d failed (attempt %d): %s", sub.Id, d.EventId, d.Attempts, err.Error())
+	if d.Attempts < webhookMaxAttempts {
+		d.NextAttemptAt = time.Now().UTC().Add(webhookFirstBackoff << (d.Attempts - 1))
+		if err := ws.webhookRepo.RetryDelivery(d); err != nil {
+			log.Printf("Couldn't reschedule webhook %d delivery of event %d: %s", sub.Id, d.EventId, err.Error())
+		}
+		return
+	}
+
+	dl := model.DeadLetter{
+		SubscriptionId: sub.Id,
+		EventId:        d.EventId,
+		EventType:      d.EventType,
+		Payload:        d.Payload,
+		Attempts:       d.Attempts,
+		LastError:      d.LastError,
+		FailedAt:       time.Now().UTC().Truncate(time.Second),
+	}
+	if err := ws.webhookRepo.DeadLetterDelivery(d, &dl); err != nil {
+		log.Printf("Couldn't save dead letter for webhook %d: %s", sub.Id, err.Error())
+	}
+}
+
+// deliverDue sends the deliveries which are due, concurrently so that a
+// slow receiver doesn't hold up the others
+func (ws webhookService) deliverDue(ctx context.Context) {
+	deliveries, err := ws.webhookRepo.ClaimDeliveries(webhookBatchSize, 2*webhookTimeout)
+	if err != nil {
+		log.Printf("Couldn't get webhook deliveries: %s", err.Error())
+		return
+	}
+	var wg sync.WaitGroup
+	for _, d := range deliveries {
+		wg.Add(1)
+		go func(d model.WebhookDelivery) {
+			defer wg.Done()
+			ws.attempt(ctx, d)
+		}(d)
+	}
+	wg.Wait()
+}
+
+// Run implements WebhookService
+func (ws webhookService) Run(ctx context.Context) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ws.deliverDue(ctx)
+		}
+	}
+}
+
+// Consume implements events.Consumer. Deliveries are stored before the relay
+// moves past the event, so they survive a restart.
+func (ws webhookService) Consume(ctx context.Context, event model.Event) error {
+	subs, err := ws.webhookRepo.FindAll()
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("Couldn't encode event %d: %w", event.Id, err)
+	}
+	now := time.Now().UTC()
+	deliveries := make([]model.WebhookDelivery, 0)
+	for _, sub := range subs {
+		if sub.Filter().Matches(event) {
+			deliveries = append(deliveries, model.WebhookDelivery{
+				SubscriptionId: sub.Id,
+				EventId:        event.Id,
+				EventType:      event.Type,
+				Payload:        payload,
+				NextAttemptAt:  now,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return ws.webhookRepo.SaveDeliveries(deliveries)
+}
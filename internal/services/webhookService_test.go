@@ -0,0 +1,171 @@
+package services
+
+import (
+	"apr-backend/client"
+	"apr-backend/internal/db"
+	"apr-backend/internal/model"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookStore keeps subscriptions, deliveries and dead letters in memory
+type webhookStore struct {
+	db.WebhookRepository
+	mu          sync.Mutex
+	subs        []model.WebhookSubscription
+	deliveries  map[int]model.WebhookDelivery
+	deadLetters []model.DeadLetter
+}
+
+func (ws *webhookStore) FindAll() ([]model.WebhookSubscription, error) {
+	return ws.subs, nil
+}
+
+func (ws *webhookStore) FindOne(id int) (model.WebhookSubscription, error) {
+	for _, sub := range ws.subs {
+		if sub.Id == id {
+			return sub, nil
+		}
+	}
+	return model.WebhookSubscription{}, db.NoSuchWebhookError
+}
+
+func (ws *webhookStore) SaveDeliveries(deliveries []model.WebhookDelivery) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for _, d := range deliveries {
+		duplicate := false
+		for _, queued := range ws.deliveries {
+			duplicate = duplicate || queued.SubscriptionId == d.SubscriptionId && queued.EventId == d.EventId
+		}
+		if !duplicate {
+			d.Id = len(ws.deliveries) + len(ws.deadLetters) + 1
+			ws.deliveries[d.Id] = d
+		}
+	}
+	return nil
+}
+
+func (ws *webhookStore) ClaimDeliveries(limit int, lease time.Duration) ([]model.WebhookDelivery, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	now := time.Now().UTC()
+	claimed := []model.WebhookDelivery{}
+	for id, d := range ws.deliveries {
+		if !d.NextAttemptAt.After(now) && len(claimed) < limit {
+			claimed = append(claimed, d)
+			d.NextAttemptAt = now.Add(lease)
+			ws.deliveries[id] = d
+		}
+	}
+	return claimed, nil
+}
+
+func (ws *webhookStore) DeleteDelivery(id int) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	delete(ws.deliveries, id)
+	return nil
+}
+
+func (ws *webhookStore) RetryDelivery(d model.WebhookDelivery) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.deliveries[d.Id] = d
+	return nil
+}
+
+func (ws *webhookStore) DeadLetterDelivery(d model.WebhookDelivery, dl *model.DeadLetter) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	delete(ws.deliveries, d.Id)
+	ws.deadLetters = append(ws.deadLetters, *dl)
+	return nil
+}
+
+// due makes every queued delivery due now, skipping the backoff
+func (ws *webhookStore) due() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for id, d := range ws.deliveries {
+		d.NextAttemptAt = time.Now().UTC()
+		ws.deliveries[id] = d
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	tests := []struct {
+		name string
+		// Status the receiver responds with
+		status          int
+		attempts        int
+		wantQueued      bool
+		wantDeadLetters int
+	}{
+		{"delivered", http.StatusOK, 1, false, 0},
+		{"retried", http.StatusServiceUnavailable, 1, true, 0},
+		{"dead letter", http.StatusServiceUnavailable, webhookMaxAttempts, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const secret = "subscription secret"
+			var mu sync.Mutex
+			received := 0
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				err := client.VerifyWebhook(secret, r.Header.Get(client.WebhookSignatureHeader), r.Header.Get(client.WebhookTimestampHeader), body, time.Minute)
+				if err != nil {
+					t.Errorf("receiver: %v", err)
+				}
+				mu.Lock()
+				received++
+				mu.Unlock()
+				w.WriteHeader(tt.status)
+			}))
+			defer receiver.Close()
+
+			store := &webhookStore{
+				subs: []model.WebhookSubscription{
+					{Id: 1, URL: receiver.URL, Secret: secret, Types: []string{model.EventCompanyRegistered}},
+					{Id: 2, URL: receiver.URL, Secret: secret, Types: []string{model.EventCompanyLiquidated}},
+				},
+				deliveries: map[int]model.WebhookDelivery{},
+			}
+			serv := NewWebhookService(store).(webhookService)
+			event := model.Event{Id: 7, Type: model.EventCompanyRegistered, PIB: 100000001}
+			// Handed over twice, as after a restart of the relay
+			for i := 0; i < 2; i++ {
+				if err := serv.Consume(context.Background(), event); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(store.deliveries) != 1 {
+				t.Fatalf("queued %d deliveries, want 1", len(store.deliveries))
+			}
+
+			for i := 0; i < tt.attempts; i++ {
+				store.due()
+				serv.deliverDue(context.Background())
+			}
+			if received != tt.attempts {
+				t.Errorf("receiver got %d deliveries, want %d", received, tt.attempts)
+			}
+			if (len(store.deliveries) > 0) != tt.wantQueued {
+				t.Errorf("deliveries still queued: %v", store.deliveries)
+			}
+			if len(store.deadLetters) != tt.wantDeadLetters {
+				t.Errorf("got %d dead letters, want %d", len(store.deadLetters), tt.wantDeadLetters)
+			}
+			for _, d := range store.deliveries {
+				if d.Attempts != tt.attempts || !d.NextAttemptAt.After(time.Now()) {
+					t.Errorf("retried delivery has %d attempts, next at %v", d.Attempts, d.NextAttemptAt)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// Key of the RFC 6238 test vectors, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA1, truncated to six digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("code at %d = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantOk   bool
+		wantStep int64
+	}{
+		{"current step", rfcSecret, code(current), true, current},
+		{"previous step", rfcSecret, code(current - 1), true, current - 1},
+		{"next step", rfcSecret, code(current + 1), true, current + 1},
+		{"two steps old", rfcSecret, code(current - 2), false, 0},
+		{"two steps ahead", rfcSecret, code(current + 2), false, 0},
+		{"with a space", rfcSecret, code(current)[:3] + " " + code(current)[3:], true, current},
+		{"too short", rfcSecret, code(current)[:5], false, 0},
+		{"too long", rfcSecret, code(current) + "0", false, 0},
+		{"other secret", "JBSWY3DPEHPK3PXP", code(current), false, 0},
+		{"invalid secret", "not base32!", code(current), false, 0},
+		{"empty", rfcSecret, "", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Verify(tt.secret, tt.code, now)
+			if ok != tt.wantOk || step != tt.wantStep {
+				t.Errorf("got step %d %v, want %d %v", step, ok, tt.wantStep, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := NewSecret()
+	if secret == other {
+		t.Error("two secrets are the same")
+	}
+	if _, err := Code(secret, 1); err != nil {
+		t.Errorf("new secret can't generate codes: %v", err)
+	}
+}
@@ -18,25 +18,39 @@
 //	     type: apiKey
 //	     in: header
 //	     name: Authorization
+//	basicAuth:
+//	     type: basic
 //
 // swagger:meta
 package main
 
 import (
+	"apr-backend/api"
+	"apr-backend/api/aprpb"
 	"apr-backend/client"
 	"apr-backend/internal/auth"
 	"apr-backend/internal/controllers"
 	"apr-backend/internal/db"
+	"apr-backend/internal/events"
+	"apr-backend/internal/gql"
+	"apr-backend/internal/lockout"
 	"apr-backend/internal/model"
+	"apr-backend/internal/openapi"
+	"apr-backend/internal/ratelimit"
+	"apr-backend/internal/rpc"
 	"apr-backend/internal/services"
+	"bytes"
 	"context"
+	"crypto/rsa"
 	"database/sql"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -46,12 +60,26 @@ import (
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
 	_ "github.com/go-sql-driver/mysql"
+	"google.golang.org/grpc"
 )
 
+//go:generate swagger generate spec -o api/openapi.json --scan-models
+
 func main() {
 	logger := log.Default()
 	router := gin.New()
 	router.Use(gin.Recovery())
+	// Client addresses are used to limit logins, so X-Forwarded-For is only
+	// believed when it comes from a known proxy
+	var trustedProxies []string
+	if proxiesStr, ok := os.LookupEnv("TRUSTED_PROXIES"); ok && proxiesStr != "" {
+		for _, proxy := range strings.Split(proxiesStr, ",") {
+			trustedProxies = append(trustedProxies, strings.TrimSpace(proxy))
+		}
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		logger.Fatalf("TRUSTED_PROXIES is invalid: %s", err.Error())
+	}
 
 	dbUsr := "apr"
 
@@ -63,13 +91,9 @@ func main() {
 	if !ok {
 		logger.Fatal("DB_PASS env variable was not set")
 	}
-	rsaKeyFile, ok := os.LookupEnv("RSA_KEY_FILE")
-	if !ok {
-		logger.Fatal("RSA_KEY_FILE env variable was not set")
-	}
 
 	dbPass, err := ioutil.ReadFile(dbPassFile)
-	sqlConStr := fmt.Sprintf("%s:%s@tcp(%s)/apr", dbUsr, strings.TrimSpace(string(dbPass)), mysqlAddr)
+	sqlConStr := fmt.Sprintf("%s:%s@tcp(%s)/apr?parseTime=true", dbUsr, strings.TrimSpace(string(dbPass)), mysqlAddr)
 	mysqlDb, err := sql.Open("mysql", sqlConStr)
 	if err != nil {
 		logger.Println(err.Error())
@@ -89,23 +113,223 @@ func main() {
 
 	userRepo := db.NewPersonRepo(mysqlDb)
 	comRepo := db.NewCompanyRepository(mysqlDb, userRepo)
-	authServ := services.NewAuthService(comRepo)
 
-	privateKey, err := auth.ReadRSAPrivateKeyFromFile(rsaKeyFile)
-	if err != nil {
-		logger.Println(err.Error())
-		return
+	// Signing keys come from a PEM file, from the key store in the database,
+	// or in dev mode are generated on every start.
+	devMode := os.Getenv("APR_DEV") == "true"
+	var keyring *auth.Keyring
+	var keyStore *auth.KeyStore
+	if rsaKeyFile, ok := os.LookupEnv("RSA_KEY_FILE"); ok {
+		privateKey, err := auth.ReadRSAPrivateKeyFromFile(rsaKeyFile)
+		if err != nil {
+			logger.Println(err.Error())
+			return
+		}
+		// Keys which were used before the current one, still accepted for
+		// tokens they have signed
+		var retiredKeys []*rsa.PrivateKey
+		if retiredStr, ok := os.LookupEnv("RSA_RETIRED_KEY_FILES"); ok && retiredStr != "" {
+			for _, file := range strings.Split(retiredStr, ",") {
+				key, err := auth.ReadRSAPrivateKeyFromFile(strings.TrimSpace(file))
+				if err != nil {
+					logger.Println(err.Error())
+					return
+				}
+				retiredKeys = append(retiredKeys, key)
+			}
+		}
+		keyring = auth.NewKeyring(privateKey, retiredKeys...)
+	} else if passFile, ok := os.LookupEnv("SIGNING_KEY_PASS_FILE"); ok {
+		passphrase, err := ioutil.ReadFile(passFile)
+		if err != nil {
+			logger.Println(err.Error())
+			return
+		}
+		passphrase = bytes.TrimSpace(passphrase)
+		if len(passphrase) == 0 {
+			logger.Fatal("SIGNING_KEY_PASS_FILE is empty")
+		}
+		keyStore = auth.NewKeyStore(db.NewSigningKeyRepository(mysqlDb), passphrase)
+		if keyring, err = keyStore.Keyring(); err != nil {
+			logger.Println(err.Error())
+			return
+		}
+	} else if devMode {
+		privateKey, err := auth.GenerateKey()
+		if err != nil {
+			logger.Println(err.Error())
+			return
+		}
+		logger.Println("Dev mode: signing with an ephemeral key, tokens won't survive a restart")
+		keyring = auth.NewKeyring(privateKey)
+	} else {
+		logger.Fatal("No signing key: set RSA_KEY_FILE or SIGNING_KEY_PASS_FILE, or APR_DEV=true for an ephemeral key")
+	}
+	jwtGenerator := auth.NewJwtGenerator(keyring)
+	tokenRepo := db.NewTokenRepository(mysqlDb)
+	// Password reset tokens and activation codes of person accounts are
+	// posted to NOTIFY_URL, in dev mode they are only logged
+	var notifier services.Notifier
+	if notifyUrl, ok := os.LookupEnv("NOTIFY_URL"); ok {
+		notifier = services.NewHttpNotifier(notifyUrl)
+	} else if devMode {
+		notifier = services.NewLogNotifier()
+	}
+	// Failed logins are counted in process, unless replicas have to share
+	// them through the database
+	loginAttempts := lockout.NewMemoryStore()
+	if os.Getenv("LOGIN_ATTEMPTS_STORE") == "mysql" {
+		loginAttempts = db.NewLoginAttemptRepository(mysqlDb)
+	}
+	guard := lockout.NewGuard(loginAttempts, db.NewLockoutRepository(mysqlDb), lockout.DefaultPibPolicy, lockout.DefaultIpPolicy)
+	lockoutCtr := controllers.NewLockoutController(guard)
+	// Principals in APR_ADMINS, PIBs or JMBGs, are admins besides officials
+	// granted the role. A PIB makes the company's own logins admins, so
+	// anyone with its password; JMBGs are safer.
+	var admins []string
+	if adminsStr, ok := os.LookupEnv("APR_ADMINS"); ok {
+		for _, admin := range strings.Split(adminsStr, ",") {
+			admins = append(admins, strings.TrimSpace(admin))
+		}
+	}
+	roleServ := services.NewRoleService(db.NewOfficialRepository(mysqlDb), tokenRepo, admins)
+	officialCtr := controllers.NewOfficialController(roleServ)
+	accountRepo := db.NewPersonAccountRepository(mysqlDb)
+	// Companies can enrol a TOTP second factor, admins decide which
+	// operations require it
+	mfaServ := services.NewMfaService(db.NewMfaRepository(mysqlDb), tokenRepo)
+	mfaCtr := controllers.NewMfaController(mfaServ)
+	authServ := services.NewAuthService(comRepo, accountRepo, tokenRepo, roleServ, mfaServ, jwtGenerator, notifier, guard)
+	// SSO tokens are only issued for services in the catalogue
+	serviceRepo := db.NewServiceRepository(mysqlDb)
+	catalogueServ := services.NewCatalogueService(serviceRepo, jwtGenerator)
+	serviceCtr := controllers.NewServiceController(catalogueServ)
+	// A reset a minute, in bursts of three
+	authCtr := controllers.NewAuthController(authServ, catalogueServ, jwtGenerator, ratelimit.NewLimiter(1, 3))
+	// Introspection calls a minute each service may make
+	introspectionRate := 600
+	if rateStr, ok := os.LookupEnv("INTROSPECTION_RATE"); ok {
+		introspectionRate, err = strconv.Atoi(rateStr)
+		if err != nil || introspectionRate <= 0 {
+			logger.Fatalf("INTROSPECTION_RATE %q is not a positive number", rateStr)
+		}
 	}
-	jwtGenerator := auth.NewJwtGenerator(privateKey)
-	authCtr := controllers.NewAuthController(authServ, jwtGenerator)
+	// Bursts of up to ten seconds worth of calls
+	introspectionLimiter := ratelimit.NewLimiter(introspectionRate, introspectionRate/6+1)
+	introspectionServ := services.NewIntrospectionService(serviceRepo, tokenRepo, comRepo, jwtGenerator, introspectionLimiter)
+	introspectionCtr := controllers.NewIntrospectionController(introspectionServ)
+	personServ := services.NewPersonService(userRepo, accountRepo, tokenRepo, authServ, jwtGenerator, guard, notifier)
+	// Every request notifies companies, like password resets
+	personCtr := controllers.NewPersonController(personServ, ratelimit.NewLimiter(1, 3))
 
 	comServ := services.NewCompanyService(comRepo)
-	comCtr := controllers.NewCompanyController(comServ, jwtGenerator)
+	comCtr := controllers.NewCompanyController(comServ, authServ)
 
 	nstjRepo := db.NewNstjRepository(mysqlDb)
 	nstjService := services.NewNstjService(nstjRepo)
 	nstjCtr := controllers.NewNstjController(nstjService)
 
+	openDataDir, ok := os.LookupEnv("OPENDATA_DIR")
+	if !ok {
+		openDataDir = "opendata"
+	}
+	openDataInterval := 24 * time.Hour
+	if intervalStr, ok := os.LookupEnv("OPENDATA_INTERVAL"); ok {
+		openDataInterval, err = time.ParseDuration(intervalStr)
+		if err != nil || openDataInterval <= 0 {
+			logger.Fatalf("OPENDATA_INTERVAL %q is not a valid duration", intervalStr)
+		}
+	}
+	openDataServ, err := services.NewOpenDataService(openDataDir, comRepo, nstjRepo)
+	if err != nil {
+		logger.Println(err.Error())
+		return
+	}
+	openDataCtr := controllers.NewOpenDataController(openDataServ)
+
+	// Client apps sign companies in through OpenID Connect, which needs the
+	// public URL clients reach APR at
+	issuer := os.Getenv("APR_PUBLIC_URL")
+	if issuer == "" && devMode {
+		issuer = "http://localhost:7887"
+	}
+	var oauthCtr *controllers.OAuthController
+	var accountCtr *controllers.ServiceAccountController
+	if issuer != "" {
+		accountServ := services.NewServiceAccountService(issuer, db.NewServiceAccountRepository(mysqlDb), tokenRepo, jwtGenerator)
+		oauthServ := services.NewOAuthService(issuer, db.NewOAuthRepository(mysqlDb), comRepo, tokenRepo, authServ, accountServ, jwtGenerator)
+		ctr := controllers.NewOAuthController(oauthServ)
+		oauthCtr = &ctr
+		sac := controllers.NewServiceAccountController(accountServ)
+		accountCtr = &sac
+	} else {
+		logger.Println("APR_PUBLIC_URL is not set, OpenID Connect, service accounts and PDF extracts are disabled")
+	}
+
+	extractServ := services.NewExtractService(db.NewExtractRepository(mysqlDb), comRepo)
+	// Extracts each client address may issue a minute
+	const extractRate = 10
+	extractCtr := controllers.NewExtractController(extractServ, jwtGenerator, issuer, ratelimit.NewLimiter(extractRate, extractRate))
+
+	importRepo := db.NewImportRepository(mysqlDb, comRepo)
+	importServ := services.NewImportService(importRepo)
+	importCtr := controllers.NewImportController(importServ)
+
+	graphqlSchema, err := gql.NewSchema(comServ, nstjService)
+	if err != nil {
+		logger.Println(err.Error())
+		return
+	}
+	graphqlCtr := controllers.NewGraphqlController(graphqlSchema)
+
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	go openDataServ.Run(jobsCtx, openDataInterval)
+	var rotationInterval time.Duration
+	if intervalStr, ok := os.LookupEnv("KEY_ROTATION_INTERVAL"); ok {
+		rotationInterval, err = time.ParseDuration(intervalStr)
+		if err != nil || rotationInterval <= 0 {
+			logger.Fatalf("KEY_ROTATION_INTERVAL %q is not a valid duration", intervalStr)
+		}
+	}
+	// Retired keys must outlive the tokens they have signed
+	rotationGrace := 25 * time.Hour
+	if graceStr, ok := os.LookupEnv("KEY_ROTATION_GRACE"); ok {
+		rotationGrace, err = time.ParseDuration(graceStr)
+		if err != nil || rotationGrace <= 0 {
+			logger.Fatalf("KEY_ROTATION_GRACE %q is not a valid duration", graceStr)
+		}
+	}
+	switch {
+	case keyStore != nil:
+		go keyStore.Run(jobsCtx, keyring, rotationInterval, rotationGrace)
+	case rotationInterval == 0:
+	case devMode:
+		go keyring.RunRotation(jobsCtx, rotationInterval, rotationGrace, auth.GenerateKey)
+	default:
+		logger.Fatal("KEY_ROTATION_INTERVAL needs keys from SIGNING_KEY_PASS_FILE, rotated keys would be lost")
+	}
+
+	webhookRepo := db.NewWebhookRepository(mysqlDb)
+	webhookServ := services.NewWebhookService(webhookRepo)
+	webhookCtr := controllers.NewWebhookController(webhookServ)
+
+	const eventHistory = 1000
+	broker := events.NewBroker(eventHistory)
+	eventCtr := controllers.NewEventController(broker)
+	relay := events.NewRelay(db.NewOutboxRepository(mysqlDb))
+	if err := relay.Register("sse", broker, false, eventHistory); err != nil {
+		logger.Println(err.Error())
+		return
+	}
+	if err := relay.Register("webhooks", webhookServ, true, 0); err != nil {
+		logger.Println(err.Error())
+		return
+	}
+	outboxCtr := controllers.NewOutboxController(relay)
+	go relay.Run(jobsCtx)
+	go webhookServ.Run(jobsCtx)
+
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:4200", "http://localhost:4201", "http://localhost:4202"},
 		AllowMethods:     []string{"POST", "GET", "OPTIONS", "PUT", "DELETE"},
@@ -114,24 +338,175 @@ func main() {
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
+	// Registered before the validator, which only knows about the API itself
+	router.GET("/api/openapi.json", openapi.Spec(api.Spec))
+	router.GET("/api/docs/*filepath", openapi.Docs("/api/openapi.json"))
+	// The browser facing endpoints serve forms and redirects
+	if oauthCtr != nil {
+		router.GET(services.OidcConfigurationPath, oauthCtr.Configuration)
+		router.GET(services.OAuthAuthorizePath, oauthCtr.Authorize)
+		router.POST(services.OAuthAuthorizePath, oauthCtr.Login)
+		router.POST(services.OAuthTokenPath, oauthCtr.Token)
+		router.GET(services.OAuthUserInfoPath,
+			client.CheckAuth(jwtGenerator, services.UserInfoAudience, client.WithRevocationStore(tokenRepo)),
+			client.RequireRoles(client.RoleCompany),
+			client.RequireScopes("openid"),
+			oauthCtr.UserInfo)
+		router.GET(services.OAuthLogoutPath, oauthCtr.Logout)
+	}
+	if os.Getenv("OPENAPI_VALIDATION") == "true" {
+		specValidator, err := openapi.NewValidator(api.Spec, devMode)
+		if err != nil {
+			logger.Println(err.Error())
+			return
+		}
+		router.Use(specValidator.Middleware())
+	}
 	router.POST("/api/auth/login/", authCtr.Login)
+	router.POST("/api/auth/login/mfa", authCtr.LoginMfa)
+	router.POST("/api/auth/refresh", authCtr.Refresh)
+	router.POST("/api/auth/password/reset", authCtr.RequestPasswordReset)
+	router.POST("/api/auth/password/reset/confirm", authCtr.ResetPassword)
+	router.GET(client.JwksPath, authCtr.JWKS)
+	router.GET(client.RevocationsPath, authCtr.Revocations)
+	router.POST(services.OAuthIntrospectPath, introspectionCtr.Introspect)
+	router.POST("/api/person/register", personCtr.Register)
+	router.POST("/api/person/activation", personCtr.RequestActivation)
+	router.POST("/api/person/activation/confirm", personCtr.Activate)
+	router.POST("/api/person/login", personCtr.Login)
+	personGroup := router.Group("/api/person/")
+	personGroup.Use(client.CheckAuth(jwtGenerator, client.AprPerson, client.WithRevocationStore(tokenRepo)))
+	{
+		personGroup.GET("/companies", personCtr.FindCompanies)
+		personGroup.POST("/companies/:pib/session", personCtr.CreateSession)
+		personGroup.POST("/official/session", personCtr.CreateOfficialSession)
+	}
 	comGroup := router.Group("/api/company/")
 	{
 		comGroup.POST("/", comCtr.CreateCompany)
 		comGroup.GET("/", comCtr.FindCompanies)
+		comGroup.GET("/export", comCtr.ExportCompanies)
 		comGroup.GET("/:pib", comCtr.FindOne)
+		// PDFs link to their verification page, which needs the public URL
+		if issuer != "" {
+			comGroup.GET("/:pib/extract.pdf", extractCtr.Download)
+		}
+		comGroup.GET("/:pib/extract.jws", extractCtr.DownloadSigned)
 	}
 	nstjGroup := router.Group("/api/nstj/")
 	{
 		nstjGroup.GET("/", nstjCtr.FindAll)
 	}
+	openDataGroup := router.Group("/api/opendata/")
+	{
+		openDataGroup.GET("/", openDataCtr.ListSnapshots)
+		openDataGroup.GET("/:file", openDataCtr.Download)
+	}
+	router.GET("/api/extract/verify/:code", extractCtr.Verify)
+	router.GET(client.ExtractKeysPath, extractCtr.Keys)
+	router.GET("/api/events/stream", eventCtr.Stream)
 	authGroup := router.Group("/")
-	authGroup.Use(client.CheckAuth(jwtGenerator, client.Apr))
+	authGroup.Use(
+		client.CheckAuth(jwtGenerator, client.Apr, client.WithRevocationStore(tokenRepo)),
+		// Service accounts may only read, the schema checks the scope of each field
+		client.RequireScopesFor(client.RoleService, map[string][]string{
+			"POST /graphql": {},
+		}),
+	)
 	{
 		authGroup.GET("/api/auth/login/:service", authCtr.SSOLogin)
-		authGroup.DELETE("/api/company/:pib", comCtr.LiquidateById)
+		authGroup.POST("/api/auth/logout", authCtr.Logout)
+		authGroup.POST("/graphql", graphqlCtr.Query)
+	}
+	companyGroup := authGroup.Group("/")
+	companyGroup.Use(client.RequireRoles(client.RoleCompany))
+	{
+		companyGroup.POST("/api/auth/password", mfaCtr.RequireMfa(model.MfaChangePassword), authCtr.ChangePassword)
+		companyGroup.DELETE("/api/company/:pib", mfaCtr.RequireMfa(model.MfaLiquidate), comCtr.LiquidateById)
+		companyGroup.GET("/api/company/:pib/representatives", personCtr.FindRepresentatives)
+		companyGroup.POST("/api/company/:pib/representatives", mfaCtr.RequireMfa(model.MfaRepresentatives), personCtr.AddRepresentative)
+		companyGroup.DELETE("/api/company/:pib/representatives/:jmbg", mfaCtr.RequireMfa(model.MfaRepresentatives), personCtr.RemoveRepresentative)
+		companyGroup.GET("/api/auth/mfa", mfaCtr.Status)
+		companyGroup.POST("/api/auth/mfa/totp", mfaCtr.Enroll)
+		companyGroup.POST("/api/auth/mfa/totp/confirm", mfaCtr.Confirm)
+		companyGroup.DELETE("/api/auth/mfa/totp", mfaCtr.Disable)
+		companyGroup.POST("/api/auth/mfa/recovery-codes", mfaCtr.RegenerateRecoveryCodes)
+	}
+	registrarGroup := authGroup.Group("/api/registrar/")
+	registrarGroup.Use(client.RequireRoles(client.RoleClerk, client.RoleSupervisor))
+	{
+		registrarGroup.DELETE("/company/:pib", comCtr.ForceLiquidate)
+	}
+	// Auditors can see everything admins can, but not change it
+	auditGroup := authGroup.Group("/api/admin/")
+	auditGroup.Use(client.RequireRoles(client.RoleAdmin, client.RoleAuditor))
+	{
+		auditGroup.GET("/import/company/:id", importCtr.FindJob)
+		auditGroup.GET("/outbox", outboxCtr.Status)
+		auditGroup.GET("/lockouts", lockoutCtr.FindActive)
+		auditGroup.GET("/mfa/policy", mfaCtr.FindPolicies)
+		if oauthCtr != nil {
+			auditGroup.GET("/oauth/clients", oauthCtr.FindClients)
+			auditGroup.GET("/service-accounts", accountCtr.FindAll)
+		}
+		auditGroup.GET("/officials", officialCtr.FindAll)
+		auditGroup.GET("/services", serviceCtr.FindAll)
+		auditGroup.GET("/webhooks/", webhookCtr.FindAll)
+		auditGroup.GET("/webhooks/dead-letters", webhookCtr.FindDeadLetters)
+	}
+	adminGroup := authGroup.Group("/api/admin/")
+	adminGroup.Use(client.RequireRoles(client.RoleAdmin))
+	{
+		adminGroup.POST("/import/company", importCtr.ImportCompanies)
+		adminGroup.DELETE("/lockouts/:kind/:subject", lockoutCtr.Unlock)
+		adminGroup.PUT("/mfa/policy/:operation", mfaCtr.SetPolicy)
+		adminGroup.DELETE("/mfa/:pib", mfaCtr.Reset)
+		if oauthCtr != nil {
+			adminGroup.POST("/oauth/clients", oauthCtr.CreateClient)
+			adminGroup.DELETE("/oauth/clients/:id", oauthCtr.DeleteClient)
+			adminGroup.POST("/service-accounts", accountCtr.Create)
+			adminGroup.POST("/service-accounts/:clientId/rotate", accountCtr.Rotate)
+			adminGroup.POST("/service-accounts/:clientId/disable", accountCtr.Disable)
+			adminGroup.POST("/service-accounts/:clientId/enable", accountCtr.Enable)
+		}
+		adminGroup.POST("/officials", officialCtr.Grant)
+		adminGroup.DELETE("/officials/:jmbg/:role", officialCtr.Revoke)
+		adminGroup.POST("/services", serviceCtr.Create)
+		adminGroup.PUT("/services/:name", serviceCtr.Update)
+		adminGroup.DELETE("/services/:name", serviceCtr.Delete)
+		adminGroup.POST("/services/:name/introspection-secret", introspectionCtr.RotateSecret)
+		adminGroup.POST("/webhooks/", webhookCtr.Create)
+		adminGroup.DELETE("/webhooks/:id", webhookCtr.Delete)
+		adminGroup.POST("/webhooks/dead-letters/:id/redeliver", webhookCtr.Redeliver)
 	}
 
+	grpcAddr, ok := os.LookupEnv("GRPC_ADDR")
+	if !ok {
+		grpcAddr = "0.0.0.0:7888"
+	}
+	grpcListener, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		logger.Println(err.Error())
+		return
+	}
+	grpcSrv := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		rpc.CheckAuth(jwtGenerator, client.Apr, client.WithRevocationStore(tokenRepo)),
+		// Scopes service accounts need for each method
+		rpc.RequireScopesFor(client.RoleService, map[string][]string{
+			aprpb.Registry_FindOne_FullMethodName:       {client.ScopeCompaniesRead},
+			aprpb.Registry_FindMany_FullMethodName:      {client.ScopeCompaniesRead},
+			aprpb.Registry_FindCompanies_FullMethodName: {client.ScopeCompaniesRead},
+			aprpb.Registry_ListNstj_FullMethodName:      {client.ScopeNstjRead},
+		}),
+	))
+	aprpb.RegisterRegistryServer(grpcSrv, rpc.NewRegistryServer(comServ, nstjService))
+	go func() {
+		log.Printf("gRPC server starting on %s", grpcAddr)
+		if err := grpcSrv.Serve(grpcListener); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
 	srv := &http.Server{Addr: "0.0.0.0:7887", Handler: router}
 	go func() {
 		log.Println("server starting")
@@ -148,11 +523,22 @@ func main() {
 
 	// gracefully shutdown server
 	logger.Println("service shutting down ...")
+	stopJobs()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcSrv.GracefulStop()
+		close(grpcStopped)
+	}()
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal(err)
 	}
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		grpcSrv.Stop()
+	}
 	logger.Println("server stopped")
 }